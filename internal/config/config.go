@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,9 +16,12 @@ func LoadConfig() *Config {
 	loadEnvFile()
 
 	return &Config{
-		App:    loadAppConfig(),
-		Email:  loadEmailConfig(),
-		Report: loadReportConfig(),
+		App:      loadAppConfig(),
+		Email:    loadEmailConfig(),
+		Report:   loadReportConfig(),
+		Database: loadDatabaseConfig(),
+		Archive:  loadArchiveConfig(),
+		Migrate:  loadMigrationConfig(),
 	}
 }
 
@@ -39,9 +43,12 @@ func loadEnvFile() {
 
 // Config contiene toda la configuración de la aplicación
 type Config struct {
-	App    AppConfig
-	Email  EmailConfig
-	Report ReportConfig
+	App      AppConfig
+	Email    EmailConfig
+	Report   ReportConfig
+	Database DatabaseConfig
+	Archive  ArchiveConfig
+	Migrate  MigrationConfig
 }
 
 // AppConfig configuración de la aplicación
@@ -49,6 +56,7 @@ type AppConfig struct {
 	Port        string
 	Host        string
 	Environment string
+	AdminToken  string // Token exigido por los endpoints /admin (vacío = endpoints deshabilitados)
 }
 
 // EmailConfig configuración de email
@@ -67,12 +75,59 @@ type ReportConfig struct {
 	Subject  string
 }
 
+// DatabaseConfig configuración del almacenamiento de transacciones
+type DatabaseConfig struct {
+	Driver string
+	Path   string
+
+	// Persistencia opcional del driver mock (log de escritura anticipada + snapshots)
+	WALDir        string
+	SnapshotEvery int
+	WALRecovery   string
+}
+
+// ArchiveConfig configuración de la retención y el archivo de transacciones antiguas
+type ArchiveConfig struct {
+	Dir           string
+	RetentionDays int           // Antigüedad a partir de la cual se archiva (0 = archivado automático desactivado)
+	CheckInterval time.Duration // Cada cuánto se ejecuta el archivado automático
+}
+
+// MigrationConfig configuración de las migraciones asíncronas
+type MigrationConfig struct {
+	Workers   int    // Migraciones asíncronas que se procesan a la vez
+	QueueSize int    // Migraciones en espera antes de rechazar nuevas con 503
+	SpoolDir  string // Directorio de los archivos en espera (vacío = directorio temporal del sistema)
+
+	LegacyResponse bool // POST /migrate responde 200 sin body salvo Prefer: return=representation
+
+	MappingFile  string // Archivo JSON con perfiles de columnas para las subidas (vacío = sin perfiles)
+	AccountsFile string // Archivo JSON con el usuario de cada cuenta de los extractos OFX/QIF (vacío = ninguna)
+
+	MaxUncompressedSize int64 // Bytes descomprimidos permitidos por subida gzip o ZIP
+	MaxArchiveEntries   int   // Archivos permitidos dentro de un ZIP
+
+	IdempotencyTTL        time.Duration // Tiempo que se recuerda cada subida para responder sus reintentos (0 = desactivado)
+	IdempotencyMaxUploads int           // Subidas terminadas que se recuerdan a la vez; las más antiguas se olvidan antes
+
+	UploadDir     string        // Directorio de las subidas reanudables (/uploads)
+	UploadTTL     time.Duration // Tiempo sin recibir partes tras el cual se descarta una subida reanudable
+	UploadMaxSize int64         // Upload-Length máximo de una subida reanudable en bytes
+}
+
+// Drivers de almacenamiento soportados
+const (
+	DatabaseDriverMock   = "mock"
+	DatabaseDriverSQLite = "sqlite"
+)
+
 // loadAppConfig carga la configuración de la aplicación
 func loadAppConfig() AppConfig {
 	return AppConfig{
 		Port:        getEnvOrDefault("PORT", "8080"),
 		Host:        getEnvOrDefault("HOST", "localhost"),
 		Environment: getEnvOrDefault("APP_ENV", "production"),
+		AdminToken:  os.Getenv("ADMIN_TOKEN"),
 	}
 }
 
@@ -101,6 +156,97 @@ func loadReportConfig() ReportConfig {
 	}
 }
 
+// loadDatabaseConfig carga la configuración del almacenamiento
+func loadDatabaseConfig() DatabaseConfig {
+	snapshotEvery, _ := strconv.Atoi(getEnvOrDefault("DB_SNAPSHOT_EVERY", "1000"))
+
+	return DatabaseConfig{
+		Driver:        strings.ToLower(strings.TrimSpace(getEnvOrDefault("DB_DRIVER", DatabaseDriverMock))),
+		Path:          getEnvOrDefault("DB_PATH", "data/api-stori.db"),
+		WALDir:        os.Getenv("DB_WAL_DIR"),
+		SnapshotEvery: snapshotEvery,
+		WALRecovery:   getEnvOrDefault("DB_WAL_RECOVERY", "truncate"),
+	}
+}
+
+// loadArchiveConfig carga la configuración de retención
+func loadArchiveConfig() ArchiveConfig {
+	retentionDays, _ := strconv.Atoi(getEnvOrDefault("RETENTION_DAYS", "0"))
+	checkInterval, err := time.ParseDuration(getEnvOrDefault("RETENTION_CHECK_INTERVAL", "24h"))
+	if err != nil || checkInterval <= 0 {
+		checkInterval = 24 * time.Hour
+	}
+
+	return ArchiveConfig{
+		Dir:           getEnvOrDefault("ARCHIVE_DIR", "data/archive"),
+		RetentionDays: retentionDays,
+		CheckInterval: checkInterval,
+	}
+}
+
+// loadMigrationConfig carga la configuración de las migraciones asíncronas
+func loadMigrationConfig() MigrationConfig {
+	workers, err := strconv.Atoi(getEnvOrDefault("MIGRATION_WORKERS", "2"))
+	if err != nil || workers <= 0 {
+		workers = 2
+	}
+	queueSize, err := strconv.Atoi(getEnvOrDefault("MIGRATION_QUEUE_SIZE", "100"))
+	if err != nil || queueSize <= 0 {
+		queueSize = 100
+	}
+
+	legacyResponse, _ := strconv.ParseBool(getEnvOrDefault("MIGRATE_LEGACY_RESPONSE", "false"))
+
+	// Límites contra archivos comprimidos que se expanden sin control (zip bombs)
+	maxUncompressedSize, err := strconv.ParseInt(getEnvOrDefault("MIGRATION_MAX_UNCOMPRESSED_SIZE", "4294967296"), 10, 64)
+	if err != nil || maxUncompressedSize <= 0 {
+		maxUncompressedSize = 4 << 30
+	}
+	maxArchiveEntries, err := strconv.Atoi(getEnvOrDefault("MIGRATION_MAX_ARCHIVE_ENTRIES", "100"))
+	if err != nil || maxArchiveEntries <= 0 {
+		maxArchiveEntries = 100
+	}
+
+	// Una subida repetida dentro de este plazo responde el resultado original en lugar de importarse de nuevo
+	idempotencyTTL, err := time.ParseDuration(getEnvOrDefault("MIGRATION_IDEMPOTENCY_TTL", "24h"))
+	if err != nil || idempotencyTTL < 0 {
+		idempotencyTTL = 24 * time.Hour
+	}
+	idempotencyMaxUploads, err := strconv.Atoi(getEnvOrDefault("MIGRATION_IDEMPOTENCY_MAX_UPLOADS", "10000"))
+	if err != nil || idempotencyMaxUploads <= 0 {
+		idempotencyMaxUploads = 10000
+	}
+
+	// Las subidas reanudables abandonadas se borran después de este plazo sin actividad
+	uploadTTL, err := time.ParseDuration(getEnvOrDefault("MIGRATION_UPLOAD_TTL", "24h"))
+	if err != nil || uploadTTL <= 0 {
+		uploadTTL = 24 * time.Hour
+	}
+	uploadMaxSize, err := strconv.ParseInt(getEnvOrDefault("MIGRATION_UPLOAD_MAX_SIZE", "4294967296"), 10, 64)
+	if err != nil || uploadMaxSize <= 0 {
+		uploadMaxSize = 4 << 30
+	}
+
+	return MigrationConfig{
+		Workers:        workers,
+		QueueSize:      queueSize,
+		SpoolDir:       os.Getenv("MIGRATION_SPOOL_DIR"),
+		LegacyResponse: legacyResponse,
+		MappingFile:    os.Getenv("CSV_MAPPING_FILE"),
+		AccountsFile:   os.Getenv("STATEMENT_ACCOUNTS_FILE"),
+
+		MaxUncompressedSize: maxUncompressedSize,
+		MaxArchiveEntries:   maxArchiveEntries,
+
+		IdempotencyTTL:        idempotencyTTL,
+		IdempotencyMaxUploads: idempotencyMaxUploads,
+
+		UploadDir:     getEnvOrDefault("MIGRATION_UPLOAD_DIR", "data/uploads"),
+		UploadTTL:     uploadTTL,
+		UploadMaxSize: uploadMaxSize,
+	}
+}
+
 // getEnvOrDefault obtiene una variable de entorno con valor por defecto (usando godotenv)
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
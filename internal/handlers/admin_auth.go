@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// authorizeAdmin verifica el token de los endpoints /admin; responde 401 y retorna false si no es válido.
+// Sin token configurado los endpoints quedan deshabilitados (503): nunca se abren sin autenticación.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		http.Error(w, "Admin endpoints are disabled: ADMIN_TOKEN is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"api-stori/internal/services"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ArchiveHandler maneja los endpoints de administración de la retención de transacciones
+type ArchiveHandler struct {
+	archiveService *services.ArchiveService
+	adminToken     string // Se exige en el header X-Admin-Token; vacío deshabilita los endpoints
+}
+
+// NewArchiveHandler crea una nueva instancia de ArchiveHandler
+func NewArchiveHandler(archiveService *services.ArchiveService, adminToken string) *ArchiveHandler {
+	return &ArchiveHandler{
+		archiveService: archiveService,
+		adminToken:     adminToken,
+	}
+}
+
+// GetArchiveStatus maneja el endpoint GET /admin/archive
+func (h *ArchiveHandler) GetArchiveStatus(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r, h.adminToken) {
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(h.archiveService.Status()); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// RunArchive maneja el endpoint POST /admin/archive
+func (h *ArchiveHandler) RunArchive(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r, h.adminToken) {
+		return
+	}
+
+	// Sin older_than_days se usa la retención configurada
+	days := h.archiveService.RetentionDays()
+	if daysStr := r.URL.Query().Get("older_than_days"); daysStr != "" {
+		parsed, err := strconv.Atoi(daysStr)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid 'older_than_days' value. Expected a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	} else if days <= 0 {
+		http.Error(w, "Retention is not configured; 'older_than_days' is required", http.StatusBadRequest)
+		return
+	}
+
+	run, err := h.archiveService.ArchiveOlderThan(services.RetentionCutoff(time.Now(), days))
+	if err != nil {
+		http.Error(w, "Error archiving transactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"api-stori/internal/models"
+	"api-stori/internal/services"
+	"api-stori/tests/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newArchiveRouter registra los endpoints de archivo sobre db con el token y la retención indicados
+func newArchiveRouter(t *testing.T, db services.TransactionRepository, adminToken string, retentionDays int) *mux.Router {
+	archiveService, err := services.NewArchiveService(db, t.TempDir(), retentionDays)
+	if err != nil {
+		t.Fatalf("Expected no error creating archive service, got %v", err)
+	}
+	handler := NewArchiveHandler(archiveService, adminToken)
+	router := mux.NewRouter()
+	router.HandleFunc(config.GetPathAPI()+"/admin/archive", handler.GetArchiveStatus).Methods("GET")
+	router.HandleFunc(config.GetPathAPI()+"/admin/archive", handler.RunArchive).Methods("POST")
+	return router
+}
+
+func TestArchiveHandler_RunAndStatus(t *testing.T) {
+	db := services.NewMockDatabase()
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: time.Now().AddDate(0, 0, -400)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 50, DateTime: time.Now()})
+	router := newArchiveRouter(t, db, "secret", 0)
+
+	req, _ := http.NewRequest("POST", config.GetPathAPI()+"/admin/archive?older_than_days=365", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var run models.ArchiveRun
+	if err := json.NewDecoder(rr.Body).Decode(&run); err != nil {
+		t.Fatalf("Expected no error decoding response, got %v", err)
+	}
+	if run.ArchivedCount != 1 || len(run.Partitions) != 1 || db.GetTransactionCount() != 1 {
+		t.Errorf("Expected 1 transaction archived, got %+v", run)
+	}
+
+	req, _ = http.NewRequest("GET", config.GetPathAPI()+"/admin/archive", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var status models.ArchiveStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Expected no error decoding response, got %v", err)
+	}
+	if status.TotalArchived != 1 || len(status.Runs) != 1 || status.ArchivedUntil == nil {
+		t.Errorf("Unexpected archive status %+v", status)
+	}
+}
+
+func TestArchiveHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		token          string
+		retentionDays  int
+		expectedStatus int
+	}{
+		{"Missing admin token", "GET", "", "", 0, http.StatusUnauthorized},
+		{"Wrong admin token", "POST", "?older_than_days=30", "wrong", 0, http.StatusUnauthorized},
+		{"Invalid older_than_days", "POST", "?older_than_days=abc", "secret", 0, http.StatusBadRequest},
+		{"Negative older_than_days", "POST", "?older_than_days=-1", "secret", 0, http.StatusBadRequest},
+		{"Retention not configured", "POST", "", "secret", 0, http.StatusBadRequest},
+		{"Configured retention", "POST", "", "secret", 30, http.StatusOK},
+		{"Status", "GET", "", "secret", 0, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, config.GetPathAPI()+"/admin/archive"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("X-Admin-Token", tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+			newArchiveRouter(t, services.NewMockDatabase(), "secret", tt.retentionDays).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
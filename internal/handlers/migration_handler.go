@@ -1,13 +1,43 @@
 package handlers
 
 import (
+	"api-stori/internal/models"
 	"api-stori/internal/services"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
 )
 
+// maxFormFieldSize tamaño máximo de los campos de texto del formulario (opciones y asociación de columnas)
+const maxFormFieldSize = 1024
+
+// maxResponseErrors errores por línea incluidos en la respuesta de /migrate; la lista completa va en el reporte
+const maxResponseErrors = 100
+
+// maxIdempotencyKeySize largo máximo del header Idempotency-Key
+const maxIdempotencyKeySize = 255
+
+// sniffSize bytes iniciales del archivo que se examinan cuando su formato no se deduce del nombre
+const sniffSize = 512
+
 // MigrationHandler maneja las requests del endpoint de migración
 type MigrationHandler struct {
 	migrationService *services.MigrationService
+	jobQueue         *services.MigrationJobQueue    // Cola de migraciones asíncronas (nil = async desactivado)
+	uploads          *services.UploadRegistry       // Subidas recientes para no importarlas dos veces (nil = sin idempotencia)
+	resumableUploads *services.ResumableUploadStore // Subidas reanudables por partes (/uploads)
+	legacyResponse   bool                           // Responder 200 sin body salvo que la request pida el resultado
 }
 
 // NewMigrationHandler crea una nueva instancia de MigrationHandler
@@ -17,7 +47,33 @@ func NewMigrationHandler(migrationService *services.MigrationService) *Migration
 	}
 }
 
-// MigrateCSV maneja el endpoint POST /migrate
+// SetJobQueue habilita las migraciones asíncronas (?async=true) sobre jobQueue
+func (h *MigrationHandler) SetJobQueue(jobQueue *services.MigrationJobQueue) {
+	h.jobQueue = jobQueue
+}
+
+// SetUploadRegistry hace que /migrate sea idempotente: una subida repetida con el mismo Idempotency-Key
+// o, sin clave, con el mismo archivo y opciones responde el resultado original sin importar de nuevo
+func (h *MigrationHandler) SetUploadRegistry(uploads *services.UploadRegistry) {
+	h.uploads = uploads
+}
+
+// SetLegacyResponse hace que /migrate responda 200 sin body por defecto, como antes de incluir el resultado.
+// Cada request puede elegir con el header Prefer: return=minimal o return=representation.
+func (h *MigrationHandler) SetLegacyResponse(legacy bool) {
+	h.legacyResponse = legacy
+}
+
+// MigrationRejectedResponse respuesta cuando una migración atómica es rechazada
+type MigrationRejectedResponse struct {
+	Error        string   `json:"error"`
+	TotalRecords int      `json:"total_records"`
+	ErrorRecords int      `json:"error_records"`
+	Errors       []string `json:"errors"`
+}
+
+// MigrateCSV maneja el endpoint POST /migrate. Con el registro de subidas activo (idempotencia) cada
+// subida síncrona, con o sin Idempotency-Key, se copia a disco antes de migrarse (ver migrateOnce).
 func (h *MigrationHandler) MigrateCSV(w http.ResponseWriter, r *http.Request) {
 	// Verificar que el método sea POST
 	if r.Method != http.MethodPost {
@@ -25,42 +81,599 @@ func (h *MigrationHandler) MigrateCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verificar que el Content-Type sea multipart/form-data
-	contentType := r.Header.Get("Content-Type")
-	if contentType == "" || (contentType != "multipart/form-data" && contentType[:19] != "multipart/form-data") {
-		http.Error(w, "Content-Type must be multipart/form-data", http.StatusBadRequest)
+	request, ok := h.readMigrationRequest(w, r)
+	if !ok {
+		return
+	}
+	defer request.file.Close()
+
+	if request.dryRun {
+		if request.async {
+			http.Error(w, "'dry_run' and 'async' cannot be combined", http.StatusBadRequest)
+			return
+		}
+		h.writeValidation(w, request)
+		return
+	}
+
+	if h.uploads != nil {
+		h.migrateOnce(w, r, request)
+		return
+	}
+
+	if request.async {
+		h.submitMigrationJob(w, r, request.file, request.options)
 		return
 	}
 
-	// Parsear el formulario multipart
-	err := r.ParseMultipartForm(32 << 20) // 32 MB max
+	// Sin registro de subidas el archivo se procesa en streaming; su SHA-256 se calcula mientras se lee
+	request.options.HashContent = true
+	stats, err := h.migrationService.ProcessFile(request.file, request.options)
+	h.writeMigrationResult(w, r, stats, err)
+}
+
+// writeMigrationResult responde el resultado de una migración síncrona: 200, 207 con errores por fila,
+// 422 si la migración atómica fue rechazada o 500 si el archivo no se pudo procesar
+func (h *MigrationHandler) writeMigrationResult(w http.ResponseWriter, r *http.Request, stats *services.MigrationStats, err error) {
+	if errors.Is(err, services.ErrMigrationRejected) {
+		// Devolver la lista completa de errores para que el archivo se pueda corregir de una vez
+		response := MigrationRejectedResponse{
+			Error:        err.Error(),
+			TotalRecords: stats.TotalRecords,
+			ErrorRecords: stats.ErrorRecords,
+			Errors:       stats.Errors,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error parsing multipart form => "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "Error processing file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Obtener el archivo CSV
-	file, header, err := r.FormFile("csv_file")
+	h.writeResult(w, r, stats.Result(maxResponseErrors))
+}
+
+// writeResult responde el resultado de una migración terminada: 200, o 207 si hubo errores por fila
+func (h *MigrationHandler) writeResult(w http.ResponseWriter, r *http.Request, result *models.MigrationResult) {
+	// Clientes legacy: solo código HTTP 200 OK sin body
+	if !h.wantsResult(w, r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 207 indica éxito parcial: se guardaron filas pero otras fallaron
+	status := http.StatusOK
+	if result.ErrorRecords > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// migrateOnce migra el archivo salvo que la misma subida ya se haya procesado. Toda subida, también las que
+// no traen Idempotency-Key, se copia completa a disco para calcular su SHA-256 antes de migrarla: el espacio
+// de MIGRATION_SPOOL_DIR debe admitir el archivo más grande que se acepte. La subida se identifica por el
+// header Idempotency-Key o, sin él, por el SHA-256 y las opciones. Una repetición responde el resultado
+// original con Idempotent-Replayed: true y una clave ya usada con otro archivo u otras opciones responde 409.
+func (h *MigrationHandler) migrateOnce(w http.ResponseWriter, r *http.Request, request *migrationRequest) {
+	key := r.Header.Get("Idempotency-Key")
+	if !validIdempotencyKey(key) {
+		http.Error(w, fmt.Sprintf("Invalid Idempotency-Key header. Expected up to %d printable ASCII characters", maxIdempotencyKeySize), http.StatusBadRequest)
+		return
+	}
+	if request.async && h.jobQueue == nil {
+		http.Error(w, "Asynchronous migrations are not enabled", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.uploads.Receive(request.file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	queued := false // La cola se hace cargo de la copia del archivo al encolarlo
+	defer func() {
+		if !queued {
+			upload.Remove()
+		}
+	}()
+
+	options := request.options
+	options.ContentSHA256 = upload.SHA256
+	fingerprint := services.UploadFingerprint(upload.SHA256, options)
+
+	var record *services.UploadRecord
+	for record == nil {
+		existing, replay, err := h.uploads.Begin(r.Context(), key, upload.SHA256, fingerprint)
+		if errors.Is(err, services.ErrIdempotencyKeyConflict) {
+			http.Error(w, "Idempotency-Key was already used with a different file or options", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			// El cliente se desconectó mientras esperaba la subida original
+			return
+		}
+		if !replay {
+			record = existing
+		} else if h.replayUpload(w, r, existing) {
+			return
+		}
+	}
+
+	if request.async {
+		job, err := h.jobQueue.SubmitSpooled(upload.Path, options)
+		if err != nil {
+			h.uploads.Release(record)
+			writeSubmitError(w, err)
+			return
+		}
+		queued = true
+		h.uploads.Complete(record, nil, &job)
+		writeMigrationJob(w, r, job)
+		return
+	}
+
+	var stats *services.MigrationStats
+	file, err := os.Open(upload.Path)
+	if err == nil {
+		stats, err = h.migrationService.ProcessFile(file, options)
+		file.Close()
+	}
+
+	// Solo se recuerdan las migraciones que guardaron su resultado; un reintento de las fallidas se procesa de nuevo
+	if err != nil {
+		h.uploads.Release(record)
+	} else {
+		h.uploads.Complete(record, stats.Result(maxResponseErrors), nil)
+	}
+	h.writeMigrationResult(w, r, stats, err)
+}
+
+// replayUpload responde el resultado de una subida ya procesada. Si era asíncrona se responde el estado
+// actual de su trabajo; si ese trabajo falló la subida se descarta y retorna false para procesarla de nuevo.
+func (h *MigrationHandler) replayUpload(w http.ResponseWriter, r *http.Request, record *services.UploadRecord) bool {
+	if record.Job != nil {
+		job, err := h.jobQueue.GetJob(record.Job.ID)
+		if err != nil {
+			job = *record.Job
+		}
+		if job.State == services.MigrationJobFailed {
+			h.uploads.Release(record)
+			return false
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeMigrationJob(w, r, job)
+		return true
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	h.writeResult(w, r, record.Result)
+	return true
+}
+
+// validIdempotencyKey indica si key es un Idempotency-Key aceptable (vacío = sin clave)
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeySize {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// wantsResult indica si la respuesta de /migrate debe incluir el resultado según el header Prefer
+// (RFC 7240) o, si no lo indica, según la configuración legacy del handler
+func (h *MigrationHandler) wantsResult(w http.ResponseWriter, r *http.Request) bool {
+	for _, preference := range strings.Split(r.Header.Get("Prefer"), ",") {
+		switch strings.ToLower(strings.TrimSpace(preference)) {
+		case "return=minimal":
+			w.Header().Set("Preference-Applied", "return=minimal")
+			return false
+		case "return=representation":
+			w.Header().Set("Preference-Applied", "return=representation")
+			return true
+		}
+	}
+	return !h.legacyResponse
+}
+
+// ValidateCSV maneja el endpoint POST /migrate/validate: igual que /migrate con dry_run=true
+func (h *MigrationHandler) ValidateCSV(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, ok := h.readMigrationRequest(w, r)
+	if !ok {
+		return
+	}
+	defer request.file.Close()
+
+	h.writeValidation(w, request)
+}
+
+// writeValidation valida el archivo sin guardar nada y responde con las estadísticas y el reporte calculado
+func (h *MigrationHandler) writeValidation(w http.ResponseWriter, request *migrationRequest) {
+	validation, err := h.migrationService.ValidateFile(request.file, request.options)
+	if err != nil {
+		http.Error(w, "Invalid file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(validation); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// migrationRequest archivo y opciones de una request de migración
+type migrationRequest struct {
+	file    io.ReadCloser // Parte multipart o body de la request
+	options services.MigrationOptions
+	async   bool
+	dryRun  bool
+}
+
+// readMigrationRequest lee las opciones y avanza la request hasta el archivo sin leerlo.
+// El archivo llega como parte csv_file de un formulario multipart o como body JSON Lines / JSON.
+// Si la request es inválida responde el error y retorna false.
+func (h *MigrationHandler) readMigrationRequest(w http.ResponseWriter, r *http.Request) (*migrationRequest, bool) {
+	// Opciones en el query string; los campos del formulario enviados antes del archivo tienen prioridad
+	fields := map[string]string{
+		"on_conflict": r.URL.Query().Get("on_conflict"),
+		"atomic":      r.URL.Query().Get("atomic"),
+		"async":       r.URL.Query().Get("async"),
+		"dry_run":     r.URL.Query().Get("dry_run"),
+		"format":      r.URL.Query().Get("format"),
+		"sheet":       r.URL.Query().Get("sheet"),
+
+		"default_user_id": r.URL.Query().Get("default_user_id"),
+
+		"mapping_profile": r.URL.Query().Get("mapping_profile"),
+		"column_mapping":  r.URL.Query().Get("column_mapping"),
+	}
+
+	// Body comprimido en tránsito (Content-Encoding: gzip): se descomprime antes de leerlo, en streaming
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		body, err := h.migrationService.DecompressGzip(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		r.Body = body
+	default:
+		http.Error(w, "Unsupported Content-Encoding: "+encoding, http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	// Body JSON Lines o JSON sin formulario: las opciones solo llegan por query string
+	if format := services.DetectFileFormat(contentType, ""); format == services.FormatNDJSON || format == services.FormatJSON {
+		request, err := h.parseMigrationFields(r.Body, r.URL.Query().Get("filename"), contentType, fields)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		return request, true
+	}
+
+	// Verificar que el Content-Type sea multipart/form-data
+	if mediaType != "multipart/form-data" {
+		http.Error(w, "Content-Type must be multipart/form-data, application/x-ndjson or application/json", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Leer el formulario multipart como stream: el archivo no se guarda en memoria ni en disco
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Error parsing multipart form => "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Obtener el archivo
+	file, err := nextFilePart(reader, "csv_file", fields)
 	if err != nil {
 		http.Error(w, "Error retrieving CSV file: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	request, err := h.parseMigrationFields(file, file.FileName(), file.Header.Get("Content-Type"), fields)
+	if err != nil {
+		file.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return request, true
+}
+
+// parseMigrationFields identifica el formato del archivo y convierte los campos del formulario en opciones de migración
+func (h *MigrationHandler) parseMigrationFields(file io.ReadCloser, filename, contentType string, fields map[string]string) (*migrationRequest, error) {
+	// Formato indicado explícitamente o deducido del Content-Type / extensión del archivo
+	format, err := services.ParseFileFormat(fields["format"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Un archivo gzip (.csv.gz) se descomprime al procesarlo; su formato es el del archivo descomprimido
+	compression := services.DetectCompression(contentType, filename)
+	if format == "" && compression != services.CompressionNone {
+		format = services.DetectFileFormat("", strings.TrimSuffix(filename, filepath.Ext(filename)))
+	}
+	if format == "" {
+		format = services.DetectFileFormat(contentType, filename)
+	}
+	if format == "" && compression == services.CompressionNone {
+		// Los extractos bancarios suelen llegar sin extensión ni Content-Type útiles: se reconocen por su contenido
+		buffered := bufio.NewReaderSize(file, sniffSize)
+		prefix, _ := buffered.Peek(sniffSize)
+		format = services.SniffFileFormat(prefix)
+		file = struct {
+			io.Reader
+			io.Closer
+		}{buffered, file}
+	}
+	if format == "" {
+		return nil, errors.New("File must be a CSV, JSON Lines, JSON, XLSX, OFX/QFX, QIF or ZIP file, optionally gzip-compressed")
+	}
+
+	request, err := h.parseMigrationOptions(fields)
+	if err != nil {
+		return nil, err
+	}
+	request.file = file
+	request.options.SourceFile = filename
+	request.options.Format = format
+	request.options.Compression = compression
+	return request, nil
+}
+
+// parseMigrationOptions convierte los campos del formulario en opciones de migración, sin el archivo ni su formato
+func (h *MigrationHandler) parseMigrationOptions(fields map[string]string) (*migrationRequest, error) {
+	// Política para IDs que ya existen (query string o campo del formulario)
+	conflictPolicy, err := services.ParseConflictPolicy(fields["on_conflict"])
+	if err != nil {
+		return nil, err
+	}
+
+	// atomic: se guardan todas las filas o ninguna; async: se responde 202 en cuanto el archivo queda encolado;
+	// dry_run: solo se valida
+	flags := map[string]bool{}
+	for _, name := range []string{"atomic", "async", "dry_run"} {
+		if value := fields[name]; value != "" {
+			flags[name], err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid '%s' value. Expected: true or false", name)
+			}
+		}
+	}
+
+	// Columnas del archivo: perfil configurado o asociación enviada con la subida
+	var mapping services.ColumnMapping
+	switch {
+	case fields["mapping_profile"] != "" && fields["column_mapping"] != "":
+		return nil, errors.New("'mapping_profile' and 'column_mapping' cannot be combined")
+	case fields["mapping_profile"] != "":
+		mapping, err = h.migrationService.MappingProfile(fields["mapping_profile"])
+	case fields["column_mapping"] != "":
+		mapping, err = services.ParseColumnMapping(fields["column_mapping"])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Usuario de las cuentas de un extracto que no están en la configuración
+	var defaultUserID int
+	if value := fields["default_user_id"]; value != "" {
+		defaultUserID, err = strconv.Atoi(value)
+		if err != nil || defaultUserID <= 0 {
+			return nil, errors.New("Invalid 'default_user_id' value. Expected a positive number")
+		}
+	}
+
+	return &migrationRequest{
+		options: services.MigrationOptions{
+			ConflictPolicy: conflictPolicy,
+			Atomic:         flags["atomic"],
+			Sheet:          fields["sheet"],
+			DefaultUserID:  defaultUserID,
+			Mapping:        mapping,
+		},
+		async:  flags["async"],
+		dryRun: flags["dry_run"],
+	}, nil
+}
+
+// submitMigrationJob encola la migración y responde 202 con el trabajo y su URL en el header Location
+func (h *MigrationHandler) submitMigrationJob(w http.ResponseWriter, r *http.Request, file io.Reader, options services.MigrationOptions) {
+	if h.jobQueue == nil {
+		http.Error(w, "Asynchronous migrations are not enabled", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// Verificar que sea un archivo CSV
-	if header.Header.Get("Content-Type") != "text/csv" &&
-		header.Filename[len(header.Filename)-4:] != ".csv" {
-		http.Error(w, "File must be a CSV file", http.StatusBadRequest)
+	job, err := h.jobQueue.Submit(file, options)
+	if err != nil {
+		writeSubmitError(w, err)
 		return
 	}
+	writeMigrationJob(w, r, job)
+}
 
-	// Procesar el archivo CSV
-	_, err = h.migrationService.ProcessCSV(file)
+// writeSubmitError responde el error al encolar una migración: 503 si la cola está llena
+func writeSubmitError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrMigrationQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Migration queue is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Error queueing migration: "+err.Error(), http.StatusInternalServerError)
+}
+
+// writeMigrationJob responde 202 con el trabajo y su URL en el header Location
+func writeMigrationJob(w http.ResponseWriter, r *http.Request, job services.MigrationJob) {
+	// La URL de estado cuelga del mismo prefijo que /migrate
+	location := strings.TrimSuffix(r.URL.Path, "/migrate") + "/migrations/" + job.ID
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusAccepted)
+
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetMigrationJob maneja el endpoint GET /migrations/{id}
+func (h *MigrationHandler) GetMigrationJob(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extraer id de la migración de la URL usando Gorilla Mux
+	migrationID, exists := mux.Vars(r)["id"]
+	if !exists || migrationID == "" {
+		http.Error(w, "id parameter not found in URL", http.StatusBadRequest)
+		return
+	}
+
+	if h.jobQueue == nil {
+		http.Error(w, "Migration not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := h.jobQueue.GetJob(migrationID)
 	if err != nil {
-		http.Error(w, "Error processing CSV: "+err.Error(), http.StatusInternalServerError)
+		if err == services.ErrMigrationNotFound {
+			http.Error(w, "Migration not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Devolver solo código HTTP 200 OK sin body
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// RollbackMigration maneja el endpoint DELETE /migrations/{id}: revierte las transacciones escritas por la
+// migración. Con ?dry_run=true solo responde lo que se revertiría.
+func (h *MigrationHandler) RollbackMigration(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea DELETE
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extraer id de la migración de la URL usando Gorilla Mux
+	migrationID, exists := mux.Vars(r)["id"]
+	if !exists || migrationID == "" {
+		http.Error(w, "id parameter not found in URL", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid 'dry_run' value. Expected: true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Una migración asíncrona solo se revierte cuando terminó
+	if h.jobQueue != nil {
+		if job, err := h.jobQueue.GetJob(migrationID); err == nil && (job.State == services.MigrationJobQueued || job.State == services.MigrationJobRunning) {
+			http.Error(w, "Migration is still running", http.StatusConflict)
+			return
+		}
+	}
+
+	report, err := h.migrationService.RollbackMigration(migrationID, dryRun)
+	if err != nil {
+		if err == services.ErrMigrationNotFound {
+			http.Error(w, "Migration not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, services.ErrVersionMismatch) {
+			http.Error(w, "Transactions of the migration kept changing during the rollback, try again", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error rolling back migration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Reintentar la subida original después de revertirla la importa de nuevo
+	if h.uploads != nil && !dryRun {
+		h.uploads.ForgetMigration(migrationID)
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// nextFilePart avanza el formulario multipart hasta la parte del archivo fileField y la retorna sin leerla.
+// Los campos de texto conocidos (claves de fields) que aparecen antes del archivo se copian a fields;
+// los que vienen después del archivo no se pueden leer sin cargarlo completo y se ignoran.
+func nextFilePart(reader *multipart.Reader, fileField string, fields map[string]string) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == fileField {
+			return part, nil
+		}
+
+		if _, known := fields[part.FormName()]; known && part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			if err != nil {
+				part.Close()
+				return nil, err
+			}
+			fields[part.FormName()] = string(value)
+		}
+		part.Close()
+	}
 }
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"api-stori/internal/services"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// PurgeHandler maneja el endpoint de administración que elimina definitivamente las transacciones borradas
+type PurgeHandler struct {
+	transactionsService *services.TransactionsService
+	adminToken          string // Se exige en el header X-Admin-Token; vacío deshabilita los endpoints
+}
+
+// NewPurgeHandler crea una nueva instancia de PurgeHandler
+func NewPurgeHandler(transactionsService *services.TransactionsService, adminToken string) *PurgeHandler {
+	return &PurgeHandler{
+		transactionsService: transactionsService,
+		adminToken:          adminToken,
+	}
+}
+
+// PurgeDeletedTransactions maneja el endpoint POST /admin/purge
+func (h *PurgeHandler) PurgeDeletedTransactions(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r, h.adminToken) {
+		return
+	}
+
+	// Sin deleted_before se purgan todas las transacciones eliminadas
+	var deletedBefore *time.Time
+	if beforeStr := r.URL.Query().Get("deleted_before"); beforeStr != "" {
+		parsed, err := time.Parse(time.RFC3339, beforeStr)
+		if err != nil {
+			http.Error(w, "Invalid 'deleted_before' format. Expected: 2024-01-01T00:00:00Z", http.StatusBadRequest)
+			return
+		}
+		deletedBefore = &parsed
+	}
+
+	result, err := h.transactionsService.PurgeDeletedTransactions(deletedBefore)
+	if err != nil {
+		http.Error(w, "Error purging transactions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
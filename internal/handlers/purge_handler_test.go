@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"api-stori/internal/models"
+	"api-stori/internal/services"
+	"api-stori/tests/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newPurgeRouter registra el endpoint de purga sobre db con el token indicado
+func newPurgeRouter(db services.TransactionRepository, adminToken string) *mux.Router {
+	handler := NewPurgeHandler(services.NewTransactionsService(db), adminToken)
+	router := mux.NewRouter()
+	router.HandleFunc(config.GetPathAPI()+"/admin/purge", handler.PurgeDeletedTransactions).Methods("POST")
+	return router
+}
+
+func TestPurgeHandler_PurgeDeletedTransactions(t *testing.T) {
+	db := services.NewMockDatabase()
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime})
+	db.SoftDeleteTransaction(1, "duplicated")
+
+	req, _ := http.NewRequest("POST", config.GetPathAPI()+"/admin/purge", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rr := httptest.NewRecorder()
+	newPurgeRouter(db, "secret").ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var result models.PurgeResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding response, got %v", err)
+	}
+	if result.PurgedCount != 1 || result.Transactions[0].ID != 1 || db.GetTransactionCount() != 1 {
+		t.Errorf("Expected transaction 1 purged, got %+v", result)
+	}
+}
+
+func TestPurgeHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		token          string
+		expectedStatus int
+	}{
+		{"Missing admin token", "", "", http.StatusUnauthorized},
+		{"Wrong admin token", "", "wrong", http.StatusUnauthorized},
+		{"Invalid deleted_before", "?deleted_before=2024-01-01", "secret", http.StatusBadRequest},
+		{"Valid deleted_before", "?deleted_before=2024-01-01T00:00:00Z", "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", config.GetPathAPI()+"/admin/purge"+tt.query, nil)
+			if tt.token != "" {
+				req.Header.Set("X-Admin-Token", tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+			newPurgeRouter(services.NewMockDatabase(), "secret").ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPurgeHandler_AdminTokenNotConfigured(t *testing.T) {
+	db := services.NewMockDatabase()
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: time.Now()})
+	db.SoftDeleteTransaction(1, "duplicated")
+
+	// Sin ADMIN_TOKEN los endpoints /admin quedan deshabilitados, aun sin header
+	req, _ := http.NewRequest("POST", config.GetPathAPI()+"/admin/purge", nil)
+	rr := httptest.NewRecorder()
+	newPurgeRouter(db, "").ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if db.GetTransactionCount() != 1 {
+		t.Error("Expected no transaction purged")
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"api-stori/internal/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SnapshotHandler maneja los endpoints de administración para exportar e importar el almacén
+type SnapshotHandler struct {
+	snapshotService *services.SnapshotService
+	adminToken      string // Se exige en el header X-Admin-Token; vacío deshabilita los endpoints
+}
+
+// NewSnapshotHandler crea una nueva instancia de SnapshotHandler
+func NewSnapshotHandler(snapshotService *services.SnapshotService, adminToken string) *SnapshotHandler {
+	return &SnapshotHandler{
+		snapshotService: snapshotService,
+		adminToken:      adminToken,
+	}
+}
+
+// ExportSnapshot maneja el endpoint GET /admin/snapshot
+func (h *SnapshotHandler) ExportSnapshot(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r, h.adminToken) {
+		return
+	}
+
+	format, err := services.ParseSnapshotFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snapshot, err := h.snapshotService.CreateSnapshot(format)
+	if err != nil {
+		http.Error(w, "Error creating snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer snapshot.Close()
+
+	// El manifiesto viaja en headers para poder verificarlo al importar
+	manifest := snapshot.Manifest
+	w.Header().Set("Content-Type", snapshotContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"transactions-%s.%s\"",
+		manifest.CreatedAt.Format("20060102150405"), format))
+	w.Header().Set("X-Snapshot-Format", string(manifest.Format))
+	w.Header().Set("X-Snapshot-Count", strconv.Itoa(manifest.Count))
+	w.Header().Set("X-Snapshot-SHA256", manifest.SHA256)
+	w.Header().Set("X-Snapshot-Created-At", manifest.CreatedAt.Format(time.RFC3339))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := snapshot.WriteTo(w); err != nil {
+		// Los headers ya se enviaron: solo queda registrar el error
+		log.Printf("Error writing snapshot: %v", err)
+	}
+}
+
+// ImportSnapshot maneja el endpoint POST /admin/snapshot
+func (h *SnapshotHandler) ImportSnapshot(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r, h.adminToken) {
+		return
+	}
+
+	query := r.URL.Query()
+	format, err := services.ParseSnapshotFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode, err := services.ParseSnapshotImportMode(query.Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Checksum del manifiesto (query string o header devuelto por la exportación)
+	expectedSHA256 := query.Get("sha256")
+	if expectedSHA256 == "" {
+		expectedSHA256 = r.Header.Get("X-Snapshot-SHA256")
+	}
+
+	result, err := h.snapshotService.ImportSnapshot(r.Body, format, mode, expectedSHA256)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSnapshotChecksumMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, services.ErrInvalidSnapshot):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Error importing snapshot: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// snapshotContentType retorna el Content-Type de cada formato de snapshot
+func snapshotContentType(format services.SnapshotFormat) string {
+	if format == services.SnapshotCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"api-stori/internal/models"
+	"api-stori/internal/services"
+	"api-stori/tests/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newSnapshotRouter registra los endpoints de snapshot sobre db con el token indicado
+func newSnapshotRouter(db services.TransactionRepository, adminToken string) *mux.Router {
+	handler := NewSnapshotHandler(services.NewSnapshotService(db), adminToken)
+	router := mux.NewRouter()
+	router.HandleFunc(config.GetPathAPI()+"/admin/snapshot", handler.ExportSnapshot).Methods("GET")
+	router.HandleFunc(config.GetPathAPI()+"/admin/snapshot", handler.ImportSnapshot).Methods("POST")
+	return router
+}
+
+func TestSnapshotHandler_ExportAndImport(t *testing.T) {
+	source := services.NewMockDatabase()
+	baseTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	source.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+	source.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1002, Amount: -75.25, DateTime: baseTime})
+
+	req, _ := http.NewRequest("GET", config.GetPathAPI()+"/admin/snapshot?format=csv", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	rr := httptest.NewRecorder()
+	newSnapshotRouter(source, "secret").ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != "text/csv" || rr.Header().Get("X-Snapshot-Count") != "2" {
+		t.Errorf("Unexpected headers %v", rr.Header())
+	}
+	checksum := rr.Header().Get("X-Snapshot-SHA256")
+	if len(checksum) != 64 {
+		t.Fatalf("Expected SHA-256 manifest header, got %q", checksum)
+	}
+	body := rr.Body.String()
+
+	target := services.NewMockDatabase()
+	req, _ = http.NewRequest("POST", config.GetPathAPI()+"/admin/snapshot?format=csv&mode=replace", strings.NewReader(body))
+	req.Header.Set("X-Snapshot-SHA256", checksum)
+	req.Header.Set("X-Admin-Token", "secret")
+	rr = httptest.NewRecorder()
+	newSnapshotRouter(target, "secret").ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var result services.SnapshotImportResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding response, got %v", err)
+	}
+	if result.Count != 2 || result.Mode != services.SnapshotReplace || target.GetTransactionCount() != 2 {
+		t.Errorf("Expected 2 transactions imported, got %+v", result)
+	}
+}
+
+func TestSnapshotHandler_Errors(t *testing.T) {
+	valid := `{"id":1,"user_id":1001,"amount":20,"datetime":"2024-01-15T10:30:00Z"}`
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		body           string
+		token          string
+		expectedStatus int
+	}{
+		{"Missing admin token", "GET", "", "", "", http.StatusUnauthorized},
+		{"Wrong admin token", "POST", "", valid, "wrong", http.StatusUnauthorized},
+		{"Invalid format", "GET", "?format=xml", "", "secret", http.StatusBadRequest},
+		{"Invalid mode", "POST", "?mode=append", valid, "secret", http.StatusBadRequest},
+		{"Invalid snapshot", "POST", "", "not json", "secret", http.StatusBadRequest},
+		{"Checksum mismatch", "POST", "?sha256=" + strings.Repeat("0", 64), valid, "secret", http.StatusUnprocessableEntity},
+		{"Valid import", "POST", "", valid, "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, config.GetPathAPI()+"/admin/snapshot"+tt.query, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("X-Admin-Token", tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+			newSnapshotRouter(services.NewMockDatabase(), "secret").ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"api-stori/internal/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// TransactionHandler maneja las requests de los endpoints de transacciones
+type TransactionHandler struct {
+	transactionsService *services.TransactionsService
+}
+
+// NewTransactionHandler crea una nueva instancia de TransactionHandler
+func NewTransactionHandler(transactionsService *services.TransactionsService) *TransactionHandler {
+	return &TransactionHandler{
+		transactionsService: transactionsService,
+	}
+}
+
+// GetTransactionHistory maneja el endpoint GET /transactions/{id}/history
+func (h *TransactionHandler) GetTransactionHistory(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extraer id de la URL usando Gorilla Mux
+	idStr, exists := mux.Vars(r)["id"]
+	if !exists {
+		http.Error(w, "id parameter not found in URL", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid transaction id format", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.transactionsService.GetTransactionHistory(id)
+	if err != nil {
+		if err == services.ErrTransactionNotFound {
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetMigrationTransactions maneja el endpoint GET /migrations/{id}/transactions
+func (h *TransactionHandler) GetMigrationTransactions(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea GET
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extraer id de la migración de la URL usando Gorilla Mux
+	migrationID, exists := mux.Vars(r)["id"]
+	if !exists || migrationID == "" {
+		http.Error(w, "id parameter not found in URL", http.StatusBadRequest)
+		return
+	}
+
+	transactions, err := h.transactionsService.GetTransactionsByMigrationID(migrationID)
+	if err != nil {
+		if err == services.ErrMigrationNotFound {
+			http.Error(w, "Migration not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(transactions); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteTransaction maneja el endpoint DELETE /transactions/{id} (borrado lógico)
+func (h *TransactionHandler) DeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea DELETE
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extraer id de la URL usando Gorilla Mux
+	idStr, exists := mux.Vars(r)["id"]
+	if !exists {
+		http.Error(w, "id parameter not found in URL", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid transaction id format", http.StatusBadRequest)
+		return
+	}
+
+	transaction, err := h.transactionsService.DeleteTransaction(id, r.URL.Query().Get("reason"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeleteReasonRequired):
+			http.Error(w, "Query parameter 'reason' is required", http.StatusBadRequest)
+		case errors.Is(err, services.ErrTransactionNotFound):
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrTransactionAlreadyDeleted):
+			http.Error(w, "Transaction already deleted", http.StatusConflict)
+		default:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Escribir respuesta JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(transaction); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+}
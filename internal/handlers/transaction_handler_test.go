@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"api-stori/internal/models"
+	"api-stori/internal/services"
+	"api-stori/tests/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTransactionHandler_GetTransactionHistory(t *testing.T) {
+	// Setup
+	db := services.NewMockDatabase()
+	handler := NewTransactionHandler(services.NewTransactionsService(db))
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+	db.SaveTransactionWithOptions(models.UserTransaction{ID: 1, UserID: 1001, Amount: 99.99, DateTime: baseTime},
+		services.SaveOptions{MigrationID: "mig-test"})
+
+	tests := []struct {
+		name             string
+		id               string
+		expectedStatus   int
+		expectedVersions int
+	}{
+		{"Existing transaction", "1", http.StatusOK, 2},
+		{"Non-existent transaction", "999", http.StatusNotFound, 0},
+		{"Invalid id format", "invalid", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", config.GetPathAPI()+"/transactions/"+tt.id+"/history", nil)
+			if err != nil {
+				t.Fatalf("Expected no error creating request, got %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc(config.GetPathAPI()+"/transactions/{id}/history", handler.GetTransactionHistory).Methods("GET")
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var history models.TransactionHistory
+				if err := json.NewDecoder(rr.Body).Decode(&history); err != nil {
+					t.Fatalf("Expected no error decoding response, got %v", err)
+				}
+				if len(history.Versions) != tt.expectedVersions || history.CurrentVersion != tt.expectedVersions {
+					t.Errorf("Expected %d versions, got %+v", tt.expectedVersions, history)
+				}
+				if history.Versions[1].MigrationID != "mig-test" {
+					t.Errorf("Expected migration ID mig-test on version 2, got %q", history.Versions[1].MigrationID)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionHandler_GetMigrationTransactions(t *testing.T) {
+	// Setup
+	db := services.NewMockDatabase()
+	handler := NewTransactionHandler(services.NewTransactionsService(db))
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime,
+		MigrationID: "mig-test", SourceFile: "test.csv", SourceLine: 2})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 99.99, DateTime: baseTime,
+		MigrationID: "mig-test", SourceFile: "test.csv", SourceLine: 3})
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"Existing migration", "mig-test", http.StatusOK, 2},
+		{"Unknown migration", "mig-unknown", http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", config.GetPathAPI()+"/migrations/"+tt.id+"/transactions", nil)
+			if err != nil {
+				t.Fatalf("Expected no error creating request, got %v", err)
+			}
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc(config.GetPathAPI()+"/migrations/{id}/transactions", handler.GetMigrationTransactions).Methods("GET")
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var response models.MigrationTransactions
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatalf("Expected no error decoding response, got %v", err)
+				}
+				if response.Count != tt.expectedCount || len(response.Transactions) != tt.expectedCount {
+					t.Errorf("Expected %d transactions, got %+v", tt.expectedCount, response)
+				}
+				if response.Transactions[1].SourceLine != 3 {
+					t.Errorf("Expected transactions in source line order, got %+v", response.Transactions)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionHandler_DeleteTransaction(t *testing.T) {
+	// Setup
+	db := services.NewMockDatabase()
+	handler := NewTransactionHandler(services.NewTransactionsService(db))
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+
+	router := mux.NewRouter()
+	router.HandleFunc(config.GetPathAPI()+"/transactions/{id}", handler.DeleteTransaction).Methods("DELETE")
+
+	// Los casos se ejecutan en orden: el segundo borrado de la misma transacción es un conflicto
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{"Missing reason", "/transactions/1", http.StatusBadRequest},
+		{"Invalid id format", "/transactions/invalid?reason=x", http.StatusBadRequest},
+		{"Non-existent transaction", "/transactions/999?reason=x", http.StatusNotFound},
+		{"Existing transaction", "/transactions/1?reason=duplicated%20charge", http.StatusOK},
+		{"Already deleted", "/transactions/1?reason=again", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("DELETE", config.GetPathAPI()+tt.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var transaction models.UserTransaction
+				if err := json.NewDecoder(rr.Body).Decode(&transaction); err != nil {
+					t.Fatalf("Expected no error decoding response, got %v", err)
+				}
+				if !transaction.Deleted || transaction.DeleteReason != "duplicated charge" || transaction.DeletedAt == nil {
+					t.Errorf("Expected soft-deleted transaction, got %+v", transaction)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"api-stori/internal/services"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// tusVersion versión del protocolo tus de las subidas reanudables
+const tusVersion = "1.0.0"
+
+// maxUploadMetadataSize tamaño máximo del header Upload-Metadata
+const maxUploadMetadataSize = 4096
+
+// uploadMetadataKeys claves aceptadas en Upload-Metadata: nombre y tipo del archivo y opciones de /migrate
+var uploadMetadataKeys = map[string]bool{
+	"filename":        true,
+	"filetype":        true,
+	"on_conflict":     true,
+	"atomic":          true,
+	"format":          true,
+	"sheet":           true,
+	"default_user_id": true,
+	"mapping_profile": true,
+	"column_mapping":  true,
+}
+
+// errInvalidUpload el archivo recibido completo no se puede migrar
+var errInvalidUpload = errors.New("invalid upload")
+
+// SetResumableUploads habilita las subidas reanudables (/uploads) sobre uploads. Requiere la cola de
+// migraciones asíncronas: el archivo completo se migra en segundo plano.
+func (h *MigrationHandler) SetResumableUploads(uploads *services.ResumableUploadStore) {
+	h.resumableUploads = uploads
+}
+
+// UploadOptions maneja el endpoint OPTIONS /uploads: versión, extensiones de tus soportadas y tamaño máximo
+func (h *MigrationHandler) UploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.resumableUploads.MaxSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload maneja el endpoint POST /uploads: crea una subida de Upload-Length bytes, hasta Tus-Max-Size
+// (413 si lo excede). El nombre del archivo y las opciones de la migración llegan en Upload-Metadata y se
+// validan antes de recibir el archivo.
+func (h *MigrationHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusRequest(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Invalid Upload-Length header. Expected a positive number", http.StatusBadRequest)
+		return
+	}
+	if maxSize := h.resumableUploads.MaxSize(); length > maxSize {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+		http.Error(w, fmt.Sprintf("Upload-Length exceeds the maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := services.ParseFileFormat(metadata["format"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.parseMigrationOptions(metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.resumableUploads.Create(length, metadata)
+	if err != nil {
+		http.Error(w, "Error creating upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.ID)
+	w.Header().Set("Upload-Expires", h.resumableUploads.ExpiresAt(upload).Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetUploadOffset maneja el endpoint HEAD /uploads/{id}: bytes recibidos, para retomar la subida desde ahí
+func (h *MigrationHandler) GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusRequest(w, r) {
+		return
+	}
+
+	upload, err := h.resumableUploads.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeUploadHeaders(w, r, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload maneja el endpoint PATCH /uploads/{id}: agrega al archivo los bytes del body a partir de
+// Upload-Offset. Al completarse el archivo se encola su migración y el header Migration-Location indica
+// dónde consultarla. Si la cola está llena responde 503: un PATCH vacío con el offset final reintenta.
+func (h *MigrationHandler) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusRequest(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset header. Expected a non-negative number", http.StatusBadRequest)
+		return
+	}
+
+	// El tamaño de la parte se compara con Upload-Length después del offset: un offset desactualizado responde 409
+	id := mux.Vars(r)["id"]
+	upload, err := h.resumableUploads.Write(id, offset, r.ContentLength, r.Body)
+	if err != nil {
+		// Con el offset actual el cliente retoma la subida sin consultar antes con HEAD
+		if upload.ID != "" {
+			h.writeUploadHeaders(w, r, upload)
+		}
+		writeUploadError(w, err)
+		return
+	}
+
+	if upload.Complete() {
+		upload, err = h.resumableUploads.Finish(id, h.migrateUpload)
+		if errors.Is(err, errInvalidUpload) {
+			// Reintentar no lo arregla: la subida se descarta
+			h.resumableUploads.Delete(id)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			h.writeUploadHeaders(w, r, upload)
+			if errors.Is(err, services.ErrUploadNotFound) || errors.Is(err, services.ErrUploadLocked) {
+				writeUploadError(w, err)
+			} else {
+				writeSubmitError(w, err)
+			}
+			return
+		}
+	}
+
+	h.writeUploadHeaders(w, r, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUpload maneja el endpoint DELETE /uploads/{id}: cancela la subida y borra lo recibido
+func (h *MigrationHandler) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTusRequest(w, r) {
+		return
+	}
+
+	if err := h.resumableUploads.Delete(mux.Vars(r)["id"]); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// migrateUpload encola la migración del archivo completo en path con las opciones de su Upload-Metadata.
+// El checksum ya se calculó al recibir el archivo.
+func (h *MigrationHandler) migrateUpload(upload services.ResumableUpload, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening upload file: %v", err)
+	}
+	request, err := h.parseMigrationFields(file, upload.Metadata["filename"], upload.Metadata["filetype"], upload.Metadata)
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidUpload, err)
+	}
+
+	options := request.options
+	options.ContentSHA256 = upload.SHA256
+	job, err := h.jobQueue.SubmitSpooled(path, options)
+	if err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// checkTusRequest responde 412 si el cliente no usa la versión de tus soportada
+func (h *MigrationHandler) checkTusRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported Tus-Resumable version. Expected "+tusVersion, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// writeUploadHeaders agrega los headers con el estado de la subida y, si ya se migró, la URL de la migración
+func (h *MigrationHandler) writeUploadHeaders(w http.ResponseWriter, r *http.Request, upload services.ResumableUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", h.resumableUploads.ExpiresAt(upload).Format(http.TimeFormat))
+	if upload.MigrationID != "" {
+		// La URL de la migración cuelga del mismo prefijo que /uploads
+		prefix := strings.TrimSuffix(r.URL.Path, "/uploads/"+upload.ID)
+		w.Header().Set("Migration-Location", prefix+"/migrations/"+upload.MigrationID)
+	}
+}
+
+// writeUploadError responde el error de una operación sobre una subida reanudable. Los errores al
+// encolar la migración del archivo completo se responden con writeSubmitError.
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrUploadLocked):
+		http.Error(w, "Upload is being written by another request", http.StatusLocked)
+	case errors.Is(err, services.ErrUploadPartTooLarge):
+		http.Error(w, "Request body exceeds Upload-Length", http.StatusBadRequest)
+	case errors.Is(err, services.ErrUploadInterrupted):
+		http.Error(w, "Upload incomplete, resume from Upload-Offset: "+err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error receiving upload: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseUploadMetadata decodifica el header Upload-Metadata de tus: pares "clave valor-base64" separados por comas.
+// Solo se aceptan las claves de uploadMetadataKeys.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	if len(header) > maxUploadMetadataSize {
+		return nil, fmt.Errorf("Upload-Metadata header exceeds %d bytes", maxUploadMetadataSize)
+	}
+
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 || !uploadMetadataKeys[fields[0]] {
+			return nil, fmt.Errorf("Invalid Upload-Metadata entry %q", strings.TrimSpace(pair))
+		}
+
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid Upload-Metadata value for %q: %v", fields[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata, nil
+}
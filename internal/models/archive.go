@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// ArchivePartition archivo de una fecha escrito durante una ejecución de archivado
+type ArchivePartition struct {
+	Date  string `json:"date"` // Fecha de las transacciones (YYYY-MM-DD, UTC)
+	File  string `json:"file"` // Ruta relativa al directorio de archivo
+	Count int    `json:"count"`
+}
+
+// ArchiveRun ejecución de la política de retención
+type ArchiveRun struct {
+	RunID         string             `json:"run_id"`
+	StartedAt     time.Time          `json:"started_at"`
+	FinishedAt    time.Time          `json:"finished_at"`
+	Cutoff        time.Time          `json:"cutoff"` // Se archivaron las transacciones con fecha anterior
+	ArchivedCount int                `json:"archived_count"`
+	Partitions    []ArchivePartition `json:"partitions,omitempty"`
+}
+
+// ArchiveStatus estado del archivo de transacciones
+type ArchiveStatus struct {
+	ArchiveDir    string       `json:"archive_dir"`
+	RetentionDays int          `json:"retention_days"` // 0 si el archivado automático está desactivado
+	ArchivedUntil *time.Time   `json:"archived_until,omitempty"`
+	TotalArchived int          `json:"total_archived"`
+	Runs          []ArchiveRun `json:"runs"`
+}
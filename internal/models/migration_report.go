@@ -7,9 +7,11 @@ import (
 // MigrationReport representa el reporte de migración
 type MigrationReport struct {
 	// Información básica
-	Timestamp time.Time `json:"timestamp"`
-	Filename  string    `json:"filename"`
-	FileSize  int64     `json:"file_size"`
+	MigrationID string    `json:"migration_id,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Filename    string    `json:"filename"`
+	FileSize    int64     `json:"file_size"`
+	SHA256      string    `json:"sha256,omitempty"` // SHA-256 del archivo recibido, si se calculó
 
 	// Estadísticas de procesamiento
 	TotalRecords   int           `json:"total_records"`
@@ -30,11 +32,30 @@ type MigrationReport struct {
 		To   time.Time `json:"to"`
 	} `json:"date_range"`
 
+	// Conflictos de IDs existentes
+	SkippedRecords  int      `json:"skipped_records"`
+	ConflictRecords int      `json:"conflict_records"`
+	ConflictPolicy  string   `json:"conflict_policy,omitempty"`
+	Conflicts       []string `json:"conflicts,omitempty"`
+
 	// Errores específicos
 	Errors []string `json:"errors,omitempty"`
 
 	// Archivo de errores (CSV)
 	ErrorFileCSV string `json:"error_file_csv,omitempty"`
+
+	// Detalle por archivo cuando se sube un ZIP
+	Files []FileReport `json:"files,omitempty"`
+}
+
+// FileReport cifras de uno de los archivos de un ZIP
+type FileReport struct {
+	Filename       string `json:"filename"`
+	Format         string `json:"format"`
+	TotalRecords   int    `json:"total_records"`
+	SuccessRecords int    `json:"success_records"`
+	ErrorRecords   int    `json:"error_records"`
+	SkippedRecords int    `json:"skipped_records"`
 }
 
 // ReportChannel representa los canales de notificación
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// MigrationResult resumen de una migración devuelto en la respuesta de POST /migrate
+type MigrationResult struct {
+	MigrationID     string `json:"migration_id"`
+	SHA256          string `json:"sha256,omitempty"` // SHA-256 del archivo recibido
+	TotalRecords    int    `json:"total_records"`
+	SuccessRecords  int    `json:"success_records"`
+	ErrorRecords    int    `json:"error_records"`
+	SkippedRecords  int    `json:"skipped_records"`
+	ConflictRecords int    `json:"conflict_records"`
+	ConflictPolicy  string `json:"conflict_policy"`
+
+	// Cifras de las transacciones guardadas
+	UsersAffected  int     `json:"users_affected"`
+	TotalAmount    float64 `json:"total_amount"`
+	AverageAmount  float64 `json:"average_amount"`
+	LargestAmount  float64 `json:"largest_amount"`
+	SmallestAmount float64 `json:"smallest_amount"`
+
+	// Rango de fechas de las transacciones guardadas (ausente si no se guardó ninguna)
+	DateRange *DateRange `json:"date_range,omitempty"`
+
+	// Primeros errores por línea; ErrorsTruncated indica que hay más que los listados
+	Errors          []string `json:"errors,omitempty"`
+	ErrorsTruncated bool     `json:"errors_truncated,omitempty"`
+
+	// Detalle por archivo cuando se sube un ZIP
+	Files []FileReport `json:"files,omitempty"`
+}
+
+// DateRange rango de fechas inclusivo
+type DateRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
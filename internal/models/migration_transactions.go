@@ -0,0 +1,8 @@
+package models
+
+// MigrationTransactions transacciones vigentes importadas por una migración
+type MigrationTransactions struct {
+	MigrationID  string            `json:"migration_id"`
+	Count        int               `json:"count"`
+	Transactions []UserTransaction `json:"transactions"`
+}
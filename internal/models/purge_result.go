@@ -0,0 +1,7 @@
+package models
+
+// PurgeResult transacciones eliminadas definitivamente por una purga
+type PurgeResult struct {
+	PurgedCount  int               `json:"purged_count"`
+	Transactions []UserTransaction `json:"transactions"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Acciones de la reversión de una migración sobre cada transacción
+const (
+	RollbackRestored = "restored" // La transacción vuelve al valor que tenía antes de la migración
+	RollbackDeleted  = "deleted"  // La transacción la creó la migración y se elimina
+)
+
+// RollbackReport resultado de revertir una migración
+type RollbackReport struct {
+	MigrationID string    `json:"migration_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	DryRun      bool      `json:"dry_run,omitempty"` // true si solo se calculó la reversión, sin aplicarla
+
+	RestoredRecords int `json:"restored_records"`
+	DeletedRecords  int `json:"deleted_records"`
+	SkippedRecords  int `json:"skipped_records"` // Transacciones que cambiaron después de la migración y no se tocan
+	UsersAffected   int `json:"users_affected"`
+
+	// Detalle por transacción; Truncated indica que hay más cambios u omisiones que los listados
+	Changes   []RollbackChange `json:"changes,omitempty"`
+	Skipped   []string         `json:"skipped,omitempty"`
+	Truncated bool             `json:"truncated,omitempty"`
+}
+
+// RollbackChange cambio de una transacción al revertir la migración
+type RollbackChange struct {
+	TransactionID int              `json:"transaction_id"`
+	Action        string           `json:"action"`
+	Reverted      UserTransaction  `json:"reverted"`           // Valor que había escrito la migración
+	Restored      *UserTransaction `json:"restored,omitempty"` // Valor anterior que se restaura (ausente si se elimina)
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// TransactionVersion es una versión guardada de una transacción
+type TransactionVersion struct {
+	Version     int             `json:"version"`
+	Transaction UserTransaction `json:"transaction"`
+	ChangedAt   time.Time       `json:"changed_at"`
+	MigrationID string          `json:"migration_id,omitempty"` // Migración que escribió esta versión (vacío si no vino de una migración)
+}
+
+// TransactionHistory historial de versiones de una transacción, de la más antigua a la actual
+type TransactionHistory struct {
+	TransactionID  int                  `json:"transaction_id"`
+	CurrentVersion int                  `json:"current_version"`
+	Versions       []TransactionVersion `json:"versions"`
+}
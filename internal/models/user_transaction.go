@@ -10,4 +10,16 @@ type UserTransaction struct {
 	UserID   int       `json:"user_id"`
 	Amount   float64   `json:"amount"`
 	DateTime time.Time `json:"datetime"`
+
+	// Procedencia: de qué migración, archivo y línea se importó (vacío si no vino de una migración)
+	MigrationID string     `json:"migration_id,omitempty"`
+	SourceFile  string     `json:"source_file,omitempty"`
+	SourceLine  int        `json:"source_line,omitempty"`
+	ImportedAt  *time.Time `json:"imported_at,omitempty"`
+	ExternalID  string     `json:"external_id,omitempty"` // Clave en el sistema de origen (p. ej. "ofx:<cuenta>:<FITID>" en extractos)
+
+	// Borrado lógico: la transacción se conserva para auditoría pero no cuenta en los balances
+	Deleted      bool       `json:"deleted,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	DeleteReason string     `json:"delete_reason,omitempty"`
 }
@@ -4,45 +4,156 @@ import (
 	"api-stori/internal/config"
 	"api-stori/internal/handlers"
 	"api-stori/internal/services"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// NewTransactionRepository crea la implementación del repositorio indicada en la configuración
+func NewTransactionRepository(dbConfig config.DatabaseConfig) (services.TransactionRepository, error) {
+	switch dbConfig.Driver {
+	case "", config.DatabaseDriverMock:
+		if dbConfig.WALDir == "" {
+			return services.NewMockDatabase(), nil
+		}
+		return services.NewPersistentMockDatabase(services.PersistenceOptions{
+			Dir:           dbConfig.WALDir,
+			SnapshotEvery: dbConfig.SnapshotEvery,
+			Recovery:      services.RecoveryMode(dbConfig.WALRecovery),
+		})
+	case config.DatabaseDriverSQLite:
+		return services.NewSQLiteDatabase(dbConfig.Path)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", dbConfig.Driver)
+	}
+}
+
 func SetupRoutes(router *mux.Router) {
 	SetupRoutesConfigDetail(router, true)
 }
 
 // SetupRoutes configura todas las rutas de la API
 func SetupRoutesConfigDetail(router *mux.Router, allowSendEmail bool) {
-	// Crear instancias de servicios
-	mockDB := services.NewMockDatabase()
-	migrationService := services.NewMigrationService(mockDB)
-	usersService := services.NewUsersService(mockDB)
-
 	// Cargar configuración desde variables de entorno
 	appConfig := config.LoadConfig()
 
+	// Crear el repositorio de transacciones según la configuración
+	repository, err := NewTransactionRepository(appConfig.Database)
+	if err != nil {
+		log.Fatalf("Error initializing transaction repository: %v", err)
+	}
+
+	// Crear instancias de servicios
+	migrationService := services.NewMigrationService(repository)
+	usersService := services.NewUsersService(repository)
+	transactionsService := services.NewTransactionsService(repository)
+	snapshotService := services.NewSnapshotService(repository)
+
+	// Archivo de transacciones antiguas; las consultas de balance lo leen de forma transparente
+	archiveService, err := services.NewArchiveService(repository, appConfig.Archive.Dir, appConfig.Archive.RetentionDays)
+	if err != nil {
+		log.Fatalf("Error initializing transaction archive: %v", err)
+	}
+	usersService.SetArchive(archiveService)
+	transactionsService.SetArchive(archiveService)
+	migrationService.SetTransactionArchive(archiveService)
+	if appConfig.Archive.RetentionDays > 0 {
+		archiveService.StartRetention(appConfig.Archive.CheckInterval)
+	}
+
 	// Configurar servicio de reportes
 	reportService := services.NewReportService(appConfig.ToReportConfig())
 	if !allowSendEmail {
 		reportService.SetForceMockMode(true)
 	}
 	migrationService.SetReportService(reportService)
+	migrationService.SetArchiveLimits(services.ArchiveLimits{
+		MaxUncompressedSize: appConfig.Migrate.MaxUncompressedSize,
+		MaxEntries:          appConfig.Migrate.MaxArchiveEntries,
+	})
+
+	// Perfiles de columnas para archivos de terceros (?mapping_profile=nombre)
+	if appConfig.Migrate.MappingFile != "" {
+		profiles, err := services.LoadColumnMappingProfiles(appConfig.Migrate.MappingFile)
+		if err != nil {
+			log.Fatalf("Error loading column mapping profiles: %v", err)
+		}
+		migrationService.SetMappingProfiles(profiles)
+	}
+
+	// Usuario de cada cuenta de los extractos bancarios OFX/QIF
+	if appConfig.Migrate.AccountsFile != "" {
+		accountUsers, err := services.LoadAccountUsers(appConfig.Migrate.AccountsFile)
+		if err != nil {
+			log.Fatalf("Error loading statement accounts: %v", err)
+		}
+		migrationService.SetAccountUsers(accountUsers)
+	}
+
+	// Cola de migraciones asíncronas (?async=true)
+	migrationJobs, err := services.NewMigrationJobQueue(migrationService, appConfig.Migrate.Workers, appConfig.Migrate.QueueSize, appConfig.Migrate.SpoolDir)
+	if err != nil {
+		log.Fatalf("Error initializing migration queue: %v", err)
+	}
 
 	// Crear handlers
 	migrationHandler := handlers.NewMigrationHandler(migrationService)
+	migrationHandler.SetJobQueue(migrationJobs)
+	migrationHandler.SetLegacyResponse(appConfig.Migrate.LegacyResponse)
+	if appConfig.Migrate.IdempotencyTTL > 0 {
+		uploads, err := services.NewUploadRegistry(appConfig.Migrate.IdempotencyTTL, appConfig.Migrate.IdempotencyMaxUploads, appConfig.Migrate.SpoolDir)
+		if err != nil {
+			log.Fatalf("Error initializing upload registry: %v", err)
+		}
+		migrationHandler.SetUploadRegistry(uploads)
+	}
+
+	// Subidas reanudables de archivos grandes; las abandonadas se borran al vencer
+	resumableUploads, err := services.NewResumableUploadStore(appConfig.Migrate.UploadDir, appConfig.Migrate.UploadTTL, appConfig.Migrate.UploadMaxSize)
+	if err != nil {
+		log.Fatalf("Error initializing resumable uploads: %v", err)
+	}
+	resumableUploads.StartExpiry(min(appConfig.Migrate.UploadTTL, time.Hour))
+	migrationHandler.SetResumableUploads(resumableUploads)
+
 	balanceHandler := handlers.NewBalanceHandler(usersService)
+	transactionHandler := handlers.NewTransactionHandler(transactionsService)
+	snapshotHandler := handlers.NewSnapshotHandler(snapshotService, appConfig.App.AdminToken)
+	archiveHandler := handlers.NewArchiveHandler(archiveService, appConfig.App.AdminToken)
+	purgeHandler := handlers.NewPurgeHandler(transactionsService, appConfig.App.AdminToken)
 
 	// Configurar rutas de la API
 	api := router.PathPrefix("/api/v1").Subrouter()
 
 	// Migration Service routes
 	api.HandleFunc("/migrate", migrationHandler.MigrateCSV).Methods("POST")
+	api.HandleFunc("/migrate/validate", migrationHandler.ValidateCSV).Methods("POST")
+	api.HandleFunc("/uploads", migrationHandler.CreateUpload).Methods("POST")
+	api.HandleFunc("/uploads", migrationHandler.UploadOptions).Methods("OPTIONS")
+	api.HandleFunc("/uploads/{id}", migrationHandler.GetUploadOffset).Methods("HEAD")
+	api.HandleFunc("/uploads/{id}", migrationHandler.PatchUpload).Methods("PATCH")
+	api.HandleFunc("/uploads/{id}", migrationHandler.DeleteUpload).Methods("DELETE")
 
 	// Balance Service routes
 	api.HandleFunc("/users/{user_id}/balance", balanceHandler.GetUserBalance).Methods("GET")
 
+	// Transaction routes
+	api.HandleFunc("/transactions/{id}", transactionHandler.DeleteTransaction).Methods("DELETE")
+	api.HandleFunc("/transactions/{id}/history", transactionHandler.GetTransactionHistory).Methods("GET")
+	api.HandleFunc("/migrations/{id}", migrationHandler.GetMigrationJob).Methods("GET")
+	api.HandleFunc("/migrations/{id}", migrationHandler.RollbackMigration).Methods("DELETE")
+	api.HandleFunc("/migrations/{id}/transactions", transactionHandler.GetMigrationTransactions).Methods("GET")
+
+	// Admin routes
+	api.HandleFunc("/admin/snapshot", snapshotHandler.ExportSnapshot).Methods("GET")
+	api.HandleFunc("/admin/snapshot", snapshotHandler.ImportSnapshot).Methods("POST")
+	api.HandleFunc("/admin/archive", archiveHandler.GetArchiveStatus).Methods("GET")
+	api.HandleFunc("/admin/archive", archiveHandler.RunArchive).Methods("POST")
+	api.HandleFunc("/admin/purge", purgeHandler.PurgeDeletedTransactions).Methods("POST")
+
 	// Health check
 	api.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -113,7 +224,19 @@ func SetupRoutesConfigDetail(router *mux.Router, allowSendEmail bool) {
 			"version": "1.0.0",
 			"endpoints": {
 				"migrate": "POST /api/v1/migrate",
+				"migrate_validate": "POST /api/v1/migrate/validate",
+				"upload_create": "POST /api/v1/uploads",
+				"upload_resume": "PATCH /api/v1/uploads/{id}",
 				"balance": "GET /api/v1/users/{user_id}/balance",
+				"transaction_delete": "DELETE /api/v1/transactions/{id}",
+				"transaction_history": "GET /api/v1/transactions/{id}/history",
+				"migration_status": "GET /api/v1/migrations/{id}",
+				"migration_transactions": "GET /api/v1/migrations/{id}/transactions",
+				"snapshot_export": "GET /api/v1/admin/snapshot",
+				"snapshot_import": "POST /api/v1/admin/snapshot",
+				"archive_status": "GET /api/v1/admin/archive",
+				"archive_run": "POST /api/v1/admin/archive",
+				"purge": "POST /api/v1/admin/purge",
 				"health": "GET /api/v1/health"
 			},
 			"documentation": {
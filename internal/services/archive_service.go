@@ -0,0 +1,672 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveRunsFile registro de ejecuciones dentro del directorio de archivo (una ejecución JSON por línea)
+const archiveRunsFile = "runs.jsonl"
+
+// archiveDateLayout formato de la fecha de cada partición
+const archiveDateLayout = "2006-01-02"
+
+// ArchiveService mueve las transacciones antiguas del almacén a archivos comprimidos por fecha
+// y las lee de vuelta cuando una consulta llega a periodos archivados.
+//
+// Cada partición es <dir>/YYYY/MM/YYYY-MM-DD.jsonl.gz; cada escritura agrega un miembro gzip nuevo
+// al final del archivo, por lo que las particiones nunca se reescriben. Para sacar una transacción del
+// archivo se agrega una marca de eliminación (archive_removed) que oculta las copias anteriores de la partición.
+type ArchiveService struct {
+	database      TransactionRepository
+	dir           string
+	retentionDays int
+
+	runMutex sync.Mutex   // Serializa las ejecuciones y las eliminaciones del archivo
+	mutex    sync.RWMutex // Particiones, índice y registro de ejecuciones; las escrituras lo toman por partición
+	runs     []models.ArchiveRun
+	dates    []string                // Fechas de las particiones existentes, ordenadas
+	users    map[int]map[string]bool // Fechas de las particiones con transacciones de cada usuario
+	ids      map[int]map[string]bool // Fechas de las particiones con una copia vigente de cada ID
+	stop     chan struct{}
+}
+
+// archiveEntry línea de una partición: una copia de la transacción o, con Removed, su marca de eliminación
+type archiveEntry struct {
+	models.UserTransaction
+	Removed bool `json:"archive_removed,omitempty"`
+}
+
+// NewArchiveService crea el servicio de archivo sobre dir, cargando las ejecuciones previas y el índice
+// de particiones. retentionDays es la antigüedad usada por ArchiveExpired (0 desactiva el archivado automático).
+func NewArchiveService(database TransactionRepository, dir string, retentionDays int) (*ArchiveService, error) {
+	as := &ArchiveService{
+		database:      database,
+		dir:           dir,
+		retentionDays: retentionDays,
+		users:         make(map[int]map[string]bool),
+		ids:           make(map[int]map[string]bool),
+	}
+
+	if err := as.loadRuns(); err != nil {
+		return nil, err
+	}
+	if err := as.loadIndex(); err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// RetentionCutoff retorna el inicio (UTC) del día de hace days días: las transacciones anteriores se archivan
+func RetentionCutoff(now time.Time, days int) time.Time {
+	year, month, day := now.UTC().AddDate(0, 0, -days).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// RetentionDays retorna la retención configurada en días (0 si el archivado automático está desactivado)
+func (as *ArchiveService) RetentionDays() int {
+	return as.retentionDays
+}
+
+// ArchiveExpired archiva las transacciones más antiguas que la retención configurada
+func (as *ArchiveService) ArchiveExpired() (*models.ArchiveRun, error) {
+	if as.retentionDays <= 0 {
+		return nil, fmt.Errorf("retention is not configured")
+	}
+	return as.ArchiveOlderThan(RetentionCutoff(time.Now(), as.retentionDays))
+}
+
+// ArchiveOlderThan mueve al archivo las transacciones con fecha anterior a cutoff, una partición a la vez.
+// Cada partición se escribe y sincroniza antes de eliminar sus transacciones del almacén, y solo se eliminan
+// las que siguen iguales a la copia archivada; las modificadas mientras tanto se quedan en el almacén y su
+// copia se marca como eliminada del archivo. Una transacción nunca queda fuera de ambos lados.
+// Las lecturas solo esperan mientras se escribe cada partición.
+func (as *ArchiveService) ArchiveOlderThan(cutoff time.Time) (*models.ArchiveRun, error) {
+	as.runMutex.Lock()
+	defer as.runMutex.Unlock()
+
+	run := models.ArchiveRun{
+		RunID:     fmt.Sprintf("arch-%s", time.Now().UTC().Format("20060102150405.000000")),
+		StartedAt: time.Now().UTC(),
+		Cutoff:    cutoff.UTC(),
+	}
+
+	// Agrupar por fecha (UTC) las transacciones a archivar
+	byDate := make(map[string][]models.UserTransaction)
+	err := as.database.ForEachTransaction(func(transaction models.UserTransaction) error {
+		if transaction.DateTime.Before(cutoff) {
+			date := transaction.DateTime.UTC().Format(archiveDateLayout)
+			byDate[date] = append(byDate[date], transaction)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading transactions to archive: %v", err)
+	}
+
+	dates := make([]string, 0, len(byDate))
+	for date := range byDate {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	for _, date := range dates {
+		partition, err := as.archivePartition(date, byDate[date])
+		if err != nil {
+			return nil, err
+		}
+		if partition.Count > 0 {
+			run.Partitions = append(run.Partitions, partition)
+			run.ArchivedCount += partition.Count
+		}
+	}
+
+	run.FinishedAt = time.Now().UTC()
+	if err := as.appendRun(run); err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// archivePartition archiva las transacciones de una fecha: escribe las copias, elimina del almacén
+// las que no cambiaron y marca como eliminadas del archivo las copias de las que sí.
+// Las copias de los mismos IDs en otras particiones (archivados antes con otra fecha) se marcan como
+// eliminadas antes de borrar del almacén; mientras el ID sigue en el almacén esas copias no se leen.
+func (as *ArchiveService) archivePartition(date string, transactions []models.UserTransaction) (models.ArchivePartition, error) {
+	archived := make(map[int]models.UserTransaction, len(transactions))
+	ids := make([]int, len(transactions))
+	for i, transaction := range transactions {
+		archived[transaction.ID] = transaction
+		ids[i] = transaction.ID
+	}
+
+	file, err := as.appendPartition(date, transactions, false)
+	if err != nil {
+		return models.ArchivePartition{}, err
+	}
+
+	for otherDate, stale := range as.copiesInOtherPartitions(date, transactions) {
+		if _, err := as.appendPartition(otherDate, stale, true); err != nil {
+			return models.ArchivePartition{}, err
+		}
+	}
+
+	deleted, err := as.database.DeleteTransactionsIf(ids, func(current models.UserTransaction) bool {
+		return sameStoredTransaction(current, archived[current.ID])
+	})
+	if err != nil {
+		return models.ArchivePartition{}, fmt.Errorf("error removing archived transactions: %v", err)
+	}
+
+	// Hasta marcarlas, las copias de las transacciones que cambiaron quedan ocultas por el almacén
+	if len(deleted) < len(transactions) {
+		for _, transaction := range deleted {
+			delete(archived, transaction.ID)
+		}
+		changed := make([]models.UserTransaction, 0, len(archived))
+		for _, transaction := range transactions {
+			if _, kept := archived[transaction.ID]; kept {
+				changed = append(changed, transaction)
+			}
+		}
+		if _, err := as.appendPartition(date, changed, true); err != nil {
+			return models.ArchivePartition{}, err
+		}
+	}
+
+	return models.ArchivePartition{Date: date, File: file, Count: len(deleted)}, nil
+}
+
+// copiesInOtherPartitions agrupa por fecha las transacciones que, según el índice, tienen una copia
+// vigente en una partición distinta de date
+func (as *ArchiveService) copiesInOtherPartitions(date string, transactions []models.UserTransaction) map[string][]models.UserTransaction {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	byDate := make(map[string][]models.UserTransaction)
+	for _, transaction := range transactions {
+		for otherDate := range as.ids[transaction.ID] {
+			if otherDate != date {
+				byDate[otherDate] = append(byDate[otherDate], transaction)
+			}
+		}
+	}
+	return byDate
+}
+
+// GetTransactionsByUserIDWithDateRange lee del archivo las transacciones de un usuario en el rango
+// indicado (límites inclusivos, nil = sin límite). Solo abre las particiones del rango que, según el
+// índice, tienen transacciones del usuario.
+func (as *ArchiveService) GetTransactionsByUserIDWithDateRange(userID int, fromDate, toDate *time.Time) ([]models.UserTransaction, error) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	userDates := as.users[userID]
+	if len(userDates) == 0 {
+		return nil, nil
+	}
+
+	var transactions []models.UserTransaction
+	for _, date := range as.datesInRange(fromDate, toDate) {
+		if !userDates[date] {
+			continue
+		}
+
+		partition, err := as.readPartitionTransactions(date, func(transaction models.UserTransaction) bool {
+			return transaction.UserID == userID &&
+				(fromDate == nil || !transaction.DateTime.Before(*fromDate)) &&
+				(toDate == nil || !transaction.DateTime.After(*toDate))
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, transaction := range partition {
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		if !transactions[i].DateTime.Equal(transactions[j].DateTime) {
+			return transactions[i].DateTime.Before(transactions[j].DateTime)
+		}
+		return transactions[i].ID < transactions[j].ID
+	})
+
+	return transactions, nil
+}
+
+// FindTransactions recorre todo el archivo y retorna las transacciones archivadas que cumplen match, ordenadas por ID
+func (as *ArchiveService) FindTransactions(match func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	byDate, err := as.findByDate(match)
+	if err != nil {
+		return nil, err
+	}
+	return flattenByID(byDate), nil
+}
+
+// RemoveTransactions saca del archivo las transacciones archivadas que cumplen match (marcándolas como
+// eliminadas en su partición) y las retorna ordenadas por ID. Se usa al purgar y al revertir migraciones.
+func (as *ArchiveService) RemoveTransactions(match func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	as.runMutex.Lock()
+	defer as.runMutex.Unlock()
+
+	// Con runMutex tomado nadie más escribe particiones: la búsqueda no necesita bloquear las lecturas
+	as.mutex.RLock()
+	byDate, err := as.findByDate(match)
+	as.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for date, transactions := range byDate {
+		if _, err := as.appendPartition(date, transactions, true); err != nil {
+			return nil, err
+		}
+	}
+	return flattenByID(byDate), nil
+}
+
+// Status retorna la configuración de retención y las ejecuciones registradas
+func (as *ArchiveService) Status() *models.ArchiveStatus {
+	as.mutex.RLock()
+	defer as.mutex.RUnlock()
+
+	status := &models.ArchiveStatus{
+		ArchiveDir:    as.dir,
+		RetentionDays: as.retentionDays,
+		ArchivedUntil: as.archivedUntil(),
+		Runs:          make([]models.ArchiveRun, len(as.runs)),
+	}
+	copy(status.Runs, as.runs)
+	for _, run := range as.runs {
+		status.TotalArchived += run.ArchivedCount
+	}
+
+	return status
+}
+
+// StartRetention ejecuta ArchiveExpired ahora y luego cada interval hasta que se llame a Stop
+func (as *ArchiveService) StartRetention(interval time.Duration) {
+	as.mutex.Lock()
+	if as.stop != nil {
+		as.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	as.stop = stop
+	as.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if run, err := as.ArchiveExpired(); err != nil {
+				log.Printf("Error archiving expired transactions: %v", err)
+			} else if run.ArchivedCount > 0 {
+				log.Printf("Archived %d transactions older than %s", run.ArchivedCount, run.Cutoff.Format(archiveDateLayout))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop detiene el archivado periódico iniciado con StartRetention
+func (as *ArchiveService) Stop() {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	if as.stop != nil {
+		close(as.stop)
+		as.stop = nil
+	}
+}
+
+// archivedUntil retorna el mayor cutoff de las ejecuciones que archivaron algo (nil si ninguna).
+// Debe llamarse con mutex tomado.
+func (as *ArchiveService) archivedUntil() *time.Time {
+	var until *time.Time
+	for i := range as.runs {
+		if as.runs[i].ArchivedCount > 0 && (until == nil || as.runs[i].Cutoff.After(*until)) {
+			until = &as.runs[i].Cutoff
+		}
+	}
+	if until == nil {
+		return nil
+	}
+	result := *until
+	return &result
+}
+
+// appendPartition agrega las transacciones (o con removed sus marcas de eliminación) como un miembro gzip
+// nuevo al final de la partición de la fecha, y actualiza el índice
+func (as *ArchiveService) appendPartition(date string, transactions []models.UserTransaction, removed bool) (string, error) {
+	relative := filepath.Join(date[:4], date[5:7], date+".jsonl.gz")
+	path := filepath.Join(as.dir, relative)
+
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive partition %s: %v", relative, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive partition %s: %v", relative, err)
+	}
+
+	if err := writePartitionMember(file, transactions, removed); err != nil {
+		// Un miembro a medias no puede quedar antes del siguiente: se descarta. Si el recorte también
+		// falla, la lectura lo ignora mientras sea el último y al reiniciar se recorta.
+		file.Truncate(info.Size())
+		return "", fmt.Errorf("failed to write archive partition %s: %v", relative, err)
+	}
+
+	for _, transaction := range transactions {
+		as.indexTransaction(date, transaction, removed)
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+// writePartitionMember escribe las transacciones (o sus marcas de eliminación) como un miembro gzip y lo sincroniza
+func writePartitionMember(file *os.File, transactions []models.UserTransaction, removed bool) error {
+	gzipWriter := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gzipWriter)
+	for _, transaction := range transactions {
+		if err := encoder.Encode(archiveEntry{UserTransaction: transaction, Removed: removed}); err != nil {
+			return err
+		}
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// indexTransaction registra la partición en el índice; una marca de eliminación quita la copia vigente
+// del ID en esa partición. Debe llamarse con mutex tomado en escritura y en el orden de las líneas.
+func (as *ArchiveService) indexTransaction(date string, transaction models.UserTransaction, removed bool) {
+	if removed {
+		delete(as.ids[transaction.ID], date)
+		if len(as.ids[transaction.ID]) == 0 {
+			delete(as.ids, transaction.ID)
+		}
+	} else {
+		if as.ids[transaction.ID] == nil {
+			as.ids[transaction.ID] = make(map[string]bool)
+		}
+		as.ids[transaction.ID][date] = true
+	}
+
+	position := sort.SearchStrings(as.dates, date)
+	if position == len(as.dates) || as.dates[position] != date {
+		as.dates = append(as.dates, "")
+		copy(as.dates[position+1:], as.dates[position:])
+		as.dates[position] = date
+	}
+
+	if as.users[transaction.UserID] == nil {
+		as.users[transaction.UserID] = make(map[string]bool)
+	}
+	as.users[transaction.UserID][date] = true
+}
+
+// datesInRange retorna las fechas de partición que pueden tener transacciones en el rango (nil = sin límite).
+// Debe llamarse con mutex tomado.
+func (as *ArchiveService) datesInRange(fromDate, toDate *time.Time) []string {
+	start, end := 0, len(as.dates)
+	if fromDate != nil {
+		start = sort.SearchStrings(as.dates, fromDate.UTC().Format(archiveDateLayout))
+	}
+	if toDate != nil {
+		last := toDate.UTC().Format(archiveDateLayout)
+		end = sort.Search(len(as.dates), func(i int) bool { return as.dates[i] > last })
+	}
+	if start >= end {
+		return nil
+	}
+	return as.dates[start:end]
+}
+
+// partitionPath retorna la ruta de la partición de una fecha
+func (as *ArchiveService) partitionPath(date string) string {
+	return filepath.Join(as.dir, date[:4], date[5:7], date+".jsonl.gz")
+}
+
+// readPartitionTransactions retorna las transacciones vigentes de la partición de la fecha que cumplen match:
+// la última copia de cada ID, salvo que después se haya marcado como eliminada. Debe llamarse con mutex tomado.
+func (as *ArchiveService) readPartitionTransactions(date string, match func(models.UserTransaction) bool) (map[int]models.UserTransaction, error) {
+	byID := make(map[int]models.UserTransaction)
+	_, err := readPartition(as.partitionPath(date), func(entry archiveEntry) {
+		if entry.Removed {
+			delete(byID, entry.ID)
+		} else if match(entry.UserTransaction) {
+			byID[entry.ID] = entry.UserTransaction
+		} else {
+			delete(byID, entry.ID) // Una copia posterior que no cumple reemplaza a la anterior
+		}
+	})
+	return byID, err
+}
+
+// findByDate recorre todas las particiones y agrupa por fecha las transacciones vigentes que cumplen match.
+// Debe llamarse con mutex tomado.
+func (as *ArchiveService) findByDate(match func(models.UserTransaction) bool) (map[string][]models.UserTransaction, error) {
+	byDate := make(map[string][]models.UserTransaction)
+	for _, date := range as.dates {
+		partition, err := as.readPartitionTransactions(date, match)
+		if err != nil {
+			return nil, err
+		}
+		for _, transaction := range partition {
+			byDate[date] = append(byDate[date], transaction)
+		}
+	}
+	return byDate, nil
+}
+
+// flattenByID une las transacciones de todas las fechas ordenadas por ID
+func flattenByID(byDate map[string][]models.UserTransaction) []models.UserTransaction {
+	var transactions []models.UserTransaction
+	for _, partition := range byDate {
+		transactions = append(transactions, partition...)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].ID < transactions[j].ID
+	})
+	return transactions
+}
+
+// appendRun agrega la ejecución al registro de ejecuciones (archivo y memoria)
+func (as *ArchiveService) appendRun(run models.ArchiveRun) error {
+	if err := os.MkdirAll(as.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(as.dir, archiveRunsFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive runs file: %v", err)
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(run); err != nil {
+		return fmt.Errorf("failed to write archive run: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync archive runs file: %v", err)
+	}
+
+	as.mutex.Lock()
+	as.runs = append(as.runs, run)
+	as.mutex.Unlock()
+	return nil
+}
+
+// loadRuns lee el registro de ejecuciones; si no existe el archivo aún no hay nada archivado
+func (as *ArchiveService) loadRuns() error {
+	file, err := os.Open(filepath.Join(as.dir, archiveRunsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open archive runs file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var run models.ArchiveRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return fmt.Errorf("invalid archive run: %v", err)
+		}
+		as.runs = append(as.runs, run)
+	}
+	return scanner.Err()
+}
+
+// loadIndex arma el índice de particiones leyendo una vez todas las existentes
+func (as *ArchiveService) loadIndex() error {
+	files, err := filepath.Glob(filepath.Join(as.dir, "*", "*", "*.jsonl.gz"))
+	if err != nil {
+		return fmt.Errorf("error listing archive partitions: %v", err)
+	}
+
+	for _, file := range files {
+		date := strings.TrimSuffix(filepath.Base(file), ".jsonl.gz")
+		if _, err := time.Parse(archiveDateLayout, date); err != nil {
+			continue // No es una partición
+		}
+		valid, err := readPartition(file, func(entry archiveEntry) {
+			as.indexTransaction(date, entry.UserTransaction, entry.Removed)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Igual que el log de MockDatabase: la cola incompleta se descarta para que la próxima escritura
+		// no quede detrás de ella
+		if info, err := os.Stat(file); err == nil && info.Size() > valid {
+			if err := os.Truncate(file, valid); err != nil {
+				return fmt.Errorf("failed to truncate archive partition %s: %v", filepath.Base(file), err)
+			}
+		}
+	}
+	return nil
+}
+
+// partitionReader cuenta los bytes comprimidos consumidos, para saber dónde termina cada miembro gzip
+type partitionReader struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func (r *partitionReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *partitionReader) ReadByte() (byte, error) {
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.offset++
+	}
+	return b, err
+}
+
+// readPartition recorre en orden las líneas de una partición, un miembro gzip a la vez, y retorna el
+// tamaño hasta el final del último miembro completo. Las líneas de un miembro se entregan solo si el
+// miembro se leyó entero. Un último miembro incompleto (una escritura interrumpida) se ignora y se
+// registra en el log; cualquier otro error de lectura se retorna.
+func readPartition(path string, visit func(archiveEntry)) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive partition: %v", err)
+	}
+	defer file.Close()
+
+	reader := &partitionReader{reader: bufio.NewReader(file)}
+	var valid int64 // Fin del último miembro completo
+	var gzipReader *gzip.Reader
+	for {
+		// Solo un miembro que empieza como gzip puede ser una escritura interrumpida; otra cosa es corrupción
+		if head, _ := reader.reader.Peek(2); !bytes.HasPrefix([]byte{0x1f, 0x8b}, head) {
+			return 0, fmt.Errorf("failed to read archive partition %s: invalid gzip member at offset %d", filepath.Base(path), valid)
+		}
+
+		var err error
+		if gzipReader == nil {
+			gzipReader, err = gzip.NewReader(reader)
+		} else {
+			err = gzipReader.Reset(reader)
+		}
+		if err == io.EOF {
+			return valid, nil
+		}
+
+		var entries []archiveEntry
+		if err == nil {
+			gzipReader.Multistream(false)
+			entries, err = readPartitionMember(gzipReader)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("Ignoring incomplete archive member at the end of %s after %d bytes", filepath.Base(path), valid)
+			return valid, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read archive partition %s: %v", filepath.Base(path), err)
+		}
+
+		for _, entry := range entries {
+			visit(entry)
+		}
+		valid = reader.offset
+	}
+}
+
+// readPartitionMember lee las líneas del miembro gzip actual hasta su final
+func readPartitionMember(gzipReader *gzip.Reader) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	decoder := json.NewDecoder(gzipReader)
+	for {
+		var entry archiveEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -0,0 +1,394 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+	expected := time.Date(2024, 2, 9, 0, 0, 0, 0, time.UTC)
+	if cutoff := RetentionCutoff(now, 30); !cutoff.Equal(expected) {
+		t.Errorf("Expected cutoff %v, got %v", expected, cutoff)
+	}
+}
+
+func TestArchiveService_ArchiveOlderThan(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: -30, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1002, Amount: 50, DateTime: day(2)})
+	db.SaveTransaction(models.UserTransaction{ID: 4, UserID: 1001, Amount: 10, DateTime: day(20)})
+
+	archive, err := NewArchiveService(db, dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	cutoff := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	run, err := archive.ArchiveOlderThan(cutoff)
+	if err != nil {
+		t.Fatalf("Expected no error archiving, got %v", err)
+	}
+	if run.ArchivedCount != 3 || len(run.Partitions) != 2 {
+		t.Fatalf("Expected 3 transactions in 2 partitions, got %+v", run)
+	}
+	if run.Partitions[0].File != "2024/01/2024-01-01.jsonl.gz" || run.Partitions[0].Count != 2 {
+		t.Errorf("Unexpected first partition %+v", run.Partitions[0])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024", "01", "2024-01-02.jsonl.gz")); err != nil {
+		t.Errorf("Expected partition file for 2024-01-02, got %v", err)
+	}
+	if db.GetTransactionCount() != 1 {
+		t.Errorf("Expected only the recent transaction in the store, got %d", db.GetTransactionCount())
+	}
+
+	// Una segunda ejecución agrega un miembro gzip a la partición existente
+	db.SaveTransaction(models.UserTransaction{ID: 5, UserID: 1001, Amount: 5, DateTime: day(1).Add(time.Hour)})
+	if _, err := archive.ArchiveOlderThan(cutoff); err != nil {
+		t.Fatalf("Expected no error on second run, got %v", err)
+	}
+
+	archived, err := archive.GetTransactionsByUserIDWithDateRange(1001, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error reading archive, got %v", err)
+	}
+	if len(archived) != 3 || archived[0].ID != 1 || archived[2].ID != 5 {
+		t.Errorf("Expected archived transactions [1 2 5] of user 1001, got %+v", archived)
+	}
+
+	// Las ejecuciones se recuperan al reabrir el archivo
+	reopened, err := NewArchiveService(db, dir, 30)
+	if err != nil {
+		t.Fatalf("Expected no error reopening, got %v", err)
+	}
+	status := reopened.Status()
+	if len(status.Runs) != 2 || status.TotalArchived != 4 || status.RetentionDays != 30 {
+		t.Errorf("Unexpected status after reopen: %+v", status)
+	}
+	if status.ArchivedUntil == nil || !status.ArchivedUntil.Equal(cutoff) {
+		t.Errorf("Expected archived until %v, got %v", cutoff, status.ArchivedUntil)
+	}
+}
+
+func TestUsersService_GetUserBalanceReadsArchive(t *testing.T) {
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: -30, DateTime: day(5)})
+	db.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1001, Amount: 10, DateTime: day(20)})
+	db.SaveTransaction(models.UserTransaction{ID: 4, UserID: 1002, Amount: 70, DateTime: day(2)})
+
+	archive, err := NewArchiveService(db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := archive.ArchiveOlderThan(time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Expected no error archiving, got %v", err)
+	}
+
+	service := NewUsersService(db)
+	service.SetArchive(archive)
+
+	from := day(3)
+	to := day(31)
+	tests := []struct {
+		name     string
+		userID   int
+		from, to *time.Time
+		expected float64
+	}{
+		{"full history", 1001, nil, nil, 80},
+		{"range reaching the archive", 1001, &from, &to, -20},
+		{"range after the archive", 1001, &to, nil, 0},
+		{"user only in the archive", 1002, nil, nil, 70},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balance, err := service.GetUserBalance(tt.userID, tt.from, tt.to)
+			if tt.expected == 0 {
+				if err != ErrUserNotFound {
+					t.Errorf("Expected ErrUserNotFound, got %v (%+v)", err, balance)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if float64(balance.Balance) != tt.expected {
+				t.Errorf("Expected balance %.2f, got %.2f", tt.expected, float64(balance.Balance))
+			}
+		})
+	}
+
+	// Si el ID vuelve al almacén, su versión actual prevalece sobre la archivada
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 200, DateTime: day(1)})
+	if balance, _ := service.GetUserBalance(1001, nil, nil); float64(balance.Balance) != 180 {
+		t.Errorf("Expected balance 180 with the store version of transaction 1, got %.2f", float64(balance.Balance))
+	}
+}
+
+func TestArchiveService_KeepsTransactionsChangedDuringArchival(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: -30, DateTime: day(1)})
+
+	// La transacción 2 se recarga con otro monto entre la escritura de la partición y el borrado
+	repository := &reloadBeforeDelete{TransactionRepository: db,
+		reload: models.UserTransaction{ID: 2, UserID: 1001, Amount: -40, DateTime: day(1)}}
+	archive, _ := NewArchiveService(repository, dir, 0)
+
+	run, err := archive.ArchiveOlderThan(day(10))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if run.ArchivedCount != 1 || run.Partitions[0].Count != 1 {
+		t.Errorf("Expected only transaction 1 archived, got %+v", run)
+	}
+
+	// La recargada sigue en el almacén sin haberse borrado y restaurado, y su copia vieja no se lee
+	if tx, found := db.GetTransaction(2); !found || tx.Amount != -40 || len(db.GetTransactionHistory(2)) != 2 {
+		t.Errorf("Expected transaction 2 kept with 2 versions, got %+v", tx)
+	}
+	for _, service := range []*ArchiveService{archive, mustReopenArchive(t, db, dir)} {
+		archived, err := service.GetTransactionsByUserIDWithDateRange(1001, nil, nil)
+		if err != nil || len(archived) != 1 || archived[0].ID != 1 {
+			t.Errorf("Expected only transaction 1 in the archive, got %+v (%v)", archived, err)
+		}
+	}
+}
+
+func TestUsersService_ReArchivedTransactionWithNewDateCountedOnce(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+
+	archive, _ := NewArchiveService(db, dir, 0)
+	if _, err := archive.ArchiveOlderThan(day(10)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// La transacción vuelve al almacén con otra fecha y se archiva de nuevo en otra partición
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(3)})
+	if _, err := archive.ArchiveOlderThan(day(10)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, service := range []*ArchiveService{archive, mustReopenArchive(t, db, dir)} {
+		users := NewUsersService(db)
+		users.SetArchive(service)
+		balance, err := users.GetUserBalance(1001, nil, nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if float64(balance.Balance) != 100 {
+			t.Errorf("Expected balance 100 with transaction 1 counted once, got %.2f", float64(balance.Balance))
+		}
+
+		archived, _ := service.GetTransactionsByUserIDWithDateRange(1001, nil, nil)
+		if len(archived) != 1 || !archived[0].DateTime.Equal(day(3)) {
+			t.Errorf("Expected only the copy dated %v in the archive, got %+v", day(3), archived)
+		}
+	}
+}
+
+func TestArchiveService_ReadsOnlyIndexedPartitions(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1002, Amount: 50, DateTime: day(2)})
+
+	archive, _ := NewArchiveService(db, dir, 0)
+	if _, err := archive.ArchiveOlderThan(day(10)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Una partición ilegible solo falla a quien tiene que leerla
+	os.WriteFile(filepath.Join(dir, "2024", "01", "2024-01-02.jsonl.gz"), []byte("corrupted"), 0644)
+
+	if archived, err := archive.GetTransactionsByUserIDWithDateRange(1001, nil, nil); err != nil || len(archived) != 1 {
+		t.Errorf("Expected the partition of another user to be skipped, got %+v (%v)", archived, err)
+	}
+	from := day(3)
+	if archived, err := archive.GetTransactionsByUserIDWithDateRange(1002, &from, nil); err != nil || len(archived) != 0 {
+		t.Errorf("Expected partitions before the range to be skipped, got %+v (%v)", archived, err)
+	}
+	if _, err := archive.GetTransactionsByUserIDWithDateRange(1002, nil, nil); err == nil {
+		t.Error("Expected error reading the corrupted partition")
+	}
+}
+
+func TestArchiveService_IgnoresIncompleteLastMember(t *testing.T) {
+	dir := t.TempDir()
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+
+	archive, _ := NewArchiveService(db, dir, 0)
+	if _, err := archive.ArchiveOlderThan(day(10)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	path := filepath.Join(dir, "2024", "01", "2024-01-01.jsonl.gz")
+	good, _ := os.Stat(path)
+
+	// Segundo miembro cortado a la mitad, como si el proceso muriera durante la escritura
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 50, DateTime: day(1)})
+	if _, err := archive.ArchiveOlderThan(day(10)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	full, _ := os.Stat(path)
+	os.Truncate(path, good.Size()+(full.Size()-good.Size())/2)
+
+	if archived, err := archive.GetTransactionsByUserIDWithDateRange(1001, nil, nil); err != nil || len(archived) != 1 || archived[0].ID != 1 {
+		t.Errorf("Expected the complete member to be read, got %+v (%v)", archived, err)
+	}
+
+	// Al reabrir la cola incompleta se recorta y las escrituras siguientes quedan legibles
+	reopened := mustReopenArchive(t, db, dir)
+	if info, _ := os.Stat(path); info.Size() != good.Size() {
+		t.Errorf("Expected partition truncated to %d bytes, got %d", good.Size(), info.Size())
+	}
+	db.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1001, Amount: 25, DateTime: day(1)})
+	if _, err := reopened.ArchiveOlderThan(day(10)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if archived, err := reopened.GetTransactionsByUserIDWithDateRange(1001, nil, nil); err != nil || len(archived) != 2 {
+		t.Errorf("Expected transactions 1 and 3 in the archive, got %+v (%v)", archived, err)
+	}
+}
+
+func TestUsersService_ArchivedCopyHiddenByLiveTransaction(t *testing.T) {
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 5, DateTime: day(2)})
+
+	archive, _ := NewArchiveService(db, t.TempDir(), 0)
+	archive.ArchiveOlderThan(day(10))
+
+	// El ID vuelve al almacén con una fecha fuera del rango consultado: la copia archivada no cuenta
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(20)})
+
+	service := NewUsersService(db)
+	service.SetArchive(archive)
+	to := day(3)
+	balance, err := service.GetUserBalance(1001, nil, &to)
+	if err != nil || float64(balance.Balance) != 5 {
+		t.Errorf("Expected balance 5 without the stale archived copy, got %+v (%v)", balance, err)
+	}
+}
+
+func TestTransactionsService_PurgeRemovesArchivedTransactions(t *testing.T) {
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: -30, DateTime: day(1)})
+	db.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1001, Amount: 10, DateTime: day(20)})
+	db.SoftDeleteTransaction(2, "duplicated")
+	db.SoftDeleteTransaction(3, "duplicated")
+
+	archive, _ := NewArchiveService(db, t.TempDir(), 0)
+	archive.ArchiveOlderThan(day(10))
+
+	service := NewTransactionsService(db)
+	service.SetArchive(archive)
+	result, err := service.PurgeDeletedTransactions(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.PurgedCount != 2 || result.Transactions[0].ID != 2 || result.Transactions[1].ID != 3 {
+		t.Errorf("Expected transactions [2 3] purged from the archive and the store, got %+v", result)
+	}
+	archived, _ := archive.GetTransactionsByUserIDWithDateRange(1001, nil, nil)
+	if len(archived) != 1 || archived[0].ID != 1 {
+		t.Errorf("Expected only transaction 1 left in the archive, got %+v", archived)
+	}
+}
+
+func TestMigrationService_RollbackArchivedTransactions(t *testing.T) {
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+	db.SaveTransactions([]models.UserTransaction{
+		{ID: 1, UserID: 1001, Amount: 150, DateTime: day(1), MigrationID: "mig-1"},
+		{ID: 2, UserID: 1001, Amount: -30, DateTime: day(2), MigrationID: "mig-1"},
+	}, SaveOptions{MigrationID: "mig-1"})
+
+	archive, _ := NewArchiveService(db, t.TempDir(), 0)
+	archive.ArchiveOlderThan(day(10))
+
+	service := NewMigrationService(db)
+	service.SetTransactionArchive(archive)
+	report, err := service.RollbackMigration("mig-1", false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if report.RestoredRecords != 1 || report.DeletedRecords != 1 {
+		t.Errorf("Expected 1 restored and 1 deleted, got %+v", report)
+	}
+
+	// Las revertidas vuelven al almacén y salen del archivo
+	if tx, found := db.GetTransaction(1); !found || tx.Amount != 100 {
+		t.Errorf("Expected transaction 1 restored in the store, got %+v", tx)
+	}
+	if tx, found := db.GetTransaction(2); !found || !tx.Deleted {
+		t.Errorf("Expected transaction 2 deleted in the store, got %+v", tx)
+	}
+	if archived, _ := archive.GetTransactionsByUserIDWithDateRange(1001, nil, nil); len(archived) != 0 {
+		t.Errorf("Expected the archive to be empty, got %+v", archived)
+	}
+
+	users := NewUsersService(db)
+	users.SetArchive(archive)
+	if balance, _ := users.GetUserBalance(1001, nil, nil); float64(balance.Balance) != 100 {
+		t.Errorf("Expected balance 100 after rollback, got %.2f", float64(balance.Balance))
+	}
+}
+
+func TestMigrationService_RollbackRecreatedArchivedID(t *testing.T) {
+	db := NewMockDatabase()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: day(1)})
+
+	archive, _ := NewArchiveService(db, t.TempDir(), 0)
+	archive.ArchiveOlderThan(day(10))
+
+	// La migración vuelve a crear en el almacén el ID archivado
+	db.SaveTransactions([]models.UserTransaction{
+		{ID: 1, UserID: 1002, Amount: 40, DateTime: day(20), MigrationID: "mig-1"},
+	}, SaveOptions{MigrationID: "mig-1"})
+
+	service := NewMigrationService(db)
+	service.SetTransactionArchive(archive)
+	report, err := service.RollbackMigration("mig-1", false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if report.DeletedRecords != 1 || report.RestoredRecords != 0 {
+		t.Errorf("Expected the re-created ID to be deleted, got %+v", report)
+	}
+	if tx, _ := db.GetTransaction(1); !tx.Deleted || tx.UserID != 1002 {
+		t.Errorf("Expected the migration version deleted, got %+v", tx)
+	}
+}
+
+// mustReopenArchive abre de nuevo el archivo de dir, reconstruyendo su índice
+func mustReopenArchive(t *testing.T, db TransactionRepository, dir string) *ArchiveService {
+	archive, err := NewArchiveService(db, dir, 0)
+	if err != nil {
+		t.Fatalf("Expected no error reopening the archive, got %v", err)
+	}
+	return archive
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultChangeRetention cantidad de eventos recientes que se conservan para reanudar suscripciones
+const defaultChangeRetention = 1024
+
+// defaultSubscriptionBuffer tamaño del buffer de una suscripción si no se indica otro
+const defaultSubscriptionBuffer = 256
+
+// ChangeType tipo de cambio sobre una transacción
+type ChangeType string
+
+const (
+	// ChangeInsert se guardó una transacción con un ID nuevo
+	ChangeInsert ChangeType = "insert"
+	// ChangeOverwrite se reemplazó una transacción existente
+	ChangeOverwrite ChangeType = "overwrite"
+	// ChangeDelete se eliminó una transacción
+	ChangeDelete ChangeType = "delete"
+)
+
+// ErrSubscriberLagging indica que la suscripción se cerró porque su buffer se llenó
+var ErrSubscriberLagging = errors.New("subscriber fell behind and was disconnected")
+
+// ErrSequenceTooOld indica que los eventos pedidos ya no se conservan para reanudar
+var ErrSequenceTooOld = errors.New("requested sequence is no longer retained")
+
+// ErrSequenceAhead indica que se pidió reanudar desde una secuencia que aún no se publicó, por ejemplo
+// después de un reinicio (la secuencia no se persiste): los eventos nuevos repetirían números ya vistos
+var ErrSequenceAhead = errors.New("requested sequence has not been published")
+
+// ErrSubscriptionClosed indica que la suscripción fue cerrada por el consumidor
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// ChangeEvent describe un cambio confirmado en el almacén de transacciones.
+// Before es nil en inserciones y After es nil en eliminaciones.
+type ChangeEvent struct {
+	Seq       uint64                  `json:"seq"`
+	Type      ChangeType              `json:"type"`
+	Before    *models.UserTransaction `json:"before,omitempty"`
+	After     *models.UserTransaction `json:"after,omitempty"`
+	Timestamp time.Time               `json:"timestamp"`
+}
+
+// Subscription entrega los eventos de cambio en orden de secuencia
+type Subscription struct {
+	feed   *changeFeed
+	events chan ChangeEvent
+	err    error // Motivo del cierre; protegido por feed.mutex
+}
+
+// Events retorna el canal de eventos; se cierra cuando la suscripción termina
+func (s *Subscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Err retorna el motivo por el que se cerró la suscripción (nil mientras siga activa)
+func (s *Subscription) Err() error {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	return s.err
+}
+
+// Close cancela la suscripción y cierra el canal de eventos
+func (s *Subscription) Close() {
+	s.feed.mutex.Lock()
+	defer s.feed.mutex.Unlock()
+	s.feed.closeLocked(s, ErrSubscriptionClosed)
+}
+
+// changeFeed asigna números de secuencia a los cambios y los distribuye a los suscriptores.
+// Nunca bloquea al escritor: un suscriptor con el buffer lleno se desconecta con ErrSubscriberLagging
+// y puede reanudar desde el último Seq recibido mientras el evento siga retenido.
+type changeFeed struct {
+	mutex       sync.Mutex
+	seq         uint64
+	retained    []ChangeEvent // Últimos eventos en orden de secuencia
+	retention   int
+	subscribers map[*Subscription]struct{}
+}
+
+// newChangeFeed crea un feed que conserva los últimos retention eventos
+func newChangeFeed(retention int) *changeFeed {
+	if retention <= 0 {
+		retention = defaultChangeRetention
+	}
+	return &changeFeed{
+		retention:   retention,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// subscribe registra un suscriptor. Con fromSeq 0 recibe solo los cambios nuevos; con fromSeq > 0
+// recibe primero los eventos retenidos con Seq >= fromSeq y luego los nuevos. fromSeq puede ser a lo
+// sumo el siguiente número a publicar (ErrSequenceAhead si no).
+func (f *changeFeed) subscribe(fromSeq uint64, bufferSize int) (*Subscription, error) {
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriptionBuffer
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if fromSeq > f.seq+1 {
+		return nil, ErrSequenceAhead
+	}
+
+	var backlog []ChangeEvent
+	if fromSeq > 0 && fromSeq <= f.seq {
+		oldest := f.seq + 1
+		if len(f.retained) > 0 {
+			oldest = f.retained[0].Seq
+		}
+		if fromSeq < oldest {
+			return nil, ErrSequenceTooOld
+		}
+		backlog = f.retained[fromSeq-oldest:]
+	}
+
+	subscription := &Subscription{
+		feed:   f,
+		events: make(chan ChangeEvent, bufferSize+len(backlog)),
+	}
+	for _, event := range backlog {
+		subscription.events <- event
+	}
+
+	f.subscribers[subscription] = struct{}{}
+	return subscription, nil
+}
+
+// publish asigna secuencia a los eventos y los entrega en el orden en que se llama. El almacén lo llama
+// antes de soltar el lock que protege lo escrito (la partición del ID en MockDatabase, el lock de
+// escritura en SQLite), por lo que los cambios de una misma transacción se numeran en el orden en que
+// se confirmaron; cambios concurrentes de transacciones distintas pueden numerarse en cualquier orden.
+func (f *changeFeed) publish(events ...ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := time.Now()
+	for _, event := range events {
+		f.seq++
+		event.Seq = f.seq
+		event.Timestamp = now
+
+		f.retained = append(f.retained, event)
+		if len(f.retained) > f.retention {
+			f.retained = f.retained[len(f.retained)-f.retention:]
+		}
+
+		for subscription := range f.subscribers {
+			select {
+			case subscription.events <- event:
+			default:
+				f.closeLocked(subscription, ErrSubscriberLagging)
+			}
+		}
+	}
+}
+
+// lastSequence retorna la secuencia del último evento publicado
+func (f *changeFeed) lastSequence() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.seq
+}
+
+// closeLocked cierra una suscripción activa. Debe llamarse con f.mutex tomado.
+func (f *changeFeed) closeLocked(subscription *Subscription, reason error) {
+	if _, active := f.subscribers[subscription]; !active {
+		return
+	}
+	delete(f.subscribers, subscription)
+	subscription.err = reason
+	close(subscription.events)
+}
+
+// saveChangeEvent construye el evento de una escritura; retorna false si no hubo cambio
+func saveChangeEvent(result SaveResult) (ChangeEvent, bool) {
+	after := result.Transaction
+	switch result.Outcome {
+	case SaveInserted:
+		return ChangeEvent{Type: ChangeInsert, After: &after}, true
+	case SaveOverwritten:
+		return ChangeEvent{Type: ChangeOverwrite, Before: result.Previous, After: &after}, true
+	}
+	return ChangeEvent{}, false
+}
+
+// deleteChangeEvents construye los eventos de eliminación de las transacciones indicadas
+func deleteChangeEvents(transactions []models.UserTransaction) []ChangeEvent {
+	events := make([]ChangeEvent, len(transactions))
+	for i := range transactions {
+		before := transactions[i]
+		events[i] = ChangeEvent{Type: ChangeDelete, Before: &before}
+	}
+	return events
+}
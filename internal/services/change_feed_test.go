@@ -0,0 +1,155 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChangeFeed_OrderedDelivery(t *testing.T) {
+	feed := newChangeFeed(10)
+	subscription, err := feed.subscribe(0, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		feed.publish(ChangeEvent{Type: ChangeInsert, After: &models.UserTransaction{ID: i}})
+	}
+
+	for i := 1; i <= 3; i++ {
+		event := <-subscription.Events()
+		if event.Seq != uint64(i) || event.After.ID != i {
+			t.Errorf("Expected event %d, got seq %d for ID %d", i, event.Seq, event.After.ID)
+		}
+	}
+
+	subscription.Close()
+	if _, open := <-subscription.Events(); open {
+		t.Error("Expected events channel to be closed")
+	}
+	if !errors.Is(subscription.Err(), ErrSubscriptionClosed) {
+		t.Errorf("Expected ErrSubscriptionClosed, got %v", subscription.Err())
+	}
+}
+
+func TestChangeFeed_LaggingSubscriberCanResume(t *testing.T) {
+	feed := newChangeFeed(10)
+	slow, _ := feed.subscribe(0, 2)
+
+	for i := 1; i <= 5; i++ {
+		feed.publish(ChangeEvent{Type: ChangeInsert, After: &models.UserTransaction{ID: i}})
+	}
+
+	// El buffer solo admite 2 eventos: la suscripción se cierra sin bloquear al escritor
+	var lastSeq uint64
+	for event := range slow.Events() {
+		lastSeq = event.Seq
+	}
+	if lastSeq != 2 {
+		t.Errorf("Expected to receive events up to seq 2, got %d", lastSeq)
+	}
+	if !errors.Is(slow.Err(), ErrSubscriberLagging) {
+		t.Fatalf("Expected ErrSubscriberLagging, got %v", slow.Err())
+	}
+
+	// Reanudar desde el siguiente evento no recibido
+	resumed, err := feed.subscribe(lastSeq+1, 2)
+	if err != nil {
+		t.Fatalf("Expected no error resuming, got %v", err)
+	}
+	defer resumed.Close()
+
+	for expected := lastSeq + 1; expected <= 5; expected++ {
+		if event := <-resumed.Events(); event.Seq != expected {
+			t.Errorf("Expected seq %d, got %d", expected, event.Seq)
+		}
+	}
+}
+
+func TestChangeFeed_SequenceTooOld(t *testing.T) {
+	feed := newChangeFeed(3)
+	for i := 1; i <= 5; i++ {
+		feed.publish(ChangeEvent{Type: ChangeInsert, After: &models.UserTransaction{ID: i}})
+	}
+
+	if _, err := feed.subscribe(2, 10); !errors.Is(err, ErrSequenceTooOld) {
+		t.Errorf("Expected ErrSequenceTooOld, got %v", err)
+	}
+	if _, err := feed.subscribe(3, 10); err != nil {
+		t.Errorf("Expected oldest retained sequence to be resumable, got %v", err)
+	}
+	if feed.lastSequence() != 5 {
+		t.Errorf("Expected last sequence 5, got %d", feed.lastSequence())
+	}
+}
+
+func TestChangeFeed_SequenceAhead(t *testing.T) {
+	feed := newChangeFeed(10)
+	for i := 1; i <= 3; i++ {
+		feed.publish(ChangeEvent{Type: ChangeInsert, After: &models.UserTransaction{ID: i}})
+	}
+
+	// Reanudar desde el siguiente número a publicar es válido: el cliente ya vio todo
+	next, err := feed.subscribe(4, 10)
+	if err != nil {
+		t.Fatalf("Expected the next sequence to be resumable, got %v", err)
+	}
+	defer next.Close()
+
+	// Una secuencia posterior (p. ej. vista antes de un reinicio) se rechaza
+	if _, err := feed.subscribe(5, 10); !errors.Is(err, ErrSequenceAhead) {
+		t.Errorf("Expected ErrSequenceAhead, got %v", err)
+	}
+	if _, err := newChangeFeed(10).subscribe(2, 10); !errors.Is(err, ErrSequenceAhead) {
+		t.Errorf("Expected ErrSequenceAhead on a restarted feed, got %v", err)
+	}
+}
+
+func TestTransactionRepository_PublishesChanges(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			subscription, err := repository.Subscribe(0, 10)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer subscription.Close()
+
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 20, DateTime: baseTime})
+			// Una fila descartada por la política de conflictos no genera evento
+			repository.SaveTransactionWithOptions(models.UserTransaction{ID: 1, UserID: 1001, Amount: 30, DateTime: baseTime}, SaveOptions{ConflictPolicy: ConflictSkip})
+			repository.ClearTransactions()
+
+			expected := []struct {
+				changeType ChangeType
+				before     float64
+				after      float64
+			}{
+				{ChangeInsert, 0, 10},
+				{ChangeOverwrite, 10, 20},
+				{ChangeDelete, 20, 0},
+			}
+
+			for i, want := range expected {
+				event := <-subscription.Events()
+				if event.Seq != uint64(i+1) || event.Type != want.changeType {
+					t.Fatalf("Expected %s with seq %d, got %s with seq %d", want.changeType, i+1, event.Type, event.Seq)
+				}
+				if (event.Before == nil) != (want.before == 0) || (event.Before != nil && event.Before.Amount != want.before) {
+					t.Errorf("Unexpected before value for %s: %+v", event.Type, event.Before)
+				}
+				if (event.After == nil) != (want.after == 0) || (event.After != nil && event.After.Amount != want.after) {
+					t.Errorf("Unexpected after value for %s: %+v", event.Type, event.After)
+				}
+			}
+
+			if repository.LastSequence() != 3 {
+				t.Errorf("Expected last sequence 3, got %d", repository.LastSequence())
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrUnknownMappingProfile indica que el perfil de columnas pedido no está configurado
+var ErrUnknownMappingProfile = errors.New("unknown column mapping profile")
+
+// Campos de una transacción que se leen del archivo, en el orden del header por defecto
+var transactionFields = []string{"id", "user_id", "amount", "datetime"}
+
+// ColumnMapping asocia cada campo de la transacción con los nombres de columna aceptados en el header.
+// En JSON cada campo acepta un nombre o una lista: {"user_id": "UserId", "datetime": ["timestamp", "date"]}.
+// Los nombres se comparan sin distinguir mayúsculas y el nombre del campo siempre se acepta.
+type ColumnMapping map[string][]string
+
+// UnmarshalJSON acepta un nombre o una lista de nombres por campo
+func (m *ColumnMapping) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	mapping := make(ColumnMapping, len(raw))
+	for field, value := range raw {
+		var names []string
+		if err := json.Unmarshal(value, &names); err != nil {
+			var name string
+			if err := json.Unmarshal(value, &name); err != nil {
+				return fmt.Errorf("field %q: expected a column name or a list of names", field)
+			}
+			names = []string{name}
+		}
+		mapping[field] = names
+	}
+
+	*m = mapping
+	return nil
+}
+
+// Validate verifica que la asociación solo use campos conocidos y nombres no vacíos
+func (m ColumnMapping) Validate() error {
+	for field, names := range m {
+		if !isTransactionField(field) {
+			return fmt.Errorf("unknown field %q in column mapping. Expected one of: %s", field, strings.Join(transactionFields, ", "))
+		}
+		for _, name := range names {
+			if strings.TrimSpace(name) == "" {
+				return fmt.Errorf("empty column name for field %q in column mapping", field)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseColumnMapping convierte el JSON de una asociación de columnas enviada con la subida
+func ParseColumnMapping(value string) (ColumnMapping, error) {
+	var mapping ColumnMapping
+	if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+		return nil, fmt.Errorf("invalid column mapping: %v", err)
+	}
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// LoadColumnMappingProfiles lee los perfiles con nombre de un archivo JSON:
+// {"partner_x": {"user_id": "UserId", "datetime": "timestamp"}}
+func LoadColumnMappingProfiles(path string) (map[string]ColumnMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading column mapping profiles: %v", err)
+	}
+
+	var profiles map[string]ColumnMapping
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid column mapping profiles in %s: %v", path, err)
+	}
+	for name, mapping := range profiles {
+		if err := mapping.Validate(); err != nil {
+			return nil, fmt.Errorf("column mapping profile %q: %v", name, err)
+		}
+	}
+	return profiles, nil
+}
+
+// columnIndex posición en cada fila de los campos de la transacción
+type columnIndex struct {
+	id, userID, amount, datetime int
+	width                        int // Columnas del header; las filas deben tener la misma cantidad
+}
+
+// resolveColumns ubica en el header la columna de cada campo según mapping. Las columnas que no
+// corresponden a ningún campo se ignoran; falta o ambigüedad de un campo es un error.
+func resolveColumns(header []string, mapping ColumnMapping) (columnIndex, error) {
+	positions := make(map[string]int, len(transactionFields))
+	for i, column := range header {
+		name := normalizeColumnName(column, i)
+		for _, field := range transactionFields {
+			if !mapping.accepts(field, name) {
+				continue
+			}
+			if previous, exists := positions[field]; exists {
+				return columnIndex{}, fmt.Errorf("invalid CSV header: columns %q and %q both map to field %s", header[previous], column, field)
+			}
+			positions[field] = i
+		}
+	}
+
+	for _, field := range transactionFields {
+		if _, exists := positions[field]; !exists {
+			return columnIndex{}, fmt.Errorf("invalid CSV header: no column for field %s (accepted: %s). Got: %v",
+				field, strings.Join(mapping.names(field), ", "), header)
+		}
+	}
+
+	return columnIndex{
+		id:       positions["id"],
+		userID:   positions["user_id"],
+		amount:   positions["amount"],
+		datetime: positions["datetime"],
+		width:    len(header),
+	}, nil
+}
+
+// accepts indica si name (ya normalizado) es un nombre aceptado para field
+func (m ColumnMapping) accepts(field, name string) bool {
+	for _, accepted := range m.names(field) {
+		if strings.EqualFold(strings.TrimSpace(accepted), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// names retorna los nombres aceptados para field: el propio campo y sus alias
+func (m ColumnMapping) names(field string) []string {
+	return append([]string{field}, m[field]...)
+}
+
+// normalizeColumnName quita espacios y el BOM UTF-8 que algunos programas agregan al inicio del archivo
+func normalizeColumnName(column string, position int) string {
+	if position == 0 {
+		column = strings.TrimPrefix(column, "\ufeff")
+	}
+	return strings.TrimSpace(column)
+}
+
+// isTransactionField indica si field es uno de los campos que se leen del archivo
+func isTransactionField(field string) bool {
+	for _, known := range transactionFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
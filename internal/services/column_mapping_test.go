@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveColumns(t *testing.T) {
+	partner := ColumnMapping{"user_id": {"UserId"}, "datetime": {"timestamp", "date"}}
+
+	tests := []struct {
+		name          string
+		header        []string
+		mapping       ColumnMapping
+		expected      columnIndex
+		expectedError string
+	}{
+		{"default header", []string{"id", "user_id", "amount", "datetime"}, nil, columnIndex{0, 1, 2, 3, 4}, ""},
+		{"case and spaces", []string{" ID", "User_Id ", "AMOUNT", "DateTime"}, nil, columnIndex{0, 1, 2, 3, 4}, ""},
+		{"byte order mark", []string{"\ufeffid", "user_id", "amount", "datetime"}, nil, columnIndex{0, 1, 2, 3, 4}, ""},
+		{"aliases, order and extras", []string{"timestamp", "note", "amount", "userid", "id"}, partner, columnIndex{4, 3, 2, 0, 5}, ""},
+		{"missing field", []string{"id", "user_id", "amount"}, nil, columnIndex{}, "no column for field datetime"},
+		{"alias not in mapping", []string{"id", "UserId", "amount", "datetime"}, nil, columnIndex{}, "no column for field user_id"},
+		{"ambiguous field", []string{"id", "user_id", "amount", "date", "timestamp"}, partner, columnIndex{}, "both map to field datetime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := resolveColumns(tt.header, tt.mapping)
+			if tt.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+					t.Errorf("Expected error containing %q, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if columns != tt.expected {
+				t.Errorf("Expected columns %+v, got %+v", tt.expected, columns)
+			}
+		})
+	}
+}
+
+func TestParseColumnMapping(t *testing.T) {
+	mapping, err := ParseColumnMapping(`{"user_id": "UserId", "datetime": ["timestamp", "date"]}`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mapping["user_id"]) != 1 || len(mapping["datetime"]) != 2 {
+		t.Errorf("Unexpected mapping %v", mapping)
+	}
+
+	for _, invalid := range []string{`not json`, `{"balance": "saldo"}`, `{"amount": ""}`, `{"amount": 3}`} {
+		if _, err := ParseColumnMapping(invalid); err == nil {
+			t.Errorf("Expected error for mapping %s", invalid)
+		}
+	}
+}
+
+func TestLoadColumnMappingProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(path, []byte(`{"partner_x": {"user_id": "UserId", "datetime": "timestamp"}}`), 0644)
+
+	profiles, err := LoadColumnMappingProfiles(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	service := NewMigrationService(NewMockDatabase())
+	service.SetMappingProfiles(profiles)
+	if mapping, err := service.MappingProfile("partner_x"); err != nil || mapping["datetime"][0] != "timestamp" {
+		t.Errorf("Expected partner_x profile, got %v (%v)", mapping, err)
+	}
+	if _, err := service.MappingProfile("partner_y"); !errors.Is(err, ErrUnknownMappingProfile) {
+		t.Errorf("Expected ErrUnknownMappingProfile, got %v", err)
+	}
+
+	os.WriteFile(path, []byte(`{"bad": {"balance": "saldo"}}`), 0644)
+	if _, err := LoadColumnMappingProfiles(path); err == nil {
+		t.Error("Expected error for profile with unknown field")
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Compression compresión de un archivo de migración
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip" // El archivo se descomprime en streaming (.csv.gz, .ndjson.gz, ...)
+)
+
+// ArchiveLimits límites de los archivos comprimidos, para que un archivo pequeño que se expande
+// sin control (zip bomb) no agote el disco ni la memoria
+type ArchiveLimits struct {
+	MaxUncompressedSize int64 // Bytes descomprimidos en total: el stream gzip o la suma de los archivos del ZIP
+	MaxEntries          int   // Archivos dentro de un ZIP
+}
+
+// DefaultArchiveLimits límites por defecto de los archivos comprimidos
+var DefaultArchiveLimits = ArchiveLimits{MaxUncompressedSize: 4 << 30, MaxEntries: 100}
+
+// DetectCompression deduce la compresión del archivo por el Content-Type o la extensión
+func DetectCompression(contentType, filename string) Compression {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/gzip", mediaType == "application/x-gzip":
+		return CompressionGzip
+	case strings.EqualFold(filepath.Ext(filename), ".gz"):
+		return CompressionGzip
+	}
+	return CompressionNone
+}
+
+// DecompressGzip descomprime reader en streaming. La lectura falla si el contenido descomprimido
+// supera el límite configurado (ver SetArchiveLimits).
+func (ms *MigrationService) DecompressGzip(reader io.Reader) (io.ReadCloser, error) {
+	budget := ms.archiveLimits.MaxUncompressedSize
+	return ms.decompressGzip(reader, &budget)
+}
+
+// decompressGzip descomprime reader descontando los bytes leídos de budget
+func (ms *MigrationService) decompressGzip(reader io.Reader, budget *int64) (io.ReadCloser, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip file: %v", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&uncompressedReader{reader: gzipReader, budget: budget, limit: ms.archiveLimits.MaxUncompressedSize}, gzipReader}, nil
+}
+
+// uncompressedReader cuenta los bytes descomprimidos y corta la lectura al superar el límite
+type uncompressedReader struct {
+	reader io.Reader
+	budget *int64 // Bytes que quedan por leer; compartido entre los archivos de un ZIP
+	limit  int64
+}
+
+func (r *uncompressedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	*r.budget -= int64(n)
+	if *r.budget < 0 {
+		return n, fmt.Errorf("uncompressed size exceeds %d bytes", r.limit)
+	}
+	return n, err
+}
+
+// zipSource lee uno tras otro los archivos de un ZIP, cada uno con el lector de su formato (por extensión).
+// Todos los registros forman una sola migración; el detalle por archivo queda en MigrationStats.Files.
+type zipSource struct {
+	ms      *MigrationService
+	options MigrationOptions
+	name    string      // Nombre del ZIP; los registros se identifican como "<zip>/<archivo>"
+	entries []*zip.File // Archivos a migrar, en el orden del ZIP
+	files   []models.FileReport
+	budget  int64
+
+	current recordSource
+	entry   io.ReadCloser
+	spool   *os.File // Copia temporal del ZIP si llegó como stream; se borra al cerrar
+}
+
+// newZipSource lee el índice del ZIP y valida los límites antes de procesar ningún archivo
+func (ms *MigrationService) newZipSource(reader io.Reader, options MigrationOptions) (_ *zipSource, err error) {
+	// El índice está al final del ZIP: si el archivo llega como stream se copia a disco
+	readerAt, size, spool, err := randomAccess(reader, FormatZIP)
+	if err != nil {
+		return nil, err
+	}
+	source := &zipSource{ms: ms, options: options, name: options.reportFilename(), spool: spool}
+	defer func() {
+		if err != nil {
+			source.Close()
+		}
+	}()
+
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZIP file: %v", err)
+	}
+
+	limits := ms.archiveLimits
+	var declaredSize uint64
+	for _, file := range archive.File {
+		if !isArchiveDataFile(file) {
+			continue
+		}
+		source.entries = append(source.entries, file)
+		declaredSize += file.UncompressedSize64
+	}
+	if len(source.entries) == 0 {
+		return nil, fmt.Errorf("ZIP file has no files to migrate")
+	}
+	if len(source.entries) > limits.MaxEntries {
+		return nil, fmt.Errorf("ZIP file has %d files, the maximum is %d", len(source.entries), limits.MaxEntries)
+	}
+	if declaredSize > uint64(limits.MaxUncompressedSize) {
+		return nil, fmt.Errorf("ZIP file uncompressed size (%d bytes) exceeds %d bytes", declaredSize, limits.MaxUncompressedSize)
+	}
+
+	// El tamaño declarado en el índice puede ser falso: además se cuentan los bytes realmente descomprimidos
+	source.budget = limits.MaxUncompressedSize
+	return source, nil
+}
+
+// isArchiveDataFile descarta directorios, archivos ocultos y los metadatos que agrega macOS
+func isArchiveDataFile(file *zip.File) bool {
+	if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") {
+		return false
+	}
+	return !strings.HasPrefix(path.Base(file.Name), ".")
+}
+
+func (s *zipSource) next() (sourceRecord, error) {
+	for {
+		if s.current == nil {
+			if len(s.files) == len(s.entries) {
+				return sourceRecord{}, io.EOF
+			}
+			if err := s.openEntry(s.entries[len(s.files)]); err != nil {
+				return sourceRecord{}, err
+			}
+		}
+
+		entryName := s.files[len(s.files)-1].Filename
+		record, err := s.current.next()
+		if err == io.EOF {
+			s.closeEntry()
+			continue
+		}
+		if err != nil {
+			return sourceRecord{}, fmt.Errorf("%s: %v", entryName, err)
+		}
+
+		record.file = s.name + "/" + entryName
+		if record.err != nil {
+			record.err = fmt.Errorf("%s: %v", entryName, record.err)
+		}
+		return record, nil
+	}
+}
+
+// openEntry abre el siguiente archivo del ZIP con el lector de su formato y las opciones de la migración
+func (s *zipSource) openEntry(file *zip.File) error {
+	options := s.options
+	options.SourceFile = s.name + "/" + file.Name
+	options.Compression = CompressionNone
+	compression := DetectCompression("", file.Name)
+	formatName := file.Name
+	if compression != CompressionNone {
+		formatName = strings.TrimSuffix(formatName, path.Ext(formatName))
+	}
+	options.Format = DetectFileFormat("", formatName)
+	switch options.Format {
+	case "":
+		return fmt.Errorf("%s: unsupported file in ZIP. Expected CSV, JSON Lines, JSON, XLSX, OFX or QIF files", file.Name)
+	case FormatZIP:
+		return fmt.Errorf("%s: nested ZIP files are not supported", file.Name)
+	}
+
+	entry, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("%s: %v", file.Name, err)
+	}
+	s.entry = entry
+	s.files = append(s.files, models.FileReport{Filename: file.Name, Format: string(options.Format)})
+
+	// Un archivo .gz dentro del ZIP descuenta sus bytes descomprimidos del mismo límite total
+	var reader io.Reader = &uncompressedReader{reader: entry, budget: &s.budget, limit: s.ms.archiveLimits.MaxUncompressedSize}
+	if compression == CompressionGzip {
+		if reader, err = s.ms.decompressGzip(entry, &s.budget); err != nil {
+			s.closeEntry()
+			return fmt.Errorf("%s: %v", file.Name, err)
+		}
+	}
+
+	s.current, err = s.ms.openRecordSource(reader, options)
+	if err != nil {
+		s.closeEntry()
+		return fmt.Errorf("%s: %v", file.Name, err)
+	}
+	return nil
+}
+
+func (s *zipSource) closeEntry() {
+	if s.current != nil {
+		closeRecordSource(s.current)
+		s.current = nil
+	}
+	if s.entry != nil {
+		s.entry.Close()
+		s.entry = nil
+	}
+}
+
+// Close cierra el archivo en curso y borra la copia temporal del ZIP
+func (s *zipSource) Close() error {
+	s.closeEntry()
+	if s.spool != nil {
+		s.spool.Close()
+		os.Remove(s.spool.Name())
+		s.spool = nil
+	}
+	return nil
+}
+
+// archiveFiles retorna los archivos leídos de un ZIP, o nil si source no es un ZIP
+func archiveFiles(source recordSource) []models.FileReport {
+	if archive, ok := source.(*zipSource); ok {
+		return archive.files
+	}
+	return nil
+}
+
+// randomAccess retorna acceso aleatorio al archivo (lo requieren ZIP y XLSX). Los archivos en disco
+// y en memoria se usan directamente; cualquier otro stream se copia a un temporal que el llamador debe borrar.
+func randomAccess(reader io.Reader, format FileFormat) (io.ReaderAt, int64, *os.File, error) {
+	switch r := reader.(type) {
+	case *os.File:
+		info, err := r.Stat()
+		if err == nil && info.Mode().IsRegular() {
+			return r, info.Size(), nil, nil
+		}
+	case interface {
+		io.ReaderAt
+		Size() int64
+	}:
+		return r, r.Size(), nil, nil
+	}
+
+	spool, err := os.CreateTemp("", "migration-*."+string(format))
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("error creating spool file: %v", err)
+	}
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, 0, nil, fmt.Errorf("error receiving %s file: %v", strings.ToUpper(string(format)), err)
+	}
+	return spool, size, spool, nil
+}
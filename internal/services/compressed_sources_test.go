@@ -0,0 +1,157 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+// gzipBytes comprime content con gzip
+func gzipBytes(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(content))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Expected no error compressing, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+// buildZip arma un ZIP con los archivos indicados, en orden (nombre, contenido)
+func buildZip(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		entry, err := archive.Create(files[i])
+		if err != nil {
+			t.Fatalf("Expected no error creating %s, got %v", files[i], err)
+		}
+		entry.Write([]byte(files[i+1]))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Expected no error closing ZIP, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMigrationService_ProcessFileGzip(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	content := gzipBytes(t, "id,user_id,amount,datetime\n1,1001,100.50,2024-01-15 10:30:00\n2,1001,-20,2024-01-16\n")
+	stats, err := service.ProcessFile(bytes.NewReader(content), MigrationOptions{Compression: CompressionGzip, SourceFile: "nightly.csv.gz"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.SuccessRecords != 2 || stats.Files != nil {
+		t.Errorf("Expected 2 successful records without file breakdown, got %+v", stats)
+	}
+	if tx, _ := db.GetTransaction(1); tx.SourceFile != "nightly.csv.gz" || tx.SourceLine != 2 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+
+	// El límite corta la lectura aunque el archivo comprimido sea pequeño
+	service.SetArchiveLimits(ArchiveLimits{MaxUncompressedSize: 64, MaxEntries: 10})
+	large := gzipBytes(t, "id,user_id,amount,datetime\n"+strings.Repeat("3,1001,1,2024-01-15\n", 100))
+	if _, err := service.ProcessFile(bytes.NewReader(large), MigrationOptions{Compression: CompressionGzip}); err == nil || !strings.Contains(err.Error(), "exceeds 64 bytes") {
+		t.Errorf("Expected uncompressed size error, got %v", err)
+	}
+	if _, err := service.ProcessFile(strings.NewReader("id,user_id"), MigrationOptions{Compression: CompressionGzip}); err == nil || !strings.Contains(err.Error(), "invalid gzip file") {
+		t.Errorf("Expected invalid gzip error, got %v", err)
+	}
+}
+
+func TestMigrationService_ProcessFileZip(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Dos CSV (uno comprimido), un JSON Lines sin registros, un directorio y metadatos de macOS
+	archive := buildZip(t,
+		"exports/", "",
+		"exports/jan.csv", "id,user_id,amount,datetime\n1,1001,100,2024-01-15\n2,1001,abc,2024-01-16\n",
+		"__MACOSX/exports/._jan.csv", "binary",
+		"exports/feb.csv.gz", string(gzipBytes(t, "id,user_id,amount,datetime\n3,1002,-30,2024-02-01\n")),
+		"exports/empty.ndjson", "\n",
+	)
+
+	stats, err := service.ProcessFile(bytes.NewReader(archive), MigrationOptions{SourceFile: "nightly.zip", Format: FormatZIP})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 3 || stats.SuccessRecords != 2 || stats.ErrorRecords != 1 {
+		t.Errorf("Expected 3 records, 2 success and 1 error, got %+v", stats)
+	}
+	if len(stats.Errors) != 1 || !strings.HasPrefix(stats.Errors[0], "Line 3: exports/jan.csv: invalid amount at line 3") {
+		t.Errorf("Expected error naming the file, got %v", stats.Errors)
+	}
+
+	// Detalle por archivo en el orden del ZIP, incluido el archivo sin registros
+	expected := []struct {
+		name                   string
+		total, success, errors int
+	}{
+		{"exports/jan.csv", 2, 1, 1},
+		{"exports/feb.csv.gz", 1, 1, 0},
+		{"exports/empty.ndjson", 0, 0, 0},
+	}
+	if len(stats.Files) != len(expected) {
+		t.Fatalf("Expected %d files, got %+v", len(expected), stats.Files)
+	}
+	for i, file := range expected {
+		got := stats.Files[i]
+		if got.Filename != file.name || got.TotalRecords != file.total || got.SuccessRecords != file.success || got.ErrorRecords != file.errors {
+			t.Errorf("Unexpected file %d: %+v", i, got)
+		}
+	}
+	if stats.Files[1].Format != "csv" || len(stats.Result(10).Files) != 3 {
+		t.Errorf("Expected file format and breakdown in the result, got %+v", stats.Files)
+	}
+
+	if tx, _ := db.GetTransaction(3); tx.SourceFile != "nightly.zip/exports/feb.csv.gz" || tx.SourceLine != 2 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+
+	// Al validar o migrar de nuevo con skip cada archivo cuenta sus conflictos
+	validation, err := service.ValidateFile(io.MultiReader(bytes.NewReader(archive)), MigrationOptions{Format: FormatZIP, ConflictPolicy: ConflictSkip})
+	if err != nil || len(validation.Report.Files) != 3 || validation.Stats.Files[0].SkippedRecords != 1 || validation.Stats.Files[1].SkippedRecords != 1 {
+		t.Errorf("Unexpected validation %+v, err %v", validation, err)
+	}
+	if db.GetTransactionCount() != 2 {
+		t.Errorf("Expected validation to save nothing, got %d transactions", db.GetTransactionCount())
+	}
+}
+
+func TestMigrationService_ProcessFileZipLimits(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+	service.SetArchiveLimits(ArchiveLimits{MaxUncompressedSize: 128, MaxEntries: 2})
+
+	csv := "id,user_id,amount,datetime\n1,1001,1,2024-01-15\n"
+	tests := map[string]struct {
+		archive  []byte
+		expected string
+	}{
+		"too many files": {buildZip(t, "a.csv", csv, "b.csv", csv, "c.csv", csv), "has 3 files, the maximum is 2"},
+		"too large":      {buildZip(t, "a.csv", csv+strings.Repeat("2,1001,1,2024-01-15\n", 10)), "exceeds 128 bytes"},
+		"gzip inside":    {buildZip(t, "a.csv.gz", string(gzipBytes(t, csv+strings.Repeat("2,1001,1,2024-01-15\n", 10)))), "exceeds 128 bytes"},
+		"unsupported":    {buildZip(t, "a.csv", csv, "notes.txt", "hello"), "notes.txt: unsupported file in ZIP"},
+		"nested":         {buildZip(t, "inner.zip", "PK"), "nested ZIP files are not supported"},
+		"empty":          {buildZip(t, "docs/", ""), "no files to migrate"},
+		"not a zip":      {[]byte(csv), "invalid ZIP file"},
+	}
+
+	for name, tt := range tests {
+		_, err := service.ProcessFile(bytes.NewReader(tt.archive), MigrationOptions{Format: FormatZIP})
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tt.expected, err)
+		}
+	}
+}
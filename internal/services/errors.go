@@ -6,3 +6,15 @@ import "errors"
 var (
 	ErrUserNotFound = errors.New("user not found")
 )
+
+// Errores del servicio de transacciones
+var (
+	ErrTransactionNotFound       = errors.New("transaction not found")
+	ErrTransactionAlreadyDeleted = errors.New("transaction already deleted")
+	ErrDeleteReasonRequired      = errors.New("delete reason is required")
+)
+
+// Errores del servicio de migraciones
+var (
+	ErrMigrationNotFound = errors.New("migration not found")
+)
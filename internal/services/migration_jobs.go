@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrMigrationQueueFull indica que la cola de migraciones asíncronas no admite más trabajos
+var ErrMigrationQueueFull = errors.New("migration queue is full")
+
+// MigrationJobState estado de una migración asíncrona
+type MigrationJobState string
+
+const (
+	MigrationJobQueued    MigrationJobState = "queued"
+	MigrationJobRunning   MigrationJobState = "running"
+	MigrationJobSucceeded MigrationJobState = "succeeded"
+	MigrationJobFailed    MigrationJobState = "failed"
+)
+
+// maxFinishedJobs trabajos terminados que se conservan para consulta; los más antiguos se descartan
+const maxFinishedJobs = 1000
+
+// MigrationJob estado consultable de una migración asíncrona. Su ID es el ID de la migración,
+// por lo que las transacciones importadas se consultan con el mismo identificador.
+type MigrationJob struct {
+	ID             string            `json:"id"`
+	State          MigrationJobState `json:"state"`
+	SourceFile     string            `json:"source_file,omitempty"`
+	SHA256         string            `json:"sha256,omitempty"`
+	ConflictPolicy ConflictPolicy    `json:"conflict_policy"`
+	Atomic         bool              `json:"atomic"`
+	CreatedAt      time.Time         `json:"created_at"`
+	StartedAt      *time.Time        `json:"started_at,omitempty"`
+	FinishedAt     *time.Time        `json:"finished_at,omitempty"`
+	Progress       MigrationProgress `json:"progress"`
+	Stats          *MigrationStats   `json:"stats,omitempty"` // Estadísticas finales, al terminar
+	Error          string            `json:"error,omitempty"`
+}
+
+// migrationTask trabajo encolado: el archivo ya está copiado en disco
+type migrationTask struct {
+	options   MigrationOptions
+	spoolPath string
+}
+
+// MigrationJobQueue ejecuta migraciones en segundo plano con un número fijo de workers.
+// Los archivos se copian a disco al encolarlos para liberar la conexión HTTP; si la cola
+// está llena el trabajo se rechaza con ErrMigrationQueueFull en lugar de sobrecargar el servidor.
+// El estado de los trabajos vive en memoria y se pierde al reiniciar.
+type MigrationJobQueue struct {
+	migrationService *MigrationService
+	spoolDir         string
+	tasks            chan migrationTask
+
+	mutex    sync.RWMutex
+	jobs     map[string]*MigrationJob
+	finished []string // IDs de trabajos terminados, del más antiguo al más reciente
+}
+
+// NewMigrationJobQueue crea la cola e inicia workers goroutines que procesan hasta queueSize
+// trabajos pendientes. spoolDir es el directorio de los archivos en espera (vacío = directorio temporal).
+func NewMigrationJobQueue(migrationService *MigrationService, workers, queueSize int, spoolDir string) (*MigrationJobQueue, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("invalid number of migration workers: %d", workers)
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("invalid migration queue size: %d", queueSize)
+	}
+	if spoolDir != "" {
+		if err := os.MkdirAll(spoolDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating migration spool directory: %v", err)
+		}
+	}
+
+	queue := &MigrationJobQueue{
+		migrationService: migrationService,
+		spoolDir:         spoolDir,
+		tasks:            make(chan migrationTask, queueSize),
+		jobs:             make(map[string]*MigrationJob),
+	}
+	for i := 0; i < workers; i++ {
+		go queue.work()
+	}
+	return queue, nil
+}
+
+// Submit copia el archivo de reader a disco y encola su migración. Retorna el trabajo en estado queued.
+func (q *MigrationJobQueue) Submit(reader io.Reader, options MigrationOptions) (MigrationJob, error) {
+	// Rechazar antes de copiar el archivo si la cola ya está llena
+	if len(q.tasks) == cap(q.tasks) {
+		return MigrationJob{}, ErrMigrationQueueFull
+	}
+
+	spoolPath, err := q.spool(reader)
+	if err != nil {
+		return MigrationJob{}, err
+	}
+
+	job, err := q.SubmitSpooled(spoolPath, options)
+	if err != nil {
+		os.Remove(spoolPath)
+	}
+	return job, err
+}
+
+// SubmitSpooled encola la migración de un archivo que ya está en disco. Si lo encola, la cola se hace
+// cargo del archivo y lo borra al terminar; si retorna error el archivo sigue siendo del llamador.
+func (q *MigrationJobQueue) SubmitSpooled(spoolPath string, options MigrationOptions) (MigrationJob, error) {
+	if options.ConflictPolicy == "" {
+		options.ConflictPolicy = ConflictOverwrite
+	}
+	if options.MigrationID == "" {
+		options.MigrationID = NewMigrationID()
+	}
+
+	job := &MigrationJob{
+		ID:             options.MigrationID,
+		State:          MigrationJobQueued,
+		SourceFile:     options.SourceFile,
+		SHA256:         options.ContentSHA256,
+		ConflictPolicy: options.ConflictPolicy,
+		Atomic:         options.Atomic,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	select {
+	case q.tasks <- migrationTask{options: options, spoolPath: spoolPath}:
+	default:
+		return MigrationJob{}, ErrMigrationQueueFull
+	}
+	q.jobs[job.ID] = job
+
+	return *job, nil
+}
+
+// GetJob retorna una copia del estado del trabajo id
+func (q *MigrationJobQueue) GetJob(id string) (MigrationJob, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	job, exists := q.jobs[id]
+	if !exists {
+		return MigrationJob{}, ErrMigrationNotFound
+	}
+	return *job, nil
+}
+
+// spool copia el archivo a un temporal en spoolDir y retorna su ruta
+func (q *MigrationJobQueue) spool(reader io.Reader) (string, error) {
+	file, err := os.CreateTemp(q.spoolDir, "migration-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating spool file: %v", err)
+	}
+
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("error receiving file: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// work procesa trabajos de la cola uno a uno
+func (q *MigrationJobQueue) work() {
+	for task := range q.tasks {
+		q.run(task)
+	}
+}
+
+// run ejecuta la migración de task y registra el resultado
+func (q *MigrationJobQueue) run(task migrationTask) {
+	defer os.Remove(task.spoolPath)
+
+	id := task.options.MigrationID
+	q.update(id, func(job *MigrationJob) {
+		startedAt := time.Now().UTC()
+		job.State = MigrationJobRunning
+		job.StartedAt = &startedAt
+	})
+
+	task.options.OnProgress = func(progress MigrationProgress) {
+		q.update(id, func(job *MigrationJob) {
+			job.Progress = progress
+		})
+	}
+
+	var stats *MigrationStats
+	file, err := os.Open(task.spoolPath)
+	if err == nil {
+		stats, err = q.migrationService.ProcessFile(file, task.options)
+		file.Close()
+	}
+	if err != nil {
+		log.Printf("Migration job %s failed: %v", id, err)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job := q.jobs[id]
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	job.Stats = stats
+	if stats != nil {
+		job.Progress = stats.Progress()
+	}
+	if err != nil {
+		job.State = MigrationJobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = MigrationJobSucceeded
+	}
+
+	q.finished = append(q.finished, id)
+	if len(q.finished) > maxFinishedJobs {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// update aplica change al trabajo id bajo el mutex
+func (q *MigrationJobQueue) update(id string, change func(job *MigrationJob)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if job, exists := q.jobs[id]; exists {
+		change(job)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingRepository detiene cada guardado hasta que se cierre release
+type blockingRepository struct {
+	TransactionRepository
+	release chan struct{}
+}
+
+func (r *blockingRepository) SaveTransactionWithOptions(transaction models.UserTransaction, options SaveOptions) (SaveResult, error) {
+	<-r.release
+	return r.TransactionRepository.SaveTransactionWithOptions(transaction, options)
+}
+
+// waitForJob espera a que el trabajo id llegue a alguno de los estados indicados
+func waitForJob(t *testing.T, queue *MigrationJobQueue, id string, states ...MigrationJobState) MigrationJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := queue.GetJob(id)
+		if err != nil {
+			t.Fatalf("Expected no error getting job %s, got %v", id, err)
+		}
+		for _, state := range states {
+			if job.State == state {
+				return job
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job %s, last state %s", id, job.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestJobQueue(t *testing.T, db TransactionRepository, workers, queueSize int) (*MigrationJobQueue, string) {
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	spoolDir := t.TempDir()
+	queue, err := NewMigrationJobQueue(service, workers, queueSize, spoolDir)
+	if err != nil {
+		t.Fatalf("Expected no error creating queue, got %v", err)
+	}
+	return queue, spoolDir
+}
+
+func TestMigrationJobQueue_RunsJobs(t *testing.T) {
+	db := NewMockDatabase()
+	queue, spoolDir := newTestJobQueue(t, db, 2, 10)
+
+	csvContent := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,invalid,2024-01-15 14:45:00
+3,1002,200.00,2024-01-16 09:15:00`
+
+	job, err := queue.Submit(strings.NewReader(csvContent), MigrationOptions{SourceFile: "jobs.csv"})
+	if err != nil {
+		t.Fatalf("Expected no error submitting job, got %v", err)
+	}
+	if job.State != MigrationJobQueued || job.ID == "" || job.ConflictPolicy != ConflictOverwrite {
+		t.Errorf("Unexpected submitted job %+v", job)
+	}
+
+	job = waitForJob(t, queue, job.ID, MigrationJobSucceeded, MigrationJobFailed)
+	if job.State != MigrationJobSucceeded || job.Stats == nil || job.StartedAt == nil || job.FinishedAt == nil {
+		t.Fatalf("Expected succeeded job with stats, got %+v", job)
+	}
+	if job.Stats.SuccessRecords != 2 || job.Stats.ErrorRecords != 1 || job.Progress.ProcessedRecords != 3 {
+		t.Errorf("Unexpected job counters: stats %+v, progress %+v", job.Stats, job.Progress)
+	}
+
+	// El ID del trabajo es el ID de la migración
+	if tx, _ := db.GetTransaction(3); tx.MigrationID != job.ID || tx.SourceFile != "jobs.csv" {
+		t.Errorf("Expected transaction 3 imported by %s, got %+v", job.ID, tx)
+	}
+
+	// Un header inválido deja el trabajo en failed
+	failed, err := queue.Submit(strings.NewReader("a,b,c\n1,2,3"), MigrationOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error submitting job, got %v", err)
+	}
+	failed = waitForJob(t, queue, failed.ID, MigrationJobSucceeded, MigrationJobFailed)
+	if failed.State != MigrationJobFailed || !strings.Contains(failed.Error, "invalid CSV header") {
+		t.Errorf("Expected failed job with header error, got %+v", failed)
+	}
+
+	// Los archivos en espera se borran al terminar
+	if entries, _ := os.ReadDir(spoolDir); len(entries) != 0 {
+		t.Errorf("Expected empty spool directory, got %d files", len(entries))
+	}
+
+	if _, err := queue.GetJob("mig-unknown"); err != ErrMigrationNotFound {
+		t.Errorf("Expected ErrMigrationNotFound, got %v", err)
+	}
+}
+
+func TestMigrationJobQueue_QueueFull(t *testing.T) {
+	db := &blockingRepository{TransactionRepository: NewMockDatabase(), release: make(chan struct{})}
+	queue, _ := newTestJobQueue(t, db, 1, 1)
+	csvContent := "id,user_id,amount,datetime\n1,1001,10,2024-01-15\n"
+
+	// El único worker queda ocupado con el primer trabajo y el segundo ocupa la cola
+	first, err := queue.Submit(strings.NewReader(csvContent), MigrationOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error submitting first job, got %v", err)
+	}
+	waitForJob(t, queue, first.ID, MigrationJobRunning)
+
+	second, err := queue.Submit(strings.NewReader(csvContent), MigrationOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error submitting second job, got %v", err)
+	}
+
+	if _, err := queue.Submit(strings.NewReader(csvContent), MigrationOptions{}); err != ErrMigrationQueueFull {
+		t.Errorf("Expected ErrMigrationQueueFull, got %v", err)
+	}
+
+	close(db.release)
+	waitForJob(t, queue, first.ID, MigrationJobSucceeded)
+	waitForJob(t, queue, second.ID, MigrationJobSucceeded)
+}
+
+func TestNewMigrationJobQueue_InvalidConfig(t *testing.T) {
+	service := NewMigrationService(NewMockDatabase())
+	if _, err := NewMigrationJobQueue(service, 0, 10, ""); err == nil {
+		t.Error("Expected error with 0 workers")
+	}
+	if _, err := NewMigrationJobQueue(service, 1, 0, ""); err == nil {
+		t.Error("Expected error with queue size 0")
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxRollbackAttempts veces que se recalcula una reversión cuando alguna transacción cambia entre la
+// lectura de su historial y la escritura del lote
+const maxRollbackAttempts = 3
+
+// rollbackReason motivo con el que se eliminan las transacciones creadas por la migración revertida
+func rollbackReason(migrationID string) string {
+	return "rollback of migration " + migrationID
+}
+
+// RollbackMigration revierte las transacciones escritas por la migración migrationID: las que creó se
+// eliminan (soft delete) y las que sobrescribió vuelven a su versión anterior, en un solo lote atómico.
+// Un ID que no estaba vigente en el almacén antes de la migración (eliminado, purgado o archivado) cuenta
+// como creado por ella: se elimina en lugar de restaurar la versión antigua.
+// Las transacciones que cambiaron después de la migración no se tocan y se listan como omitidas.
+// Las archivadas se revierten igual: vuelven al almacén con su versión revertida y salen del archivo.
+// El lote solo se escribe si ninguna transacción cambió desde que se leyó su historial; si cambió la reversión
+// se recalcula, y tras maxRollbackAttempts intentos retorna un error que envuelve ErrVersionMismatch.
+// Con dryRun solo calcula el reporte. Retorna ErrMigrationNotFound si ninguna transacción vigente viene de la migración.
+func (ms *MigrationService) RollbackMigration(migrationID string, dryRun bool) (*models.RollbackReport, error) {
+	for attempt := 1; ; attempt++ {
+		plan, err := ms.planRollback(migrationID, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		if dryRun || len(plan.batch) == 0 {
+			return plan.report, nil
+		}
+
+		// Sin migration ID: las versiones que agrega la reversión no cuentan como escritas por ninguna migración
+		options := SaveOptions{ConflictPolicy: ConflictOverwrite, ExpectedVersions: plan.versions}
+		_, err = ms.database.SaveTransactions(plan.batch, options)
+		if errors.Is(err, ErrVersionMismatch) && attempt < maxRollbackAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error rolling back migration %s: %w", migrationID, err)
+		}
+
+		// Las archivadas revertidas ya están en el almacén, que prevalece: solo queda sacar sus copias del archivo
+		if len(plan.archived) > 0 {
+			reverted := make(map[int]bool, len(plan.batch))
+			for _, transaction := range plan.batch {
+				reverted[transaction.ID] = true
+			}
+			_, err := ms.archive.RemoveTransactions(func(transaction models.UserTransaction) bool {
+				archivedCopy, found := plan.archived[transaction.ID]
+				return found && reverted[transaction.ID] && sameStoredTransaction(transaction, archivedCopy)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error removing rolled back transactions from the archive: %v", err)
+			}
+		}
+
+		// Enviar reporte de la reversión (asíncrono)
+		if ms.reportService != nil {
+			go ms.reportService.SendRollbackReport(plan.report)
+		}
+
+		return plan.report, nil
+	}
+}
+
+// rollbackPlan reversión calculada y aún no escrita
+type rollbackPlan struct {
+	report   *models.RollbackReport
+	batch    []models.UserTransaction       // Versiones que escribe la reversión
+	versions map[int]int                    // Última versión de cada ID del lote al leer su historial
+	archived map[int]models.UserTransaction // Copias archivadas de las transacciones que se revierten
+}
+
+// planRollback calcula el reporte y el lote de la reversión de migrationID sin escribir nada
+func (ms *MigrationService) planRollback(migrationID string, dryRun bool) (*rollbackPlan, error) {
+	transactions := ms.database.GetTransactionsByMigrationID(migrationID)
+	live := make(map[int]bool, len(transactions))
+	for _, transaction := range transactions {
+		live[transaction.ID] = true
+	}
+
+	archived := make(map[int]models.UserTransaction)
+	archivedBefore := make(map[int]models.UserTransaction) // Copias archivadas de IDs que la migración volvió a escribir
+	if ms.archive != nil {
+		archivedTransactions, err := ms.archive.FindTransactions(func(transaction models.UserTransaction) bool {
+			return transaction.MigrationID == migrationID || live[transaction.ID]
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading archived transactions: %v", err)
+		}
+		for _, transaction := range archivedTransactions {
+			if live[transaction.ID] {
+				archivedBefore[transaction.ID] = transaction
+				continue
+			}
+			// Si el ID también está en el almacén la copia archivada está desactualizada
+			if _, live := ms.database.GetTransaction(transaction.ID); !live {
+				archived[transaction.ID] = transaction
+				transactions = append(transactions, transaction)
+			}
+		}
+	}
+
+	if len(transactions) == 0 {
+		return nil, ErrMigrationNotFound
+	}
+
+	startTime := time.Now()
+	report := &models.RollbackReport{MigrationID: migrationID, Timestamp: startTime.UTC(), DryRun: dryRun}
+	reason := rollbackReason(migrationID)
+	users := make(map[int]bool)
+
+	var batch []models.UserTransaction
+	versions := make(map[int]int)
+	for _, current := range transactions {
+		history := ms.database.GetTransactionHistory(current.ID)
+		previous, written := versionBeforeMigration(history, migrationID)
+		if !written {
+			report.SkippedRecords++
+			message := fmt.Sprintf("id %d: changed after the migration", current.ID)
+			if current.Deleted && current.DeleteReason == reason {
+				message = fmt.Sprintf("id %d: already rolled back", current.ID)
+			}
+			report.Skipped = appendCapped(report.Skipped, message, &report.Truncated)
+			continue
+		}
+
+		// Si la versión anterior es la que se archivó, el ID no estaba en el almacén y la migración lo volvió a crear
+		if archivedCopy, found := archivedBefore[current.ID]; found && previous != nil && sameStoredTransaction(previous.Transaction, archivedCopy) {
+			previous = nil
+		}
+
+		change := models.RollbackChange{TransactionID: current.ID, Reverted: current}
+		if previous == nil {
+			// La migración creó la transacción: se elimina conservando su historial
+			deleted, err := markDeleted(current, reason, startTime.UTC())
+			if err != nil {
+				report.SkippedRecords++
+				report.Skipped = appendCapped(report.Skipped, fmt.Sprintf("id %d: %v", current.ID, err), &report.Truncated)
+				continue
+			}
+			change.Action = models.RollbackDeleted
+			report.DeletedRecords++
+			batch = append(batch, deleted)
+		} else {
+			restored := previous.Transaction
+			change.Action = models.RollbackRestored
+			change.Restored = &restored
+			report.RestoredRecords++
+			users[restored.UserID] = true
+			batch = append(batch, restored)
+		}
+		users[current.UserID] = true
+		versions[current.ID] = history[len(history)-1].Version
+
+		if len(report.Changes) < maxStatsMessages {
+			report.Changes = append(report.Changes, change)
+		} else {
+			report.Truncated = true
+		}
+	}
+	report.UsersAffected = len(users)
+
+	return &rollbackPlan{report: report, batch: batch, versions: versions, archived: archived}, nil
+}
+
+// versionBeforeMigration busca en el historial la versión anterior a la migración. written es false si la
+// versión actual no la escribió la migración; previous es nil si la migración creó la transacción, también
+// cuando la versión anterior estaba eliminada (quizás purgada después) y la migración volvió a crear el ID.
+func versionBeforeMigration(versions []models.TransactionVersion, migrationID string) (previous *models.TransactionVersion, written bool) {
+	last := len(versions) - 1
+	if last < 0 || versions[last].MigrationID != migrationID {
+		return nil, false
+	}
+
+	// Un archivo puede repetir un ID: todas las versiones seguidas de la migración se revierten juntas
+	for last >= 0 && versions[last].MigrationID == migrationID {
+		last--
+	}
+	if last < 0 || versions[last].Transaction.Deleted {
+		return nil, true
+	}
+	return &versions[last], true
+}
+
+// appendCapped agrega message a messages hasta maxStatsMessages; si no cabe marca truncated
+func appendCapped(messages []string, message string, truncated *bool) []string {
+	if len(messages) >= maxStatsMessages {
+		*truncated = true
+		return messages
+	}
+	return append(messages, message)
+}
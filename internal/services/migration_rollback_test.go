@@ -0,0 +1,203 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationService_RollbackMigration(t *testing.T) {
+	sqliteDB, _ := newTestSQLiteDatabase(t)
+	repositories := map[string]TransactionRepository{
+		"mock":   NewMockDatabase(),
+		"sqlite": sqliteDB,
+	}
+
+	for name, db := range repositories {
+		service := NewMigrationService(db)
+		service.GetReportService().SetForceMockMode(true)
+
+		first, err := service.ProcessCSV(strings.NewReader("id,user_id,amount,datetime\n1,1001,100,2024-01-15\n2,1001,50,2024-01-16\n"))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+
+		// La segunda migración sobrescribe el ID 1 (dos veces) y crea los IDs 3 y 4
+		second, err := service.ProcessCSV(strings.NewReader("id,user_id,amount,datetime\n1,1001,999,2024-01-15\n3,1002,30,2024-01-17\n1,1001,888,2024-01-15\n4,1002,40,2024-01-18\n"))
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+
+		// El ID 4 se elimina a mano después de la migración: la reversión no lo toca
+		if _, err := db.SoftDeleteTransaction(4, "wrong amount"); err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+
+		// dry_run calcula la reversión sin aplicarla
+		preview, err := service.RollbackMigration(second.MigrationID, true)
+		if err != nil || !preview.DryRun || preview.RestoredRecords != 1 || preview.DeletedRecords != 1 || preview.SkippedRecords != 1 {
+			t.Errorf("%s: unexpected preview %+v, err %v", name, preview, err)
+		}
+		if tx, _ := db.GetTransaction(1); tx.Amount != 888 {
+			t.Errorf("%s: expected dry run to change nothing, got %+v", name, tx)
+		}
+
+		report, err := service.RollbackMigration(second.MigrationID, false)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if report.RestoredRecords != 1 || report.DeletedRecords != 1 || report.UsersAffected != 2 || len(report.Changes) != 2 {
+			t.Fatalf("%s: unexpected report %+v", name, report)
+		}
+
+		// Los cambios siguen el orden de las líneas del archivo: el ID 3 (línea 3) y el ID 1 (última escritura en la línea 4)
+		if change := report.Changes[0]; change.Action != models.RollbackDeleted || change.TransactionID != 3 || change.Restored != nil {
+			t.Errorf("%s: unexpected change %+v", name, change)
+		}
+		if change := report.Changes[1]; change.Action != models.RollbackRestored || change.Reverted.Amount != 888 || change.Restored.Amount != 100 {
+			t.Errorf("%s: unexpected change %+v", name, change)
+		}
+
+		// El ID 1 vuelve al valor y la procedencia de la primera migración; el ID 3 queda eliminado
+		if tx, _ := db.GetTransaction(1); tx.Amount != 100 || tx.MigrationID != first.MigrationID || tx.Deleted {
+			t.Errorf("%s: expected id 1 restored, got %+v", name, tx)
+		}
+		if tx, _ := db.GetTransaction(3); !tx.Deleted || tx.DeleteReason != "rollback of migration "+second.MigrationID {
+			t.Errorf("%s: expected id 3 deleted, got %+v", name, tx)
+		}
+		if tx, _ := db.GetTransaction(4); tx.DeleteReason != "wrong amount" {
+			t.Errorf("%s: expected id 4 untouched, got %+v", name, tx)
+		}
+		if history := db.GetTransactionHistory(1); len(history) != 4 || history[3].MigrationID != "" {
+			t.Errorf("%s: expected the rollback as a new version, got %+v", name, history)
+		}
+
+		// Repetir la reversión no cambia nada
+		again, err := service.RollbackMigration(second.MigrationID, false)
+		if err != nil || again.RestoredRecords != 0 || again.DeletedRecords != 0 || again.SkippedRecords != 2 {
+			t.Errorf("%s: unexpected second rollback %+v, err %v", name, again, err)
+		}
+		if len(again.Skipped) != 2 || again.Skipped[0] != "id 3: already rolled back" || again.Skipped[1] != "id 4: changed after the migration" {
+			t.Errorf("%s: unexpected skipped %v", name, again.Skipped)
+		}
+
+		if _, err := service.RollbackMigration("mig-unknown", false); err != ErrMigrationNotFound {
+			t.Errorf("%s: expected ErrMigrationNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestMigrationService_RollbackRecreatedPurgedID(t *testing.T) {
+	for name, db := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			service := NewMigrationService(db)
+			service.GetReportService().SetForceMockMode(true)
+
+			// El ID 1 venía de un extracto, se eliminó y se purgó antes de la migración
+			baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+			db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime, ExternalID: "stmt-1"})
+			db.SoftDeleteTransaction(1, "duplicated")
+			if _, err := NewTransactionsService(db).PurgeDeletedTransactions(nil); err != nil {
+				t.Fatalf("Expected no error purging, got %v", err)
+			}
+
+			stats, err := service.ProcessCSV(strings.NewReader("id,user_id,amount,datetime\n1,1002,50,2024-01-16\n"))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// El ID no existía antes de la migración: se elimina en lugar de restaurar la versión purgada
+			report, err := service.RollbackMigration(stats.MigrationID, false)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if report.DeletedRecords != 1 || report.RestoredRecords != 0 || report.Changes[0].Action != models.RollbackDeleted {
+				t.Errorf("Expected the re-created ID to be deleted, got %+v", report)
+			}
+			tx, _ := db.GetTransaction(1)
+			if !tx.Deleted || tx.UserID != 1002 || tx.ExternalID != "" || tx.DeleteReason != rollbackReason(stats.MigrationID) {
+				t.Errorf("Expected the migration version deleted, got %+v", tx)
+			}
+		})
+	}
+}
+
+// writeBeforeSave simula una escritura concurrente: guarda write justo antes del primer lote
+type writeBeforeSave struct {
+	TransactionRepository
+	write models.UserTransaction
+	done  bool
+}
+
+func (r *writeBeforeSave) SaveTransactions(transactions []models.UserTransaction, options SaveOptions) ([]SaveResult, error) {
+	if !r.done {
+		r.done = true
+		r.TransactionRepository.SaveTransaction(r.write)
+	}
+	return r.TransactionRepository.SaveTransactions(transactions, options)
+}
+
+func TestMigrationService_RollbackKeepsConcurrentWrites(t *testing.T) {
+	for name, db := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			service := NewMigrationService(db)
+			service.GetReportService().SetForceMockMode(true)
+
+			stats, err := service.ProcessCSV(strings.NewReader("id,user_id,amount,datetime\n1,1001,100,2024-01-15\n2,1001,50,2024-01-16\n"))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// El ID 1 cambia después de calcular la reversión y antes de escribirla
+			corrected, _ := db.GetTransaction(1)
+			corrected.Amount = 75
+			racing := &writeBeforeSave{TransactionRepository: db, write: corrected}
+			racingService := NewMigrationService(racing)
+			racingService.GetReportService().SetForceMockMode(true)
+
+			report, err := racingService.RollbackMigration(stats.MigrationID, false)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if report.DeletedRecords != 1 || report.SkippedRecords != 1 || report.Skipped[0] != "id 1: changed after the migration" {
+				t.Errorf("Expected the changed transaction to be skipped on the retry, got %+v", report)
+			}
+			if tx, _ := db.GetTransaction(1); tx.Amount != 75 || tx.Deleted {
+				t.Errorf("Expected the concurrent write kept, got %+v", tx)
+			}
+			if tx, _ := db.GetTransaction(2); !tx.Deleted {
+				t.Errorf("Expected transaction 2 rolled back, got %+v", tx)
+			}
+		})
+	}
+}
+
+func TestVersionBeforeMigration(t *testing.T) {
+	versions := []models.TransactionVersion{
+		{Version: 1, MigrationID: "mig-a"},
+		{Version: 2, MigrationID: "mig-b"},
+		{Version: 3, MigrationID: "mig-b"},
+	}
+
+	if previous, written := versionBeforeMigration(versions, "mig-b"); !written || previous.Version != 1 {
+		t.Errorf("Expected version 1 before mig-b, got %+v", previous)
+	}
+	if _, written := versionBeforeMigration(versions, "mig-a"); written {
+		t.Error("Expected mig-a not to own the current version")
+	}
+	if previous, written := versionBeforeMigration(versions[:1], "mig-a"); !written || previous != nil {
+		t.Errorf("Expected mig-a to have created the transaction, got %+v", previous)
+	}
+	// Una versión anterior eliminada no se restaura: la migración volvió a crear el ID
+	deleted := []models.TransactionVersion{
+		{Version: 1, Transaction: models.UserTransaction{Deleted: true}},
+		{Version: 2, MigrationID: "mig-a"},
+	}
+	if previous, written := versionBeforeMigration(deleted, "mig-a"); !written || previous != nil {
+		t.Errorf("Expected mig-a to have re-created the transaction, got %+v", previous)
+	}
+	if _, written := versionBeforeMigration(nil, "mig-a"); written {
+		t.Error("Expected no history not to be written by the migration")
+	}
+}
@@ -2,8 +2,12 @@ package services
 
 import (
 	"api-stori/internal/models"
-	"encoding/csv"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"strconv"
 	"time"
@@ -11,12 +15,16 @@ import (
 
 // MigrationService maneja la migración de datos desde archivos CSV
 type MigrationService struct {
-	database      *MockDatabase
-	reportService *ReportService
+	database        TransactionRepository
+	reportService   *ReportService
+	mappingProfiles map[string]ColumnMapping // Perfiles de columnas con nombre (ver LoadColumnMappingProfiles)
+	accountUsers    map[string]int           // Usuario de cada cuenta de los extractos OFX/QIF (ver LoadAccountUsers)
+	archiveLimits   ArchiveLimits            // Límites de los archivos gzip y ZIP
+	archive         *ArchiveService          // Opcional: transacciones movidas fuera del almacén por la retención
 }
 
 // NewMigrationService crea una nueva instancia de MigrationService
-func NewMigrationService(database *MockDatabase) *MigrationService {
+func NewMigrationService(database TransactionRepository) *MigrationService {
 	// Crear ReportService por defecto (solo logs, modo mock)
 	defaultConfig := &models.ReportConfig{
 		Channels: []models.ReportChannel{models.LogChannel},
@@ -29,6 +37,7 @@ func NewMigrationService(database *MockDatabase) *MigrationService {
 	return &MigrationService{
 		database:      database,
 		reportService: defaultReportService,
+		archiveLimits: DefaultArchiveLimits,
 	}
 }
 
@@ -42,20 +51,146 @@ func (ms *MigrationService) GetReportService() *ReportService {
 	return ms.reportService
 }
 
+// SetMappingProfiles establece los perfiles de columnas que se pueden pedir por nombre en una subida
+func (ms *MigrationService) SetMappingProfiles(profiles map[string]ColumnMapping) {
+	ms.mappingProfiles = profiles
+}
+
+// SetAccountUsers establece el usuario al que pertenece cada cuenta de los extractos bancarios (OFX/QIF)
+func (ms *MigrationService) SetAccountUsers(accountUsers map[string]int) {
+	ms.accountUsers = accountUsers
+}
+
+// SetTransactionArchive establece el archivo de transacciones antiguas, para revertir también las archivadas
+func (ms *MigrationService) SetTransactionArchive(archive *ArchiveService) {
+	ms.archive = archive
+}
+
+// SetArchiveLimits establece los límites de tamaño descomprimido y cantidad de archivos de las subidas comprimidas
+func (ms *MigrationService) SetArchiveLimits(limits ArchiveLimits) {
+	ms.archiveLimits = limits
+}
+
+// MappingProfile retorna el perfil de columnas name, o ErrUnknownMappingProfile si no existe
+func (ms *MigrationService) MappingProfile(name string) (ColumnMapping, error) {
+	mapping, exists := ms.mappingProfiles[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMappingProfile, name)
+	}
+	return mapping, nil
+}
+
+// ErrMigrationRejected indica que una migración atómica fue rechazada y no se guardó ninguna fila
+var ErrMigrationRejected = errors.New("migration rejected: no transactions were saved")
+
+// MigrationOptions opciones de una migración
+type MigrationOptions struct {
+	ConflictPolicy ConflictPolicy // Qué hacer con IDs que ya existen (default: overwrite)
+	Atomic         bool           // Valida el archivo completo y guarda todas las filas o ninguna
+	MigrationID    string         // Identificador de la migración; si está vacío se genera uno
+	SourceFile     string         // Nombre del archivo subido; se guarda como procedencia de cada transacción
+	Format         FileFormat     // Formato del archivo (default: csv); en un ZIP, el de cada archivo según su extensión
+	Compression    Compression    // Compresión del archivo (default: ninguna)
+	Sheet          string         // Hoja de un archivo XLSX: nombre o posición desde 1 (default: la primera)
+	DefaultUserID  int            // Usuario de las cuentas OFX/QIF que no están en la configuración (0 = ninguno)
+	Mapping        ColumnMapping  // Nombres de columna aceptados por campo (nil = solo los nombres de los campos)
+	ContentSHA256  string         // SHA-256 del archivo recibido (opcional); se registra en el resultado y el reporte
+	HashContent    bool           // Sin ContentSHA256: calcularlo mientras se lee el archivo, sin copiarlo antes
+
+	// OnProgress se llama cada progressInterval filas leídas y al terminar (opcional)
+	OnProgress func(progress MigrationProgress)
+}
+
+// MigrationProgress contadores parciales de una migración en curso
+type MigrationProgress struct {
+	ProcessedRecords int `json:"processed_records"`
+	SuccessRecords   int `json:"success_records"`
+	ErrorRecords     int `json:"error_records"`
+	SkippedRecords   int `json:"skipped_records"`
+}
+
+// progressInterval cada cuántas filas leídas se notifica el progreso
+const progressInterval = 1000
+
+// defaultSourceFile nombre usado en reportes cuando no se conoce el archivo de origen (sin extensión)
+const defaultSourceFile = "uploaded_file"
+
+// withDefaults completa las opciones no indicadas
+func (o MigrationOptions) withDefaults() MigrationOptions {
+	if o.ConflictPolicy == "" {
+		o.ConflictPolicy = ConflictOverwrite
+	}
+	if o.MigrationID == "" {
+		o.MigrationID = NewMigrationID()
+	}
+	if o.Format == "" {
+		o.Format = FormatCSV
+	}
+	return o
+}
+
+// reportFilename nombre del archivo en los reportes: el subido o uno genérico con la extensión del formato
+func (o MigrationOptions) reportFilename() string {
+	if o.SourceFile != "" {
+		return o.SourceFile
+	}
+	return defaultSourceFile + "." + string(o.Format)
+}
+
+// maxStatsMessages máximo de mensajes de error y de conflicto que se guardan por migración.
+// Los contadores siguen siendo exactos; el límite mantiene acotada la memoria con archivos muy grandes.
+const maxStatsMessages = 10000
+
+// importOrigin procedencia común a todas las filas de una migración
+type importOrigin struct {
+	migrationID string
+	sourceFile  string
+	importedAt  time.Time
+}
+
+// stamp agrega a la transacción la procedencia de la línea lineNumber del archivo
+func (o importOrigin) stamp(transaction models.UserTransaction, lineNumber int) models.UserTransaction {
+	importedAt := o.importedAt
+	transaction.MigrationID = o.migrationID
+	transaction.SourceFile = o.sourceFile
+	transaction.SourceLine = lineNumber
+	transaction.ImportedAt = &importedAt
+	return transaction
+}
+
+// MigrationValidation resultado de validar un archivo sin migrarlo
+type MigrationValidation struct {
+	Valid  bool                    `json:"valid"` // true si todas las filas se guardarían sin error
+	Stats  *MigrationStats         `json:"stats"`
+	Report *models.MigrationReport `json:"report"`
+}
+
 // MigrationStats representa las estadísticas de migración (usado tanto para procesamiento como respuesta)
 type MigrationStats struct {
-	TotalRecords   int      `json:"total_records"`
-	SuccessRecords int      `json:"success_records"`
-	ErrorRecords   int      `json:"error_records"`
-	Errors         []string `json:"errors,omitempty"`
+	MigrationID     string         `json:"migration_id"`
+	ContentSHA256   string         `json:"sha256,omitempty"`
+	TotalRecords    int            `json:"total_records"`
+	SuccessRecords  int            `json:"success_records"`
+	ErrorRecords    int            `json:"error_records"`
+	SkippedRecords  int            `json:"skipped_records"`
+	ConflictRecords int            `json:"conflict_records"`
+	ConflictPolicy  ConflictPolicy `json:"conflict_policy"`
+	Errors          []string       `json:"errors,omitempty"`
+	Conflicts       []string       `json:"conflicts,omitempty"`
+
+	// Detalle por archivo cuando se sube un ZIP
+	Files     []models.FileReport `json:"files,omitempty"`
+	fileIndex map[string]int      // Posición en Files de cada archivo, por su nombre "<zip>/<archivo>"
 
 	// Campos internos para cálculos (no se serializan en JSON)
-	UsersAffected  map[int]bool
-	TotalAmount    float64
-	LargestAmount  float64
-	SmallestAmount float64
-	FirstDate      time.Time
-	LastDate       time.Time
+	UsersAffected  map[int]bool `json:"-"`
+	TotalAmount    float64      `json:"-"`
+	LargestAmount  float64      `json:"-"`
+	SmallestAmount float64      `json:"-"`
+	FirstDate      time.Time    `json:"-"`
+	LastDate       time.Time    `json:"-"`
+
+	onProgress func(progress MigrationProgress)
 }
 
 // NewMigrationStats crea una nueva instancia de estadísticas
@@ -63,6 +198,7 @@ func NewMigrationStats() *MigrationStats {
 	return &MigrationStats{
 		UsersAffected: make(map[int]bool),
 		Errors:        []string{},
+		Conflicts:     []string{},
 	}
 }
 
@@ -92,128 +228,447 @@ func (ms *MigrationStats) UpdateSuccess(transaction models.UserTransaction) {
 // UpdateError actualiza las estadísticas para una transacción con error
 func (ms *MigrationStats) UpdateError(lineNumber int, err error) {
 	ms.ErrorRecords++
-	errorMsg := fmt.Sprintf("Line %d: %v", lineNumber, err)
-	ms.Errors = append(ms.Errors, errorMsg)
+	if len(ms.Errors) < maxStatsMessages {
+		ms.Errors = append(ms.Errors, fmt.Sprintf("Line %d: %v", lineNumber, err))
+	}
+}
+
+// UpdateConflict registra un ID que ya existía en la base de datos
+func (ms *MigrationStats) UpdateConflict(lineNumber int, transactionID int) {
+	ms.ConflictRecords++
+	if len(ms.Conflicts) < maxStatsMessages {
+		conflictMsg := fmt.Sprintf("Line %d: transaction ID %d already exists (policy: %s)", lineNumber, transactionID, ms.ConflictPolicy)
+		ms.Conflicts = append(ms.Conflicts, conflictMsg)
+	}
+}
+
+// UpdateSkipped registra una transacción descartada por la política de conflictos
+func (ms *MigrationStats) UpdateSkipped() {
+	ms.SkippedRecords++
+}
+
+// trackFile retorna el detalle del archivo name de un ZIP, registrándolo si es la primera vez.
+// Retorna nil si name está vacío (el registro no viene de un ZIP).
+func (ms *MigrationStats) trackFile(name string) *models.FileReport {
+	if name == "" {
+		return nil
+	}
+	if ms.fileIndex == nil {
+		ms.fileIndex = make(map[string]int)
+	}
+	if _, exists := ms.fileIndex[name]; !exists {
+		ms.fileIndex[name] = len(ms.Files)
+		ms.Files = append(ms.Files, models.FileReport{Filename: name})
+	}
+	return &ms.Files[ms.fileIndex[name]]
+}
+
+// fileReport retorna el detalle del archivo name de un ZIP, o nil si no se está registrando
+func (ms *MigrationStats) fileReport(name string) *models.FileReport {
+	index, exists := ms.fileIndex[name]
+	if !exists {
+		return nil
+	}
+	return &ms.Files[index]
+}
+
+// setFiles ordena el detalle por archivo según files (el orden del ZIP), incluyendo los archivos sin registros
+func (ms *MigrationStats) setFiles(archiveName string, files []models.FileReport) {
+	if files == nil {
+		return
+	}
+	ordered := make([]models.FileReport, len(files))
+	index := make(map[string]int, len(files))
+	for i, file := range files {
+		name := archiveName + "/" + file.Filename
+		if tracked := ms.fileReport(name); tracked != nil {
+			ordered[i] = *tracked
+		}
+		ordered[i].Filename = file.Filename
+		ordered[i].Format = file.Format
+		index[name] = i
+	}
+	ms.Files = ordered
+	ms.fileIndex = index
+}
+
+// Progress retorna los contadores actuales de la migración
+func (ms *MigrationStats) Progress() MigrationProgress {
+	return MigrationProgress{
+		ProcessedRecords: ms.TotalRecords,
+		SuccessRecords:   ms.SuccessRecords,
+		ErrorRecords:     ms.ErrorRecords,
+		SkippedRecords:   ms.SkippedRecords,
+	}
+}
+
+// Result resume las estadísticas para la respuesta de la migración, con los primeros maxErrors errores
+func (ms *MigrationStats) Result(maxErrors int) *models.MigrationResult {
+	result := &models.MigrationResult{
+		MigrationID:     ms.MigrationID,
+		SHA256:          ms.ContentSHA256,
+		TotalRecords:    ms.TotalRecords,
+		SuccessRecords:  ms.SuccessRecords,
+		ErrorRecords:    ms.ErrorRecords,
+		SkippedRecords:  ms.SkippedRecords,
+		ConflictRecords: ms.ConflictRecords,
+		ConflictPolicy:  string(ms.ConflictPolicy),
+		UsersAffected:   len(ms.UsersAffected),
+		TotalAmount:     ms.TotalAmount,
+		LargestAmount:   ms.LargestAmount,
+		SmallestAmount:  ms.SmallestAmount,
+		Errors:          ms.Errors,
+		Files:           ms.Files,
+	}
+
+	if ms.SuccessRecords > 0 {
+		result.AverageAmount = ms.TotalAmount / float64(ms.SuccessRecords)
+		result.DateRange = &models.DateRange{From: ms.FirstDate, To: ms.LastDate}
+	}
+
+	// Errors puede estar acotado por maxStatsMessages: ErrorRecords es el total real
+	if len(result.Errors) > maxErrors {
+		result.Errors = result.Errors[:maxErrors]
+	}
+	result.ErrorsTruncated = len(result.Errors) < ms.ErrorRecords
+
+	return result
+}
+
+// reportProgress notifica el progreso si la migración tiene un callback configurado
+func (ms *MigrationStats) reportProgress() {
+	if ms.onProgress != nil {
+		ms.onProgress(ms.Progress())
+	}
 }
 
 // ProcessCSV procesa un archivo CSV y migra las transacciones a la base de datos
 func (ms *MigrationService) ProcessCSV(reader io.Reader) (*MigrationStats, error) {
+	return ms.ProcessCSVWithOptions(reader, MigrationOptions{})
+}
+
+// ProcessCSVWithOptions procesa un archivo CSV aplicando las opciones de migración indicadas
+func (ms *MigrationService) ProcessCSVWithOptions(reader io.Reader, options MigrationOptions) (*MigrationStats, error) {
+	options.Format = FormatCSV
+	return ms.ProcessFile(reader, options)
+}
+
+// ProcessFile procesa un archivo en el formato options.Format (CSV por defecto) aplicando las opciones
+// de migración indicadas. El archivo se lee registro por registro desde reader sin cargarlo completo en memoria.
+func (ms *MigrationService) ProcessFile(reader io.Reader, options MigrationOptions) (*MigrationStats, error) {
+	options = options.withDefaults()
+
 	// Capturar tiempo de inicio
 	startTime := time.Now()
 
-	csvReader := csv.NewReader(reader)
+	var contentHash hash.Hash
+	if options.HashContent && options.ContentSHA256 == "" {
+		contentHash = sha256.New()
+		reader = io.TeeReader(reader, contentHash)
+	}
 
-	// Leer todas las líneas del CSV
-	records, err := csvReader.ReadAll()
+	source, err := ms.openRecordSource(reader, options)
 	if err != nil {
-		return nil, fmt.Errorf("error reading CSV: %v", err)
+		return nil, err
 	}
+	defer closeRecordSource(source)
 
-	if len(records) == 0 {
-		return nil, fmt.Errorf("CSV file is empty")
+	// Inicializar estadísticas en línea
+	stats := NewMigrationStats()
+	stats.ConflictPolicy = options.ConflictPolicy
+	stats.MigrationID = options.MigrationID
+	stats.ContentSHA256 = options.ContentSHA256
+	stats.onProgress = options.OnProgress
+
+	saveOptions := SaveOptions{ConflictPolicy: options.ConflictPolicy, MigrationID: options.MigrationID}
+	origin := importOrigin{
+		migrationID: options.MigrationID,
+		sourceFile:  options.SourceFile,
+		importedAt:  startTime.UTC(),
+	}
+	if options.Atomic {
+		err = ms.migrateAtomically(source, origin, saveOptions, stats)
+	} else {
+		err = ms.migrateRowByRow(source, origin, saveOptions, stats)
 	}
+	stats.setFiles(options.reportFilename(), archiveFiles(source))
 
-	// Verificar que tenga el header esperado
-	header := records[0]
-	expectedHeader := []string{"id", "user_id", "amount", "datetime"}
-	if !ms.validateHeader(header, expectedHeader) {
-		return nil, fmt.Errorf("invalid CSV header. Expected: %v, Got: %v", expectedHeader, header)
+	// La lectura puede terminar antes del final del archivo (p. ej. espacios después de un arreglo JSON)
+	if contentHash != nil && err == nil {
+		if _, drainErr := io.Copy(io.Discard, reader); drainErr == nil {
+			stats.ContentSHA256 = hex.EncodeToString(contentHash.Sum(nil))
+		}
 	}
 
-	// Inicializar estadísticas en línea
+	stats.reportProgress()
+
+	// Calcular tiempo de procesamiento real
+	processingTime := time.Since(startTime)
+
+	// Enviar reporte de migración (asíncrono)
+	if ms.reportService != nil {
+		filename := options.reportFilename()
+		go func() {
+			report := ms.generateMigrationReportFromStats(stats, filename, 0, processingTime)
+			ms.reportService.SendMigrationReport(report)
+		}()
+	}
+
+	return stats, err
+}
+
+// ValidateCSV valida un archivo CSV sin migrarlo (ver ValidateFile)
+func (ms *MigrationService) ValidateCSV(reader io.Reader, options MigrationOptions) (*MigrationValidation, error) {
+	options.Format = FormatCSV
+	return ms.ValidateFile(reader, options)
+}
+
+// ValidateFile simula la migración sin escribir nada: valida el header, parsea cada registro y evalúa la
+// política de conflictos contra las transacciones ya guardadas. No envía reportes ni genera archivos.
+func (ms *MigrationService) ValidateFile(reader io.Reader, options MigrationOptions) (*MigrationValidation, error) {
+	options = options.withDefaults()
+
+	startTime := time.Now()
+
+	source, err := ms.openRecordSource(reader, options)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRecordSource(source)
+
 	stats := NewMigrationStats()
-	stats.TotalRecords = len(records) - 1 // Excluir header
+	stats.ConflictPolicy = options.ConflictPolicy
+	stats.MigrationID = options.MigrationID
+
+	origin := importOrigin{
+		migrationID: options.MigrationID,
+		sourceFile:  options.SourceFile,
+		importedAt:  startTime.UTC(),
+	}
+	pending := make(map[int]models.UserTransaction) // Escrituras simuladas de líneas anteriores del archivo
+	err = ms.streamRecords(source, origin, stats, func(lineNumber int, transaction models.UserTransaction) {
+		ms.validateRecord(stats, pending, lineNumber, transaction)
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.setFiles(options.reportFilename(), archiveFiles(source))
+
+	return &MigrationValidation{
+		Valid:  stats.ErrorRecords == 0,
+		Stats:  stats,
+		Report: ms.buildMigrationReport(stats, options.reportFilename(), 0, time.Since(startTime)),
+	}, nil
+}
+
+// validateRecord registra en stats lo que pasaría al guardar transaction, sin escribirla.
+// pending guarda las escrituras simuladas de las líneas anteriores: un ID repetido en el archivo
+// se compara con lo que esas líneas habrían guardado, como al migrar, y no solo con el almacén.
+func (ms *MigrationService) validateRecord(stats *MigrationStats, pending map[int]models.UserTransaction, lineNumber int, transaction models.UserTransaction) {
+	previous, exists := pending[transaction.ID]
+	if !exists {
+		previous, exists = ms.database.GetTransaction(transaction.ID)
+	}
+	if !exists {
+		if transaction.ID != 0 {
+			pending[transaction.ID] = transaction
+		}
+		ms.recordSaveResult(stats, lineNumber, transaction, SaveResult{Transaction: transaction, Outcome: SaveInserted})
+		return
+	}
 
-	// Procesar cada línea de datos (saltar header) - ESTADÍSTICAS EN LÍNEA
-	for i, record := range records[1:] {
-		lineNumber := i + 2 // +2 porque empezamos desde línea 2
+	outcome, err := resolveConflict(previous, transaction, stats.ConflictPolicy)
+	if err != nil {
+		stats.UpdateConflict(lineNumber, transaction.ID)
+		stats.UpdateError(lineNumber, err)
+		if file := stats.fileReport(transaction.SourceFile); file != nil {
+			file.ErrorRecords++
+		}
+		return
+	}
+	if outcome == SaveOverwritten {
+		pending[transaction.ID] = transaction
+	}
+	ms.recordSaveResult(stats, lineNumber, transaction, SaveResult{Transaction: transaction, Outcome: outcome, Previous: &previous})
+}
 
-		// Parsear transacción
-		transaction, err := ms.parseTransaction(record, lineNumber)
+// streamRecords lee los registros uno a uno y entrega cada transacción válida con su número de línea.
+// Los registros inválidos se registran en stats y se omiten; solo un error de lectura del archivo corta el recorrido.
+func (ms *MigrationService) streamRecords(source recordSource, origin importOrigin, stats *MigrationStats, handle func(lineNumber int, transaction models.UserTransaction)) error {
+	for {
+		if stats.TotalRecords > 0 && stats.TotalRecords%progressInterval == 0 {
+			stats.reportProgress()
+		}
+
+		record, err := source.next()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			stats.UpdateError(lineNumber, err)
-			fmt.Printf("Error parsing record at line %d: %v\n", lineNumber, err)
+			return err
+		}
+		stats.TotalRecords++
+		file := stats.trackFile(record.file)
+		if file != nil {
+			file.TotalRecords++
+		}
+
+		if record.err != nil {
+			stats.UpdateError(record.line, record.err)
+			if file != nil {
+				file.ErrorRecords++
+			}
+			fmt.Printf("Error parsing record at line %d: %v\n", record.line, record.err)
 			continue
 		}
 
-		// Guardar en la base de datos mock
-		savedTransaction, err := ms.database.SaveTransaction(transaction)
+		// Los registros de un ZIP guardan como procedencia el archivo del que salen
+		recordOrigin := origin
+		if record.file != "" {
+			recordOrigin.sourceFile = record.file
+		}
+		handle(record.line, recordOrigin.stamp(record.transaction, record.line))
+	}
+}
+
+// migrateRowByRow guarda cada fila por separado a medida que se lee; las filas con error se omiten
+// y el resto se guarda. La memoria usada no depende del tamaño del archivo.
+func (ms *MigrationService) migrateRowByRow(source recordSource, origin importOrigin, saveOptions SaveOptions, stats *MigrationStats) error {
+	return ms.streamRecords(source, origin, stats, func(lineNumber int, transaction models.UserTransaction) {
+		// Guardar en la base de datos aplicando la política de conflictos
+		result, err := ms.database.SaveTransactionWithOptions(transaction, saveOptions)
 		if err != nil {
-			stats.UpdateError(lineNumber, err)
-			fmt.Printf("Error saving transaction at line %d: %v\n", lineNumber, err)
-			continue
+			ms.recordSaveError(stats, lineNumber, transaction, err)
+			return
 		}
 
 		// Actualizar estadísticas en línea (NO almacenar en memoria)
-		stats.UpdateSuccess(savedTransaction)
+		ms.recordSaveResult(stats, lineNumber, transaction, result)
+	})
+}
+
+// migrateAtomically valida todas las filas y las guarda en un solo lote. Si alguna fila es
+// inválida o el lote es rechazado no se guarda nada y se retorna ErrMigrationRejected.
+// Las transacciones válidas se mantienen en memoria hasta guardar el lote.
+func (ms *MigrationService) migrateAtomically(source recordSource, origin importOrigin, saveOptions SaveOptions, stats *MigrationStats) error {
+	var transactions []models.UserTransaction
+	var lineNumbers []int
+
+	// Primera pasada: validar el archivo completo antes de tocar la base de datos
+	err := ms.streamRecords(source, origin, stats, func(lineNumber int, transaction models.UserTransaction) {
+		transactions = append(transactions, transaction)
+		lineNumbers = append(lineNumbers, lineNumber)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Calcular tiempo de procesamiento real
-	processingTime := time.Since(startTime)
+	if stats.ErrorRecords > 0 {
+		return ErrMigrationRejected
+	}
 
-	// Enviar reporte de migración (asíncrono)
-	if ms.reportService != nil {
-		go func() {
-			report := ms.generateMigrationReportFromStats(stats, "uploaded_file.csv", 0, processingTime)
-			ms.reportService.SendMigrationReport(report)
-		}()
+	results, err := ms.database.SaveTransactions(transactions, saveOptions)
+	if err != nil {
+		var batchErr *BatchSaveError
+		if !errors.As(err, &batchErr) {
+			return fmt.Errorf("%w: %v", ErrMigrationRejected, err)
+		}
+
+		for _, itemErr := range batchErr.Errors {
+			ms.recordSaveError(stats, lineNumbers[itemErr.Index], transactions[itemErr.Index], itemErr.Err)
+		}
+		return ErrMigrationRejected
+	}
+
+	for i, result := range results {
+		ms.recordSaveResult(stats, lineNumbers[i], transactions[i], result)
 	}
 
-	return stats, nil
+	return nil
 }
 
-// validateHeader verifica que el header del CSV sea correcto
-func (ms *MigrationService) validateHeader(header, expected []string) bool {
-	if len(header) != len(expected) {
-		return false
+// recordSaveError registra en las estadísticas una transacción que no se pudo guardar
+func (ms *MigrationService) recordSaveError(stats *MigrationStats, lineNumber int, transaction models.UserTransaction, err error) {
+	if errors.Is(err, ErrTransactionConflict) {
+		stats.UpdateConflict(lineNumber, transaction.ID)
 	}
+	stats.UpdateError(lineNumber, err)
+	if file := stats.fileReport(transaction.SourceFile); file != nil {
+		file.ErrorRecords++
+	}
+	fmt.Printf("Error saving transaction at line %d: %v\n", lineNumber, err)
+}
 
-	for i, col := range header {
-		if col != expected[i] {
-			return false
+// recordSaveResult registra en las estadísticas el resultado de una transacción guardada
+func (ms *MigrationService) recordSaveResult(stats *MigrationStats, lineNumber int, transaction models.UserTransaction, result SaveResult) {
+	file := stats.fileReport(transaction.SourceFile)
+	switch result.Outcome {
+	case SaveSkipped:
+		stats.UpdateConflict(lineNumber, transaction.ID)
+		stats.UpdateSkipped()
+		if file != nil {
+			file.SkippedRecords++
 		}
+		return
+	case SaveOverwritten:
+		stats.UpdateConflict(lineNumber, transaction.ID)
 	}
 
-	return true
+	stats.UpdateSuccess(result.Transaction)
+	if file != nil {
+		file.SuccessRecords++
+	}
+}
+
+// NewMigrationID genera un identificador único para una migración
+func NewMigrationID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return fmt.Sprintf("mig-%s-%s", time.Now().UTC().Format("20060102150405"), hex.EncodeToString(random))
 }
 
-// parseTransaction convierte una línea del CSV en una transacción
-func (ms *MigrationService) parseTransaction(record []string, lineNumber int) (models.UserTransaction, error) {
-	if len(record) != 4 {
+// transactionDateLayouts formatos de fecha aceptados, en orden de prueba. El último define el mensaje de error.
+var transactionDateLayouts = []string{
+	"2006-01-02 15:04:05", // Formato estándar
+	"2006-01-02T15:04:05", // Formato ISO
+	time.RFC3339,          // Formato ISO con zona horaria (el de UserTransaction en JSON)
+	"2006-01-02",          // Solo fecha
+}
+
+// parseTransaction convierte una línea del archivo en una transacción tomando cada campo de su columna
+func parseTransaction(record []string, columns columnIndex, lineNumber int) (models.UserTransaction, error) {
+	if len(record) != columns.width {
 		return models.UserTransaction{}, fmt.Errorf("invalid number of columns at line %d", lineNumber)
 	}
 
 	// Parsear ID
-	id, err := strconv.Atoi(record[0])
+	id, err := strconv.Atoi(record[columns.id])
 	if err != nil {
 		return models.UserTransaction{}, fmt.Errorf("invalid ID at line %d: %v", lineNumber, err)
 	}
 
 	// Parsear UserID
-	userID, err := strconv.Atoi(record[1])
+	userID, err := strconv.Atoi(record[columns.userID])
 	if err != nil {
 		return models.UserTransaction{}, fmt.Errorf("invalid user_id at line %d: %v", lineNumber, err)
 	}
 
 	// Parsear Amount
-	amount, err := strconv.ParseFloat(record[2], 64)
+	amount, err := strconv.ParseFloat(record[columns.amount], 64)
 	if err != nil {
 		return models.UserTransaction{}, fmt.Errorf("invalid amount at line %d: %v", lineNumber, err)
 	}
 
-	// Parsear DateTime
-	datetime, err := time.Parse("2006-01-02 15:04:05", record[3])
-	if err != nil {
-		// Intentar con otro formato común
-		datetime, err = time.Parse("2006-01-02T15:04:05", record[3])
-		if err != nil {
-			// Intentar con formato de fecha solamente
-			datetime, err = time.Parse("2006-01-02", record[3])
-			if err != nil {
-				return models.UserTransaction{}, fmt.Errorf("invalid datetime at line %d: %v", lineNumber, err)
-			}
+	// Parsear DateTime probando cada formato soportado
+	var datetime time.Time
+	for _, layout := range transactionDateLayouts {
+		if datetime, err = time.Parse(layout, record[columns.datetime]); err == nil {
+			break
 		}
 	}
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("invalid datetime at line %d: %v", lineNumber, err)
+	}
 
 	return models.UserTransaction{
 		ID:       id,
@@ -225,35 +680,47 @@ func (ms *MigrationService) parseTransaction(record []string, lineNumber int) (m
 
 // generateMigrationReportFromStats genera un reporte basado en estadísticas en línea
 func (ms *MigrationService) generateMigrationReportFromStats(stats *MigrationStats, filename string, fileSize int64, processingTime time.Duration) *models.MigrationReport {
-	// Calcular promedio basado en transacciones exitosas
-	averageAmount := float64(0)
-	if stats.SuccessRecords > 0 {
-		averageAmount = stats.TotalAmount / float64(stats.SuccessRecords)
-	}
+	report := ms.buildMigrationReport(stats, filename, fileSize, processingTime)
 
 	// Generar archivo CSV de errores si hay errores
-	var errorFileCSV string
 	if len(stats.Errors) > 0 && ms.reportService != nil {
 		if errorPath, err := ms.reportService.GenerateErrorCSV(stats.Errors, filename); err == nil {
-			errorFileCSV = errorPath
+			report.ErrorFileCSV = errorPath
 		}
 	}
 
+	return report
+}
+
+// buildMigrationReport calcula las cifras del reporte a partir de las estadísticas, sin efectos secundarios
+func (ms *MigrationService) buildMigrationReport(stats *MigrationStats, filename string, fileSize int64, processingTime time.Duration) *models.MigrationReport {
+	// Calcular promedio basado en transacciones exitosas
+	averageAmount := float64(0)
+	if stats.SuccessRecords > 0 {
+		averageAmount = stats.TotalAmount / float64(stats.SuccessRecords)
+	}
+
 	report := &models.MigrationReport{
-		Timestamp:      time.Now(),
-		Filename:       filename,
-		FileSize:       fileSize,
-		TotalRecords:   stats.TotalRecords,
-		SuccessRecords: stats.SuccessRecords,
-		ErrorRecords:   stats.ErrorRecords,
-		ProcessingTime: processingTime,
-		UsersAffected:  len(stats.UsersAffected),
-		TotalAmount:    stats.TotalAmount,
-		AverageAmount:  averageAmount,
-		LargestAmount:  stats.LargestAmount,
-		SmallestAmount: stats.SmallestAmount,
-		Errors:         stats.Errors,
-		ErrorFileCSV:   errorFileCSV,
+		MigrationID:     stats.MigrationID,
+		SHA256:          stats.ContentSHA256,
+		Timestamp:       time.Now(),
+		Filename:        filename,
+		FileSize:        fileSize,
+		TotalRecords:    stats.TotalRecords,
+		SuccessRecords:  stats.SuccessRecords,
+		ErrorRecords:    stats.ErrorRecords,
+		SkippedRecords:  stats.SkippedRecords,
+		ConflictRecords: stats.ConflictRecords,
+		ConflictPolicy:  string(stats.ConflictPolicy),
+		Conflicts:       stats.Conflicts,
+		ProcessingTime:  processingTime,
+		UsersAffected:   len(stats.UsersAffected),
+		TotalAmount:     stats.TotalAmount,
+		AverageAmount:   averageAmount,
+		LargestAmount:   stats.LargestAmount,
+		SmallestAmount:  stats.SmallestAmount,
+		Errors:          stats.Errors,
+		Files:           stats.Files,
 	}
 
 	// Configurar rango de fechas
@@ -2,8 +2,12 @@ package services
 
 import (
 	"api-stori/internal/models"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMigrationService_ProcessCSV(t *testing.T) {
@@ -164,3 +168,392 @@ func TestMigrationService_ProcessCSVDifferentDateFormats(t *testing.T) {
 		t.Errorf("Expected 3 success records, got %d", result.SuccessRecords)
 	}
 }
+
+func TestMigrationService_ProcessCSVConflictPolicies(t *testing.T) {
+	initialCSV := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,-75.25,2024-01-15 14:45:00`
+
+	// La fila 1 es idéntica, la fila 2 cambia el monto y la fila 3 es nueva
+	reuploadCSV := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,-80.00,2024-01-15 14:45:00
+3,1002,200.00,2024-01-16 09:15:00`
+
+	tests := []struct {
+		policy            ConflictPolicy
+		expectedSuccess   int
+		expectedErrors    int
+		expectedSkipped   int
+		expectedConflicts int
+		expectedAmount2   float64
+	}{
+		{ConflictOverwrite, 3, 0, 0, 2, -80.00},
+		{ConflictReject, 1, 2, 0, 2, -75.25},
+		{ConflictSkip, 1, 0, 2, 2, -75.25},
+		{ConflictFailIfDifferent, 2, 1, 0, 1, -75.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			db := NewMockDatabase()
+			service := NewMigrationService(db)
+			service.GetReportService().SetForceMockMode(true)
+
+			if _, err := service.ProcessCSV(strings.NewReader(initialCSV)); err != nil {
+				t.Fatalf("Expected no error on initial upload, got %v", err)
+			}
+
+			stats, err := service.ProcessCSVWithOptions(strings.NewReader(reuploadCSV), MigrationOptions{ConflictPolicy: tt.policy})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if stats.SuccessRecords != tt.expectedSuccess {
+				t.Errorf("Expected %d success records, got %d", tt.expectedSuccess, stats.SuccessRecords)
+			}
+			if stats.ErrorRecords != tt.expectedErrors {
+				t.Errorf("Expected %d error records, got %d", tt.expectedErrors, stats.ErrorRecords)
+			}
+			if stats.SkippedRecords != tt.expectedSkipped {
+				t.Errorf("Expected %d skipped records, got %d", tt.expectedSkipped, stats.SkippedRecords)
+			}
+			if stats.ConflictRecords != tt.expectedConflicts || len(stats.Conflicts) != tt.expectedConflicts {
+				t.Errorf("Expected %d conflicts, got %d (%v)", tt.expectedConflicts, stats.ConflictRecords, stats.Conflicts)
+			}
+			if stats.ConflictPolicy != tt.policy {
+				t.Errorf("Expected policy %s in stats, got %s", tt.policy, stats.ConflictPolicy)
+			}
+
+			tx, _ := db.GetTransaction(2)
+			if tx.Amount != tt.expectedAmount2 {
+				t.Errorf("Expected transaction 2 amount %.2f, got %.2f", tt.expectedAmount2, tx.Amount)
+			}
+
+			report := service.generateMigrationReportFromStats(stats, "test.csv", 0, 0)
+			if report.ConflictRecords != tt.expectedConflicts || len(report.Conflicts) != tt.expectedConflicts {
+				t.Errorf("Expected %d conflicts in report, got %d", tt.expectedConflicts, report.ConflictRecords)
+			}
+		})
+	}
+}
+
+func TestMigrationService_ProcessCSVAtomic(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Dos filas inválidas: se reportan ambas y no se guarda ninguna fila
+	invalidCSV := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,invalid,2024-01-15 14:45:00
+3,1002,200.00,not-a-date`
+
+	stats, err := service.ProcessCSVWithOptions(strings.NewReader(invalidCSV), MigrationOptions{Atomic: true})
+	if !errors.Is(err, ErrMigrationRejected) {
+		t.Fatalf("Expected ErrMigrationRejected, got %v", err)
+	}
+	if stats.ErrorRecords != 2 || len(stats.Errors) != 2 {
+		t.Errorf("Expected 2 errors, got %d (%v)", stats.ErrorRecords, stats.Errors)
+	}
+	if stats.SuccessRecords != 0 || db.GetTransactionCount() != 0 {
+		t.Errorf("Expected no transactions saved, got %d", db.GetTransactionCount())
+	}
+
+	// Un conflicto también rechaza el lote completo
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 1, DateTime: time.Now()})
+	conflictCSV := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,-75.25,2024-01-15 14:45:00`
+
+	stats, err = service.ProcessCSVWithOptions(strings.NewReader(conflictCSV), MigrationOptions{Atomic: true, ConflictPolicy: ConflictReject})
+	if !errors.Is(err, ErrMigrationRejected) {
+		t.Fatalf("Expected ErrMigrationRejected, got %v", err)
+	}
+	if stats.ConflictRecords != 1 || stats.ErrorRecords != 1 {
+		t.Errorf("Expected 1 conflict error, got %d conflicts and %d errors", stats.ConflictRecords, stats.ErrorRecords)
+	}
+	if db.GetTransactionCount() != 1 {
+		t.Errorf("Expected only the pre-existing transaction, got %d", db.GetTransactionCount())
+	}
+
+	// Un archivo válido se guarda completo
+	stats, err = service.ProcessCSVWithOptions(strings.NewReader(conflictCSV), MigrationOptions{Atomic: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.SuccessRecords != 2 || db.GetTransactionCount() != 2 {
+		t.Errorf("Expected 2 transactions saved, got %d", db.GetTransactionCount())
+	}
+}
+
+func TestMigrationService_ProcessCSVRecordsProvenance(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	csvData := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,invalid,2024-01-15 14:45:00
+3,1002,200.00,2024-01-16 09:15:00`
+
+	before := time.Now().UTC()
+	stats, err := service.ProcessCSVWithOptions(strings.NewReader(csvData), MigrationOptions{SourceFile: "january.csv"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	transactions := db.GetTransactionsByMigrationID(stats.MigrationID)
+	if len(transactions) != 2 {
+		t.Fatalf("Expected 2 transactions for migration %s, got %d", stats.MigrationID, len(transactions))
+	}
+
+	// La línea de origen cuenta el header: la fila inválida (línea 3) no se guarda
+	expectedLines := []int{2, 4}
+	for i, transaction := range transactions {
+		if transaction.SourceFile != "january.csv" || transaction.SourceLine != expectedLines[i] {
+			t.Errorf("Expected january.csv line %d, got %s line %d", expectedLines[i], transaction.SourceFile, transaction.SourceLine)
+		}
+		if transaction.ImportedAt == nil || transaction.ImportedAt.Before(before.Add(-time.Second)) {
+			t.Errorf("Expected import timestamp, got %v", transaction.ImportedAt)
+		}
+	}
+}
+
+func TestMigrationService_ProcessCSVMalformedRows(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Las filas con columnas de más o comillas inválidas se registran como error sin cortar el archivo
+	csvContent := "id,user_id,amount,datetime\n" +
+		"1,1001,150.50,2024-01-15 10:30:00\n" +
+		"2,1001,10.00,2024-01-15 10:30:00,extra\n" +
+		"3,10\"01,10.00,2024-01-15 10:30:00\n" +
+		"4,1002,200.00,2024-01-16 09:15:00\n"
+
+	stats, err := service.ProcessCSV(strings.NewReader(csvContent))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 4 || stats.SuccessRecords != 2 || stats.ErrorRecords != 2 {
+		t.Errorf("Expected 4 records with 2 errors, got %+v", stats)
+	}
+	if !strings.HasPrefix(stats.Errors[0], "Line 3:") || !strings.HasPrefix(stats.Errors[1], "Line 4:") {
+		t.Errorf("Expected errors at lines 3 and 4, got %v", stats.Errors)
+	}
+}
+
+// failingReader entrega el contenido y luego falla, como una subida interrumpida
+type failingReader struct {
+	reader io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestMigrationService_ProcessCSVReadError(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	csvContent := "id,user_id,amount,datetime\n1,1001,150.50,2024-01-15 10:30:00\n"
+
+	stats, err := service.ProcessCSV(&failingReader{reader: strings.NewReader(csvContent)})
+	if err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Fatalf("Expected read error, got %v", err)
+	}
+
+	// Las filas leídas antes del error ya se guardaron
+	if stats.SuccessRecords != 1 || db.GetTransactionCount() != 1 {
+		t.Errorf("Expected the row read before the error to be saved, got %+v", stats)
+	}
+}
+
+func TestMigrationService_ProcessCSVCapsErrorMessages(t *testing.T) {
+	service := NewMigrationService(NewMockDatabase())
+	service.GetReportService().SetForceMockMode(true)
+
+	var csvContent strings.Builder
+	csvContent.WriteString("id,user_id,amount,datetime\n")
+	for i := 1; i <= maxStatsMessages+5; i++ {
+		fmt.Fprintf(&csvContent, "%d,1001,invalid,2024-01-15 10:30:00\n", i)
+	}
+
+	stats, err := service.ProcessCSV(strings.NewReader(csvContent.String()))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.ErrorRecords != maxStatsMessages+5 || len(stats.Errors) != maxStatsMessages {
+		t.Errorf("Expected %d errors counted and %d messages kept, got %d and %d",
+			maxStatsMessages+5, maxStatsMessages, stats.ErrorRecords, len(stats.Errors))
+	}
+}
+
+func TestMigrationService_ValidateCSV(t *testing.T) {
+	initialCSV := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,-75.25,2024-01-15 14:45:00`
+
+	reuploadCSV := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,-80.00,2024-01-15 14:45:00
+3,1002,200.00,2024-01-16 09:15:00
+4,1002,invalid,2024-01-16 09:15:00`
+
+	// La validación debe predecir exactamente lo que haría la migración real
+	for _, policy := range []ConflictPolicy{ConflictOverwrite, ConflictReject, ConflictSkip, ConflictFailIfDifferent} {
+		t.Run(string(policy), func(t *testing.T) {
+			validated := NewMockDatabase()
+			migrated := NewMockDatabase()
+			validatingService := NewMigrationService(validated)
+			validatingService.GetReportService().SetForceMockMode(true)
+			migratingService := NewMigrationService(migrated)
+			migratingService.GetReportService().SetForceMockMode(true)
+
+			validatingService.ProcessCSV(strings.NewReader(initialCSV))
+			migratingService.ProcessCSV(strings.NewReader(initialCSV))
+
+			validation, err := validatingService.ValidateCSV(strings.NewReader(reuploadCSV), MigrationOptions{ConflictPolicy: policy})
+			if err != nil {
+				t.Fatalf("Expected no error validating, got %v", err)
+			}
+			stats, err := migratingService.ProcessCSVWithOptions(strings.NewReader(reuploadCSV), MigrationOptions{ConflictPolicy: policy})
+			if err != nil {
+				t.Fatalf("Expected no error migrating, got %v", err)
+			}
+
+			got := validation.Stats
+			if got.TotalRecords != stats.TotalRecords || got.SuccessRecords != stats.SuccessRecords ||
+				got.ErrorRecords != stats.ErrorRecords || got.SkippedRecords != stats.SkippedRecords ||
+				got.ConflictRecords != stats.ConflictRecords || len(got.Errors) != len(stats.Errors) {
+				t.Errorf("Expected validation stats %+v to match migration stats %+v", got, stats)
+			}
+			if validation.Valid {
+				t.Error("Expected invalid result with a malformed row")
+			}
+			if validation.Report.SuccessRecords != stats.SuccessRecords || validation.Report.UsersAffected == 0 {
+				t.Errorf("Unexpected report figures %+v", validation.Report)
+			}
+
+			// Nada se escribió en el almacén
+			if validated.GetTransactionCount() != 2 {
+				t.Errorf("Expected 2 transactions after validating, got %d", validated.GetTransactionCount())
+			}
+			if tx, _ := validated.GetTransaction(2); tx.Amount != -75.25 {
+				t.Errorf("Expected transaction 2 unchanged, got %.2f", tx.Amount)
+			}
+		})
+	}
+
+	service := NewMigrationService(NewMockDatabase())
+	if _, err := service.ValidateCSV(strings.NewReader("a,b\n1,2"), MigrationOptions{}); err == nil {
+		t.Error("Expected error for invalid header")
+	}
+	validation, err := service.ValidateCSV(strings.NewReader("id,user_id,amount,datetime\n1,1001,10,2024-01-15"), MigrationOptions{})
+	if err != nil || !validation.Valid || validation.Report.TotalAmount != 10 {
+		t.Errorf("Expected valid file, got %+v (%v)", validation, err)
+	}
+}
+
+func TestMigrationService_ValidateCSVDuplicatedID(t *testing.T) {
+	// El ID 5 no existe en el almacén pero se repite en el archivo: la segunda línea choca con la primera
+	csvContent := `id,user_id,amount,datetime
+5,1001,10.00,2024-01-15 10:30:00
+5,1001,20.00,2024-01-15 10:30:00
+5,1001,10.00,2024-01-15 10:30:00
+6,1002,30.00,2024-01-16 09:15:00`
+
+	for _, policy := range []ConflictPolicy{ConflictOverwrite, ConflictReject, ConflictSkip, ConflictFailIfDifferent} {
+		t.Run(string(policy), func(t *testing.T) {
+			validatingService := NewMigrationService(NewMockDatabase())
+			validatingService.GetReportService().SetForceMockMode(true)
+			migratingService := NewMigrationService(NewMockDatabase())
+			migratingService.GetReportService().SetForceMockMode(true)
+
+			validation, err := validatingService.ValidateCSV(strings.NewReader(csvContent), MigrationOptions{ConflictPolicy: policy})
+			if err != nil {
+				t.Fatalf("Expected no error validating, got %v", err)
+			}
+			stats, err := migratingService.ProcessCSVWithOptions(strings.NewReader(csvContent), MigrationOptions{ConflictPolicy: policy})
+			if err != nil {
+				t.Fatalf("Expected no error migrating, got %v", err)
+			}
+
+			got := validation.Stats
+			if got.SuccessRecords != stats.SuccessRecords || got.ErrorRecords != stats.ErrorRecords ||
+				got.SkippedRecords != stats.SkippedRecords || got.ConflictRecords != stats.ConflictRecords {
+				t.Errorf("Expected validation stats %+v to match migration stats %+v", got, stats)
+			}
+			if policy == ConflictReject || policy == ConflictFailIfDifferent {
+				if validation.Valid || got.ConflictRecords == 0 {
+					t.Errorf("Expected the duplicated ID to be reported as a conflict, got %+v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationStats_Result(t *testing.T) {
+	stats := NewMigrationStats()
+	stats.MigrationID = "mig-result"
+	stats.ConflictPolicy = ConflictOverwrite
+	stats.TotalRecords = 5
+	stats.UpdateSuccess(models.UserTransaction{ID: 1, UserID: 1001, Amount: 30, DateTime: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)})
+	stats.UpdateSuccess(models.UserTransaction{ID: 2, UserID: 1002, Amount: -10, DateTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	for line := 4; line <= 6; line++ {
+		stats.UpdateError(line, fmt.Errorf("invalid row"))
+	}
+
+	result := stats.Result(2)
+	if result.SuccessRecords != 2 || result.ErrorRecords != 3 || result.UsersAffected != 2 || result.AverageAmount != 10 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(result.Errors) != 2 || !result.ErrorsTruncated {
+		t.Errorf("Expected the first 2 errors and truncated flag, got %v (truncated %v)", result.Errors, result.ErrorsTruncated)
+	}
+	if result.DateRange == nil || !result.DateRange.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected date range %+v", result.DateRange)
+	}
+
+	// Sin transacciones guardadas no hay rango de fechas
+	if empty := NewMigrationStats().Result(10); empty.DateRange != nil || empty.ErrorsTruncated {
+		t.Errorf("Expected empty result without date range, got %+v", empty)
+	}
+}
+
+func TestMigrationService_ProcessCSVWithColumnMapping(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Archivo de un tercero: otro orden, otros nombres y columnas adicionales
+	csvContent := `Timestamp,Reference,Amount,UserId,ID
+2024-01-15 10:30:00,ref-1,150.50,1001,1
+2024-01-16 09:15:00,ref-2,-20.00,1002,2
+2024-01-16 09:15:00,ref-3,-20.00,1002`
+
+	mapping := ColumnMapping{"user_id": {"userid"}, "datetime": {"timestamp"}}
+	stats, err := service.ProcessCSVWithOptions(strings.NewReader(csvContent), MigrationOptions{Mapping: mapping})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.SuccessRecords != 2 || stats.ErrorRecords != 1 {
+		t.Errorf("Expected 2 success and 1 error (missing column), got %+v", stats)
+	}
+
+	tx, _ := db.GetTransaction(1)
+	if tx.UserID != 1001 || tx.Amount != 150.50 || tx.DateTime.Day() != 15 {
+		t.Errorf("Unexpected mapped transaction %+v", tx)
+	}
+
+	// Sin la asociación el archivo se rechaza
+	if _, err := service.ProcessCSV(strings.NewReader(csvContent)); err == nil || !strings.Contains(err.Error(), "invalid CSV header") {
+		t.Errorf("Expected header error without mapping, got %v", err)
+	}
+}
@@ -2,128 +2,891 @@ package services
 
 import (
 	"api-stori/internal/models"
+	"fmt"
+	"io"
+	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// MockDatabase simula una base de datos en memoria
-type MockDatabase struct {
-	transactions map[int]models.UserTransaction
-	nextID       int
+// mockShardCount número de particiones con lock propio para IDs y para usuarios
+const mockShardCount = 32
+
+// idShard contiene las transacciones (y su historial) cuyo ID cae en la partición
+type idShard struct {
 	mutex        sync.RWMutex
+	transactions map[int]models.UserTransaction
+	history      map[int][]models.TransactionVersion
+}
+
+// userShard contiene el índice cronológico de los usuarios que caen en la partición
+type userShard struct {
+	mutex     sync.RWMutex
+	userIndex map[int][]models.UserTransaction // Transacciones por usuario ordenadas por DateTime (y luego ID)
+}
+
+// MockDatabase simula una base de datos en memoria.
+//
+// Los datos están particionados para que migraciones concurrentes y consultas de balance no
+// compitan por un único lock: las transacciones por ID (idShards) y el índice por usuario
+// (userShards). Orden de locks para evitar deadlocks: stateMutex, luego la partición del ID,
+// luego las particiones de usuario en orden ascendente.
+type MockDatabase struct {
+	idShards   [mockShardCount]*idShard
+	userShards [mockShardCount]*userShard
+	nextID     atomic.Int64
+
+	// stateMutex lo toman en lectura las escrituras individuales y en escritura las operaciones
+	// que necesitan ver o modificar todo el estado a la vez (lotes, limpieza y compactación)
+	stateMutex sync.RWMutex
+
+	persistence *mockPersistence // nil si la base es solo en memoria
+	changes     *changeFeed
 }
 
 // NewMockDatabase crea una nueva instancia de MockDatabase
 func NewMockDatabase() *MockDatabase {
-	return &MockDatabase{
-		transactions: make(map[int]models.UserTransaction),
-		nextID:       1,
+	db := &MockDatabase{
+		changes: newChangeFeed(defaultChangeRetention),
 	}
+	for i := range db.idShards {
+		db.idShards[i] = &idShard{}
+		db.userShards[i] = &userShard{}
+	}
+	db.reset()
+	db.nextID.Store(1)
+	return db
 }
 
-// SaveTransaction guarda una transacción en el mock de base de datos
+// SaveTransaction guarda una transacción en el mock de base de datos, sobrescribiendo si el ID ya existe
 func (db *MockDatabase) SaveTransaction(transaction models.UserTransaction) (models.UserTransaction, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	result, err := db.SaveTransactionWithOptions(transaction, SaveOptions{ConflictPolicy: ConflictOverwrite})
+	if err != nil {
+		return models.UserTransaction{}, err
+	}
+	return result.Transaction, nil
+}
+
+// SaveTransactionWithOptions guarda una transacción aplicando la política de conflictos indicada.
+// Solo bloquea la partición del ID y las de los usuarios afectados.
+func (db *MockDatabase) SaveTransactionWithOptions(transaction models.UserTransaction, options SaveOptions) (SaveResult, error) {
+	db.stateMutex.RLock()
+	result, err := db.saveOne(transaction, options)
+	db.stateMutex.RUnlock()
+
+	if err == nil {
+		db.compactIfNeeded()
+	}
+	return result, err
+}
+
+// saveOne guarda una transacción. Debe llamarse con stateMutex tomado en lectura.
+func (db *MockDatabase) saveOne(transaction models.UserTransaction, options SaveOptions) (SaveResult, error) {
+	var shard *idShard
 
-	// Si no tiene ID, asignar uno nuevo
+	// Si no tiene ID, asignar uno nuevo (allocateID retorna la partición ya bloqueada)
 	if transaction.ID == 0 {
-		transaction.ID = db.nextID
-		db.nextID++
+		transaction.ID, shard = db.allocateID()
+	} else {
+		shard = db.idShardFor(transaction.ID)
+		shard.mutex.Lock()
 	}
+	defer shard.mutex.Unlock()
 
-	// Guardar la transacción
-	db.transactions[transaction.ID] = transaction
+	if err := checkExpectedVersion(options, transaction.ID, len(shard.history[transaction.ID])); err != nil {
+		return SaveResult{}, err
+	}
 
-	return transaction, nil
+	previous, exists := shard.transactions[transaction.ID]
+	result, write, err := prepareSave(transaction, options, previous, exists)
+	if err != nil || !write {
+		return result, err
+	}
+
+	if err := db.commitOne(shard, result, time.Now().UTC(), options.MigrationID); err != nil {
+		return SaveResult{}, err
+	}
+	return result, nil
+}
+
+// commitOne registra en el log, guarda y publica una escritura ya resuelta.
+// Debe llamarse con stateMutex en lectura y el lock de escritura de la partición del ID.
+func (db *MockDatabase) commitOne(shard *idShard, result SaveResult, changedAt time.Time, migrationID string) error {
+	transaction := result.Transaction
+
+	// Registrar en el log antes de modificar el estado en memoria. El lock de la partición
+	// garantiza que las escrituras del mismo ID quedan en el log en el orden en que se aplican.
+	if db.persistence != nil {
+		record := walRecord{Op: walOpSave, Transaction: &transaction, NextID: int(db.nextID.Load()), ChangedAt: changedAt, MigrationID: migrationID}
+		if err := db.persistence.append(record); err != nil {
+			return err
+		}
+	}
+
+	// Guardar la transacción y su nueva versión
+	shard.store(transaction, changedAt, migrationID)
+	db.reindex(result.Previous, transaction)
+
+	if event, changed := saveChangeEvent(result); changed {
+		db.changes.publish(event)
+	}
+
+	return nil
+}
+
+// SaveTransactions guarda un lote de forma atómica: primero valida todas las transacciones
+// contra el estado actual (y contra las anteriores del mismo lote) y solo si ninguna falla las aplica.
+// Toma el estado completo en exclusiva, así que ninguna lectura observa el lote a medias.
+func (db *MockDatabase) SaveTransactions(transactions []models.UserTransaction, options SaveOptions) ([]SaveResult, error) {
+	db.stateMutex.Lock()
+	results, err := db.saveBatch(transactions, options)
+	db.stateMutex.Unlock()
+
+	if err == nil {
+		db.compactIfNeeded()
+	}
+	return results, err
+}
+
+// saveBatch guarda un lote. Debe llamarse con stateMutex tomado en escritura.
+func (db *MockDatabase) saveBatch(transactions []models.UserTransaction, options SaveOptions) ([]SaveResult, error) {
+	db.lockAll()
+	defer db.unlockAll()
+
+	results := make([]SaveResult, len(transactions))
+	pending := make(map[int]models.UserTransaction) // Escrituras del lote aún no aplicadas
+	var toWrite []models.UserTransaction
+	batchErr := &BatchSaveError{Total: len(transactions)}
+	nextID := int(db.nextID.Load())
+
+	for i, transaction := range transactions {
+		if transaction.ID == 0 {
+			transaction.ID, nextID = db.peekID(nextID, pending)
+		}
+
+		// La versión esperada se compara con el historial guardado, no con las escrituras previas del lote
+		shard := db.idShardFor(transaction.ID)
+		if err := checkExpectedVersion(options, transaction.ID, len(shard.history[transaction.ID])); err != nil {
+			batchErr.Errors = append(batchErr.Errors, BatchItemError{Index: i, Err: err})
+			continue
+		}
+
+		previous, exists := pending[transaction.ID]
+		if !exists {
+			previous, exists = shard.transactions[transaction.ID]
+		}
+
+		result, write, err := prepareSave(transaction, options, previous, exists)
+		if err != nil {
+			batchErr.Errors = append(batchErr.Errors, BatchItemError{Index: i, Err: err})
+			continue
+		}
+
+		results[i] = result
+		if write {
+			pending[transaction.ID] = transaction
+			toWrite = append(toWrite, transaction)
+		}
+	}
+
+	if len(batchErr.Errors) > 0 {
+		return nil, batchErr
+	}
+
+	changedAt := time.Now().UTC()
+
+	// Un único registro en el log: si la escritura se interrumpe el lote completo se descarta al recuperar
+	if db.persistence != nil && len(toWrite) > 0 {
+		record := walRecord{Op: walOpBatch, Transactions: toWrite, NextID: nextID, ChangedAt: changedAt, MigrationID: options.MigrationID}
+		if err := db.persistence.append(record); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, transaction := range toWrite {
+		db.writeLocked(transaction, changedAt, options.MigrationID)
+	}
+	db.nextID.Store(int64(nextID))
+
+	var events []ChangeEvent
+	for _, result := range results {
+		if event, changed := saveChangeEvent(result); changed {
+			events = append(events, event)
+		}
+	}
+	db.changes.publish(events...)
+
+	return results, nil
+}
+
+// prepareSave resuelve la política de conflictos para una transacción con ID asignado, dado el
+// registro existente con el mismo ID (si exists). Retorna el resultado y si la transacción debe escribirse.
+func prepareSave(transaction models.UserTransaction, options SaveOptions, previous models.UserTransaction, exists bool) (SaveResult, bool, error) {
+	if !exists {
+		return SaveResult{Transaction: transaction, Outcome: SaveInserted}, true, nil
+	}
+
+	result := SaveResult{Previous: &previous}
+	outcome, err := resolveConflict(previous, transaction, options.ConflictPolicy)
+	if err != nil {
+		return result, false, err
+	}
+
+	result.Outcome = outcome
+	if outcome != SaveOverwritten {
+		result.Transaction = previous
+		return result, false, nil
+	}
+
+	result.Transaction = transaction
+	return result, true, nil
 }
 
 // GetTransaction obtiene una transacción por ID
 func (db *MockDatabase) GetTransaction(id int) (models.UserTransaction, bool) {
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
+	shard := db.idShardFor(id)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
 
-	transaction, exists := db.transactions[id]
+	transaction, exists := shard.transactions[id]
 	return transaction, exists
 }
 
-// GetTransactionsByUserID obtiene todas las transacciones de un usuario
+// GetTransactionHistory obtiene todas las versiones de una transacción, de la más antigua a la actual
+func (db *MockDatabase) GetTransactionHistory(id int) []models.TransactionVersion {
+	shard := db.idShardFor(id)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	versions := shard.history[id]
+	if len(versions) == 0 {
+		return nil
+	}
+
+	result := make([]models.TransactionVersion, len(versions))
+	copy(result, versions)
+	return result
+}
+
+// GetTransactionsByUserID obtiene todas las transacciones de un usuario en orden cronológico
 func (db *MockDatabase) GetTransactionsByUserID(userID int) []models.UserTransaction {
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
+	return db.GetTransactionsByUserIDWithDateRange(userID, nil, nil)
+}
+
+// GetTransactionsByUserIDWithDateRange obtiene las transacciones de un usuario filtradas por rango de fechas.
+// Usa el índice por usuario: búsqueda binaria de los límites y copia del tramo, en orden cronológico.
+// Solo bloquea (en lectura) la partición del usuario.
+func (db *MockDatabase) GetTransactionsByUserIDWithDateRange(userID int, fromDate, toDate *time.Time) []models.UserTransaction {
+	shard := db.userShardFor(userID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	userTransactions := shard.userIndex[userID]
+
+	// Primera transacción con DateTime >= fromDate
+	start := 0
+	if fromDate != nil {
+		start = sort.Search(len(userTransactions), func(i int) bool {
+			return !userTransactions[i].DateTime.Before(*fromDate)
+		})
+	}
 
-	var userTransactions []models.UserTransaction
-	for _, transaction := range db.transactions {
-		if transaction.UserID == userID {
-			userTransactions = append(userTransactions, transaction)
+	// Primera transacción con DateTime > toDate
+	end := len(userTransactions)
+	if toDate != nil {
+		end = sort.Search(len(userTransactions), func(i int) bool {
+			return userTransactions[i].DateTime.After(*toDate)
+		})
+	}
+
+	if start >= end {
+		return nil
+	}
+
+	result := make([]models.UserTransaction, end-start)
+	copy(result, userTransactions[start:end])
+	return result
+}
+
+// GetAllTransactions obtiene todas las transacciones ordenadas por ID
+func (db *MockDatabase) GetAllTransactions() []models.UserTransaction {
+	db.rLockIDShards()
+	defer db.rUnlockIDShards()
+
+	return db.sortedTransactions()
+}
+
+// ForEachTransaction recorre las transacciones ordenadas por ID con el estado bloqueado en lectura:
+// las escrituras esperan a que termine el recorrido
+func (db *MockDatabase) ForEachTransaction(fn func(transaction models.UserTransaction) error) error {
+	db.stateMutex.RLock()
+	defer db.stateMutex.RUnlock()
+	db.rLockIDShards()
+	defer db.rUnlockIDShards()
+
+	var ids []int
+	for _, shard := range db.idShards {
+		for id := range shard.transactions {
+			ids = append(ids, id)
 		}
 	}
+	sort.Ints(ids)
 
-	return userTransactions
+	for _, id := range ids {
+		if err := fn(db.idShardFor(id).transactions[id]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// GetTransactionsByUserIDWithDateRange obtiene las transacciones de un usuario filtradas por rango de fechas
-func (db *MockDatabase) GetTransactionsByUserIDWithDateRange(userID int, fromDate, toDate *time.Time) []models.UserTransaction {
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
+// GetTransactionsByMigrationID obtiene las transacciones vigentes importadas por una migración,
+// ordenadas por línea de origen. Recorre todos los shards: no hay índice por migración.
+func (db *MockDatabase) GetTransactionsByMigrationID(migrationID string) []models.UserTransaction {
+	if migrationID == "" {
+		return nil
+	}
 
-	var userTransactions []models.UserTransaction
-	for _, transaction := range db.transactions {
-		if transaction.UserID != userID {
-			continue
+	db.rLockIDShards()
+	defer db.rUnlockIDShards()
+
+	var transactions []models.UserTransaction
+	for _, shard := range db.idShards {
+		for _, transaction := range shard.transactions {
+			if transaction.MigrationID == migrationID {
+				transactions = append(transactions, transaction)
+			}
 		}
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].SourceLine != transactions[j].SourceLine {
+			return transactions[i].SourceLine < transactions[j].SourceLine
+		}
+		return transactions[i].ID < transactions[j].ID
+	})
+
+	return transactions
+}
 
-		// Si no hay filtros de fecha, incluir la transacción
-		if fromDate == nil && toDate == nil {
-			userTransactions = append(userTransactions, transaction)
+// GetTransactionCount retorna el número total de transacciones
+func (db *MockDatabase) GetTransactionCount() int {
+	db.rLockIDShards()
+	defer db.rUnlockIDShards()
+
+	count := 0
+	for _, shard := range db.idShards {
+		count += len(shard.transactions)
+	}
+	return count
+}
+
+// SoftDeleteTransaction marca la transacción como eliminada guardando una versión nueva.
+// Solo bloquea la partición del ID y la del usuario.
+func (db *MockDatabase) SoftDeleteTransaction(id int, reason string) (models.UserTransaction, error) {
+	db.stateMutex.RLock()
+	transaction, err := db.softDelete(id, reason)
+	db.stateMutex.RUnlock()
+
+	if err == nil {
+		db.compactIfNeeded()
+	}
+	return transaction, err
+}
+
+// softDelete marca una transacción como eliminada. Debe llamarse con stateMutex tomado en lectura.
+func (db *MockDatabase) softDelete(id int, reason string) (models.UserTransaction, error) {
+	shard := db.idShardFor(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	previous, exists := shard.transactions[id]
+	if !exists {
+		return models.UserTransaction{}, fmt.Errorf("%w: id %d", ErrTransactionNotFound, id)
+	}
+
+	changedAt := time.Now().UTC()
+	transaction, err := markDeleted(previous, reason, changedAt)
+	if err != nil {
+		return transaction, err
+	}
+
+	result := SaveResult{Transaction: transaction, Outcome: SaveOverwritten, Previous: &previous}
+	if err := db.commitOne(shard, result, changedAt, ""); err != nil {
+		return models.UserTransaction{}, err
+	}
+	return transaction, nil
+}
+
+// DeleteTransactions elimina las transacciones indicadas y retorna las que existían, ordenadas por ID.
+// El historial de versiones se conserva.
+func (db *MockDatabase) DeleteTransactions(ids []int) ([]models.UserTransaction, error) {
+	return db.DeleteTransactionsIf(ids, nil)
+}
+
+// DeleteTransactionsIf elimina las transacciones indicadas que cumplen condition (todas si es nil).
+// La condición se evalúa con el estado bloqueado en exclusiva.
+func (db *MockDatabase) DeleteTransactionsIf(ids []int, condition func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	db.stateMutex.Lock()
+	deleted, err := db.deleteBatch(ids, condition)
+	db.stateMutex.Unlock()
+
+	if err == nil {
+		db.compactIfNeeded()
+	}
+	return deleted, err
+}
+
+// deleteBatch elimina un lote. Debe llamarse con stateMutex tomado en escritura.
+func (db *MockDatabase) deleteBatch(ids []int, condition func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	db.lockAll()
+	defer db.unlockAll()
+
+	var deleted []models.UserTransaction
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		transaction, exists := db.idShardFor(id).transactions[id]
+		if !exists || seen[id] {
 			continue
 		}
+		seen[id] = true
+		if condition == nil || condition(transaction) {
+			deleted = append(deleted, transaction)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].ID < deleted[j].ID
+	})
+
+	deletedIDs := make([]int, len(deleted))
+	for i, transaction := range deleted {
+		deletedIDs[i] = transaction.ID
+	}
+
+	if db.persistence != nil {
+		record := walRecord{Op: walOpDelete, IDs: deletedIDs, NextID: int(db.nextID.Load())}
+		if err := db.persistence.append(record); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range deletedIDs {
+		db.removeLocked(id)
+	}
+
+	db.changes.publish(deleteChangeEvents(deleted)...)
+
+	return deleted, nil
+}
+
+// ClearTransactions limpia todas las transacciones (útil para testing)
+func (db *MockDatabase) ClearTransactions() {
+	db.stateMutex.Lock()
+	db.clear()
+	db.stateMutex.Unlock()
+
+	db.compactIfNeeded()
+}
 
-		// Verificar si la transacción está en el rango de fechas
-		include := true
+// clear elimina todo el estado. Debe llamarse con stateMutex tomado en escritura.
+func (db *MockDatabase) clear() {
+	db.lockAll()
+	defer db.unlockAll()
 
-		if fromDate != nil && transaction.DateTime.Before(*fromDate) {
-			include = false
+	if db.persistence != nil {
+		if err := db.persistence.append(walRecord{Op: walOpClear, NextID: 1}); err != nil {
+			log.Printf("Error logging clear operation: %v", err)
+			return
 		}
+	}
+
+	deleted := db.sortedTransactions()
+
+	db.reset()
+	db.nextID.Store(1)
+
+	db.changes.publish(deleteChangeEvents(deleted)...)
+}
 
-		if toDate != nil && transaction.DateTime.After(*toDate) {
-			include = false
+// ImportTransactions lee todas las transacciones de next y las guarda como un único lote; con replace
+// el lote reemplaza el estado completo. Las transacciones se leen antes de tomar los locks.
+func (db *MockDatabase) ImportTransactions(next func() (models.UserTransaction, error), replace bool) (int, int, error) {
+	var transactions []models.UserTransaction
+	for {
+		transaction, err := next()
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if transaction.ID <= 0 {
+			return 0, 0, fmt.Errorf("invalid transaction ID %d: imported transactions keep their IDs", transaction.ID)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	db.stateMutex.Lock()
+	inserted, overwritten, err := db.importBatch(transactions, replace)
+	db.stateMutex.Unlock()
 
-		if include {
-			userTransactions = append(userTransactions, transaction)
+	if err == nil {
+		db.compactIfNeeded()
+	}
+	return inserted, overwritten, err
+}
+
+// importBatch guarda un lote sobrescribiendo, vaciando antes el estado si replace.
+// Debe llamarse con stateMutex tomado en escritura.
+func (db *MockDatabase) importBatch(transactions []models.UserTransaction, replace bool) (int, int, error) {
+	db.lockAll()
+	defer db.unlockAll()
+
+	nextID := int(db.nextID.Load())
+	if replace {
+		nextID = 1
+	}
+	for _, transaction := range transactions {
+		if transaction.ID >= nextID {
+			nextID = transaction.ID + 1
 		}
 	}
 
-	return userTransactions
+	changedAt := time.Now().UTC()
+
+	// Un único registro en el log: el reemplazo no puede quedar a medias al recuperar
+	if db.persistence != nil && (replace || len(transactions) > 0) {
+		record := walRecord{Op: walOpBatch, Replace: replace, Transactions: transactions, NextID: nextID, ChangedAt: changedAt}
+		if err := db.persistence.append(record); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var events []ChangeEvent
+	if replace {
+		events = deleteChangeEvents(db.sortedTransactions())
+		db.reset()
+	}
+
+	var inserted, overwritten int
+	for _, transaction := range transactions {
+		result := SaveResult{Transaction: transaction, Outcome: SaveInserted}
+		if previous, exists := db.idShardFor(transaction.ID).transactions[transaction.ID]; exists {
+			result.Outcome, result.Previous = SaveOverwritten, &previous
+			overwritten++
+		} else {
+			inserted++
+		}
+
+		db.writeLocked(transaction, changedAt, "")
+		if event, changed := saveChangeEvent(result); changed {
+			events = append(events, event)
+		}
+	}
+	db.nextID.Store(int64(nextID))
+
+	db.changes.publish(events...)
+
+	return inserted, overwritten, nil
 }
 
-// GetAllTransactions obtiene todas las transacciones
-func (db *MockDatabase) GetAllTransactions() []models.UserTransaction {
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
+// Subscribe entrega los cambios confirmados en orden de secuencia.
+// La secuencia no se persiste: al reiniciar el proceso comienza de nuevo.
+func (db *MockDatabase) Subscribe(fromSeq uint64, bufferSize int) (*Subscription, error) {
+	return db.changes.subscribe(fromSeq, bufferSize)
+}
+
+// LastSequence retorna el número de secuencia del último cambio publicado
+func (db *MockDatabase) LastSequence() uint64 {
+	return db.changes.lastSequence()
+}
 
+// sortedTransactions retorna todas las transacciones ordenadas por ID.
+// Debe llamarse con las particiones de ID bloqueadas.
+func (db *MockDatabase) sortedTransactions() []models.UserTransaction {
 	var allTransactions []models.UserTransaction
-	for _, transaction := range db.transactions {
-		allTransactions = append(allTransactions, transaction)
+	for _, shard := range db.idShards {
+		for _, transaction := range shard.transactions {
+			allTransactions = append(allTransactions, transaction)
+		}
 	}
 
+	sort.Slice(allTransactions, func(i, j int) bool {
+		return allTransactions[i].ID < allTransactions[j].ID
+	})
+
 	return allTransactions
 }
 
-// GetTransactionCount retorna el número total de transacciones
-func (db *MockDatabase) GetTransactionCount() int {
-	db.mutex.RLock()
-	defer db.mutex.RUnlock()
+// applyWALRecord aplica una operación del log durante la recuperación (un solo hilo, sin locks)
+func (db *MockDatabase) applyWALRecord(record walRecord) {
+	switch record.Op {
+	case walOpSave:
+		if record.Transaction != nil {
+			db.writeLocked(*record.Transaction, record.ChangedAt, record.MigrationID)
+		}
+	case walOpBatch:
+		if record.Replace {
+			db.reset()
+			db.nextID.Store(int64(record.NextID))
+		}
+		for _, transaction := range record.Transactions {
+			db.writeLocked(transaction, record.ChangedAt, record.MigrationID)
+		}
+	case walOpDelete:
+		for _, id := range record.IDs {
+			db.removeLocked(id)
+		}
+	case walOpClear:
+		db.reset()
+		db.nextID.Store(int64(record.NextID))
+		return
+	}
 
-	return len(db.transactions)
+	// Con escrituras concurrentes los registros pueden llegar al log con contadores desordenados
+	if int64(record.NextID) > db.nextID.Load() {
+		db.nextID.Store(int64(record.NextID))
+	}
 }
 
-// ClearTransactions limpia todas las transacciones (útil para testing)
-func (db *MockDatabase) ClearTransactions() {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// compactIfNeeded compacta el log en un snapshot cuando alcanza el tamaño configurado.
+// Debe llamarse sin locks tomados; un fallo no pierde datos porque el log sigue intacto.
+func (db *MockDatabase) compactIfNeeded() {
+	if db.persistence == nil || !db.persistence.shouldCompact() {
+		return
+	}
+
+	db.stateMutex.Lock()
+	defer db.stateMutex.Unlock()
+
+	// Otra escritura pudo compactar mientras se esperaba el lock
+	if !db.persistence.shouldCompact() {
+		return
+	}
+
+	if err := db.persistence.compact(db); err != nil {
+		log.Printf("Error compacting transaction log: %v", err)
+	}
+}
+
+// allocateID reserva el siguiente ID libre, saltando los IDs ya usados explícitamente y los
+// que conservan historial (purgados o archivados), igual que AUTOINCREMENT en SQLite.
+// Retorna el ID con su partición bloqueada en escritura para que nadie lo tome antes de guardarlo.
+func (db *MockDatabase) allocateID() (int, *idShard) {
+	for {
+		id := int(db.nextID.Add(1) - 1)
+		shard := db.idShardFor(id)
+		shard.mutex.Lock()
+		if !shard.taken(id) {
+			return id, shard
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// peekID busca el primer ID libre desde nextID sin modificar el estado, con el mismo
+// criterio que allocateID y considerando también los IDs de pending. Retorna el ID y el nuevo valor del contador.
+// Debe llamarse con las particiones de ID bloqueadas.
+func (db *MockDatabase) peekID(nextID int, pending map[int]models.UserTransaction) (int, int) {
+	for {
+		id := nextID
+		nextID++
+		_, takenInBatch := pending[id]
+		if !db.idShardFor(id).taken(id) && !takenInBatch {
+			return id, nextID
+		}
+	}
+}
+
+// taken indica si el ID tiene una transacción vigente o historial y no puede asignarse.
+// Debe llamarse con el lock de la partición.
+func (shard *idShard) taken(id int) bool {
+	_, exists := shard.transactions[id]
+	return exists || len(shard.history[id]) > 0
+}
+
+// store guarda la transacción como versión actual de su ID y la agrega a su historial.
+// Debe llamarse con el lock de escritura de la partición.
+func (shard *idShard) store(transaction models.UserTransaction, changedAt time.Time, migrationID string) {
+	shard.transactions[transaction.ID] = transaction
+	shard.history[transaction.ID] = append(shard.history[transaction.ID], models.TransactionVersion{
+		Version:     len(shard.history[transaction.ID]) + 1,
+		Transaction: transaction,
+		ChangedAt:   changedAt,
+		MigrationID: migrationID,
+	})
+}
+
+// writeLocked guarda una versión nueva y actualiza el índice. Debe llamarse con todas las
+// particiones bloqueadas (lotes) o antes de publicar la base de datos (recuperación).
+func (db *MockDatabase) writeLocked(transaction models.UserTransaction, changedAt time.Time, migrationID string) {
+	shard := db.idShardFor(transaction.ID)
+
+	var previous *models.UserTransaction
+	if existing, exists := shard.transactions[transaction.ID]; exists {
+		previous = &existing
+	}
+
+	shard.store(transaction, changedAt, migrationID)
+	db.reindexLocked(previous, transaction)
+}
+
+// put guarda la transacción y actualiza el índice sin agregar versión (carga de snapshots).
+// Mismas condiciones de lock que writeLocked.
+func (db *MockDatabase) put(transaction models.UserTransaction) {
+	shard := db.idShardFor(transaction.ID)
+
+	var previous *models.UserTransaction
+	if existing, exists := shard.transactions[transaction.ID]; exists {
+		previous = &existing
+	}
+
+	shard.transactions[transaction.ID] = transaction
+	db.reindexLocked(previous, transaction)
+}
+
+// removeLocked elimina la transacción vigente y su entrada del índice, conservando el historial.
+// Mismas condiciones de lock que writeLocked.
+func (db *MockDatabase) removeLocked(id int) {
+	shard := db.idShardFor(id)
+	if existing, exists := shard.transactions[id]; exists {
+		delete(shard.transactions, id)
+		db.removeFromIndex(existing)
+	}
+}
+
+// reindex mueve la transacción dentro del índice por usuario tomando los locks de las
+// particiones involucradas en orden ascendente
+func (db *MockDatabase) reindex(previous *models.UserTransaction, transaction models.UserTransaction) {
+	first := shardIndex(transaction.UserID)
+	second := first
+	if previous != nil {
+		second = shardIndex(previous.UserID)
+	}
+	if second < first {
+		first, second = second, first
+	}
+
+	db.userShards[first].mutex.Lock()
+	defer db.userShards[first].mutex.Unlock()
+	if second != first {
+		db.userShards[second].mutex.Lock()
+		defer db.userShards[second].mutex.Unlock()
+	}
+
+	db.reindexLocked(previous, transaction)
+}
+
+// reindexLocked reemplaza previous por transaction en el índice por usuario.
+// Debe llamarse con los locks de las particiones de usuario involucradas.
+func (db *MockDatabase) reindexLocked(previous *models.UserTransaction, transaction models.UserTransaction) {
+	if previous != nil {
+		db.removeFromIndex(*previous)
+	}
+	db.insertIntoIndex(transaction)
+}
 
-	db.transactions = make(map[int]models.UserTransaction)
-	db.nextID = 1
+// reset elimina todas las transacciones y el índice. Debe llamarse con todas las particiones bloqueadas.
+func (db *MockDatabase) reset() {
+	for i := range db.idShards {
+		db.idShards[i].transactions = make(map[int]models.UserTransaction)
+		db.idShards[i].history = make(map[int][]models.TransactionVersion)
+		db.userShards[i].userIndex = make(map[int][]models.UserTransaction)
+	}
+}
+
+// lockAll bloquea en escritura todas las particiones: primero las de ID y luego las de usuario
+func (db *MockDatabase) lockAll() {
+	for _, shard := range db.idShards {
+		shard.mutex.Lock()
+	}
+	for _, shard := range db.userShards {
+		shard.mutex.Lock()
+	}
+}
+
+// unlockAll libera los locks tomados por lockAll
+func (db *MockDatabase) unlockAll() {
+	for _, shard := range db.userShards {
+		shard.mutex.Unlock()
+	}
+	for _, shard := range db.idShards {
+		shard.mutex.Unlock()
+	}
+}
+
+// rLockIDShards bloquea en lectura todas las particiones de ID en orden ascendente
+func (db *MockDatabase) rLockIDShards() {
+	for _, shard := range db.idShards {
+		shard.mutex.RLock()
+	}
+}
+
+// rUnlockIDShards libera los locks tomados por rLockIDShards
+func (db *MockDatabase) rUnlockIDShards() {
+	for _, shard := range db.idShards {
+		shard.mutex.RUnlock()
+	}
+}
+
+// idShardFor retorna la partición de un ID de transacción
+func (db *MockDatabase) idShardFor(id int) *idShard {
+	return db.idShards[shardIndex(id)]
+}
+
+// userShardFor retorna la partición de un usuario
+func (db *MockDatabase) userShardFor(userID int) *userShard {
+	return db.userShards[shardIndex(userID)]
+}
+
+// shardIndex reparte una clave entera (incluso negativa) entre las particiones
+func shardIndex(key int) int {
+	return int(uint64(key) % mockShardCount)
+}
+
+// indexPosition retorna la posición de la transacción dentro de la lista ordenada del usuario
+func indexPosition(userTransactions []models.UserTransaction, transaction models.UserTransaction) int {
+	return sort.Search(len(userTransactions), func(i int) bool {
+		current := userTransactions[i]
+		if !current.DateTime.Equal(transaction.DateTime) {
+			return current.DateTime.After(transaction.DateTime)
+		}
+		return current.ID >= transaction.ID
+	})
+}
+
+// insertIntoIndex inserta la transacción manteniendo el orden cronológico del usuario
+func (db *MockDatabase) insertIntoIndex(transaction models.UserTransaction) {
+	shard := db.userShardFor(transaction.UserID)
+	userTransactions := shard.userIndex[transaction.UserID]
+	position := indexPosition(userTransactions, transaction)
+
+	userTransactions = append(userTransactions, models.UserTransaction{})
+	copy(userTransactions[position+1:], userTransactions[position:])
+	userTransactions[position] = transaction
+
+	shard.userIndex[transaction.UserID] = userTransactions
+}
+
+// removeFromIndex elimina la transacción de la lista ordenada de su usuario
+func (db *MockDatabase) removeFromIndex(transaction models.UserTransaction) {
+	shard := db.userShardFor(transaction.UserID)
+	userTransactions := shard.userIndex[transaction.UserID]
+	position := indexPosition(userTransactions, transaction)
+	if position >= len(userTransactions) || userTransactions[position].ID != transaction.ID {
+		return
+	}
+
+	userTransactions = append(userTransactions[:position], userTransactions[position+1:]...)
+	if len(userTransactions) == 0 {
+		delete(shard.userIndex, transaction.UserID)
+		return
+	}
+	shard.userIndex[transaction.UserID] = userTransactions
 }
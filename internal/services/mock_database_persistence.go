@@ -0,0 +1,581 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Nombres de archivo dentro del directorio de persistencia
+const (
+	walFileName      = "transactions.wal"
+	snapshotFileName = "transactions.snapshot"
+)
+
+// walHeaderSize tamaño del encabezado de cada registro: longitud (4 bytes) + CRC32 (4 bytes)
+const walHeaderSize = 8
+
+// walMaxRecordSize límite de tamaño de un registro; un valor mayor indica corrupción.
+// Los lotes y snapshots más grandes se escriben en varios registros.
+const walMaxRecordSize = 16 << 20
+
+// snapshotChunkSize transacciones (o versiones) por registro del snapshot
+const snapshotChunkSize = 10000
+
+// RecoveryMode define cómo reaccionar ante un registro corrupto al reproducir el log
+type RecoveryMode string
+
+const (
+	// RecoveryStrict falla el arranque si el log contiene un registro corrupto
+	RecoveryStrict RecoveryMode = "strict"
+	// RecoveryTruncate trunca el log al último registro válido y continúa
+	RecoveryTruncate RecoveryMode = "truncate"
+)
+
+// ErrWALCorrupted indica que el log de escritura anticipada contiene un registro inválido
+var ErrWALCorrupted = errors.New("write-ahead log corrupted")
+
+// ErrPersistenceClosed indica que se intentó escribir después de cerrar la base de datos
+var ErrPersistenceClosed = errors.New("persistent database is closed")
+
+// ErrPersistenceFailed indica que el log quedó en un estado desconocido tras una escritura fallida
+var ErrPersistenceFailed = errors.New("write-ahead log failed")
+
+// PersistenceOptions configuración de la persistencia de MockDatabase
+type PersistenceOptions struct {
+	Dir           string       // Directorio donde se guardan el log y el snapshot
+	SnapshotEvery int          // Registros en el log antes de compactar en un snapshot
+	Recovery      RecoveryMode // Comportamiento ante registros corruptos
+}
+
+// Operaciones registradas en el log
+const (
+	walOpSave   = "save"
+	walOpBatch  = "batch"
+	walOpDelete = "delete"
+	walOpClear  = "clear"
+)
+
+// walRecord es una operación de escritura registrada en el log. Un lote que excede walMaxRecordSize
+// se escribe en varios registros con More=true salvo el último; al reproducir el log se aplica
+// completo o, si la escritura se interrumpió, se descarta.
+type walRecord struct {
+	Seq          uint64                   `json:"seq,omitempty"` // Orden de la operación; el snapshot registra la última incluida
+	More         bool                     `json:"more,omitempty"`
+	Op           string                   `json:"op"`
+	Transaction  *models.UserTransaction  `json:"transaction,omitempty"`
+	Transactions []models.UserTransaction `json:"transactions,omitempty"`
+	IDs          []int                    `json:"ids,omitempty"`
+	Replace      bool                     `json:"replace,omitempty"` // El lote reemplaza todo el estado (importación de snapshots)
+	NextID       int                      `json:"next_id"`
+	ChangedAt    time.Time                `json:"changed_at,omitempty"`
+	MigrationID  string                   `json:"migration_id,omitempty"`
+}
+
+// mockSnapshot es el estado completo de MockDatabase en un punto del tiempo. Se escribe en
+// varios registros de hasta snapshotChunkSize transacciones; todos salvo el último llevan More=true.
+type mockSnapshot struct {
+	Seq          uint64                      `json:"seq,omitempty"` // Última operación del log incluida
+	More         bool                        `json:"more,omitempty"`
+	NextID       int                         `json:"next_id"`
+	Transactions []models.UserTransaction    `json:"transactions"`
+	History      []models.TransactionVersion `json:"history,omitempty"`
+}
+
+// mockPersistence mantiene el log de escritura anticipada y los snapshots de MockDatabase.
+// Las escrituras al log se serializan con su propio mutex; compact requiere además que
+// MockDatabase tenga su estado bloqueado en exclusiva.
+type mockPersistence struct {
+	mutex      sync.Mutex
+	options    PersistenceOptions
+	walFile    walWriter
+	walRecords int
+	seq        uint64 // Última operación registrada
+	failed     error  // Error que dejó el log sin poder restaurar; se rechazan las escrituras siguientes
+}
+
+// walWriter operaciones sobre el archivo del log (*os.File)
+type walWriter interface {
+	io.Writer
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// NewPersistentMockDatabase crea un MockDatabase que sobrevive reinicios: cada escritura se
+// agrega a un log sincronizado en disco y el estado se compacta periódicamente en un snapshot.
+// Al arrancar se reproduce el snapshot y después el log.
+func NewPersistentMockDatabase(options PersistenceOptions) (*MockDatabase, error) {
+	if options.SnapshotEvery <= 0 {
+		options.SnapshotEvery = 1000
+	}
+	if options.Recovery == "" {
+		options.Recovery = RecoveryTruncate
+	}
+
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create persistence directory: %v", err)
+	}
+
+	db := NewMockDatabase()
+	persistence := &mockPersistence{options: options}
+
+	if err := persistence.loadSnapshot(db); err != nil {
+		return nil, err
+	}
+
+	if err := persistence.replayWAL(db); err != nil {
+		return nil, err
+	}
+
+	db.persistence = persistence
+	return db, nil
+}
+
+// Close cierra el log de escritura anticipada
+func (db *MockDatabase) Close() error {
+	db.stateMutex.Lock()
+	defer db.stateMutex.Unlock()
+
+	if db.persistence == nil {
+		return nil
+	}
+	return db.persistence.close()
+}
+
+// Compact escribe un snapshot con el estado actual y vacía el log
+func (db *MockDatabase) Compact() error {
+	db.stateMutex.Lock()
+	defer db.stateMutex.Unlock()
+
+	if db.persistence == nil {
+		return nil
+	}
+	return db.persistence.compact(db)
+}
+
+// close cierra el archivo del log
+func (p *mockPersistence) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.walFile == nil {
+		return nil
+	}
+
+	err := p.walFile.Close()
+	p.walFile = nil
+	return err
+}
+
+// append registra una operación en el log y la sincroniza en disco
+func (p *mockPersistence) append(record walRecord) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.walFile == nil {
+		return ErrPersistenceClosed
+	}
+	if p.failed != nil {
+		return fmt.Errorf("%w: %v", ErrPersistenceFailed, p.failed)
+	}
+
+	record.Seq = p.seq + 1
+	frames, err := encodeWALRecord(record)
+	if err != nil {
+		return err
+	}
+
+	offset, err := p.walFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to read WAL offset: %v", err)
+	}
+
+	// Un registro a medias no puede quedar en el log: al reproducirlo en modo truncate se
+	// descartarían todas las operaciones confirmadas que se escriban después
+	if _, err := p.walFile.Write(frames); err != nil {
+		return p.rollback(offset, fmt.Errorf("failed to write WAL record: %v", err))
+	}
+
+	if err := p.walFile.Sync(); err != nil {
+		return p.rollback(offset, fmt.Errorf("failed to sync WAL: %v", err))
+	}
+
+	p.seq = record.Seq
+	p.walRecords++
+	return nil
+}
+
+// rollback recorta el log hasta offset después de una escritura fallida. Si no se puede
+// restaurar, la persistencia queda marcada como fallida y rechaza las escrituras siguientes.
+// Debe llamarse con p.mutex tomado.
+func (p *mockPersistence) rollback(offset int64, cause error) error {
+	if err := p.walFile.Truncate(offset); err != nil {
+		p.failed = fmt.Errorf("failed to truncate WAL after %v: %v", cause, err)
+		return fmt.Errorf("%w: %v", ErrPersistenceFailed, p.failed)
+	}
+	if _, err := p.walFile.Seek(offset, io.SeekStart); err != nil {
+		p.failed = fmt.Errorf("failed to rewind WAL after %v: %v", cause, err)
+		return fmt.Errorf("%w: %v", ErrPersistenceFailed, p.failed)
+	}
+	return cause
+}
+
+// encodeWALRecord codifica la operación en uno o más registros de hasta walMaxRecordSize
+func encodeWALRecord(record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode WAL record: %v", err)
+	}
+	if len(payload) <= walMaxRecordSize {
+		return encodeFrame(payload), nil
+	}
+
+	// Dividir el lote en dos mitades; la segunda conserva More del registro original
+	first, second := record, record
+	switch {
+	case len(record.Transactions) > 1:
+		half := len(record.Transactions) / 2
+		first.Transactions, second.Transactions = record.Transactions[:half], record.Transactions[half:]
+	case len(record.IDs) > 1:
+		half := len(record.IDs) / 2
+		first.IDs, second.IDs = record.IDs[:half], record.IDs[half:]
+	default:
+		return nil, fmt.Errorf("WAL record of %d bytes exceeds limit", len(payload))
+	}
+	first.More = true
+
+	firstFrames, err := encodeWALRecord(first)
+	if err != nil {
+		return nil, err
+	}
+	secondFrames, err := encodeWALRecord(second)
+	if err != nil {
+		return nil, err
+	}
+	return append(firstFrames, secondFrames...), nil
+}
+
+// shouldCompact indica si el log alcanzó el tamaño configurado para compactar
+func (p *mockPersistence) shouldCompact() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.walFile != nil && p.walRecords >= p.options.SnapshotEvery
+}
+
+// compact escribe el snapshot de forma atómica y trunca el log.
+// Debe llamarse con stateMutex de MockDatabase tomado en escritura.
+func (p *mockPersistence) compact(db *MockDatabase) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.walFile == nil {
+		return ErrPersistenceClosed
+	}
+
+	// Escribir en un archivo temporal y renombrar para no dejar nunca un snapshot a medias
+	snapshotPath := filepath.Join(p.options.Dir, snapshotFileName)
+	tmpPath := snapshotPath + ".tmp"
+
+	if err := p.writeSnapshot(tmpPath, db); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, snapshotPath); err != nil {
+		return fmt.Errorf("failed to install snapshot: %v", err)
+	}
+	syncDir(p.options.Dir)
+
+	// Si el proceso muere antes de truncar, al reproducir el log se omiten las operaciones con Seq
+	// hasta la del snapshot, que ya están incluidas en él
+	if err := p.walFile.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %v", err)
+	}
+	if _, err := p.walFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind WAL: %v", err)
+	}
+	if err := p.walFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL: %v", err)
+	}
+
+	// El snapshot contiene todo lo confirmado y el log quedó vacío, así que vuelve a aceptar escrituras
+	p.walRecords = 0
+	p.failed = nil
+	return nil
+}
+
+// writeSnapshot escribe el estado de db en path, en registros de hasta snapshotChunkSize elementos.
+// Debe llamarse con p.mutex y el estado de MockDatabase bloqueados.
+func (p *mockPersistence) writeSnapshot(path string, db *MockDatabase) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	chunk := mockSnapshot{Seq: p.seq, NextID: int(db.nextID.Load())}
+	flush := func(more bool) error {
+		chunk.More = more
+		frames, err := encodeSnapshotChunk(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(frames); err != nil {
+			return err
+		}
+		chunk.Transactions, chunk.History = nil, nil
+		return nil
+	}
+
+	for _, shard := range db.idShards {
+		for _, transaction := range shard.transactions {
+			chunk.Transactions = append(chunk.Transactions, transaction)
+			if len(chunk.Transactions) >= snapshotChunkSize {
+				if err := flush(true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, shard := range db.idShards {
+		for _, versions := range shard.history {
+			chunk.History = append(chunk.History, versions...)
+			if len(chunk.History) >= snapshotChunkSize {
+				if err := flush(true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := flush(false); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// encodeSnapshotChunk codifica una parte del snapshot en uno o más registros de hasta walMaxRecordSize
+func encodeSnapshotChunk(chunk mockSnapshot) ([]byte, error) {
+	payload, err := json.Marshal(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %v", err)
+	}
+	if len(payload) <= walMaxRecordSize {
+		return encodeFrame(payload), nil
+	}
+	if len(chunk.Transactions)+len(chunk.History) <= 1 {
+		return nil, fmt.Errorf("snapshot record of %d bytes exceeds limit", len(payload))
+	}
+
+	// Dividir en dos mitades conservando el orden de las versiones
+	first, second := chunk, chunk
+	half := len(chunk.Transactions) / 2
+	first.Transactions, second.Transactions = chunk.Transactions[:half], chunk.Transactions[half:]
+	half = len(chunk.History) / 2
+	first.History, second.History = chunk.History[:half], chunk.History[half:]
+	first.More = true
+
+	firstFrames, err := encodeSnapshotChunk(first)
+	if err != nil {
+		return nil, err
+	}
+	secondFrames, err := encodeSnapshotChunk(second)
+	if err != nil {
+		return nil, err
+	}
+	return append(firstFrames, secondFrames...), nil
+}
+
+// loadSnapshot carga el último snapshot si existe
+func (p *mockPersistence) loadSnapshot(db *MockDatabase) error {
+	file, err := os.Open(filepath.Join(p.options.Dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	defer file.Close()
+
+	// El snapshot se instala con rename atómico: si está dañado no hay registro válido al cual truncar
+	reader := bufio.NewReader(file)
+	for more := true; more; {
+		payload, _, err := decodeFrame(reader)
+		if err == io.EOF {
+			err = errors.New("snapshot ends before its last record")
+		}
+		if err != nil {
+			return fmt.Errorf("%w: invalid snapshot: %v", ErrWALCorrupted, err)
+		}
+
+		var chunk mockSnapshot
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			return fmt.Errorf("%w: invalid snapshot: %v", ErrWALCorrupted, err)
+		}
+
+		for _, transaction := range chunk.Transactions {
+			db.put(transaction)
+		}
+
+		// Las versiones de cada transacción se escribieron en orden, así que se restauran agregándolas tal cual
+		for _, version := range chunk.History {
+			shard := db.idShardFor(version.Transaction.ID)
+			shard.history[version.Transaction.ID] = append(shard.history[version.Transaction.ID], version)
+		}
+		db.nextID.Store(int64(chunk.NextID))
+		p.seq = chunk.Seq
+		more = chunk.More
+	}
+
+	return nil
+}
+
+// replayWAL reproduce el log sobre el estado cargado y lo deja abierto para agregar registros
+func (p *mockPersistence) replayWAL(db *MockDatabase) error {
+	walPath := filepath.Join(p.options.Dir, walFileName)
+
+	file, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL: %v", err)
+	}
+
+	reader := bufio.NewReader(file)
+	snapshotSeq := p.seq
+	var goodOffset, pendingSize int64
+	var pending []walRecord // Partes de un lote cuyo último registro aún no se leyó
+
+	for {
+		payload, size, err := decodeFrame(reader)
+		if err == io.EOF {
+			if len(pending) == 0 {
+				break
+			}
+			err = errors.New("incomplete batch record")
+		}
+
+		var record walRecord
+		if err == nil {
+			err = json.Unmarshal(payload, &record)
+		}
+		if err == nil && len(pending) > 0 && (record.Seq != pending[0].Seq || record.Op != pending[0].Op) {
+			err = errors.New("incomplete batch record")
+		}
+
+		if err != nil {
+			if p.options.Recovery != RecoveryTruncate {
+				file.Close()
+				return fmt.Errorf("%w at offset %d: %v", ErrWALCorrupted, goodOffset, err)
+			}
+
+			log.Printf("WAL corrupted at offset %d (%v), truncating to last good record", goodOffset, err)
+			if err := file.Truncate(goodOffset); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to truncate WAL: %v", err)
+			}
+			break
+		}
+
+		// Las partes de un lote se aplican juntas al leer la última
+		pendingSize += size
+		if record.More {
+			pending = append(pending, record)
+			continue
+		}
+		if len(pending) > 0 {
+			parts := append(pending, record)
+			record.Transactions, record.IDs = nil, nil
+			for _, part := range parts {
+				record.Transactions = append(record.Transactions, part.Transactions...)
+				record.IDs = append(record.IDs, part.IDs...)
+			}
+			pending = nil
+		}
+
+		// Las operaciones anteriores al snapshot ya están incluidas en él (registros sin Seq: formato anterior)
+		if record.Seq == 0 || record.Seq > snapshotSeq {
+			db.applyWALRecord(record)
+		}
+		if record.Seq > p.seq {
+			p.seq = record.Seq
+		}
+		goodOffset += pendingSize
+		pendingSize = 0
+		p.walRecords++
+	}
+
+	if _, err := file.Seek(goodOffset, io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to position WAL: %v", err)
+	}
+
+	p.walFile = file
+	return nil
+}
+
+// encodeFrame antepone al payload su longitud y su checksum CRC32
+func encodeFrame(payload []byte) []byte {
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[walHeaderSize:], payload)
+	return frame
+}
+
+// decodeFrame lee un registro y verifica su checksum. Retorna io.EOF solo si no hay más datos;
+// un registro incompleto (escritura interrumpida) se reporta como error.
+func decodeFrame(reader *bufio.Reader) ([]byte, int64, error) {
+	header := make([]byte, walHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("truncated record header (%d bytes)", n)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	if length > walMaxRecordSize {
+		return nil, 0, fmt.Errorf("record length %d exceeds limit", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, fmt.Errorf("truncated record payload")
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	return payload, int64(walHeaderSize) + int64(length), nil
+}
+
+// syncDir sincroniza el directorio para que el rename sea durable (best effort)
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
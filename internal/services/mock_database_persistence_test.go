@@ -0,0 +1,424 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openPersistentTestDB(t *testing.T, options PersistenceOptions) *MockDatabase {
+	db, err := NewPersistentMockDatabase(options)
+	if err != nil {
+		t.Fatalf("Expected no error opening persistent database, got %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPersistentMockDatabase_ReplaysWAL(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 100}
+
+	db := openPersistentTestDB(t, options)
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 10, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 10, UserID: 1001, Amount: 99.99, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{UserID: 1002, Amount: -10, DateTime: baseTime})
+	db.Close()
+
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != 2 {
+		t.Fatalf("Expected 2 transactions after replay, got %d", reopened.GetTransactionCount())
+	}
+
+	tx, exists := reopened.GetTransaction(10)
+	if !exists || tx.Amount != 99.99 {
+		t.Errorf("Expected overwritten transaction with amount 99.99, got %+v", tx)
+	}
+
+	// El contador de IDs también debe recuperarse
+	saved, _ := reopened.SaveTransaction(models.UserTransaction{UserID: 1003, Amount: 1, DateTime: baseTime})
+	if saved.ID != 2 {
+		t.Errorf("Expected next auto ID 2, got %d", saved.ID)
+	}
+}
+
+func TestPersistentMockDatabase_CompactsIntoSnapshot(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 3}
+
+	db := openPersistentTestDB(t, options)
+	for i := 1; i <= 7; i++ {
+		db.SaveTransaction(models.UserTransaction{ID: i, UserID: 1001, Amount: float64(i), DateTime: time.Now()})
+	}
+	db.Close()
+
+	if _, err := os.Stat(filepath.Join(options.Dir, snapshotFileName)); err != nil {
+		t.Fatalf("Expected snapshot file to exist, got %v", err)
+	}
+
+	// Después de 7 escrituras con compactación cada 3, el log solo conserva 1 registro
+	info, _ := os.Stat(filepath.Join(options.Dir, walFileName))
+	if info.Size() == 0 {
+		t.Error("Expected WAL to contain records written after the last snapshot")
+	}
+
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != 7 {
+		t.Errorf("Expected 7 transactions from snapshot + WAL, got %d", reopened.GetTransactionCount())
+	}
+}
+
+func TestPersistentMockDatabase_ClearIsPersisted(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir()}
+
+	db := openPersistentTestDB(t, options)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()})
+	db.ClearTransactions()
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 2, DateTime: time.Now()})
+	db.Close()
+
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != 1 {
+		t.Errorf("Expected 1 transaction after clear + save, got %d", reopened.GetTransactionCount())
+	}
+}
+
+func TestPersistentMockDatabase_ReplaysImportReplace(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir()}
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	db := openPersistentTestDB(t, options)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 9, UserID: 1001, Amount: 9, DateTime: baseTime})
+	replace := []models.UserTransaction{{ID: 4, UserID: 1002, Amount: 4, DateTime: baseTime}}
+	if _, _, err := db.ImportTransactions(sliceIterator(replace, io.EOF), true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	db.Close()
+
+	// El reemplazo se recupera completo: sin las transacciones ni el historial anteriores
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != 1 || len(reopened.GetTransactionHistory(9)) != 0 {
+		t.Fatalf("Expected only the imported transaction, got %d", reopened.GetTransactionCount())
+	}
+	if saved, _ := reopened.SaveTransaction(models.UserTransaction{UserID: 1003, Amount: 1, DateTime: baseTime}); saved.ID != 5 {
+		t.Errorf("Expected next auto ID 5, got %d", saved.ID)
+	}
+}
+
+func TestPersistentMockDatabase_CorruptedTail(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openPersistentTestDB(t, PersistenceOptions{Dir: dir})
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 2, DateTime: time.Now()})
+	db.Close()
+
+	// Simular una escritura interrumpida: un registro incompleto al final del log
+	walPath := filepath.Join(dir, walFileName)
+	goodInfo, _ := os.Stat(walPath)
+	file, _ := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write([]byte{0, 0, 0, 50, 1, 2, 3, 4, '{', '"'})
+	file.Close()
+
+	// Modo estricto: el arranque falla
+	_, err := NewPersistentMockDatabase(PersistenceOptions{Dir: dir, Recovery: RecoveryStrict})
+	if !errors.Is(err, ErrWALCorrupted) {
+		t.Fatalf("Expected ErrWALCorrupted in strict mode, got %v", err)
+	}
+
+	// Modo truncate: se recuperan los registros válidos y se descarta la cola corrupta
+	recovered := openPersistentTestDB(t, PersistenceOptions{Dir: dir, Recovery: RecoveryTruncate})
+	if recovered.GetTransactionCount() != 2 {
+		t.Errorf("Expected 2 recovered transactions, got %d", recovered.GetTransactionCount())
+	}
+
+	info, _ := os.Stat(walPath)
+	if info.Size() != goodInfo.Size() {
+		t.Errorf("Expected WAL truncated to %d bytes, got %d", goodInfo.Size(), info.Size())
+	}
+
+	// Las nuevas escrituras continúan después del último registro válido
+	recovered.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1001, Amount: 3, DateTime: time.Now()})
+	recovered.Close()
+
+	reopened := openPersistentTestDB(t, PersistenceOptions{Dir: dir, Recovery: RecoveryStrict})
+	if reopened.GetTransactionCount() != 3 {
+		t.Errorf("Expected 3 transactions after recovery, got %d", reopened.GetTransactionCount())
+	}
+}
+
+func TestPersistentMockDatabase_ChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openPersistentTestDB(t, PersistenceOptions{Dir: dir})
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 2, DateTime: time.Now()})
+	db.Close()
+
+	// Alterar un byte del payload del último registro
+	walPath := filepath.Join(dir, walFileName)
+	data, _ := os.ReadFile(walPath)
+	data[len(data)-2] ^= 0xFF
+	os.WriteFile(walPath, data, 0644)
+
+	recovered := openPersistentTestDB(t, PersistenceOptions{Dir: dir, Recovery: RecoveryTruncate})
+	if recovered.GetTransactionCount() != 1 {
+		t.Errorf("Expected only the first record to survive, got %d", recovered.GetTransactionCount())
+	}
+}
+
+func TestPersistentMockDatabase_ReplaysBatch(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir()}
+
+	db := openPersistentTestDB(t, options)
+	batch := []models.UserTransaction{
+		{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()},
+		{ID: 2, UserID: 1001, Amount: 2, DateTime: time.Now()},
+		{UserID: 1002, Amount: 3, DateTime: time.Now()},
+	}
+	if _, err := db.SaveTransactions(batch, SaveOptions{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	db.Close()
+
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != 3 {
+		t.Errorf("Expected 3 transactions after replaying batch, got %d", reopened.GetTransactionCount())
+	}
+
+	saved, _ := reopened.SaveTransaction(models.UserTransaction{UserID: 1003, Amount: 4, DateTime: time.Now()})
+	if saved.ID != 4 {
+		t.Errorf("Expected next auto ID 4, got %d", saved.ID)
+	}
+}
+
+func TestPersistentMockDatabase_PersistsHistory(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 2}
+
+	db := openPersistentTestDB(t, options)
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for i, amount := range []float64{1, 2, 3} {
+		db.SaveTransactionWithOptions(models.UserTransaction{ID: 1, UserID: 1001, Amount: amount, DateTime: baseTime},
+			SaveOptions{MigrationID: fmt.Sprintf("mig-%d", i+1)})
+	}
+	db.Close()
+
+	// Las dos primeras versiones vienen del snapshot y la tercera del log
+	reopened := openPersistentTestDB(t, options)
+	versions := reopened.GetTransactionHistory(1)
+	if len(versions) != 3 {
+		t.Fatalf("Expected 3 versions after reopen, got %d", len(versions))
+	}
+	if versions[2].Version != 3 || versions[2].MigrationID != "mig-3" || versions[2].Transaction.Amount != 3 {
+		t.Errorf("Unexpected latest version: %+v", versions[2])
+	}
+}
+
+func TestPersistentMockDatabase_ReplaysDelete(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 1000}
+
+	db := openPersistentTestDB(t, options)
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for id := 1; id <= 3; id++ {
+		db.SaveTransaction(models.UserTransaction{ID: id, UserID: 1001, Amount: 10, DateTime: baseTime})
+	}
+	db.DeleteTransactions([]int{2})
+	db.SoftDeleteTransaction(3, "wrong amount")
+	db.Close()
+
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != 2 {
+		t.Fatalf("Expected 2 transactions after replay, got %d", reopened.GetTransactionCount())
+	}
+	if _, found := reopened.GetTransaction(2); found {
+		t.Error("Expected transaction 2 to stay deleted after replay")
+	}
+	if got := reopened.GetTransactionsByUserID(1001); len(got) != 2 {
+		t.Errorf("Expected user index without the deleted transaction, got %d", len(got))
+	}
+	if tx, _ := reopened.GetTransaction(3); !tx.Deleted || tx.DeleteReason != "wrong amount" {
+		t.Errorf("Expected transaction 3 soft-deleted after replay, got %+v", tx)
+	}
+}
+
+func TestPersistentMockDatabase_LargeBatchAndSnapshot(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 1000}
+
+	// Un lote de 160k filas supera walMaxRecordSize y se escribe en varios registros
+	db := openPersistentTestDB(t, options)
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	batch := make([]models.UserTransaction, 160000)
+	for i := range batch {
+		batch[i] = models.UserTransaction{ID: i + 1, UserID: 1000 + i%50, Amount: float64(i), DateTime: baseTime,
+			SourceFile: "exports/2024/bank-statement-large-batch.csv", SourceLine: i + 2}
+	}
+	if _, err := db.SaveTransactions(batch, SaveOptions{MigrationID: "mig-large"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	db.Close()
+
+	if info, _ := os.Stat(filepath.Join(options.Dir, walFileName)); info.Size() <= walMaxRecordSize {
+		t.Fatalf("Expected a WAL larger than one record, got %d bytes", info.Size())
+	}
+
+	reopened := openPersistentTestDB(t, options)
+	if reopened.GetTransactionCount() != len(batch) {
+		t.Fatalf("Expected %d transactions after replaying the batch, got %d", len(batch), reopened.GetTransactionCount())
+	}
+
+	// El snapshot también supera walMaxRecordSize y se escribe en varios registros
+	if err := reopened.Compact(); err != nil {
+		t.Fatalf("Expected no error compacting, got %v", err)
+	}
+	reopened.Close()
+
+	if info, _ := os.Stat(filepath.Join(options.Dir, snapshotFileName)); info.Size() <= walMaxRecordSize {
+		t.Fatalf("Expected a snapshot larger than one record, got %d bytes", info.Size())
+	}
+
+	restored := openPersistentTestDB(t, options)
+	if restored.GetTransactionCount() != len(batch) {
+		t.Fatalf("Expected %d transactions from the snapshot, got %d", len(batch), restored.GetTransactionCount())
+	}
+	if versions := restored.GetTransactionHistory(160000); len(versions) != 1 || versions[0].MigrationID != "mig-large" {
+		t.Errorf("Unexpected history after restore: %+v", versions)
+	}
+}
+
+func TestPersistentMockDatabase_IncompleteLargeBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openPersistentTestDB(t, PersistenceOptions{Dir: dir})
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()})
+	walPath := filepath.Join(dir, walFileName)
+	goodInfo, _ := os.Stat(walPath)
+
+	batch := make([]models.UserTransaction, 160000)
+	for i := range batch {
+		batch[i] = models.UserTransaction{ID: i + 2, UserID: 1001, Amount: 1, DateTime: time.Now(), SourceFile: "exports/2024/bank-statement-large-batch.csv"}
+	}
+	db.SaveTransactions(batch, SaveOptions{})
+	db.Close()
+
+	// Simular una caída después de escribir solo el primer registro del lote
+	file, _ := os.Open(walPath)
+	reader := bufio.NewReader(file)
+	reader.Discard(int(goodInfo.Size()))
+	_, size, err := decodeFrame(reader)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Expected a valid first batch record, got %v", err)
+	}
+	os.Truncate(walPath, goodInfo.Size()+size)
+
+	// El lote incompleto se descarta entero
+	recovered := openPersistentTestDB(t, PersistenceOptions{Dir: dir, Recovery: RecoveryTruncate})
+	if recovered.GetTransactionCount() != 1 {
+		t.Errorf("Expected only the transaction before the batch, got %d", recovered.GetTransactionCount())
+	}
+	if info, _ := os.Stat(walPath); info.Size() != goodInfo.Size() {
+		t.Errorf("Expected WAL truncated to %d bytes, got %d", goodInfo.Size(), info.Size())
+	}
+}
+
+func TestPersistentMockDatabase_ReplaySkipsSnapshotRecords(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, walFileName)
+
+	db := openPersistentTestDB(t, PersistenceOptions{Dir: dir})
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 2, DateTime: baseTime})
+	walBeforeCompact, _ := os.ReadFile(walPath)
+
+	// Simular una caída entre instalar el snapshot y truncar el log
+	db.Compact()
+	db.Close()
+	os.WriteFile(walPath, walBeforeCompact, 0644)
+
+	reopened := openPersistentTestDB(t, PersistenceOptions{Dir: dir})
+	if versions := reopened.GetTransactionHistory(1); len(versions) != 2 {
+		t.Fatalf("Expected 2 versions without duplicates from the WAL, got %d", len(versions))
+	}
+
+	// Las escrituras nuevas continúan la secuencia y se reproducen después de otro reinicio
+	reopened.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 3, DateTime: baseTime})
+	reopened.Close()
+
+	again := openPersistentTestDB(t, PersistenceOptions{Dir: dir})
+	if versions := again.GetTransactionHistory(1); len(versions) != 3 || versions[2].Transaction.Amount != 3 {
+		t.Errorf("Expected 3 versions after reopening, got %+v", versions)
+	}
+}
+
+// failingWAL simula un disco que acepta solo parte de la próxima escritura
+type failingWAL struct {
+	walWriter
+	failNextWrite bool
+	failTruncate  bool
+}
+
+func (f *failingWAL) Write(data []byte) (int, error) {
+	if f.failNextWrite {
+		f.failNextWrite = false
+		n, _ := f.walWriter.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+	return f.walWriter.Write(data)
+}
+
+func (f *failingWAL) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("device error")
+	}
+	return f.walWriter.Truncate(size)
+}
+
+func TestPersistentMockDatabase_FailedWriteIsRolledBack(t *testing.T) {
+	options := PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 100}
+
+	db := openPersistentTestDB(t, options)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()})
+
+	wal := &failingWAL{walWriter: db.persistence.walFile, failNextWrite: true}
+	db.persistence.walFile = wal
+	if _, err := db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 2, DateTime: time.Now()}); err == nil {
+		t.Fatal("Expected error from the failed WAL write")
+	}
+
+	// La escritura siguiente se confirma y debe sobrevivir al reinicio
+	if _, err := db.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1001, Amount: 3, DateTime: time.Now()}); err != nil {
+		t.Fatalf("Expected no error after rollback, got %v", err)
+	}
+	db.Close()
+
+	reopened := openPersistentTestDB(t, PersistenceOptions{Dir: options.Dir, Recovery: RecoveryStrict})
+	if reopened.GetTransactionCount() != 2 {
+		t.Errorf("Expected 2 transactions after restart, got %d", reopened.GetTransactionCount())
+	}
+	if _, exists := reopened.GetTransaction(3); !exists {
+		t.Error("Expected acknowledged transaction 3 to survive the restart")
+	}
+}
+
+func TestPersistentMockDatabase_FailedRollbackRefusesWrites(t *testing.T) {
+	db := openPersistentTestDB(t, PersistenceOptions{Dir: t.TempDir(), SnapshotEvery: 100})
+
+	db.persistence.walFile = &failingWAL{walWriter: db.persistence.walFile, failNextWrite: true, failTruncate: true}
+	if _, err := db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 1, DateTime: time.Now()}); !errors.Is(err, ErrPersistenceFailed) {
+		t.Fatalf("Expected ErrPersistenceFailed, got %v", err)
+	}
+
+	if _, err := db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 2, DateTime: time.Now()}); !errors.Is(err, ErrPersistenceFailed) {
+		t.Errorf("Expected later writes refused with ErrPersistenceFailed, got %v", err)
+	}
+	if db.GetTransactionCount() != 0 {
+		t.Errorf("Expected no transactions applied, got %d", db.GetTransactionCount())
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"api-stori/internal/models"
+	"sync"
 	"testing"
 	"time"
 )
@@ -230,3 +231,140 @@ func TestMockDatabase_ClearTransactions(t *testing.T) {
 		t.Errorf("Expected ID to reset to 1, got %d", saved.ID)
 	}
 }
+
+func TestMockDatabase_GetTransactionsByUserIDChronologicalOrder(t *testing.T) {
+	db := NewMockDatabase()
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	transactions := []models.UserTransaction{
+		{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime.Add(72 * time.Hour)},
+		{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime},
+		{ID: 3, UserID: 1001, Amount: 30, DateTime: baseTime.Add(24 * time.Hour)},
+		{ID: 4, UserID: 1001, Amount: 40, DateTime: baseTime},
+		{ID: 5, UserID: 1002, Amount: 50, DateTime: baseTime},
+	}
+	for _, tx := range transactions {
+		db.SaveTransaction(tx)
+	}
+
+	// Mover la transacción 1 a otra fecha y la 5 a otro usuario
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime.Add(36 * time.Hour)})
+	db.SaveTransaction(models.UserTransaction{ID: 5, UserID: 1001, Amount: 50, DateTime: baseTime.Add(48 * time.Hour)})
+
+	expectedIDs := []int{2, 4, 3, 1, 5}
+	userTransactions := db.GetTransactionsByUserID(1001)
+	if len(userTransactions) != len(expectedIDs) {
+		t.Fatalf("Expected %d transactions, got %d", len(expectedIDs), len(userTransactions))
+	}
+	for i, tx := range userTransactions {
+		if tx.ID != expectedIDs[i] {
+			t.Errorf("Position %d: expected ID %d, got %d", i, expectedIDs[i], tx.ID)
+		}
+	}
+
+	if len(db.GetTransactionsByUserID(1002)) != 0 {
+		t.Error("Expected user 1002 to have no transactions after reassignment")
+	}
+
+	// Los límites del rango son inclusivos
+	fromDate := baseTime.Add(24 * time.Hour)
+	toDate := baseTime.Add(48 * time.Hour)
+	filtered := db.GetTransactionsByUserIDWithDateRange(1001, &fromDate, &toDate)
+	if len(filtered) != 3 || filtered[0].ID != 3 || filtered[2].ID != 5 {
+		t.Errorf("Expected IDs [3 1 5] in inclusive range, got %+v", filtered)
+	}
+}
+
+func TestMockDatabase_ConcurrentSaves(t *testing.T) {
+	db := NewMockDatabase()
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	writers := 8
+	perWriter := 200
+	var wg sync.WaitGroup
+	ids := make(chan int, writers*perWriter)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				saved, err := db.SaveTransaction(models.UserTransaction{UserID: 1001 + w, Amount: 1, DateTime: baseTime.Add(time.Duration(i) * time.Minute)})
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+				ids <- saved.ID
+			}
+		}(w)
+	}
+
+	// Lecturas concurrentes sobre los mismos usuarios mientras se escribe
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				db.GetTransactionsByUserID(1001 + (r+i)%writers)
+				db.GetTransactionCount()
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	close(ids)
+
+	// El asignador atómico no repite IDs
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("Duplicate auto ID %d", id)
+		}
+		seen[id] = true
+	}
+	if db.GetTransactionCount() != writers*perWriter {
+		t.Errorf("Expected %d transactions, got %d", writers*perWriter, db.GetTransactionCount())
+	}
+	for w := 0; w < writers; w++ {
+		if got := len(db.GetTransactionsByUserID(1001 + w)); got != perWriter {
+			t.Errorf("User %d: expected %d transactions, got %d", 1001+w, perWriter, got)
+		}
+	}
+}
+
+// benchmarkUserRangeLookup mide la consulta por rango de un usuario con 100 transacciones
+// mientras otros usuarios acumulan otherTransactions transacciones en el mismo store
+func benchmarkUserRangeLookup(b *testing.B, otherTransactions int) {
+	db := NewMockDatabase()
+	baseTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	id := 1
+	for i := 0; i < 100; i++ {
+		db.SaveTransaction(models.UserTransaction{ID: id, UserID: 1, Amount: 1, DateTime: baseTime.Add(time.Duration(i) * time.Hour)})
+		id++
+	}
+	for i := 0; i < otherTransactions; i++ {
+		db.SaveTransaction(models.UserTransaction{ID: id, UserID: 2 + i%1000, Amount: 1, DateTime: baseTime.Add(time.Duration(i) * time.Minute)})
+		id++
+	}
+
+	fromDate := baseTime.Add(25 * time.Hour)
+	toDate := baseTime.Add(75 * time.Hour)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.GetTransactionsByUserIDWithDateRange(1, &fromDate, &toDate)
+	}
+}
+
+func BenchmarkMockDatabase_UserRangeLookup_NoOtherUsers(b *testing.B) {
+	benchmarkUserRangeLookup(b, 0)
+}
+
+func BenchmarkMockDatabase_UserRangeLookup_10kOtherTransactions(b *testing.B) {
+	benchmarkUserRangeLookup(b, 10000)
+}
+
+func BenchmarkMockDatabase_UserRangeLookup_100kOtherTransactions(b *testing.B) {
+	benchmarkUserRangeLookup(b, 100000)
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// FileFormat formato de un archivo de migración
+type FileFormat string
+
+const (
+	FormatCSV    FileFormat = "csv"
+	FormatNDJSON FileFormat = "ndjson" // JSON Lines: un objeto UserTransaction por línea
+	FormatJSON   FileFormat = "json"   // Arreglo JSON de objetos UserTransaction
+	FormatXLSX   FileFormat = "xlsx"   // Libro de Excel; se lee una hoja (MigrationOptions.Sheet)
+	FormatOFX    FileFormat = "ofx"    // Extracto bancario OFX/QFX (SGML o XML)
+	FormatQIF    FileFormat = "qif"    // Extracto bancario Quicken Interchange Format
+	FormatZIP    FileFormat = "zip"    // Archivo ZIP con varios archivos de los formatos anteriores
+)
+
+// maxJSONRecordSize tamaño máximo de un objeto JSON; los más grandes se cuentan como error sin cargarlos
+const maxJSONRecordSize = 1 << 20
+
+// ParseFileFormat convierte un texto en FileFormat; vacío significa detectar el formato del archivo
+func ParseFileFormat(value string) (FileFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return "", nil
+	case "csv":
+		return FormatCSV, nil
+	case "ndjson", "jsonl":
+		return FormatNDJSON, nil
+	case "json":
+		return FormatJSON, nil
+	case "xlsx":
+		return FormatXLSX, nil
+	case "ofx", "qfx":
+		return FormatOFX, nil
+	case "qif":
+		return FormatQIF, nil
+	case "zip":
+		return FormatZIP, nil
+	default:
+		return "", fmt.Errorf("invalid format %q. Expected one of: csv, ndjson, json, xlsx, ofx, qif, zip", value)
+	}
+}
+
+// DetectFileFormat deduce el formato por el Content-Type o, si no es concluyente, por la extensión del archivo.
+// Retorna "" si no se reconoce ninguno.
+func DetectFileFormat(contentType, filename string) FileFormat {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return FormatNDJSON
+	case "application/json":
+		return FormatJSON
+	case "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+		return FormatXLSX
+	case "application/x-ofx", "application/ofx", "application/vnd.intu.qfx":
+		return FormatOFX
+	case "application/qif", "application/x-qif":
+		return FormatQIF
+	case "application/zip", "application/x-zip-compressed":
+		return FormatZIP
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".json":
+		return FormatJSON
+	case ".xlsx":
+		return FormatXLSX
+	case ".ofx", ".qfx":
+		return FormatOFX
+	case ".qif":
+		return FormatQIF
+	case ".zip":
+		return FormatZIP
+	}
+	return ""
+}
+
+// SniffFileFormat reconoce los extractos bancarios por su contenido inicial, para archivos
+// sin extensión ni Content-Type conocidos. Retorna "" si no es OFX ni QIF.
+func SniffFileFormat(prefix []byte) FileFormat {
+	text := strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(string(prefix), "\ufeff")))
+	switch {
+	case strings.HasPrefix(text, "OFXHEADER"), strings.Contains(text, "<?OFX"), strings.Contains(text, "<OFX>"):
+		return FormatOFX
+	case strings.HasPrefix(text, "!TYPE:"), strings.HasPrefix(text, "!ACCOUNT"), strings.HasPrefix(text, "!OPTION"):
+		return FormatQIF
+	}
+	return ""
+}
+
+// sourceRecord registro leído de un archivo de migración
+type sourceRecord struct {
+	line        int    // Línea del registro (en un arreglo JSON, su posición)
+	file        string // Archivo del ZIP del que sale el registro, como "<zip>/<archivo>" (vacío = el archivo subido)
+	transaction models.UserTransaction
+	err         error // Error de este registro; la migración sigue con el siguiente
+}
+
+// recordSource lee los registros de un archivo de migración uno a uno
+type recordSource interface {
+	// next retorna el siguiente registro, io.EOF al terminar o un error de lectura que corta la migración
+	next() (sourceRecord, error)
+}
+
+// openRecordSource crea el lector de registros del formato de options. Si el lector implementa
+// io.Closer debe cerrarse al terminar (ver closeRecordSource).
+func (ms *MigrationService) openRecordSource(reader io.Reader, options MigrationOptions) (recordSource, error) {
+	if options.Compression == CompressionGzip {
+		decompressed, err := ms.DecompressGzip(reader)
+		if err != nil {
+			return nil, err
+		}
+		reader = decompressed
+	}
+	accounts := accountLookup{users: ms.accountUsers, defaultUserID: options.DefaultUserID}
+
+	switch options.Format {
+	case FormatCSV, "":
+		return newCSVSource(reader, options.Mapping)
+	case FormatNDJSON:
+		return newNDJSONSource(reader, options.Mapping)
+	case FormatJSON:
+		return newJSONArraySource(reader, options.Mapping)
+	case FormatXLSX:
+		return newXLSXSource(reader, options.Sheet, options.Mapping)
+	case FormatOFX:
+		return newOFXSource(reader, accounts)
+	case FormatQIF:
+		return newQIFSource(reader, accounts)
+	case FormatZIP:
+		return ms.newZipSource(reader, options)
+	default:
+		return nil, fmt.Errorf("unsupported file format %q", options.Format)
+	}
+}
+
+// closeRecordSource libera los recursos del lector, si los tiene
+func closeRecordSource(source recordSource) {
+	if closer, ok := source.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// csvSource lee filas de un CSV con header
+type csvSource struct {
+	reader  *csv.Reader
+	columns columnIndex
+	line    int
+}
+
+// newCSVSource lee el header y ubica la columna de cada campo según mapping.
+// La cantidad de columnas se valida por fila para registrar el error y seguir con el resto.
+func newCSVSource(reader io.Reader, mapping ColumnMapping) (*csvSource, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV: %v", err)
+	}
+
+	columns, err := resolveColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvSource{reader: csvReader, columns: columns, line: 1}, nil // La línea 1 es el header
+}
+
+func (s *csvSource) next() (sourceRecord, error) {
+	record, err := s.reader.Read()
+	if err == io.EOF {
+		return sourceRecord{}, io.EOF
+	}
+	s.line++
+
+	// Una fila mal formada es un error de esa fila; cualquier otro error corta la lectura
+	var parseErr *csv.ParseError
+	if err != nil && !errors.As(err, &parseErr) {
+		return sourceRecord{}, fmt.Errorf("error reading CSV: %v", err)
+	}
+	if err != nil {
+		return sourceRecord{line: s.line, err: err}, nil
+	}
+
+	transaction, err := parseTransaction(record, s.columns, s.line)
+	return sourceRecord{line: s.line, transaction: transaction, err: err}, nil
+}
+
+// ndjsonSource lee un objeto JSON por línea; las líneas vacías se ignoran
+type ndjsonSource struct {
+	reader  *bufio.Reader
+	mapping ColumnMapping
+	line    int
+}
+
+func newNDJSONSource(reader io.Reader, mapping ColumnMapping) (*ndjsonSource, error) {
+	buffered := bufio.NewReader(reader)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		return nil, fmt.Errorf("JSON Lines file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading JSON Lines: %v", err)
+	}
+	return &ndjsonSource{reader: buffered, mapping: mapping}, nil
+}
+
+func (s *ndjsonSource) next() (sourceRecord, error) {
+	for {
+		data, tooLong, err := readUntil(s.reader, '\n', maxJSONRecordSize)
+		if err != nil && err != io.EOF {
+			return sourceRecord{}, fmt.Errorf("error reading JSON Lines: %v", err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 && !tooLong {
+			if err == io.EOF {
+				return sourceRecord{}, io.EOF
+			}
+			s.line++
+			continue
+		}
+		s.line++
+
+		if tooLong {
+			return sourceRecord{line: s.line, err: fmt.Errorf("JSON object at line %d exceeds %d bytes", s.line, maxJSONRecordSize)}, nil
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return sourceRecord{line: s.line, err: fmt.Errorf("invalid JSON at line %d: %v", s.line, err)}, nil
+		}
+		transaction, err := parseJSONTransaction(object, s.mapping, s.line)
+		return sourceRecord{line: s.line, transaction: transaction, err: err}, nil
+	}
+}
+
+// readUntil lee hasta delim inclusive (p. ej. el siguiente salto de línea). Si el texto supera max bytes
+// lo descarta y retorna tooLong.
+func readUntil(reader *bufio.Reader, delim byte, max int) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := reader.ReadSlice(delim)
+		if !tooLong {
+			if len(line)+len(chunk) > max {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, tooLong, err
+		}
+	}
+}
+
+// jsonArraySource lee los elementos de un arreglo JSON sin cargarlo completo. Cada elemento se delimita
+// antes de decodificarlo: los de más de maxJSONRecordSize bytes se cuentan como error sin cargarlos.
+type jsonArraySource struct {
+	reader   *bufio.Reader
+	mapping  ColumnMapping
+	position int
+	done     bool
+}
+
+func newJSONArraySource(reader io.Reader, mapping ColumnMapping) (*jsonArraySource, error) {
+	buffered := bufio.NewReader(reader)
+	first, err := skipJSONSpace(buffered)
+	if err == io.EOF {
+		return nil, fmt.Errorf("JSON file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading JSON: %v", err)
+	}
+	if first != '[' {
+		return nil, fmt.Errorf("invalid JSON: expected an array of transactions")
+	}
+	return &jsonArraySource{reader: buffered, mapping: mapping}, nil
+}
+
+func (s *jsonArraySource) next() (sourceRecord, error) {
+	if s.done {
+		return sourceRecord{}, io.EOF
+	}
+
+	c, err := skipJSONSpace(s.reader)
+	if err != nil {
+		return sourceRecord{}, fmt.Errorf("error reading JSON: %v", unexpectedEOF(err))
+	}
+	if c == ']' {
+		s.done = true
+		return sourceRecord{}, io.EOF
+	}
+	if s.position > 0 {
+		if c != ',' {
+			return sourceRecord{}, fmt.Errorf("error reading JSON: invalid character %q after array element", c)
+		}
+		if c, err = skipJSONSpace(s.reader); err != nil {
+			return sourceRecord{}, fmt.Errorf("error reading JSON: %v", unexpectedEOF(err))
+		}
+	}
+	s.position++
+
+	data, tooLong, err := readJSONValue(s.reader, c, maxJSONRecordSize)
+	if err != nil {
+		return sourceRecord{}, fmt.Errorf("error reading JSON: %v", err)
+	}
+	if tooLong {
+		return sourceRecord{line: s.position, err: fmt.Errorf("JSON object at line %d exceeds %d bytes", s.position, maxJSONRecordSize)}, nil
+	}
+
+	// Un elemento que no es un objeto es un error de ese registro; un error de sintaxis corta la lectura
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return sourceRecord{line: s.position, err: fmt.Errorf("invalid JSON at line %d: expected an object, got %s", s.position, typeErr.Value)}, nil
+		}
+		return sourceRecord{}, fmt.Errorf("error reading JSON: %v", err)
+	}
+
+	transaction, err := parseJSONTransaction(object, s.mapping, s.position)
+	return sourceRecord{line: s.position, transaction: transaction, err: err}, nil
+}
+
+// skipJSONSpace descarta los espacios en blanco y retorna el siguiente byte
+func skipJSONSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c, nil
+		}
+	}
+}
+
+// readJSONValue lee el valor JSON que empieza con first, ya consumido, sin validar su sintaxis.
+// Si el valor supera max bytes sigue leyendo hasta su final pero lo descarta y retorna tooLong.
+func readJSONValue(reader *bufio.Reader, first byte, max int) (value []byte, tooLong bool, err error) {
+	value = append(value, first)
+	depth := 0
+	inString := first == '"'
+	escaped := false
+	switch first {
+	case '{', '[':
+		depth = 1
+	case '"':
+	default:
+		// Número, true, false o null: termina en el siguiente separador, que no se consume
+		for {
+			next, err := reader.Peek(1)
+			if err == io.EOF {
+				return nil, false, io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			switch next[0] {
+			case ',', ']', '}', ' ', '\t', '\n', '\r':
+				return value, tooLong, nil
+			}
+			c, _ := reader.ReadByte()
+			value, tooLong = appendLimited(value, tooLong, c, max)
+		}
+	}
+
+	for inString || depth > 0 {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+		value, tooLong = appendLimited(value, tooLong, c, max)
+
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return value, tooLong, nil
+}
+
+// appendLimited agrega c a value mientras no supere max bytes; al superarlo descarta lo leído
+func appendLimited(value []byte, tooLong bool, c byte, max int) ([]byte, bool) {
+	if tooLong || len(value) >= max {
+		return nil, true
+	}
+	return append(value, c), false
+}
+
+// unexpectedEOF convierte io.EOF en io.ErrUnexpectedEOF: el archivo terminó antes de cerrar el arreglo
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// jsonColumns posición de cada campo en el registro armado a partir de un objeto JSON
+var jsonColumns = columnIndex{id: 0, userID: 1, amount: 2, datetime: 3, width: 4}
+
+// parseJSONTransaction toma cada campo del objeto según mapping (sin distinguir mayúsculas en las claves)
+// y lo valida igual que una fila CSV. Números y textos se aceptan por igual; las claves adicionales se ignoran.
+func parseJSONTransaction(object map[string]json.RawMessage, mapping ColumnMapping, lineNumber int) (models.UserTransaction, error) {
+	record := make([]string, jsonColumns.width)
+	for i, field := range transactionFields {
+		found := ""
+		for key, raw := range object {
+			if !mapping.accepts(field, strings.TrimSpace(key)) {
+				continue
+			}
+			if found != "" {
+				return models.UserTransaction{}, fmt.Errorf("keys %q and %q both map to field %s at line %d", found, key, field, lineNumber)
+			}
+
+			value, err := jsonScalar(raw)
+			if err != nil {
+				return models.UserTransaction{}, fmt.Errorf("invalid %s at line %d: %v", field, lineNumber, err)
+			}
+			found = key
+			record[i] = value
+		}
+		if found == "" || record[i] == "" {
+			return models.UserTransaction{}, fmt.Errorf("missing field %s at line %d", field, lineNumber)
+		}
+	}
+
+	return parseTransaction(record, jsonColumns, lineNumber)
+}
+
+// jsonScalar retorna el texto de un valor JSON string o number; null equivale a vacío
+func jsonScalar(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case bytes.Equal(raw, []byte("null")):
+		return "", nil
+	case len(raw) > 0 && raw[0] == '"':
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", err
+		}
+		return value, nil
+	case len(raw) > 0 && (raw[0] == '-' || (raw[0] >= '0' && raw[0] <= '9')):
+		return string(raw), nil
+	default:
+		return "", fmt.Errorf("expected a string or a number, got %s", raw)
+	}
+}
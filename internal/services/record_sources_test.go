@@ -0,0 +1,177 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDetectFileFormat(t *testing.T) {
+	tests := []struct {
+		contentType string
+		filename    string
+		expected    FileFormat
+	}{
+		{"text/csv", "", FormatCSV},
+		{"application/x-ndjson", "", FormatNDJSON},
+		{"application/json; charset=utf-8", "", FormatJSON},
+		{"application/octet-stream", "data.CSV", FormatCSV},
+		{"application/octet-stream", "data.jsonl", FormatNDJSON},
+		{"", "data.json", FormatJSON},
+		{"application/octet-stream", "finance.xlsx", FormatXLSX},
+		{"application/x-ofx", "", FormatOFX},
+		{"application/octet-stream", "statement.QFX", FormatOFX},
+		{"", "statement.qif", FormatQIF},
+		{"application/zip", "", FormatZIP},
+		{"application/octet-stream", "exports.zip", FormatZIP},
+		{"text/plain", "data.txt", ""},
+	}
+
+	for _, tt := range tests {
+		if got := DetectFileFormat(tt.contentType, tt.filename); got != tt.expected {
+			t.Errorf("DetectFileFormat(%q, %q) = %q, expected %q", tt.contentType, tt.filename, got, tt.expected)
+		}
+	}
+
+	if _, err := ParseFileFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestMigrationService_ProcessFileNDJSON(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Números o textos, datetime en RFC 3339 (como lo serializa UserTransaction) y líneas vacías
+	content := `{"id": 1, "user_id": 1001, "amount": 150.50, "datetime": "2024-01-15T10:30:00Z"}
+
+{"id": "2", "user_id": "1001", "amount": "-20", "datetime": "2024-01-16 09:15:00"}
+{"id": 3, "user_id": 1002, "amount": true, "datetime": "2024-01-16"}
+{"id": 4, "user_id": 1002
+{"id": 5, "user_id": 1002, "datetime": "2024-01-16"}
+{"id": 6, "user_id": 1002, "amount": 10, "datetime": "2024-01-17"}`
+
+	stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatNDJSON, SourceFile: "tx.ndjson"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 6 || stats.SuccessRecords != 3 || stats.ErrorRecords != 3 {
+		t.Errorf("Expected 6 records, 3 success and 3 errors, got %+v", stats)
+	}
+
+	// Los errores indican la línea real del archivo
+	for i, expected := range []string{"invalid amount at line 4", "invalid JSON at line 5", "missing field amount at line 6"} {
+		if i >= len(stats.Errors) || !strings.Contains(stats.Errors[i], expected) {
+			t.Errorf("Expected error %d to contain %q, got %v", i, expected, stats.Errors)
+		}
+	}
+
+	tx, _ := db.GetTransaction(1)
+	if tx.Amount != 150.50 || tx.DateTime.Hour() != 10 || tx.SourceFile != "tx.ndjson" || tx.SourceLine != 1 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+	if tx, _ := db.GetTransaction(6); tx.SourceLine != 7 {
+		t.Errorf("Expected transaction 6 from line 7, got %+v", tx)
+	}
+
+	if _, err := service.ProcessFile(strings.NewReader(""), MigrationOptions{Format: FormatNDJSON}); err == nil {
+		t.Error("Expected error for empty file")
+	}
+}
+
+func TestMigrationService_ProcessFileJSONArray(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Las claves se asocian con la misma configuración de columnas que los CSV
+	content := `[
+		{"ID": 1, "UserId": 1001, "Amount": 150.50, "timestamp": "2024-01-15T10:30:00-03:00", "extra": {"a": 1}},
+		{"ID": 2, "UserId": 1001, "Amount": "abc", "timestamp": "2024-01-16"},
+		42,
+		{"ID": 3, "UserId": 1002, "Amount": -5, "timestamp": "2024-01-17"}
+	]`
+
+	mapping := ColumnMapping{"user_id": {"userid"}, "datetime": {"timestamp"}}
+	stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatJSON, Mapping: mapping})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 4 || stats.SuccessRecords != 2 || stats.ErrorRecords != 2 {
+		t.Errorf("Expected 4 records, 2 success and 2 errors, got %+v", stats)
+	}
+	if len(stats.Errors) != 2 || !strings.Contains(stats.Errors[1], "expected an object") {
+		t.Errorf("Expected error for non-object element, got %v", stats.Errors)
+	}
+
+	if tx, _ := db.GetTransaction(3); tx.UserID != 1002 || tx.Amount != -5 || tx.SourceLine != 4 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+
+	// Validar un arreglo da los mismos contadores sin guardar nada
+	validation, err := service.ValidateFile(strings.NewReader(content), MigrationOptions{Format: FormatJSON, Mapping: mapping})
+	if err != nil || validation.Stats.TotalRecords != 4 || validation.Stats.ErrorRecords != 2 {
+		t.Errorf("Unexpected validation %+v, err %v", validation, err)
+	}
+
+	for name, invalid := range map[string]string{
+		"empty":     "",
+		"not array": `{"id": 1}`,
+		"truncated": `[{"id": 1, "user_id": 1001, "amount": 1, "datetime": "2024-01-15"}, {"id": `,
+	} {
+		if _, err := service.ProcessFile(strings.NewReader(invalid), MigrationOptions{Format: FormatJSON}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestMigrationService_ProcessFileJSONRecordTooLarge(t *testing.T) {
+	service := NewMigrationService(NewMockDatabase())
+	service.GetReportService().SetForceMockMode(true)
+
+	// El elemento grande se cuenta como error y la lectura sigue con el siguiente, igual que en JSON Lines
+	large := `{"id": 2, "user_id": 1001, "amount": 1, "datetime": "2024-01-15", "note": "` + strings.Repeat("x", maxJSONRecordSize) + `"}`
+	valid := `{"id": %d, "user_id": 1001, "amount": 1, "datetime": "2024-01-15", "note": "a \\\"]}, b"}`
+	contents := map[FileFormat]string{
+		FormatJSON:   "[" + fmt.Sprintf(valid, 1) + ",\n" + large + ",\n" + fmt.Sprintf(valid, 3) + "]",
+		FormatNDJSON: fmt.Sprintf(valid, 1) + "\n" + large + "\n" + fmt.Sprintf(valid, 3),
+	}
+	for format, content := range contents {
+		stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: format})
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", format, err)
+		}
+		if stats.TotalRecords != 3 || stats.SuccessRecords != 2 || stats.ErrorRecords != 1 {
+			t.Errorf("%s: expected 3 records, 2 success and 1 error, got %+v", format, stats)
+		}
+		if len(stats.Errors) != 1 || !strings.Contains(stats.Errors[0], "line 2 exceeds") {
+			t.Errorf("%s: expected size error at line 2, got %v", format, stats.Errors)
+		}
+	}
+}
+
+func TestMigrationService_ProcessFileHashContent(t *testing.T) {
+	service := NewMigrationService(NewMockDatabase())
+	service.GetReportService().SetForceMockMode(true)
+
+	// El hash cubre el archivo completo aunque la lectura termine en el cierre del arreglo
+	content := `[{"id": 1, "user_id": 1001, "amount": 1, "datetime": "2024-01-15"}]` + "\n\n  \n"
+	sum := sha256.Sum256([]byte(content))
+
+	stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatJSON, HashContent: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.ContentSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected SHA-256 %x, got %q", sum, stats.ContentSHA256)
+	}
+
+	// Un SHA-256 ya calculado se respeta
+	stats, _ = service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatJSON, HashContent: true, ContentSHA256: "given"})
+	if stats.ContentSHA256 != "given" {
+		t.Errorf("Expected the given SHA-256 to be kept, got %q", stats.ContentSHA256)
+	}
+}
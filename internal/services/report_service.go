@@ -100,9 +100,14 @@ func (rs *ReportService) sendWebhookReport(report *models.MigrationReport) {
 // sendLogReport envía el reporte por log
 func (rs *ReportService) sendLogReport(report *models.MigrationReport) {
 	log.Printf("=== MIGRATION REPORT ===")
+	log.Printf("Migration ID: %s", report.MigrationID)
 	log.Printf("File: %s (%d bytes)", report.Filename, report.FileSize)
 	log.Printf("Records: %d total, %d success, %d errors",
 		report.TotalRecords, report.SuccessRecords, report.ErrorRecords)
+	if report.ConflictRecords > 0 {
+		log.Printf("Conflicts: %d (policy: %s, %d skipped)",
+			report.ConflictRecords, report.ConflictPolicy, report.SkippedRecords)
+	}
 	log.Printf("Users affected: %d", report.UsersAffected)
 	log.Printf("Amount range: %.2f to %.2f (avg: %.2f)",
 		report.SmallestAmount, report.LargestAmount, report.AverageAmount)
@@ -113,12 +118,116 @@ func (rs *ReportService) sendLogReport(report *models.MigrationReport) {
 	log.Printf("=== END REPORT ===")
 }
 
+// SendRollbackReport envía el reporte de la reversión de una migración por los canales configurados
+func (rs *ReportService) SendRollbackReport(report *models.RollbackReport) {
+	for _, channel := range rs.config.Channels {
+		switch channel {
+		case models.EmailChannel:
+			go rs.sendRollbackEmail(report)
+		case models.WebhookChannel:
+			go rs.sendRollbackWebhook(report)
+		case models.LogChannel:
+			go rs.sendRollbackLog(report)
+		}
+	}
+}
+
+// sendRollbackEmail envía el reporte de la reversión por email (mock si no hay SMTP configurado)
+func (rs *ReportService) sendRollbackEmail(report *models.RollbackReport) {
+	subject := fmt.Sprintf("%s - Rollback %s", rs.config.Email.Subject, report.MigrationID)
+	body := rs.generateRollbackEmailBody(report)
+
+	if rs.forceMockMode || rs.config.Email.SMTPHost == "" {
+		log.Printf("=== MOCK EMAIL REPORT ===")
+		log.Printf("To: %v", rs.config.Email.ToEmails)
+		log.Printf("Subject: %s", subject)
+		log.Printf("Body:\n%s", body)
+		log.Printf("=== END MOCK EMAIL ===")
+		return
+	}
+
+	auth := smtp.PlainAuth("", rs.config.Email.Username, rs.config.Email.Password, rs.config.Email.SMTPHost)
+	msg := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s",
+		rs.config.Email.ToEmails[0], subject, body))
+
+	addr := fmt.Sprintf("%s:%d", rs.config.Email.SMTPHost, rs.config.Email.SMTPPort)
+	if err := smtp.SendMail(addr, auth, rs.config.Email.FromEmail, rs.config.Email.ToEmails, msg); err != nil {
+		log.Printf("Error sending rollback email report: %v", err)
+	} else {
+		log.Printf("Rollback report sent via email successfully")
+	}
+}
+
+// sendRollbackWebhook envía el reporte de la reversión por webhook
+func (rs *ReportService) sendRollbackWebhook(report *models.RollbackReport) {
+	// TODO: Implementar webhook
+	log.Printf("Webhook rollback report sent: %s", report.MigrationID)
+}
+
+// sendRollbackLog envía el reporte de la reversión por log
+func (rs *ReportService) sendRollbackLog(report *models.RollbackReport) {
+	log.Printf("=== ROLLBACK REPORT ===")
+	log.Printf("Migration ID: %s", report.MigrationID)
+	log.Printf("Records: %d restored, %d deleted, %d skipped",
+		report.RestoredRecords, report.DeletedRecords, report.SkippedRecords)
+	log.Printf("Users affected: %d", report.UsersAffected)
+	if len(report.Skipped) > 0 {
+		log.Printf("Skipped: %v", report.Skipped)
+	}
+	log.Printf("=== END REPORT ===")
+}
+
+// generateRollbackEmailBody genera el cuerpo del email de la reversión
+func (rs *ReportService) generateRollbackEmailBody(report *models.RollbackReport) string {
+	var body bytes.Buffer
+
+	body.WriteString("=== ROLLBACK REPORT ===\n\n")
+	body.WriteString(fmt.Sprintf("Migration ID: %s\n", report.MigrationID))
+	body.WriteString(fmt.Sprintf("Timestamp: %s\n\n", report.Timestamp.Format("2006-01-02 15:04:05")))
+
+	body.WriteString("=== STATISTICS ===\n")
+	body.WriteString(fmt.Sprintf("Restored records: %d\n", report.RestoredRecords))
+	body.WriteString(fmt.Sprintf("Deleted records: %d\n", report.DeletedRecords))
+	body.WriteString(fmt.Sprintf("Skipped records: %d\n", report.SkippedRecords))
+	body.WriteString(fmt.Sprintf("Users affected: %d\n\n", report.UsersAffected))
+
+	if len(report.Changes) > 0 {
+		body.WriteString("=== CHANGES ===\n")
+		for i, change := range report.Changes {
+			if change.Restored != nil {
+				body.WriteString(fmt.Sprintf("%d. id %d: restored amount %.2f -> %.2f\n",
+					i+1, change.TransactionID, change.Reverted.Amount, change.Restored.Amount))
+			} else {
+				body.WriteString(fmt.Sprintf("%d. id %d: deleted (amount %.2f)\n",
+					i+1, change.TransactionID, change.Reverted.Amount))
+			}
+		}
+		body.WriteString("\n")
+	}
+
+	if len(report.Skipped) > 0 {
+		body.WriteString("=== SKIPPED ===\n")
+		for i, skipped := range report.Skipped {
+			body.WriteString(fmt.Sprintf("%d. %s\n", i+1, skipped))
+		}
+		body.WriteString("\n")
+	}
+
+	body.WriteString("=== END REPORT ===")
+
+	return body.String()
+}
+
 // generateEmailBody genera el cuerpo del email
 func (rs *ReportService) generateEmailBody(report *models.MigrationReport) string {
 	var body bytes.Buffer
 
 	body.WriteString("=== MIGRATION REPORT ===\n\n")
+	body.WriteString(fmt.Sprintf("Migration ID: %s\n", report.MigrationID))
 	body.WriteString(fmt.Sprintf("File: %s (%d bytes)\n", report.Filename, report.FileSize))
+	if report.SHA256 != "" {
+		body.WriteString(fmt.Sprintf("SHA-256: %s\n", report.SHA256))
+	}
 	body.WriteString(fmt.Sprintf("Timestamp: %s\n", report.Timestamp.Format("2006-01-02 15:04:05")))
 	body.WriteString(fmt.Sprintf("Processing time: %v\n\n", report.ProcessingTime))
 
@@ -126,6 +235,8 @@ func (rs *ReportService) generateEmailBody(report *models.MigrationReport) strin
 	body.WriteString(fmt.Sprintf("Total records: %d\n", report.TotalRecords))
 	body.WriteString(fmt.Sprintf("Success records: %d\n", report.SuccessRecords))
 	body.WriteString(fmt.Sprintf("Error records: %d\n", report.ErrorRecords))
+	body.WriteString(fmt.Sprintf("Skipped records: %d\n", report.SkippedRecords))
+	body.WriteString(fmt.Sprintf("Conflict records: %d (policy: %s)\n", report.ConflictRecords, report.ConflictPolicy))
 	body.WriteString(fmt.Sprintf("Success rate: %.2f%%\n\n",
 		float64(report.SuccessRecords)/float64(report.TotalRecords)*100))
 
@@ -147,6 +258,14 @@ func (rs *ReportService) generateEmailBody(report *models.MigrationReport) strin
 		body.WriteString("\n")
 	}
 
+	if len(report.Conflicts) > 0 {
+		body.WriteString("=== CONFLICTS ===\n")
+		for i, conflict := range report.Conflicts {
+			body.WriteString(fmt.Sprintf("%d. %s\n", i+1, conflict))
+		}
+		body.WriteString("\n")
+	}
+
 	if report.ErrorFileCSV != "" {
 		body.WriteString("=== ERROR FILE ===\n")
 		body.WriteString(fmt.Sprintf("Error records exported to: %s\n", report.ErrorFileCSV))
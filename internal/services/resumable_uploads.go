@@ -0,0 +1,569 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errores de las subidas reanudables
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadLocked         = errors.New("upload is being written by another request")
+	ErrUploadIncomplete     = errors.New("upload is not complete")
+	ErrUploadTooLarge       = errors.New("upload exceeds the maximum size")
+	ErrUploadInterrupted    = errors.New("upload part was interrupted")
+	ErrUploadPartTooLarge   = errors.New("upload part exceeds the upload length")
+)
+
+// ResumableUpload estado de una subida reanudable. El archivo se recibe por partes y al completarse
+// se migra; Offset es la cantidad de bytes ya guardados en disco.
+type ResumableUpload struct {
+	ID          string            `json:"id"`
+	Length      int64             `json:"length"`
+	Offset      int64             `json:"offset"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // Nombre del archivo y opciones de la migración
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"` // Última parte recibida; la subida vence ttl después
+	MigrationID string            `json:"migration_id,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"` // Checksum del archivo, calculado al recibirlo completo
+}
+
+// Complete indica si ya se recibió el archivo completo
+func (u ResumableUpload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// resumableUpload subida con el lock que serializa sus escrituras
+type resumableUpload struct {
+	ResumableUpload
+	hash    hash.Hash // SHA-256 de los Offset bytes recibidos
+	mutex   sync.Mutex
+	removed bool // Cancelada o vencida mientras otra request esperaba el lock
+
+	// Copia del estado al soltar el lock, para consultarlo sin esperar a que termine una parte en curso (nil = eliminada)
+	published atomic.Pointer[ResumableUpload]
+}
+
+// publish guarda una copia del estado actual para las lecturas sin lock. Requiere el lock de la subida.
+func (u *resumableUpload) publish() {
+	state := u.ResumableUpload
+	u.published.Store(&state)
+}
+
+// uploadInfo estado guardado en <id>.json: la subida y el SHA-256 parcial de los bytes recibidos,
+// para no volver a leer el archivo al completarlo
+type uploadInfo struct {
+	ResumableUpload
+	HashState []byte `json:"hash_state,omitempty"`
+}
+
+// hashingWriter escribe en file y agrega al hash solo los bytes que se escribieron
+type hashingWriter struct {
+	file io.Writer
+	hash hash.Hash
+}
+
+func (w hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+// partReader lee el body de una parte y guarda su error, para distinguirlo de uno al escribir el archivo
+type partReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// ResumableUploadStore guarda en disco las subidas reanudables (protocolo tus): por cada subida un archivo
+// de datos (<id>.bin) y su estado (<id>.json), por lo que sobreviven a un reinicio. Las subidas sin
+// actividad durante ttl se eliminan y las de más de maxSize bytes se rechazan.
+type ResumableUploadStore struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+
+	mutex   sync.Mutex
+	uploads map[string]*resumableUpload
+	stop    chan struct{}
+
+	now func() time.Time
+}
+
+// NewResumableUploadStore crea el almacén en dir y carga las subidas que quedaron en curso
+func NewResumableUploadStore(dir string, ttl time.Duration, maxSize int64) (*ResumableUploadStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("upload directory is required")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid upload expiry: %v", ttl)
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum upload size: %d", maxSize)
+	}
+
+	store := &ResumableUploadStore{
+		dir:     dir,
+		ttl:     ttl,
+		maxSize: maxSize,
+		uploads: make(map[string]*resumableUpload),
+		now:     time.Now,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// MaxSize retorna el tamaño máximo de una subida en bytes
+func (s *ResumableUploadStore) MaxSize() int64 {
+	return s.maxSize
+}
+
+// ExpiresAt retorna cuándo vence la subida si no recibe más partes
+func (s *ResumableUploadStore) ExpiresAt(upload ResumableUpload) time.Time {
+	return upload.UpdatedAt.Add(s.ttl)
+}
+
+// Create registra una subida de length bytes con sus metadatos; ErrUploadTooLarge si excede MaxSize
+func (s *ResumableUploadStore) Create(length int64, metadata map[string]string) (ResumableUpload, error) {
+	if length <= 0 {
+		return ResumableUpload{}, fmt.Errorf("invalid upload length: %d", length)
+	}
+	if length > s.maxSize {
+		return ResumableUpload{}, fmt.Errorf("%w: %d bytes (maximum %d)", ErrUploadTooLarge, length, s.maxSize)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return ResumableUpload{}, fmt.Errorf("error creating upload directory: %v", err)
+	}
+
+	random := make([]byte, 16)
+	rand.Read(random)
+	now := s.now().UTC()
+	upload := &resumableUpload{ResumableUpload: ResumableUpload{
+		ID:        hex.EncodeToString(random),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, hash: sha256.New()}
+	upload.publish()
+
+	file, err := os.OpenFile(s.dataPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return ResumableUpload{}, fmt.Errorf("error creating upload file: %v", err)
+	}
+	file.Close()
+	if err := s.saveInfo(upload); err != nil {
+		os.Remove(s.dataPath(upload.ID))
+		return ResumableUpload{}, err
+	}
+
+	s.mutex.Lock()
+	s.uploads[upload.ID] = upload
+	s.mutex.Unlock()
+
+	return upload.ResumableUpload, nil
+}
+
+// Get retorna el estado de la subida id; ErrUploadNotFound si no existe o venció.
+// Si otra request está escribiendo una parte no la espera: retorna el estado de antes de esa parte.
+func (s *ResumableUploadStore) Get(id string) (ResumableUpload, error) {
+	upload, err := s.lookup(id)
+	if err != nil {
+		return ResumableUpload{}, err
+	}
+
+	if !upload.mutex.TryLock() {
+		state := upload.published.Load()
+		if state == nil {
+			return ResumableUpload{}, ErrUploadNotFound
+		}
+		return *state, nil
+	}
+	defer upload.mutex.Unlock()
+	if s.gone(upload) {
+		return ResumableUpload{}, ErrUploadNotFound
+	}
+	return upload.ResumableUpload, nil
+}
+
+// Write agrega al archivo los bytes de reader a partir de offset, que debe ser el Offset actual
+// (ErrUploadOffsetMismatch si no). size es el tamaño de la parte si se conoce (-1 si no): si excede lo
+// que falta recibir se retorna ErrUploadPartTooLarge sin escribir nada; sin size, lo que sobra se ignora. Si la conexión se corta, los bytes ya recibidos se conservan y se
+// retorna ErrUploadInterrupted con el nuevo Offset.
+// El SHA-256 se calcula a medida que se escribe y queda en SHA256 al completarse la subida.
+// Retorna ErrUploadLocked si otra request está escribiendo la misma subida.
+func (s *ResumableUploadStore) Write(id string, offset, size int64, reader io.Reader) (ResumableUpload, error) {
+	upload, err := s.lookup(id)
+	if err != nil {
+		return ResumableUpload{}, err
+	}
+	if !upload.mutex.TryLock() {
+		return ResumableUpload{}, ErrUploadLocked
+	}
+	defer upload.mutex.Unlock()
+	if s.gone(upload) {
+		return ResumableUpload{}, ErrUploadNotFound
+	}
+	defer upload.publish()
+
+	if offset != upload.Offset {
+		return upload.ResumableUpload, fmt.Errorf("%w: expected %d, got %d", ErrUploadOffsetMismatch, upload.Offset, offset)
+	}
+	if size > upload.Length-upload.Offset {
+		return upload.ResumableUpload, fmt.Errorf("%w: %d bytes, %d remaining", ErrUploadPartTooLarge, size, upload.Length-upload.Offset)
+	}
+	if upload.Complete() {
+		return upload.ResumableUpload, nil
+	}
+
+	file, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return upload.ResumableUpload, fmt.Errorf("error opening upload file: %v", err)
+	}
+	part := &partReader{reader: io.LimitReader(reader, upload.Length-upload.Offset)}
+	written, copyErr := io.Copy(hashingWriter{file: file, hash: upload.hash}, part)
+	if err := file.Close(); copyErr == nil {
+		copyErr = err
+	}
+
+	upload.Offset += written
+	upload.UpdatedAt = s.now().UTC()
+	if upload.Complete() {
+		upload.SHA256 = hex.EncodeToString(upload.hash.Sum(nil))
+	}
+	if err := s.saveInfo(upload); err != nil {
+		return upload.ResumableUpload, err
+	}
+	if part.err != nil {
+		return upload.ResumableUpload, fmt.Errorf("%w: %v", ErrUploadInterrupted, part.err)
+	}
+	if copyErr != nil {
+		return upload.ResumableUpload, fmt.Errorf("error writing upload file: %v", copyErr)
+	}
+	return upload.ResumableUpload, nil
+}
+
+// Finish entrega el archivo completo a migrate, que retorna el ID de la migración y se hace cargo del
+// archivo en path (lo debe borrar). Si migrate falla la subida queda completa para reintentar.
+// Si la subida ya se migró retorna su estado sin volver a llamar a migrate.
+func (s *ResumableUploadStore) Finish(id string, migrate func(upload ResumableUpload, path string) (string, error)) (ResumableUpload, error) {
+	upload, err := s.lookup(id)
+	if err != nil {
+		return ResumableUpload{}, err
+	}
+	if !upload.mutex.TryLock() {
+		return ResumableUpload{}, ErrUploadLocked
+	}
+	defer upload.mutex.Unlock()
+	if s.gone(upload) {
+		return ResumableUpload{}, ErrUploadNotFound
+	}
+	defer upload.publish()
+
+	if upload.MigrationID != "" {
+		return upload.ResumableUpload, nil
+	}
+	if !upload.Complete() {
+		return upload.ResumableUpload, ErrUploadIncomplete
+	}
+
+	// El archivo se renombra antes de entregarlo: el vencimiento de la subida ya no lo borra
+	path := s.migratingPath(id)
+	if err := os.Rename(s.dataPath(id), path); err != nil {
+		return upload.ResumableUpload, fmt.Errorf("error preparing upload file: %v", err)
+	}
+	migrationID, err := migrate(upload.ResumableUpload, path)
+	if err != nil {
+		os.Rename(path, s.dataPath(id))
+		return upload.ResumableUpload, err
+	}
+
+	upload.MigrationID = migrationID
+	upload.UpdatedAt = s.now().UTC()
+	if err := s.saveInfo(upload); err != nil {
+		log.Printf("Error saving upload %s: %v", id, err)
+	}
+	return upload.ResumableUpload, nil
+}
+
+// Delete cancela la subida y borra sus archivos
+func (s *ResumableUploadStore) Delete(id string) error {
+	upload, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+	if !upload.mutex.TryLock() {
+		return ErrUploadLocked
+	}
+	defer upload.mutex.Unlock()
+	if s.gone(upload) {
+		return ErrUploadNotFound
+	}
+
+	s.remove(upload)
+	return nil
+}
+
+// Expire elimina las subidas sin actividad durante ttl y retorna cuántas eliminó.
+// Las que están recibiendo una parte no se tocan.
+func (s *ResumableUploadStore) Expire() int {
+	s.mutex.Lock()
+	uploads := make([]*resumableUpload, 0, len(s.uploads))
+	for _, upload := range s.uploads {
+		uploads = append(uploads, upload)
+	}
+	s.mutex.Unlock()
+
+	count := 0
+	for _, upload := range uploads {
+		if !upload.mutex.TryLock() {
+			continue
+		}
+		if !upload.removed && s.expired(upload) {
+			s.remove(upload)
+			count++
+		}
+		upload.mutex.Unlock()
+	}
+	return count
+}
+
+// StartExpiry ejecuta Expire cada interval hasta que se llame a Stop
+func (s *ResumableUploadStore) StartExpiry(interval time.Duration) {
+	s.mutex.Lock()
+	if s.stop != nil {
+		s.mutex.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+	s.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if count := s.Expire(); count > 0 {
+					log.Printf("Expired %d abandoned uploads", count)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop detiene el vencimiento periódico iniciado con StartExpiry
+func (s *ResumableUploadStore) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// lookup retorna la subida id; el vencimiento se comprueba con el lock de la subida tomado
+func (s *ResumableUploadStore) lookup(id string) (*resumableUpload, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	upload, exists := s.uploads[id]
+	if !exists {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// gone indica si la subida ya no existe: eliminada mientras se esperaba su lock o vencida.
+// Una subida vencida se elimina en ese momento. Requiere el lock de la subida.
+func (s *ResumableUploadStore) gone(upload *resumableUpload) bool {
+	if !upload.removed && s.expired(upload) {
+		s.remove(upload)
+	}
+	return upload.removed
+}
+
+// expired indica si la subida venció. Requiere el lock de la subida.
+func (s *ResumableUploadStore) expired(upload *resumableUpload) bool {
+	return !s.now().Before(s.ExpiresAt(upload.ResumableUpload))
+}
+
+// remove quita la subida del índice y borra sus archivos. Requiere el lock de la subida.
+func (s *ResumableUploadStore) remove(upload *resumableUpload) {
+	upload.removed = true
+	upload.published.Store(nil)
+
+	s.mutex.Lock()
+	delete(s.uploads, upload.ID)
+	s.mutex.Unlock()
+
+	os.Remove(s.dataPath(upload.ID))
+	os.Remove(s.infoPath(upload.ID))
+}
+
+func (s *ResumableUploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *ResumableUploadStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *ResumableUploadStore) migratingPath(id string) string {
+	return filepath.Join(s.dir, id+".migrating")
+}
+
+// saveInfo guarda el estado de la subida reemplazando el anterior de forma atómica
+func (s *ResumableUploadStore) saveInfo(upload *resumableUpload) error {
+	info := uploadInfo{ResumableUpload: upload.ResumableUpload}
+	if upload.MigrationID == "" {
+		state, err := upload.hash.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("error encoding upload checksum: %v", err)
+		}
+		info.HashState = state
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("error encoding upload: %v", err)
+	}
+	temp := s.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return fmt.Errorf("error saving upload: %v", err)
+	}
+	if err := os.Rename(temp, s.infoPath(upload.ID)); err != nil {
+		return fmt.Errorf("error saving upload: %v", err)
+	}
+	return nil
+}
+
+// load lee las subidas guardadas en dir; si el directorio no existe aún no hay ninguna.
+// El offset de una subida en curso es el tamaño de su archivo: los bytes escritos antes de
+// una caída cuentan aunque el estado no se haya llegado a guardar.
+func (s *ResumableUploadStore) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload directory: %v", err)
+	}
+
+	infos := make(map[string]uploadInfo)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read upload: %v", err)
+		}
+		var info uploadInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			log.Printf("Ignoring invalid upload %s: %v", entry.Name(), err)
+			continue
+		}
+		infos[info.ID] = info
+	}
+
+	// Un archivo .migrating quedó de una caída durante Finish: la cola de migraciones no sobrevive
+	// al reinicio, así que si la subida no llegó a migrarse vuelve a quedar completa para reintentar
+	// y si no, se borra
+	for _, entry := range entries {
+		id, migrating := strings.CutSuffix(entry.Name(), ".migrating")
+		if !migrating {
+			continue
+		}
+		if info, exists := infos[id]; exists && info.MigrationID == "" {
+			if _, err := os.Stat(s.dataPath(id)); os.IsNotExist(err) {
+				if err := os.Rename(s.migratingPath(id), s.dataPath(id)); err != nil {
+					return fmt.Errorf("failed to restore upload %s: %v", id, err)
+				}
+				continue
+			}
+		}
+		if err := os.Remove(s.migratingPath(id)); err != nil {
+			return fmt.Errorf("failed to remove upload file %s: %v", entry.Name(), err)
+		}
+		log.Printf("Removed leftover upload file %s", entry.Name())
+	}
+
+	for id, info := range infos {
+		upload := &resumableUpload{ResumableUpload: info.ResumableUpload}
+		if upload.MigrationID == "" {
+			fileInfo, err := os.Stat(s.dataPath(id))
+			if err != nil {
+				log.Printf("Ignoring upload %s without data: %v", id, err)
+				continue
+			}
+			if err := s.resumeHash(upload, info.HashState, min(fileInfo.Size(), upload.Length)); err != nil {
+				log.Printf("Ignoring upload %s: %v", id, err)
+				continue
+			}
+		}
+		upload.publish()
+		s.uploads[id] = upload
+	}
+	return nil
+}
+
+// resumeHash restaura el SHA-256 parcial guardado en state y lo completa con los bytes del archivo
+// hasta offset. Si el estado no corresponde al archivo (falta o el archivo es más corto) lo recalcula.
+func (s *ResumableUploadStore) resumeHash(upload *resumableUpload, state []byte, offset int64) error {
+	hashed := upload.Offset
+	upload.hash = sha256.New()
+	if state == nil || hashed > offset || upload.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state) != nil {
+		upload.hash.Reset()
+		hashed = 0
+	}
+
+	if hashed < offset {
+		file, err := os.Open(s.dataPath(upload.ID))
+		if err != nil {
+			return fmt.Errorf("error opening upload file: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.Seek(hashed, io.SeekStart); err != nil {
+			return fmt.Errorf("error reading upload file: %v", err)
+		}
+		if _, err := io.CopyN(upload.hash, file, offset-hashed); err != nil {
+			return fmt.Errorf("error reading upload file: %v", err)
+		}
+	}
+
+	upload.Offset = offset
+	if upload.Complete() {
+		upload.SHA256 = hex.EncodeToString(upload.hash.Sum(nil))
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestResumableUploadStore_Write(t *testing.T) {
+	store, err := NewResumableUploadStore(t.TempDir(), time.Hour, 1<<20)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	content := "id,user_id,amount,datetime\n1,1001,100,2024-01-15\n"
+	upload, err := store.Create(int64(len(content)), map[string]string{"filename": "big.csv"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Primera parte; una parte con otro offset se rechaza
+	upload, err = store.Write(upload.ID, 0, -1, strings.NewReader(content[:10]))
+	if err != nil || upload.Offset != 10 || upload.Complete() {
+		t.Fatalf("Unexpected upload %+v, err %v", upload, err)
+	}
+	if _, err := store.Write(upload.ID, 5, -1, strings.NewReader(content[5:])); !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Errorf("Expected ErrUploadOffsetMismatch, got %v", err)
+	}
+
+	// Una parte de tamaño conocido que excede Upload-Length se rechaza sin escribirla, pero con un
+	// offset desactualizado prevalece el error de offset para que el cliente retome desde el correcto
+	tooLarge := int64(len(content))
+	if _, err := store.Write(upload.ID, 0, tooLarge, strings.NewReader(content)); !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Errorf("Expected ErrUploadOffsetMismatch for a stale offset, got %v", err)
+	}
+	if got, err := store.Write(upload.ID, 10, tooLarge, strings.NewReader(content)); !errors.Is(err, ErrUploadPartTooLarge) || got.Offset != 10 {
+		t.Errorf("Expected ErrUploadPartTooLarge at offset 10, got %+v, err %v", got, err)
+	}
+
+	// Los bytes que exceden Length se ignoran
+	upload, err = store.Write(upload.ID, 10, -1, strings.NewReader(content[10:]+"extra"))
+	if err != nil || !upload.Complete() {
+		t.Fatalf("Expected complete upload, got %+v, err %v", upload, err)
+	}
+	if upload.SHA256 != sha256Hex(content) {
+		t.Errorf("Expected checksum %s, got %s", sha256Hex(content), upload.SHA256)
+	}
+
+	var received string
+	upload, err = store.Finish(upload.ID, func(upload ResumableUpload, path string) (string, error) {
+		data, _ := os.ReadFile(path)
+		received = string(data)
+		os.Remove(path)
+		return "mig-1", nil
+	})
+	if err != nil || upload.MigrationID != "mig-1" || received != content {
+		t.Errorf("Unexpected finish %+v, received %q, err %v", upload, received, err)
+	}
+
+	// Una subida ya migrada no se entrega de nuevo
+	if _, err := store.Finish(upload.ID, func(ResumableUpload, string) (string, error) {
+		t.Error("Expected migrate not to be called again")
+		return "", nil
+	}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	if _, err := NewResumableUploadStore(t.TempDir(), 0, 1<<20); err == nil {
+		t.Error("Expected error for zero expiry")
+	}
+	if _, err := NewResumableUploadStore(t.TempDir(), time.Hour, 0); err == nil {
+		t.Error("Expected error for zero maximum size")
+	}
+}
+
+func TestResumableUploadStore_MaxSize(t *testing.T) {
+	store, _ := NewResumableUploadStore(t.TempDir(), time.Hour, 10)
+
+	if _, err := store.Create(11, nil); !errors.Is(err, ErrUploadTooLarge) {
+		t.Errorf("Expected ErrUploadTooLarge, got %v", err)
+	}
+	if _, err := store.Create(10, nil); err != nil {
+		t.Errorf("Expected an upload of the maximum size to be accepted, got %v", err)
+	}
+}
+
+func TestResumableUploadStore_FinishRetry(t *testing.T) {
+	store, _ := NewResumableUploadStore(t.TempDir(), time.Hour, 1<<20)
+	upload, _ := store.Create(3, nil)
+
+	if _, err := store.Finish(upload.ID, nil); err != ErrUploadIncomplete {
+		t.Errorf("Expected ErrUploadIncomplete, got %v", err)
+	}
+	store.Write(upload.ID, 0, -1, strings.NewReader("abc"))
+
+	// Si la entrega falla el archivo queda para reintentar
+	queueFull := errors.New("queue full")
+	if _, err := store.Finish(upload.ID, func(ResumableUpload, string) (string, error) { return "", queueFull }); err != queueFull {
+		t.Errorf("Expected queue error, got %v", err)
+	}
+	upload, err := store.Finish(upload.ID, func(upload ResumableUpload, path string) (string, error) {
+		if data, _ := os.ReadFile(path); string(data) != "abc" {
+			t.Errorf("Expected the file on retry, got %q", data)
+		}
+		return "mig-2", nil
+	})
+	if err != nil || upload.MigrationID != "mig-2" {
+		t.Errorf("Unexpected upload %+v, err %v", upload, err)
+	}
+}
+
+func TestResumableUploadStore_GetDuringWrite(t *testing.T) {
+	store, _ := NewResumableUploadStore(t.TempDir(), time.Hour, 1<<20)
+	upload, _ := store.Create(10, nil)
+	store.Write(upload.ID, 0, -1, strings.NewReader("abc"))
+
+	// Una parte que no termina de llegar mantiene el lock de la subida
+	body, bodyWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		store.Write(upload.ID, 3, -1, body)
+		close(done)
+	}()
+	bodyWriter.Write([]byte("de"))
+
+	got, err := store.Get(upload.ID)
+	if err != nil || got.Offset != 3 {
+		t.Errorf("Expected the offset before the part in progress without waiting, got %+v, err %v", got, err)
+	}
+	if _, err := store.Write(upload.ID, 3, -1, strings.NewReader("de")); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Expected ErrUploadLocked, got %v", err)
+	}
+
+	bodyWriter.Close()
+	<-done
+	if got, err := store.Get(upload.ID); err != nil || got.Offset != 5 {
+		t.Errorf("Expected offset 5 after the part, got %+v, err %v", got, err)
+	}
+}
+
+func TestResumableUploadStore_WriteInterrupted(t *testing.T) {
+	store, _ := NewResumableUploadStore(t.TempDir(), time.Hour, 1<<20)
+	upload, _ := store.Create(10, nil)
+
+	// Los bytes leídos antes del corte se conservan y el error indica desde dónde retomar
+	body := io.MultiReader(strings.NewReader("abcd"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	got, err := store.Write(upload.ID, 0, -1, body)
+	if !errors.Is(err, ErrUploadInterrupted) || got.Offset != 4 {
+		t.Errorf("Expected ErrUploadInterrupted at offset 4, got %+v, err %v", got, err)
+	}
+	if got, err := store.Write(upload.ID, 4, -1, strings.NewReader("efghij")); err != nil || got.SHA256 != sha256Hex("abcdefghij") {
+		t.Errorf("Expected the upload resumed from offset 4, got %+v, err %v", got, err)
+	}
+}
+
+func TestResumableUploadStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewResumableUploadStore(dir, time.Hour, 1<<20)
+	upload, _ := store.Create(10, map[string]string{"filename": "big.csv", "on_conflict": "skip"})
+	store.Write(upload.ID, 0, -1, strings.NewReader("abcd"))
+
+	// Bytes escritos antes de una caída sin llegar a guardar el estado
+	file, _ := os.OpenFile(store.dataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString("ef")
+	file.Close()
+
+	reloaded, err := NewResumableUploadStore(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got, err := reloaded.Get(upload.ID)
+	if err != nil || got.Offset != 6 || got.Length != 10 || got.Metadata["on_conflict"] != "skip" {
+		t.Errorf("Unexpected reloaded upload %+v, err %v", got, err)
+	}
+
+	// El checksum parcial se retoma con los bytes que no llegaron a guardarse en el estado
+	got, err = reloaded.Write(upload.ID, 6, -1, strings.NewReader("ghij"))
+	if err != nil || got.SHA256 != sha256Hex("abcdefghij") {
+		t.Errorf("Expected checksum of the whole file, got %+v, err %v", got, err)
+	}
+
+	if _, err := NewResumableUploadStore(dir+"/missing", time.Hour, 1<<20); err != nil {
+		t.Errorf("Expected a missing directory to be empty, got %v", err)
+	}
+}
+
+func TestResumableUploadStore_Expire(t *testing.T) {
+	store, _ := NewResumableUploadStore(t.TempDir(), time.Hour, 1<<20)
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	abandoned, _ := store.Create(10, nil)
+	active, _ := store.Create(10, nil)
+
+	// Cada parte recibida extiende el plazo
+	now = now.Add(30 * time.Minute)
+	store.Write(active.ID, 0, -1, strings.NewReader("abc"))
+
+	now = now.Add(30 * time.Minute)
+	if count := store.Expire(); count != 1 {
+		t.Errorf("Expected 1 expired upload, got %d", count)
+	}
+	if _, err := store.Get(abandoned.ID); err != ErrUploadNotFound {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+	if _, err := os.Stat(store.dataPath(abandoned.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected expired upload file to be removed, got %v", err)
+	}
+	if _, err := store.Get(active.ID); err != nil {
+		t.Errorf("Expected active upload to be kept, got %v", err)
+	}
+
+	// Vencida entre dos pasadas, la subida tampoco se puede retomar
+	now = now.Add(30 * time.Minute)
+	if _, err := store.Write(active.ID, 3, -1, strings.NewReader("def")); err != ErrUploadNotFound {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+
+	if err := store.Delete(active.ID); err != ErrUploadNotFound {
+		t.Errorf("Expected ErrUploadNotFound, got %v", err)
+	}
+}
+
+func TestResumableUploadStore_ReloadMigrating(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewResumableUploadStore(dir, time.Hour, 1<<20)
+
+	// Caída durante Finish antes de encolar la migración: el archivo quedó renombrado
+	pending, _ := store.Create(3, nil)
+	store.Write(pending.ID, 0, -1, strings.NewReader("abc"))
+	os.Rename(store.dataPath(pending.ID), store.migratingPath(pending.ID))
+
+	// Caída después de encolarla: la migración se perdió con la cola pero la subida ya figura migrada
+	migrated, _ := store.Create(3, nil)
+	store.Write(migrated.ID, 0, -1, strings.NewReader("def"))
+	store.Finish(migrated.ID, func(ResumableUpload, string) (string, error) { return "mig-1", nil })
+
+	// Archivo sin estado
+	os.WriteFile(store.migratingPath("orphan"), []byte("ghi"), 0644)
+
+	reloaded, err := NewResumableUploadStore(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, id := range []string{pending.ID, migrated.ID, "orphan"} {
+		if _, err := os.Stat(reloaded.migratingPath(id)); !os.IsNotExist(err) {
+			t.Errorf("Expected no leftover file for %s, got %v", id, err)
+		}
+	}
+
+	upload, err := reloaded.Finish(pending.ID, func(upload ResumableUpload, path string) (string, error) {
+		if data, _ := os.ReadFile(path); string(data) != "abc" {
+			t.Errorf("Expected the restored file, got %q", data)
+		}
+		if upload.SHA256 != sha256Hex("abc") {
+			t.Errorf("Expected checksum %s, got %s", sha256Hex("abc"), upload.SHA256)
+		}
+		return "mig-2", nil
+	})
+	if err != nil || upload.MigrationID != "mig-2" {
+		t.Errorf("Expected the restored upload to be migrated, got %+v, err %v", upload, err)
+	}
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,490 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapshotFormat formato de serialización de un snapshot
+type SnapshotFormat string
+
+const (
+	// SnapshotJSONL una transacción JSON por línea
+	SnapshotJSONL SnapshotFormat = "jsonl"
+	// SnapshotCSV CSV con header y las columnas de procedencia
+	SnapshotCSV SnapshotFormat = "csv"
+)
+
+// ParseSnapshotFormat convierte un texto en SnapshotFormat; vacío equivale a SnapshotJSONL
+func ParseSnapshotFormat(value string) (SnapshotFormat, error) {
+	switch format := SnapshotFormat(strings.ToLower(strings.TrimSpace(value))); format {
+	case "":
+		return SnapshotJSONL, nil
+	case SnapshotJSONL, SnapshotCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid snapshot format %q (expected jsonl or csv)", value)
+	}
+}
+
+// SnapshotImportMode define qué pasa con las transacciones existentes al importar
+type SnapshotImportMode string
+
+const (
+	// SnapshotMerge agrega las transacciones del snapshot; los IDs existentes se sobrescriben
+	SnapshotMerge SnapshotImportMode = "merge"
+	// SnapshotReplace elimina todas las transacciones antes de cargar el snapshot
+	SnapshotReplace SnapshotImportMode = "replace"
+)
+
+// ParseSnapshotImportMode convierte un texto en SnapshotImportMode; vacío equivale a SnapshotMerge
+func ParseSnapshotImportMode(value string) (SnapshotImportMode, error) {
+	switch mode := SnapshotImportMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case "":
+		return SnapshotMerge, nil
+	case SnapshotMerge, SnapshotReplace:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid import mode %q (expected merge or replace)", value)
+	}
+}
+
+// ErrInvalidSnapshot indica que el contenido del snapshot no se pudo leer
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// ErrSnapshotChecksumMismatch indica que el SHA-256 del contenido no coincide con el del manifiesto
+var ErrSnapshotChecksumMismatch = errors.New("snapshot checksum mismatch")
+
+// snapshotCSVHeader columnas del formato CSV
+var snapshotCSVHeader = []string{"id", "user_id", "amount", "datetime", "migration_id", "source_file", "source_line", "imported_at",
+	"deleted", "deleted_at", "delete_reason", "external_id"}
+
+// Columnas de los snapshots CSV anteriores, que se siguen aceptando
+const (
+	snapshotLegacyCSVColumns     = 8  // Anteriores al borrado lógico
+	snapshotSoftDeleteCSVColumns = 11 // Anteriores a la clave externa
+)
+
+// SnapshotManifest describe el contenido exportado; SHA256 es el hash del cuerpo completo
+type SnapshotManifest struct {
+	Format    SnapshotFormat `json:"format"`
+	Count     int            `json:"count"`
+	SHA256    string         `json:"sha256"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// SnapshotImportResult resultado de cargar un snapshot
+type SnapshotImportResult struct {
+	Format      SnapshotFormat     `json:"format"`
+	Mode        SnapshotImportMode `json:"mode"`
+	SHA256      string             `json:"sha256"`
+	Count       int                `json:"count"`
+	Inserted    int                `json:"inserted"`
+	Overwritten int                `json:"overwritten"`
+}
+
+// Snapshot copia consistente del almacén ya serializada en un archivo temporal.
+// Close elimina el archivo; debe llamarse al terminar de escribirlo.
+type Snapshot struct {
+	Manifest SnapshotManifest
+	file     *os.File
+}
+
+// WriteTo escribe el snapshot en el formato del manifiesto; el contenido coincide con Manifest.SHA256
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("error reading snapshot: %v", err)
+	}
+	return io.Copy(w, s.file)
+}
+
+// Close elimina el archivo temporal del snapshot
+func (s *Snapshot) Close() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// SnapshotService exporta e importa el contenido completo del almacén de transacciones
+type SnapshotService struct {
+	database TransactionRepository
+}
+
+// NewSnapshotService crea una nueva instancia de SnapshotService
+func NewSnapshotService(database TransactionRepository) *SnapshotService {
+	return &SnapshotService{
+		database: database,
+	}
+}
+
+// CreateSnapshot serializa todas las transacciones en un archivo temporal y calcula su manifiesto.
+// El contenido se escribe a disco a medida que se recorre el almacén para poder enviar el manifiesto
+// antes del cuerpo sin cargar el almacén completo en memoria.
+func (ss *SnapshotService) CreateSnapshot(format SnapshotFormat) (*Snapshot, error) {
+	file, err := os.CreateTemp("", "snapshot-*."+string(format))
+	if err != nil {
+		return nil, fmt.Errorf("error creating snapshot file: %v", err)
+	}
+	snapshot := &Snapshot{file: file}
+
+	hash := sha256.New()
+	count := 0
+	writer, err := newSnapshotWriter(io.MultiWriter(file, hash), format)
+	if err == nil {
+		err = ss.database.ForEachTransaction(func(transaction models.UserTransaction) error {
+			count++
+			return writer.Write(transaction)
+		})
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+
+	snapshot.Manifest = SnapshotManifest{
+		Format:    format,
+		Count:     count,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt: time.Now().UTC(),
+	}
+	return snapshot, nil
+}
+
+// ImportSnapshot lee un snapshot completo y lo carga en el almacén en una única operación del repositorio.
+// Primero se valida el contenido (y expectedSHA256 si no está vacío) mientras se copia a un archivo
+// temporal; ante cualquier error de lectura o de checksum no se modifica nada. Después se carga desde
+// la copia: SQLite lo lee de a una transacción dentro de una única transacción SQL, mientras que
+// MockDatabase reúne el snapshot completo en memoria como un lote antes de aplicarlo. En modo replace
+// las transacciones existentes (y su historial) se reemplazan de forma atómica.
+func (ss *SnapshotService) ImportSnapshot(reader io.Reader, format SnapshotFormat, mode SnapshotImportMode, expectedSHA256 string) (*SnapshotImportResult, error) {
+	spool, err := os.CreateTemp("", "snapshot-import-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating snapshot spool file: %v", err)
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+	count, err := countSnapshot(io.TeeReader(reader, io.MultiWriter(spool, hash)), format)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && !strings.EqualFold(expectedSHA256, checksum) {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrSnapshotChecksumMismatch, expectedSHA256, checksum)
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error reading snapshot spool file: %v", err)
+	}
+	snapshotReader, err := newSnapshotReader(bufio.NewReader(spool), format)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, overwritten, err := ss.database.ImportTransactions(snapshotReader.Next, mode == SnapshotReplace)
+	if err != nil {
+		return nil, fmt.Errorf("error saving snapshot: %v", err)
+	}
+
+	return &SnapshotImportResult{
+		Format:      format,
+		Mode:        mode,
+		SHA256:      checksum,
+		Count:       count,
+		Inserted:    inserted,
+		Overwritten: overwritten,
+	}, nil
+}
+
+// countSnapshot valida todas las transacciones del snapshot y retorna cuántas contiene
+func countSnapshot(reader io.Reader, format SnapshotFormat) (int, error) {
+	snapshotReader, err := newSnapshotReader(reader, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		_, err := snapshotReader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+}
+
+// snapshotWriter serializa transacciones de a una en el formato indicado
+type snapshotWriter struct {
+	buffered  *bufio.Writer
+	encoder   *json.Encoder // SnapshotJSONL
+	csvWriter *csv.Writer   // SnapshotCSV
+}
+
+// newSnapshotWriter crea un snapshotWriter sobre w; en CSV escribe el header
+func newSnapshotWriter(w io.Writer, format SnapshotFormat) (*snapshotWriter, error) {
+	buffered := bufio.NewWriter(w)
+
+	switch format {
+	case SnapshotJSONL:
+		return &snapshotWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case SnapshotCSV:
+		csvWriter := csv.NewWriter(buffered)
+		if err := csvWriter.Write(snapshotCSVHeader); err != nil {
+			return nil, fmt.Errorf("error writing CSV header: %v", err)
+		}
+		return &snapshotWriter{buffered: buffered, csvWriter: csvWriter}, nil
+	default:
+		return nil, fmt.Errorf("unsupported snapshot format %q", format)
+	}
+}
+
+// Write serializa una transacción
+func (sw *snapshotWriter) Write(transaction models.UserTransaction) error {
+	if sw.encoder != nil {
+		if err := sw.encoder.Encode(transaction); err != nil {
+			return fmt.Errorf("error encoding transaction %d: %v", transaction.ID, err)
+		}
+		return nil
+	}
+
+	if err := sw.csvWriter.Write(snapshotCSVRecord(transaction)); err != nil {
+		return fmt.Errorf("error writing transaction %d: %v", transaction.ID, err)
+	}
+	return nil
+}
+
+// Flush escribe lo pendiente en el writer de destino
+func (sw *snapshotWriter) Flush() error {
+	if sw.csvWriter != nil {
+		sw.csvWriter.Flush()
+		if err := sw.csvWriter.Error(); err != nil {
+			return fmt.Errorf("error writing CSV: %v", err)
+		}
+	}
+	return sw.buffered.Flush()
+}
+
+// snapshotReader lee las transacciones de un snapshot de a una
+type snapshotReader struct {
+	scanner    *bufio.Scanner // SnapshotJSONL
+	csvReader  *csv.Reader    // SnapshotCSV; nil si el contenido está vacío
+	lineNumber int
+}
+
+// newSnapshotReader crea un snapshotReader sobre reader; en CSV valida el header
+func newSnapshotReader(reader io.Reader, format SnapshotFormat) (*snapshotReader, error) {
+	switch format {
+	case SnapshotJSONL:
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		return &snapshotReader{scanner: scanner}, nil
+	case SnapshotCSV:
+		// La cantidad de columnas la fija el header (actual o de una versión anterior)
+		csvReader := csv.NewReader(reader)
+
+		header, err := csvReader.Read()
+		if err == io.EOF {
+			return &snapshotReader{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		if !validSnapshotCSVHeader(header) {
+			return nil, fmt.Errorf("%w: invalid CSV header. Expected: %v, Got: %v", ErrInvalidSnapshot, snapshotCSVHeader, header)
+		}
+		return &snapshotReader{csvReader: csvReader, lineNumber: 1}, nil
+	default:
+		return nil, fmt.Errorf("unsupported snapshot format %q", format)
+	}
+}
+
+// Next retorna la siguiente transacción válida, o io.EOF al terminar el contenido
+func (sr *snapshotReader) Next() (models.UserTransaction, error) {
+	if sr.scanner != nil {
+		return sr.nextJSONL()
+	}
+	if sr.csvReader == nil {
+		return models.UserTransaction{}, io.EOF
+	}
+
+	record, err := sr.csvReader.Read()
+	if err == io.EOF {
+		return models.UserTransaction{}, io.EOF
+	}
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	sr.lineNumber++
+
+	transaction, err := parseSnapshotCSVRecord(record)
+	if err == nil {
+		err = validateSnapshotTransaction(transaction)
+	}
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("%w: line %d: %v", ErrInvalidSnapshot, sr.lineNumber, err)
+	}
+	return transaction, nil
+}
+
+// nextJSONL retorna la siguiente transacción de un snapshot JSONL, salteando las líneas vacías
+func (sr *snapshotReader) nextJSONL() (models.UserTransaction, error) {
+	for sr.scanner.Scan() {
+		sr.lineNumber++
+		line := strings.TrimSpace(sr.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var transaction models.UserTransaction
+		if err := json.Unmarshal([]byte(line), &transaction); err != nil {
+			return transaction, fmt.Errorf("%w: line %d: %v", ErrInvalidSnapshot, sr.lineNumber, err)
+		}
+		if err := validateSnapshotTransaction(transaction); err != nil {
+			return transaction, fmt.Errorf("%w: line %d: %v", ErrInvalidSnapshot, sr.lineNumber, err)
+		}
+		return transaction, nil
+	}
+
+	if err := sr.scanner.Err(); err != nil {
+		return models.UserTransaction{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return models.UserTransaction{}, io.EOF
+}
+
+// validateSnapshotTransaction verifica que la transacción tenga un ID y una fecha; un snapshot conserva los IDs
+func validateSnapshotTransaction(transaction models.UserTransaction) error {
+	if transaction.ID <= 0 {
+		return fmt.Errorf("invalid ID %d", transaction.ID)
+	}
+	if transaction.DateTime.IsZero() {
+		return fmt.Errorf("missing datetime for transaction %d", transaction.ID)
+	}
+	return nil
+}
+
+// snapshotCSVRecord convierte una transacción en una fila del formato CSV
+func snapshotCSVRecord(transaction models.UserTransaction) []string {
+	importedAt := ""
+	if transaction.ImportedAt != nil {
+		importedAt = transaction.ImportedAt.UTC().Format(time.RFC3339Nano)
+	}
+	sourceLine := ""
+	if transaction.SourceLine != 0 {
+		sourceLine = strconv.Itoa(transaction.SourceLine)
+	}
+	deleted := ""
+	deletedAt := ""
+	if transaction.Deleted {
+		deleted = "true"
+	}
+	if transaction.DeletedAt != nil {
+		deletedAt = transaction.DeletedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return []string{
+		strconv.Itoa(transaction.ID),
+		strconv.Itoa(transaction.UserID),
+		strconv.FormatFloat(transaction.Amount, 'f', -1, 64),
+		transaction.DateTime.UTC().Format(time.RFC3339Nano),
+		transaction.MigrationID,
+		transaction.SourceFile,
+		sourceLine,
+		importedAt,
+		deleted,
+		deletedAt,
+		transaction.DeleteReason,
+		transaction.ExternalID,
+	}
+}
+
+// validSnapshotCSVHeader indica si header es el del formato CSV actual o el de una versión anterior
+func validSnapshotCSVHeader(header []string) bool {
+	joined := strings.Join(header, ",")
+	if joined == strings.Join(snapshotCSVHeader, ",") {
+		return true
+	}
+	for _, columns := range []int{snapshotLegacyCSVColumns, snapshotSoftDeleteCSVColumns} {
+		if joined == strings.Join(snapshotCSVHeader[:columns], ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSnapshotCSVRecord convierte una fila del formato CSV en transacción
+func parseSnapshotCSVRecord(record []string) (models.UserTransaction, error) {
+	var transaction models.UserTransaction
+	var err error
+
+	if transaction.ID, err = strconv.Atoi(record[0]); err != nil {
+		return transaction, fmt.Errorf("invalid id: %v", err)
+	}
+	if transaction.UserID, err = strconv.Atoi(record[1]); err != nil {
+		return transaction, fmt.Errorf("invalid user_id: %v", err)
+	}
+	if transaction.Amount, err = strconv.ParseFloat(record[2], 64); err != nil {
+		return transaction, fmt.Errorf("invalid amount: %v", err)
+	}
+	if transaction.DateTime, err = time.Parse(time.RFC3339Nano, record[3]); err != nil {
+		return transaction, fmt.Errorf("invalid datetime: %v", err)
+	}
+
+	transaction.MigrationID = record[4]
+	transaction.SourceFile = record[5]
+	if record[6] != "" {
+		if transaction.SourceLine, err = strconv.Atoi(record[6]); err != nil {
+			return transaction, fmt.Errorf("invalid source_line: %v", err)
+		}
+	}
+	if record[7] != "" {
+		importedAt, err := time.Parse(time.RFC3339Nano, record[7])
+		if err != nil {
+			return transaction, fmt.Errorf("invalid imported_at: %v", err)
+		}
+		transaction.ImportedAt = &importedAt
+	}
+	if len(record) == snapshotLegacyCSVColumns {
+		return transaction, nil
+	}
+
+	if record[8] != "" {
+		if transaction.Deleted, err = strconv.ParseBool(record[8]); err != nil {
+			return transaction, fmt.Errorf("invalid deleted: %v", err)
+		}
+	}
+	if record[9] != "" {
+		deletedAt, err := time.Parse(time.RFC3339Nano, record[9])
+		if err != nil {
+			return transaction, fmt.Errorf("invalid deleted_at: %v", err)
+		}
+		transaction.DeletedAt = &deletedAt
+	}
+	transaction.DeleteReason = record[10]
+	if len(record) == snapshotSoftDeleteCSVColumns {
+		return transaction, nil
+	}
+
+	transaction.ExternalID = record[11]
+
+	return transaction, nil
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSnapshotFormatAndMode(t *testing.T) {
+	if format, err := ParseSnapshotFormat(""); err != nil || format != SnapshotJSONL {
+		t.Errorf("Expected jsonl by default, got %s (%v)", format, err)
+	}
+	if format, err := ParseSnapshotFormat("CSV"); err != nil || format != SnapshotCSV {
+		t.Errorf("Expected csv, got %s (%v)", format, err)
+	}
+	if _, err := ParseSnapshotFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+
+	if mode, err := ParseSnapshotImportMode(""); err != nil || mode != SnapshotMerge {
+		t.Errorf("Expected merge by default, got %s (%v)", mode, err)
+	}
+	if _, err := ParseSnapshotImportMode("append"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func TestSnapshotService_RoundTrip(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	importedAt := time.Date(2024, 3, 1, 9, 0, 0, 123456789, time.UTC)
+
+	for _, format := range []SnapshotFormat{SnapshotJSONL, SnapshotCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			source := NewMockDatabase()
+			source.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.5, DateTime: baseTime,
+				MigrationID: "mig-a", SourceFile: "january, final.csv", SourceLine: 2, ImportedAt: &importedAt, ExternalID: "ofx:0001:A1"})
+			source.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1002, Amount: -75.25, DateTime: baseTime.Add(time.Hour)})
+
+			snapshot, err := NewSnapshotService(source).CreateSnapshot(format)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer snapshot.Close()
+			var buf bytes.Buffer
+			if _, err := snapshot.WriteTo(&buf); err != nil {
+				t.Fatalf("Expected no error writing snapshot, got %v", err)
+			}
+			if snapshot.Manifest.Count != 2 || snapshot.Manifest.Format != format {
+				t.Errorf("Unexpected manifest %+v", snapshot.Manifest)
+			}
+
+			// Cargar en un almacén con datos previos, reemplazándolos
+			for name, target := range repositoryImplementations(t) {
+				target.SaveTransaction(models.UserTransaction{ID: 99, UserID: 1003, Amount: 1, DateTime: baseTime})
+
+				result, err := NewSnapshotService(target).ImportSnapshot(bytes.NewReader(buf.Bytes()), format, SnapshotReplace, snapshot.Manifest.SHA256)
+				if err != nil {
+					t.Fatalf("%s: expected no error importing, got %v", name, err)
+				}
+				if result.Count != 2 || result.Inserted != 2 || result.SHA256 != snapshot.Manifest.SHA256 {
+					t.Errorf("%s: unexpected import result %+v", name, result)
+				}
+
+				if target.GetTransactionCount() != 2 {
+					t.Errorf("%s: expected 2 transactions after replace, got %d", name, target.GetTransactionCount())
+				}
+				stored, _ := target.GetTransaction(1)
+				if stored.Amount != 150.5 || stored.SourceFile != "january, final.csv" || stored.SourceLine != 2 ||
+					stored.ImportedAt == nil || !stored.ImportedAt.Equal(importedAt) || !stored.DateTime.Equal(baseTime) ||
+					stored.ExternalID != "ofx:0001:A1" {
+					t.Errorf("%s: expected transaction 1 with its provenance, got %+v", name, stored)
+				}
+				if stored, _ := target.GetTransaction(2); stored.Amount != -75.25 || stored.ImportedAt != nil {
+					t.Errorf("%s: expected transaction 2 without provenance, got %+v", name, stored)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotService_MergeKeepsExistingTransactions(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	db := NewMockDatabase()
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 5, UserID: 1001, Amount: 50, DateTime: baseTime})
+
+	snapshot := `{"id":1,"user_id":1001,"amount":20,"datetime":"2024-01-15T10:30:00Z"}
+
+{"id":2,"user_id":1002,"amount":30,"datetime":"2024-01-16T10:30:00Z"}
+`
+	result, err := NewSnapshotService(db).ImportSnapshot(strings.NewReader(snapshot), SnapshotJSONL, SnapshotMerge, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Inserted != 1 || result.Overwritten != 1 {
+		t.Errorf("Expected 1 inserted and 1 overwritten, got %+v", result)
+	}
+	if db.GetTransactionCount() != 3 {
+		t.Errorf("Expected 3 transactions after merge, got %d", db.GetTransactionCount())
+	}
+	if tx, _ := db.GetTransaction(1); tx.Amount != 20 {
+		t.Errorf("Expected transaction 1 overwritten, got %.2f", tx.Amount)
+	}
+}
+
+func TestSnapshotService_RejectedImportLeavesStoreUnchanged(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	valid := `{"id":1,"user_id":1001,"amount":20,"datetime":"2024-01-15T10:30:00Z"}` + "\n"
+
+	tests := []struct {
+		name        string
+		content     string
+		checksum    string
+		expectedErr error
+	}{
+		{"checksum mismatch", valid, strings.Repeat("0", 64), ErrSnapshotChecksumMismatch},
+		{"invalid json", valid + "not json\n", "", ErrInvalidSnapshot},
+		{"missing id", `{"user_id":1001,"amount":20,"datetime":"2024-01-15T10:30:00Z"}`, "", ErrInvalidSnapshot},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMockDatabase()
+			db.SaveTransaction(models.UserTransaction{ID: 7, UserID: 1001, Amount: 10, DateTime: baseTime})
+
+			_, err := NewSnapshotService(db).ImportSnapshot(strings.NewReader(tt.content), SnapshotJSONL, SnapshotReplace, tt.checksum)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected %v, got %v", tt.expectedErr, err)
+			}
+			if _, found := db.GetTransaction(7); !found || db.GetTransactionCount() != 1 {
+				t.Errorf("Expected store unchanged, got %d transactions", db.GetTransactionCount())
+			}
+		})
+	}
+
+	_, err := NewSnapshotService(NewMockDatabase()).ImportSnapshot(strings.NewReader("id,user_id\n1,1001\n"), SnapshotCSV, SnapshotMerge, "")
+	if !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot for CSV with wrong header, got %v", err)
+	}
+}
+
+func TestSnapshotService_CSVSoftDeleteColumns(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	source := NewMockDatabase()
+	source.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+	deleted, _ := source.SoftDeleteTransaction(1, "duplicated, charge")
+
+	snapshot, err := NewSnapshotService(source).CreateSnapshot(SnapshotCSV)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer snapshot.Close()
+	var buf bytes.Buffer
+	snapshot.WriteTo(&buf)
+
+	target := NewMockDatabase()
+	if _, err := NewSnapshotService(target).ImportSnapshot(&buf, SnapshotCSV, SnapshotReplace, ""); err != nil {
+		t.Fatalf("Expected no error importing, got %v", err)
+	}
+	stored, _ := target.GetTransaction(1)
+	if !stored.Deleted || stored.DeleteReason != "duplicated, charge" || stored.DeletedAt == nil || !stored.DeletedAt.Equal(*deleted.DeletedAt) {
+		t.Errorf("Expected soft delete preserved, got %+v", stored)
+	}
+
+	// Los snapshots CSV anteriores al borrado lógico se siguen aceptando
+	legacy := "id,user_id,amount,datetime,migration_id,source_file,source_line,imported_at\n2,1002,5,2024-01-15T10:30:00Z,,,,\n"
+	if _, err := NewSnapshotService(target).ImportSnapshot(strings.NewReader(legacy), SnapshotCSV, SnapshotMerge, ""); err != nil {
+		t.Fatalf("Expected legacy CSV header to be accepted, got %v", err)
+	}
+	if stored, found := target.GetTransaction(2); !found || stored.Deleted {
+		t.Errorf("Expected active transaction 2 from legacy snapshot, got %+v", stored)
+	}
+
+	// También los anteriores a la clave externa
+	legacy = "id,user_id,amount,datetime,migration_id,source_file,source_line,imported_at,deleted,deleted_at,delete_reason\n3,1002,5,2024-01-15T10:30:00Z,,,,,,,\n"
+	if _, err := NewSnapshotService(target).ImportSnapshot(strings.NewReader(legacy), SnapshotCSV, SnapshotMerge, ""); err != nil {
+		t.Fatalf("Expected CSV header without external_id to be accepted, got %v", err)
+	}
+	if stored, found := target.GetTransaction(3); !found || stored.ExternalID != "" {
+		t.Errorf("Expected transaction 3 without external ID, got %+v", stored)
+	}
+}
+
+func TestSnapshotService_SnapshotFileRemovedOnClose(t *testing.T) {
+	source := NewMockDatabase()
+	for id := 1; id <= 1000; id++ {
+		source.SaveTransaction(models.UserTransaction{ID: id, UserID: 1001, Amount: float64(id), DateTime: time.Now()})
+	}
+
+	snapshot, err := NewSnapshotService(source).CreateSnapshot(SnapshotJSONL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	path := snapshot.file.Name()
+
+	// El contenido se puede escribir más de una vez (reintentos) y coincide con el manifiesto
+	for i := 0; i < 2; i++ {
+		hash := sha256.New()
+		if _, err := snapshot.WriteTo(hash); err != nil || hex.EncodeToString(hash.Sum(nil)) != snapshot.Manifest.SHA256 {
+			t.Fatalf("Expected content matching the manifest, got err %v", err)
+		}
+	}
+	if snapshot.Manifest.Count != 1000 {
+		t.Errorf("Expected 1000 transactions, got %d", snapshot.Manifest.Count)
+	}
+
+	snapshot.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected snapshot file removed, got %v", err)
+	}
+}
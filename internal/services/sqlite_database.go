@@ -0,0 +1,799 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // Driver SQLite en Go puro (sin cgo)
+)
+
+// sqliteTimeLayout formato de ancho fijo en UTC para que el orden lexicográfico coincida con el cronológico
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteMigrations contiene las migraciones del esquema en orden; la versión es índice+1.
+// Nunca modificar una migración existente, siempre agregar una nueva al final.
+var sqliteMigrations = []string{
+	// v1: tabla de transacciones e índices para consultas por usuario y fecha
+	`CREATE TABLE IF NOT EXISTS transactions (
+		id       INTEGER PRIMARY KEY,
+		user_id  INTEGER NOT NULL,
+		amount   REAL    NOT NULL,
+		datetime TEXT    NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_transactions_user_id_datetime ON transactions (user_id, datetime);
+	CREATE INDEX IF NOT EXISTS idx_transactions_datetime ON transactions (datetime);`,
+
+	// v2: historial de versiones; las transacciones existentes quedan como versión 1
+	`CREATE TABLE IF NOT EXISTS transaction_versions (
+		transaction_id INTEGER NOT NULL,
+		version        INTEGER NOT NULL,
+		user_id        INTEGER NOT NULL,
+		amount         REAL    NOT NULL,
+		datetime       TEXT    NOT NULL,
+		changed_at     TEXT    NOT NULL,
+		migration_id   TEXT    NOT NULL DEFAULT '',
+		PRIMARY KEY (transaction_id, version)
+	);
+	INSERT INTO transaction_versions (transaction_id, version, user_id, amount, datetime, changed_at)
+		SELECT id, 1, user_id, amount, datetime, strftime('%Y-%m-%dT%H:%M:%S.000000000Z', 'now') FROM transactions;`,
+
+	// v3: procedencia de cada transacción; las existentes toman la migración de su última versión
+	`ALTER TABLE transactions ADD COLUMN migration_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN source_file TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN source_line INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE transactions ADD COLUMN imported_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transaction_versions ADD COLUMN source_file TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transaction_versions ADD COLUMN source_line INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE transaction_versions ADD COLUMN imported_at TEXT NOT NULL DEFAULT '';
+	UPDATE transactions SET migration_id = COALESCE((SELECT v.migration_id FROM transaction_versions v
+		WHERE v.transaction_id = transactions.id ORDER BY v.version DESC LIMIT 1), '');
+	CREATE INDEX IF NOT EXISTS idx_transactions_migration_id ON transactions (migration_id, source_line);`,
+
+	// v4: borrado lógico con fecha y motivo, también en el historial
+	`ALTER TABLE transactions ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE transactions ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN delete_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transaction_versions ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE transaction_versions ADD COLUMN deleted_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transaction_versions ADD COLUMN delete_reason TEXT NOT NULL DEFAULT '';`,
+
+	// v5: clave de la transacción en su sistema de origen (extractos bancarios)
+	`ALTER TABLE transactions ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transaction_versions ADD COLUMN external_id TEXT NOT NULL DEFAULT '';`,
+
+	// v6: IDs AUTOINCREMENT para no reutilizar el de una fila purgada o archivada (su historial se conserva);
+	// la secuencia arranca por encima de cualquier ID presente en transactions o en el historial
+	`CREATE TABLE transactions_v6 (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id       INTEGER NOT NULL,
+		amount        REAL    NOT NULL,
+		datetime      TEXT    NOT NULL,
+		migration_id  TEXT    NOT NULL DEFAULT '',
+		source_file   TEXT    NOT NULL DEFAULT '',
+		source_line   INTEGER NOT NULL DEFAULT 0,
+		imported_at   TEXT    NOT NULL DEFAULT '',
+		deleted       INTEGER NOT NULL DEFAULT 0,
+		deleted_at    TEXT    NOT NULL DEFAULT '',
+		delete_reason TEXT    NOT NULL DEFAULT '',
+		external_id   TEXT    NOT NULL DEFAULT ''
+	);
+	INSERT INTO transactions_v6 (id, user_id, amount, datetime, migration_id, source_file, source_line, imported_at,
+			deleted, deleted_at, delete_reason, external_id)
+		SELECT id, user_id, amount, datetime, migration_id, source_file, source_line, imported_at,
+			deleted, deleted_at, delete_reason, external_id FROM transactions;
+	DROP TABLE transactions;
+	ALTER TABLE transactions_v6 RENAME TO transactions;
+	CREATE INDEX IF NOT EXISTS idx_transactions_user_id_datetime ON transactions (user_id, datetime);
+	CREATE INDEX IF NOT EXISTS idx_transactions_datetime ON transactions (datetime);
+	CREATE INDEX IF NOT EXISTS idx_transactions_migration_id ON transactions (migration_id, source_line);
+	DELETE FROM sqlite_sequence WHERE name = 'transactions';
+	INSERT INTO sqlite_sequence (name, seq) SELECT 'transactions', MAX(
+		COALESCE((SELECT MAX(id) FROM transactions), 0),
+		COALESCE((SELECT MAX(transaction_id) FROM transaction_versions), 0));`,
+}
+
+// sqliteTransactionColumns columnas de transactions en el orden que espera scanTransactions
+const sqliteTransactionColumns = `id, user_id, amount, datetime, migration_id, source_file, source_line, imported_at,
+	deleted, deleted_at, delete_reason, external_id`
+
+// SQLiteDatabase almacena las transacciones en una base de datos SQLite embebida
+type SQLiteDatabase struct {
+	db         *sql.DB
+	writeMutex sync.Mutex // Serializa las escrituras para publicar los cambios en orden de commit
+	changes    *changeFeed
+}
+
+// NewSQLiteDatabase abre (o crea) la base de datos en path y aplica las migraciones pendientes
+func NewSQLiteDatabase(path string) (*SQLiteDatabase, error) {
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %v", err)
+	}
+
+	// SQLite serializa las escrituras; una sola conexión evita errores SQLITE_BUSY
+	// y permite usar bases de datos en memoria (cada conexión tendría la suya)
+	db.SetMaxOpenConns(1)
+
+	sqliteDB := &SQLiteDatabase{db: db, changes: newChangeFeed(defaultChangeRetention)}
+	if err := sqliteDB.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return sqliteDB, nil
+}
+
+// migrate aplica las migraciones del esquema que aún no se han ejecutado
+func (s *SQLiteDatabase) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for i := current; i < len(sqliteMigrations); i++ {
+		version := i + 1
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			version, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion retorna la versión actual del esquema
+func (s *SQLiteDatabase) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Close cierra la conexión con la base de datos
+func (s *SQLiteDatabase) Close() error {
+	return s.db.Close()
+}
+
+// SaveTransaction guarda una transacción; si el ID ya existe la sobrescribe
+func (s *SQLiteDatabase) SaveTransaction(transaction models.UserTransaction) (models.UserTransaction, error) {
+	result, err := s.SaveTransactionWithOptions(transaction, SaveOptions{ConflictPolicy: ConflictOverwrite})
+	if err != nil {
+		return models.UserTransaction{}, err
+	}
+	return result.Transaction, nil
+}
+
+// SaveTransactionWithOptions guarda una transacción aplicando la política de conflictos indicada.
+// La lectura del registro existente y la escritura ocurren dentro de la misma transacción SQL.
+func (s *SQLiteDatabase) SaveTransactionWithOptions(transaction models.UserTransaction, options SaveOptions) (SaveResult, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.checkVersionInTx(tx, transaction.ID, options); err != nil {
+		return SaveResult{}, err
+	}
+
+	result, err := s.saveInTx(tx, transaction, options, time.Now().UTC())
+	if err != nil {
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return SaveResult{}, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	if event, changed := saveChangeEvent(result); changed {
+		s.changes.publish(event)
+	}
+
+	return result, nil
+}
+
+// SaveTransactions guarda un lote dentro de una única transacción SQL. Si alguna transacción
+// falla se hace rollback de todo el lote y se retorna un *BatchSaveError con todos los errores.
+func (s *SQLiteDatabase) SaveTransactions(transactions []models.UserTransaction, options SaveOptions) ([]SaveResult, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]SaveResult, len(transactions))
+	batchErr := &BatchSaveError{Total: len(transactions)}
+	changedAt := time.Now().UTC()
+
+	// La versión esperada se compara con el historial antes de escribir el lote
+	for i, transaction := range transactions {
+		if err := s.checkVersionInTx(tx, transaction.ID, options); err != nil {
+			batchErr.Errors = append(batchErr.Errors, BatchItemError{Index: i, Err: err})
+		}
+	}
+	if len(batchErr.Errors) > 0 {
+		return nil, batchErr
+	}
+
+	// Las escrituras previas del lote son visibles dentro de la transacción, por lo que
+	// los conflictos entre filas del mismo lote se resuelven igual que contra datos existentes
+	for i, transaction := range transactions {
+		result, err := s.saveInTx(tx, transaction, options, changedAt)
+		if err != nil {
+			batchErr.Errors = append(batchErr.Errors, BatchItemError{Index: i, Err: err})
+			continue
+		}
+		results[i] = result
+	}
+
+	if len(batchErr.Errors) > 0 {
+		return nil, batchErr
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	var events []ChangeEvent
+	for _, result := range results {
+		if event, changed := saveChangeEvent(result); changed {
+			events = append(events, event)
+		}
+	}
+	s.changes.publish(events...)
+
+	return results, nil
+}
+
+// saveInTx guarda una transacción y su nueva versión dentro de la transacción SQL tx sin hacer commit
+func (s *SQLiteDatabase) saveInTx(tx *sql.Tx, transaction models.UserTransaction, options SaveOptions, changedAt time.Time) (SaveResult, error) {
+	result := SaveResult{Outcome: SaveInserted}
+	datetime := transaction.DateTime.UTC().Format(sqliteTimeLayout)
+
+	// Si no tiene ID, dejar que SQLite asigne uno nuevo; AUTOINCREMENT nunca reutiliza el de una fila eliminada
+	if transaction.ID == 0 {
+		res, err := tx.Exec(`INSERT INTO transactions (user_id, amount, datetime, migration_id, source_file, source_line, imported_at,
+				deleted, deleted_at, delete_reason, external_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			transaction.UserID, transaction.Amount, datetime, transaction.MigrationID, transaction.SourceFile,
+			transaction.SourceLine, formatOptionalTime(transaction.ImportedAt),
+			transaction.Deleted, formatOptionalTime(transaction.DeletedAt), transaction.DeleteReason, transaction.ExternalID)
+		if err != nil {
+			return SaveResult{}, fmt.Errorf("failed to save transaction: %v", err)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return SaveResult{}, fmt.Errorf("failed to read transaction ID: %v", err)
+		}
+		transaction.ID = int(id)
+
+		if err := s.insertVersion(tx, transaction, options.MigrationID, changedAt); err != nil {
+			return SaveResult{}, err
+		}
+
+		result.Transaction = transaction
+		return result, nil
+	}
+
+	rows, err := tx.Query(`SELECT `+sqliteTransactionColumns+` FROM transactions WHERE id = ?`, transaction.ID)
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to read transaction: %v", err)
+	}
+
+	if existing := s.scanTransactions(rows); len(existing) > 0 {
+		previous := existing[0]
+		result.Previous = &previous
+
+		outcome, err := resolveConflict(previous, transaction, options.ConflictPolicy)
+		if err != nil {
+			return result, err
+		}
+		if outcome != SaveOverwritten {
+			result.Transaction = previous
+			result.Outcome = outcome
+			return result, nil
+		}
+		result.Outcome = SaveOverwritten
+	}
+
+	_, err = tx.Exec(`INSERT INTO transactions (`+sqliteTransactionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET user_id = excluded.user_id, amount = excluded.amount, datetime = excluded.datetime,
+			migration_id = excluded.migration_id, source_file = excluded.source_file,
+			source_line = excluded.source_line, imported_at = excluded.imported_at,
+			deleted = excluded.deleted, deleted_at = excluded.deleted_at, delete_reason = excluded.delete_reason,
+			external_id = excluded.external_id`,
+		transaction.ID, transaction.UserID, transaction.Amount, datetime, transaction.MigrationID, transaction.SourceFile,
+		transaction.SourceLine, formatOptionalTime(transaction.ImportedAt),
+		transaction.Deleted, formatOptionalTime(transaction.DeletedAt), transaction.DeleteReason, transaction.ExternalID)
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to save transaction: %v", err)
+	}
+
+	if err := s.insertVersion(tx, transaction, options.MigrationID, changedAt); err != nil {
+		return SaveResult{}, err
+	}
+
+	result.Transaction = transaction
+	return result, nil
+}
+
+// checkVersionInTx compara la última versión de id dentro de tx con la que espera options
+func (s *SQLiteDatabase) checkVersionInTx(tx *sql.Tx, id int, options SaveOptions) error {
+	if _, checked := options.ExpectedVersions[id]; !checked {
+		return nil
+	}
+
+	var current int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM transaction_versions WHERE transaction_id = ?`, id).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read transaction version: %v", err)
+	}
+	return checkExpectedVersion(options, id, current)
+}
+
+// insertVersion agrega la transacción como siguiente versión de su historial
+func (s *SQLiteDatabase) insertVersion(tx *sql.Tx, transaction models.UserTransaction, migrationID string, changedAt time.Time) error {
+	_, err := tx.Exec(`INSERT INTO transaction_versions (transaction_id, version, user_id, amount, datetime, changed_at,
+			migration_id, source_file, source_line, imported_at, deleted, deleted_at, delete_reason, external_id)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM transaction_versions WHERE transaction_id = ?`,
+		transaction.ID, transaction.UserID, transaction.Amount, transaction.DateTime.UTC().Format(sqliteTimeLayout),
+		changedAt.Format(sqliteTimeLayout), migrationID, transaction.SourceFile, transaction.SourceLine,
+		formatOptionalTime(transaction.ImportedAt), transaction.Deleted, formatOptionalTime(transaction.DeletedAt),
+		transaction.DeleteReason, transaction.ExternalID, transaction.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save transaction version: %v", err)
+	}
+	return nil
+}
+
+// GetTransaction obtiene una transacción por ID
+func (s *SQLiteDatabase) GetTransaction(id int) (models.UserTransaction, bool) {
+	rows, err := s.db.Query(`SELECT `+sqliteTransactionColumns+` FROM transactions WHERE id = ?`, id)
+	if err != nil {
+		log.Printf("Error querying transaction %d: %v", id, err)
+		return models.UserTransaction{}, false
+	}
+
+	transactions := s.scanTransactions(rows)
+	if len(transactions) == 0 {
+		return models.UserTransaction{}, false
+	}
+	return transactions[0], true
+}
+
+// GetTransactionHistory obtiene todas las versiones de una transacción, de la más antigua a la actual
+func (s *SQLiteDatabase) GetTransactionHistory(id int) []models.TransactionVersion {
+	rows, err := s.db.Query(`SELECT version, transaction_id, user_id, amount, datetime, changed_at, migration_id,
+			source_file, source_line, imported_at, deleted, deleted_at, delete_reason, external_id
+		FROM transaction_versions WHERE transaction_id = ? ORDER BY version`, id)
+	if err != nil {
+		log.Printf("Error querying history of transaction %d: %v", id, err)
+		return nil
+	}
+	defer rows.Close()
+
+	var versions []models.TransactionVersion
+	for rows.Next() {
+		var version models.TransactionVersion
+		var datetime, changedAt, importedAt, deletedAt string
+		if err := rows.Scan(&version.Version, &version.Transaction.ID, &version.Transaction.UserID,
+			&version.Transaction.Amount, &datetime, &changedAt, &version.MigrationID,
+			&version.Transaction.SourceFile, &version.Transaction.SourceLine, &importedAt,
+			&version.Transaction.Deleted, &deletedAt, &version.Transaction.DeleteReason,
+			&version.Transaction.ExternalID); err != nil {
+			log.Printf("Error scanning version of transaction %d: %v", id, err)
+			continue
+		}
+
+		if version.Transaction.DateTime, err = time.Parse(sqliteTimeLayout, datetime); err != nil {
+			log.Printf("Error parsing datetime of transaction %d: %v", id, err)
+			continue
+		}
+		if version.ChangedAt, err = time.Parse(sqliteTimeLayout, changedAt); err != nil {
+			log.Printf("Error parsing changed_at of transaction %d: %v", id, err)
+			continue
+		}
+		if version.Transaction.ImportedAt, err = parseOptionalTime(importedAt); err != nil {
+			log.Printf("Error parsing imported_at of transaction %d: %v", id, err)
+			continue
+		}
+		if version.Transaction.DeletedAt, err = parseOptionalTime(deletedAt); err != nil {
+			log.Printf("Error parsing deleted_at of transaction %d: %v", id, err)
+			continue
+		}
+		version.Transaction.MigrationID = version.MigrationID
+
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating history of transaction %d: %v", id, err)
+	}
+
+	return versions
+}
+
+// GetTransactionsByUserID obtiene todas las transacciones de un usuario
+func (s *SQLiteDatabase) GetTransactionsByUserID(userID int) []models.UserTransaction {
+	return s.GetTransactionsByUserIDWithDateRange(userID, nil, nil)
+}
+
+// GetTransactionsByUserIDWithDateRange obtiene las transacciones de un usuario filtradas por rango de fechas
+func (s *SQLiteDatabase) GetTransactionsByUserIDWithDateRange(userID int, fromDate, toDate *time.Time) []models.UserTransaction {
+	query := strings.Builder{}
+	query.WriteString(`SELECT ` + sqliteTransactionColumns + ` FROM transactions WHERE user_id = ?`)
+	args := []interface{}{userID}
+
+	if fromDate != nil {
+		query.WriteString(` AND datetime >= ?`)
+		args = append(args, fromDate.UTC().Format(sqliteTimeLayout))
+	}
+	if toDate != nil {
+		query.WriteString(` AND datetime <= ?`)
+		args = append(args, toDate.UTC().Format(sqliteTimeLayout))
+	}
+	query.WriteString(` ORDER BY datetime, id`)
+
+	rows, err := s.db.Query(query.String(), args...)
+	if err != nil {
+		log.Printf("Error querying transactions for user %d: %v", userID, err)
+		return nil
+	}
+
+	return s.scanTransactions(rows)
+}
+
+// GetTransactionsByMigrationID obtiene las transacciones vigentes importadas por una migración,
+// ordenadas por línea de origen
+func (s *SQLiteDatabase) GetTransactionsByMigrationID(migrationID string) []models.UserTransaction {
+	if migrationID == "" {
+		return nil
+	}
+
+	rows, err := s.db.Query(`SELECT `+sqliteTransactionColumns+` FROM transactions WHERE migration_id = ?
+		ORDER BY source_line, id`, migrationID)
+	if err != nil {
+		log.Printf("Error querying transactions of migration %s: %v", migrationID, err)
+		return nil
+	}
+
+	return s.scanTransactions(rows)
+}
+
+// GetAllTransactions obtiene todas las transacciones
+func (s *SQLiteDatabase) GetAllTransactions() []models.UserTransaction {
+	rows, err := s.db.Query(`SELECT ` + sqliteTransactionColumns + ` FROM transactions ORDER BY id`)
+	if err != nil {
+		log.Printf("Error querying all transactions: %v", err)
+		return nil
+	}
+
+	return s.scanTransactions(rows)
+}
+
+// ForEachTransaction recorre las transacciones ordenadas por ID fila por fila. La base usa una
+// sola conexión, así que las demás operaciones esperan a que termine el recorrido.
+func (s *SQLiteDatabase) ForEachTransaction(fn func(transaction models.UserTransaction) error) error {
+	rows, err := s.db.Query(`SELECT ` + sqliteTransactionColumns + ` FROM transactions ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		if err := fn(transaction); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetTransactionCount retorna el número total de transacciones
+func (s *SQLiteDatabase) GetTransactionCount() int {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&count); err != nil {
+		log.Printf("Error counting transactions: %v", err)
+		return 0
+	}
+	return count
+}
+
+// SoftDeleteTransaction marca la transacción como eliminada y guarda la versión nueva en una única transacción SQL
+func (s *SQLiteDatabase) SoftDeleteTransaction(id int, reason string) (models.UserTransaction, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT `+sqliteTransactionColumns+` FROM transactions WHERE id = ?`, id)
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("failed to read transaction: %v", err)
+	}
+	existing := s.scanTransactions(rows)
+	if len(existing) == 0 {
+		return models.UserTransaction{}, fmt.Errorf("%w: id %d", ErrTransactionNotFound, id)
+	}
+
+	changedAt := time.Now().UTC()
+	transaction, err := markDeleted(existing[0], reason, changedAt)
+	if err != nil {
+		return transaction, err
+	}
+
+	result, err := s.saveInTx(tx, transaction, SaveOptions{ConflictPolicy: ConflictOverwrite}, changedAt)
+	if err != nil {
+		return models.UserTransaction{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.UserTransaction{}, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	if event, changed := saveChangeEvent(result); changed {
+		s.changes.publish(event)
+	}
+
+	return transaction, nil
+}
+
+// DeleteTransactions elimina las transacciones indicadas dentro de una única transacción SQL y
+// retorna las que existían, ordenadas por ID. El historial de versiones se conserva.
+func (s *SQLiteDatabase) DeleteTransactions(ids []int) ([]models.UserTransaction, error) {
+	return s.DeleteTransactionsIf(ids, nil)
+}
+
+// DeleteTransactionsIf elimina las transacciones indicadas que cumplen condition (todas si es nil).
+// La condición se evalúa sobre la fila leída dentro de la misma transacción SQL que el borrado.
+func (s *SQLiteDatabase) DeleteTransactionsIf(ids []int, condition func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var deleted []models.UserTransaction
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		rows, err := tx.Query(`SELECT `+sqliteTransactionColumns+` FROM transactions WHERE id = ?`, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transaction: %v", err)
+		}
+		existing := s.scanTransactions(rows)
+		if len(existing) == 0 || (condition != nil && !condition(existing[0])) {
+			continue
+		}
+
+		if _, err := tx.Exec(`DELETE FROM transactions WHERE id = ?`, id); err != nil {
+			return nil, fmt.Errorf("failed to delete transaction %d: %v", id, err)
+		}
+		deleted = append(deleted, existing[0])
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].ID < deleted[j].ID
+	})
+	s.changes.publish(deleteChangeEvents(deleted)...)
+
+	return deleted, nil
+}
+
+// ClearTransactions elimina todas las transacciones (útil para testing)
+func (s *SQLiteDatabase) ClearTransactions() {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	deleted := s.GetAllTransactions()
+	// Sin historial ya no hay IDs que proteger, por lo que la secuencia vuelve a empezar como en MockDatabase
+	if _, err := s.db.Exec(`DELETE FROM transactions; DELETE FROM transaction_versions;
+		DELETE FROM sqlite_sequence WHERE name = 'transactions'`); err != nil {
+		log.Printf("Error clearing transactions: %v", err)
+		return
+	}
+
+	s.changes.publish(deleteChangeEvents(deleted)...)
+}
+
+// ImportTransactions guarda las transacciones de next dentro de una única transacción SQL, leyéndolas
+// de a una; con replace las tablas se vacían en la misma transacción. Ante cualquier error se hace rollback.
+func (s *SQLiteDatabase) ImportTransactions(next func() (models.UserTransaction, error), replace bool) (int, int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var events []ChangeEvent
+	if replace {
+		rows, err := tx.Query(`SELECT ` + sqliteTransactionColumns + ` FROM transactions ORDER BY id`)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to read transactions: %v", err)
+		}
+		events = deleteChangeEvents(s.scanTransactions(rows))
+
+		if _, err := tx.Exec(`DELETE FROM transactions; DELETE FROM transaction_versions`); err != nil {
+			return 0, 0, fmt.Errorf("failed to clear transactions: %v", err)
+		}
+	}
+
+	var inserted, overwritten int
+	changedAt := time.Now().UTC()
+	options := SaveOptions{ConflictPolicy: ConflictOverwrite}
+	for {
+		transaction, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if transaction.ID <= 0 {
+			return 0, 0, fmt.Errorf("invalid transaction ID %d: imported transactions keep their IDs", transaction.ID)
+		}
+
+		result, err := s.saveInTx(tx, transaction, options, changedAt)
+		if err != nil {
+			return 0, 0, err
+		}
+		if result.Outcome == SaveInserted {
+			inserted++
+		} else {
+			overwritten++
+		}
+		if event, changed := saveChangeEvent(result); changed {
+			events = append(events, event)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	s.changes.publish(events...)
+
+	return inserted, overwritten, nil
+}
+
+// Subscribe entrega los cambios confirmados en orden de secuencia.
+// Solo se publican los cambios hechos por este proceso y la secuencia no se persiste.
+func (s *SQLiteDatabase) Subscribe(fromSeq uint64, bufferSize int) (*Subscription, error) {
+	return s.changes.subscribe(fromSeq, bufferSize)
+}
+
+// LastSequence retorna el número de secuencia del último cambio publicado
+func (s *SQLiteDatabase) LastSequence() uint64 {
+	return s.changes.lastSequence()
+}
+
+// scanTransactions convierte las filas del query en transacciones y cierra rows
+func (s *SQLiteDatabase) scanTransactions(rows *sql.Rows) []models.UserTransaction {
+	defer rows.Close()
+
+	var transactions []models.UserTransaction
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			log.Printf("Error scanning transaction: %v", err)
+			continue
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating transactions: %v", err)
+	}
+
+	return transactions
+}
+
+// scanTransaction convierte la fila actual (columnas sqliteTransactionColumns) en transacción
+func scanTransaction(rows *sql.Rows) (models.UserTransaction, error) {
+	var transaction models.UserTransaction
+	var datetime, importedAt, deletedAt string
+	if err := rows.Scan(&transaction.ID, &transaction.UserID, &transaction.Amount, &datetime,
+		&transaction.MigrationID, &transaction.SourceFile, &transaction.SourceLine, &importedAt,
+		&transaction.Deleted, &deletedAt, &transaction.DeleteReason, &transaction.ExternalID); err != nil {
+		return transaction, err
+	}
+
+	parsed, err := time.Parse(sqliteTimeLayout, datetime)
+	if err != nil {
+		return transaction, fmt.Errorf("invalid datetime of transaction %d: %v", transaction.ID, err)
+	}
+	transaction.DateTime = parsed
+
+	if transaction.ImportedAt, err = parseOptionalTime(importedAt); err != nil {
+		return transaction, fmt.Errorf("invalid imported_at of transaction %d: %v", transaction.ID, err)
+	}
+	if transaction.DeletedAt, err = parseOptionalTime(deletedAt); err != nil {
+		return transaction, fmt.Errorf("invalid deleted_at of transaction %d: %v", transaction.ID, err)
+	}
+
+	return transaction, nil
+}
+
+// formatOptionalTime convierte una fecha opcional (imported_at, deleted_at) al formato de la columna (vacía si no tiene)
+func formatOptionalTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.UTC().Format(sqliteTimeLayout)
+}
+
+// parseOptionalTime convierte una columna de fecha opcional en fecha; vacía equivale a sin fecha
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(sqliteTimeLayout, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
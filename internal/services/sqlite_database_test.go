@@ -0,0 +1,321 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteDatabase(t *testing.T) (*SQLiteDatabase, string) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected no error opening sqlite database, got %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func TestSQLiteDatabase_Migrations(t *testing.T) {
+	db, path := newTestSQLiteDatabase(t)
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("Expected schema version %d, got %d", len(sqliteMigrations), version)
+	}
+	db.Close()
+
+	// Reabrir no debe volver a aplicar migraciones
+	reopened, err := NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening database, got %v", err)
+	}
+	defer reopened.Close()
+
+	version, err = reopened.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("Expected schema version %d after reopen, got %d", len(sqliteMigrations), version)
+	}
+}
+
+func TestSQLiteDatabase_SaveAndGetTransaction(t *testing.T) {
+	db, _ := newTestSQLiteDatabase(t)
+
+	transaction := models.UserTransaction{
+		ID:       1,
+		UserID:   1001,
+		Amount:   150.50,
+		DateTime: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+	}
+
+	if _, err := db.SaveTransaction(transaction); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	retrieved, exists := db.GetTransaction(1)
+	if !exists {
+		t.Fatal("Expected transaction to exist")
+	}
+	if retrieved != transaction {
+		t.Errorf("Expected %+v, got %+v", transaction, retrieved)
+	}
+
+	// Sobrescribir el mismo ID
+	transaction.Amount = 99.99
+	db.SaveTransaction(transaction)
+	retrieved, _ = db.GetTransaction(1)
+	if retrieved.Amount != 99.99 {
+		t.Errorf("Expected overwritten amount 99.99, got %.2f", retrieved.Amount)
+	}
+	if db.GetTransactionCount() != 1 {
+		t.Errorf("Expected 1 transaction after overwrite, got %d", db.GetTransactionCount())
+	}
+
+	// Auto-incremento de ID
+	saved, err := db.SaveTransaction(models.UserTransaction{UserID: 1002, Amount: -75.25, DateTime: time.Now()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if saved.ID == 0 {
+		t.Error("Expected auto-incremented ID, got 0")
+	}
+
+	if _, exists := db.GetTransaction(999); exists {
+		t.Error("Expected transaction to not exist")
+	}
+}
+
+func TestSQLiteDatabase_GetTransactionsByUserIDWithDateRange(t *testing.T) {
+	db, _ := newTestSQLiteDatabase(t)
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	transactions := []models.UserTransaction{
+		{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime},
+		{ID: 2, UserID: 1001, Amount: -75.25, DateTime: baseTime.Add(24 * time.Hour)},
+		{ID: 3, UserID: 1001, Amount: 200.00, DateTime: baseTime.Add(48 * time.Hour)},
+		{ID: 4, UserID: 1001, Amount: 50.75, DateTime: baseTime.Add(72 * time.Hour)},
+		{ID: 5, UserID: 1002, Amount: 10.00, DateTime: baseTime},
+	}
+	for _, tx := range transactions {
+		db.SaveTransaction(tx)
+	}
+
+	fromDate := baseTime.Add(12 * time.Hour)
+	toDate := baseTime.Add(60 * time.Hour)
+
+	if got := len(db.GetTransactionsByUserIDWithDateRange(1001, &fromDate, &toDate)); got != 2 {
+		t.Errorf("Expected 2 transactions in date range, got %d", got)
+	}
+	if got := len(db.GetTransactionsByUserIDWithDateRange(1001, &fromDate, nil)); got != 3 {
+		t.Errorf("Expected 3 transactions from date, got %d", got)
+	}
+	if got := len(db.GetTransactionsByUserIDWithDateRange(1001, nil, &toDate)); got != 3 {
+		t.Errorf("Expected 3 transactions to date, got %d", got)
+	}
+	if got := len(db.GetTransactionsByUserID(1001)); got != 4 {
+		t.Errorf("Expected 4 transactions for user 1001, got %d", got)
+	}
+	if got := len(db.GetAllTransactions()); got != 5 {
+		t.Errorf("Expected 5 total transactions, got %d", got)
+	}
+}
+
+func TestSQLiteDatabase_PersistsAcrossReopen(t *testing.T) {
+	db, path := newTestSQLiteDatabase(t)
+
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: time.Now()})
+	db.Close()
+
+	reopened, err := NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected no error reopening database, got %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.GetTransactionCount() != 1 {
+		t.Errorf("Expected 1 persisted transaction, got %d", reopened.GetTransactionCount())
+	}
+
+	reopened.ClearTransactions()
+	if reopened.GetTransactionCount() != 0 {
+		t.Errorf("Expected 0 transactions after clear, got %d", reopened.GetTransactionCount())
+	}
+}
+
+func TestMigrationService_ProcessCSVWithSQLite(t *testing.T) {
+	db, _ := newTestSQLiteDatabase(t)
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	csvContent := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,-75.25,2024-01-15 14:45:00`
+
+	stats, err := service.ProcessCSV(strings.NewReader(csvContent))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.SuccessRecords != 2 {
+		t.Errorf("Expected 2 success records, got %d", stats.SuccessRecords)
+	}
+
+	balance, err := NewUsersService(db).GetUserBalance(1001, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if float64(balance.Balance) != 150.50-75.25 {
+		t.Errorf("Expected balance %.2f, got %.2f", 150.50-75.25, float64(balance.Balance))
+	}
+}
+
+func TestSQLiteDatabase_HistoryMigrationBackfillsExistingRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Simular una base creada antes del historial: solo la migración v1 aplicada
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	statements := []string{
+		sqliteMigrations[0],
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`,
+		`INSERT INTO schema_migrations (version, applied_at) VALUES (1, '2024-01-01T00:00:00Z')`,
+		`INSERT INTO transactions (id, user_id, amount, datetime) VALUES (1, 1001, 150.5, '2024-01-15T10:30:00.000000000Z')`,
+	}
+	for _, statement := range statements {
+		if _, err := raw.Exec(statement); err != nil {
+			t.Fatalf("Expected no error preparing database, got %v", err)
+		}
+	}
+	raw.Close()
+
+	db, err := NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected no error migrating database, got %v", err)
+	}
+	defer db.Close()
+
+	versions := db.GetTransactionHistory(1)
+	if len(versions) != 1 || versions[0].Version != 1 || versions[0].Transaction.Amount != 150.5 {
+		t.Fatalf("Expected existing row backfilled as version 1, got %+v", versions)
+	}
+
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 99.99, DateTime: time.Now()})
+	if versions := db.GetTransactionHistory(1); len(versions) != 2 || versions[1].Version != 2 {
+		t.Errorf("Expected a second version after overwrite, got %+v", versions)
+	}
+}
+
+func TestSQLiteDatabase_ProvenanceMigrationBackfillsMigrationID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Simular una base creada antes de la procedencia: migraciones v1 y v2 aplicadas
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	statements := []string{
+		sqliteMigrations[0],
+		sqliteMigrations[1],
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`,
+		`INSERT INTO schema_migrations (version, applied_at) VALUES (1, '2024-01-01T00:00:00Z'), (2, '2024-01-01T00:00:00Z')`,
+		`INSERT INTO transactions (id, user_id, amount, datetime) VALUES (1, 1001, 150.5, '2024-01-15T10:30:00.000000000Z')`,
+		`INSERT INTO transaction_versions (transaction_id, version, user_id, amount, datetime, changed_at, migration_id)
+			VALUES (1, 2, 1001, 150.5, '2024-01-15T10:30:00.000000000Z', '2024-01-15T10:30:00.000000000Z', 'mig-old')`,
+	}
+	for _, statement := range statements {
+		if _, err := raw.Exec(statement); err != nil {
+			t.Fatalf("Expected no error preparing database, got %v", err)
+		}
+	}
+	raw.Close()
+
+	db, err := NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected no error migrating database, got %v", err)
+	}
+	defer db.Close()
+
+	transactions := db.GetTransactionsByMigrationID("mig-old")
+	if len(transactions) != 1 || transactions[0].ID != 1 || transactions[0].ImportedAt != nil {
+		t.Errorf("Expected transaction 1 attributed to mig-old without import time, got %+v", transactions)
+	}
+}
+
+func TestSQLiteDatabase_DoesNotReusePurgedIDs(t *testing.T) {
+	db, _ := newTestSQLiteDatabase(t)
+
+	first, err := db.SaveTransaction(models.UserTransaction{UserID: 1001, Amount: 100, DateTime: time.Now()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := db.DeleteTransactions([]int{first.ID}); err != nil {
+		t.Fatalf("Expected no error purging, got %v", err)
+	}
+
+	second, err := db.SaveTransaction(models.UserTransaction{UserID: 2002, Amount: 50, DateTime: time.Now()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if second.ID == first.ID {
+		t.Fatalf("Expected a new ID after purging %d, got the same one", first.ID)
+	}
+
+	versions := db.GetTransactionHistory(second.ID)
+	if len(versions) != 1 || versions[0].Version != 1 || versions[0].Transaction.UserID != 2002 {
+		t.Errorf("Expected only the new row's version in its history, got %+v", versions)
+	}
+}
+
+func TestSQLiteDatabase_AutoincrementMigrationSkipsIDsInHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Simular una base v5 donde la fila con el ID más alto ya fue purgada pero su historial sigue
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	statements := append([]string{}, sqliteMigrations[:5]...)
+	statements = append(statements,
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`,
+		`INSERT INTO schema_migrations (version, applied_at) VALUES (1, '2024-01-01T00:00:00Z'), (2, '2024-01-01T00:00:00Z'),
+			(3, '2024-01-01T00:00:00Z'), (4, '2024-01-01T00:00:00Z'), (5, '2024-01-01T00:00:00Z')`,
+		`INSERT INTO transactions (id, user_id, amount, datetime) VALUES (1, 1001, 150.5, '2024-01-15T10:30:00.000000000Z')`,
+		`INSERT INTO transaction_versions (transaction_id, version, user_id, amount, datetime, changed_at)
+			VALUES (1, 1, 1001, 150.5, '2024-01-15T10:30:00.000000000Z', '2024-01-15T10:30:00.000000000Z'),
+			(7, 1, 1002, 20, '2024-01-16T10:30:00.000000000Z', '2024-01-16T10:30:00.000000000Z')`,
+	)
+	for _, statement := range statements {
+		if _, err := raw.Exec(statement); err != nil {
+			t.Fatalf("Expected no error preparing database, got %v", err)
+		}
+	}
+	raw.Close()
+
+	db, err := NewSQLiteDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected no error migrating database, got %v", err)
+	}
+	defer db.Close()
+
+	if existing, found := db.GetTransaction(1); !found || existing.Amount != 150.5 {
+		t.Fatalf("Expected transaction 1 kept by the migration, got %+v (found=%v)", existing, found)
+	}
+
+	saved, err := db.SaveTransaction(models.UserTransaction{UserID: 2002, Amount: 50, DateTime: time.Now()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if saved.ID <= 7 {
+		t.Errorf("Expected a new ID above the purged ID 7, got %d", saved.ID)
+	}
+}
@@ -0,0 +1,491 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStatementTokenSize tamaño máximo de una etiqueta o valor OFX y de una línea QIF
+const maxStatementTokenSize = 64 << 10
+
+// LoadAccountUsers lee de un archivo JSON el usuario al que pertenece cada cuenta de los extractos:
+// {"000123456789": 1001, "Checking": 1002}. La cuenta es el ACCTID en OFX y el nombre de la cuenta en QIF.
+func LoadAccountUsers(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statement accounts: %v", err)
+	}
+
+	var accountUsers map[string]int
+	if err := json.Unmarshal(data, &accountUsers); err != nil {
+		return nil, fmt.Errorf("invalid statement accounts in %s: %v", path, err)
+	}
+	for account, userID := range accountUsers {
+		if userID <= 0 {
+			return nil, fmt.Errorf("statement account %q: user_id must be a positive number", account)
+		}
+	}
+	return accountUsers, nil
+}
+
+// accountLookup asocia las cuentas de un extracto con el UserID de sus transacciones
+type accountLookup struct {
+	users         map[string]int
+	defaultUserID int // Usuario de las cuentas que no están en users (0 = ninguno)
+}
+
+func (a accountLookup) userFor(account string) (int, error) {
+	if userID, exists := a.users[strings.TrimSpace(account)]; exists {
+		return userID, nil
+	}
+	if a.defaultUserID > 0 {
+		return a.defaultUserID, nil
+	}
+	if strings.TrimSpace(account) == "" {
+		return 0, fmt.Errorf("statement does not name an account and no default_user_id was given")
+	}
+	return 0, fmt.Errorf("no user configured for account %q", account)
+}
+
+// statementTransactionID deriva el ID de una transacción de su cuenta y su clave en el extracto
+// (el FITID en OFX). Importar el mismo extracto de nuevo produce los mismos IDs, así que los
+// duplicados se resuelven con la política de conflictos de la migración. El hash puede chocar con
+// otro ID: la transacción lleva además statementExternalID, y una escritura cuyo ID ya pertenece a
+// otra clave se rechaza (ErrExternalIDCollision).
+func statementTransactionID(account, key string) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(strings.TrimSpace(account)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key))
+
+	// 53 bits para que el ID se represente sin pérdida como número en JSON
+	id := int(hash.Sum64() & (1<<53 - 1))
+	if id == 0 {
+		return 1
+	}
+	return id
+}
+
+// statementExternalID clave de una transacción de extracto: formato, cuenta y clave en el extracto
+func statementExternalID(format FileFormat, account, key string) string {
+	return fmt.Sprintf("%s:%s:%s", format, strings.TrimSpace(account), key)
+}
+
+// ofxElement etiqueta OFX con el texto que la sigue (el valor en los elementos simples)
+type ofxElement struct {
+	name    string
+	closing bool
+	value   string
+	line    int
+}
+
+// ofxTransaction campos de un STMTTRN mientras se lee
+type ofxTransaction struct {
+	line                  int
+	fitID, amount, posted string
+}
+
+// ofxSource lee las transacciones (STMTTRN) de un extracto OFX/QFX. Acepta tanto OFX 1.x (SGML,
+// sin etiquetas de cierre en los elementos simples) como OFX 2.x (XML).
+type ofxSource struct {
+	reader   *bufio.Reader
+	accounts accountLookup
+	line     int
+	account  string // ACCTID del estado de cuenta actual
+	current  *ofxTransaction
+}
+
+// newOFXSource salta el header del archivo hasta el elemento raíz <OFX>
+func newOFXSource(reader io.Reader, accounts accountLookup) (*ofxSource, error) {
+	source := &ofxSource{reader: bufio.NewReader(reader), accounts: accounts, line: 1}
+
+	header, tooLong, err := readUntil(source.reader, '<', maxStatementTokenSize)
+	if err == io.EOF && len(bytes.TrimSpace(header)) == 0 {
+		return nil, fmt.Errorf("OFX file is empty")
+	}
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading OFX: %v", err)
+	}
+	if tooLong || err == io.EOF {
+		return nil, fmt.Errorf("invalid OFX file: missing <OFX> element")
+	}
+	source.line += bytes.Count(header, []byte("\n"))
+
+	for {
+		element, err := source.nextElement()
+		if err == io.EOF {
+			return nil, fmt.Errorf("invalid OFX file: missing <OFX> element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if element.name == "OFX" && !element.closing {
+			return source, nil
+		}
+	}
+}
+
+// nextElement lee la siguiente etiqueta (el '<' inicial ya fue consumido) y el texto hasta la próxima.
+// Las instrucciones de procesamiento y los comentarios se saltan.
+func (s *ofxSource) nextElement() (ofxElement, error) {
+	for {
+		tag, tooLong, err := readUntil(s.reader, '>', maxStatementTokenSize)
+		if err == io.EOF {
+			return ofxElement{}, io.EOF
+		}
+		if err != nil {
+			return ofxElement{}, fmt.Errorf("error reading OFX: %v", err)
+		}
+		if tooLong {
+			return ofxElement{}, fmt.Errorf("invalid OFX file: tag at line %d exceeds %d bytes", s.line, maxStatementTokenSize)
+		}
+		element := ofxElement{line: s.line}
+		s.line += bytes.Count(tag, []byte("\n"))
+
+		text, tooLong, err := readUntil(s.reader, '<', maxStatementTokenSize)
+		if err != nil && err != io.EOF {
+			return ofxElement{}, fmt.Errorf("error reading OFX: %v", err)
+		}
+		if tooLong {
+			return ofxElement{}, fmt.Errorf("invalid OFX file: value at line %d exceeds %d bytes", s.line, maxStatementTokenSize)
+		}
+		s.line += bytes.Count(text, []byte("\n"))
+
+		name := strings.TrimSpace(strings.TrimSuffix(string(tag), ">"))
+		if name == "" || name[0] == '?' || name[0] == '!' {
+			continue
+		}
+		if name[0] == '/' {
+			element.closing = true
+			name = name[1:]
+		}
+		if fields := strings.Fields(strings.TrimSuffix(name, "/")); len(fields) > 0 {
+			element.name = strings.ToUpper(fields[0])
+		}
+		element.value = html.UnescapeString(strings.TrimSpace(strings.TrimSuffix(string(text), "<")))
+		return element, nil
+	}
+}
+
+func (s *ofxSource) next() (sourceRecord, error) {
+	for {
+		element, err := s.nextElement()
+		if err == io.EOF {
+			if s.current != nil {
+				return sourceRecord{}, fmt.Errorf("invalid OFX file: unterminated STMTTRN at line %d", s.current.line)
+			}
+			return sourceRecord{}, io.EOF
+		}
+		if err != nil {
+			return sourceRecord{}, err
+		}
+
+		switch {
+		case element.name == "STMTTRN" && !element.closing:
+			s.current = &ofxTransaction{line: element.line}
+		case element.name == "STMTTRN" && s.current != nil:
+			current := s.current
+			s.current = nil
+			transaction, err := s.parseTransaction(current)
+			return sourceRecord{line: current.line, transaction: transaction, err: err}, nil
+		case element.closing:
+			continue
+		case s.current != nil:
+			// Un ACCTID dentro del STMTTRN es la cuenta destino de una transferencia y no cambia la cuenta
+			switch element.name {
+			case "FITID":
+				s.current.fitID = element.value
+			case "TRNAMT":
+				s.current.amount = element.value
+			case "DTPOSTED":
+				s.current.posted = element.value
+			}
+		case element.name == "ACCTID":
+			s.account = element.value
+		}
+	}
+}
+
+// parseTransaction convierte un STMTTRN en una transacción del usuario dueño de la cuenta
+func (s *ofxSource) parseTransaction(current *ofxTransaction) (models.UserTransaction, error) {
+	if current.fitID == "" {
+		return models.UserTransaction{}, fmt.Errorf("missing FITID at line %d", current.line)
+	}
+
+	userID, err := s.accounts.userFor(s.account)
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("%v at line %d", err, current.line)
+	}
+
+	amount, err := parseStatementAmount(current.amount)
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("invalid TRNAMT at line %d: %v", current.line, err)
+	}
+
+	datetime, err := parseOFXDate(current.posted)
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("invalid DTPOSTED at line %d: %v", current.line, err)
+	}
+
+	return models.UserTransaction{
+		ID:         statementTransactionID(s.account, current.fitID),
+		UserID:     userID,
+		Amount:     amount,
+		DateTime:   datetime,
+		ExternalID: statementExternalID(FormatOFX, s.account, current.fitID),
+	}, nil
+}
+
+// parseStatementAmount convierte un monto con signo. Acepta coma decimal si no hay punto ("-20,50").
+func parseStatementAmount(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty amount")
+	}
+	if !strings.Contains(value, ".") && strings.Count(value, ",") == 1 {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// parseOFXDate convierte una fecha OFX, YYYYMMDD[HHMM[SS[.XXX]]][gmt offset[:tz name]], a UTC.
+// Sin zona horaria la fecha se toma en UTC.
+func parseOFXDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	location := time.UTC
+	if start := strings.IndexByte(value, '['); start >= 0 {
+		zone := strings.TrimSuffix(value[start+1:], "]")
+		value = value[:start]
+		offsetText, _, _ := strings.Cut(zone, ":")
+		offset, err := strconv.ParseFloat(strings.TrimSpace(offsetText), 64)
+		if err != nil || offset < -14 || offset > 14 {
+			return time.Time{}, fmt.Errorf("invalid time zone %q", zone)
+		}
+		location = time.FixedZone("", int(offset*3600))
+	}
+	if dot := strings.IndexByte(value, '.'); dot >= 0 {
+		value = value[:dot] // Milisegundos
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("expected YYYYMMDD[HHMMSS], got %q", value)
+	}
+	datetime, err := time.ParseInLocation(layout, value, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return datetime.UTC(), nil
+}
+
+// qifTransactionTypes secciones !Type de QIF con transacciones de cuenta; las demás (inversiones,
+// categorías, clases, transacciones memorizadas) se saltan
+var qifTransactionTypes = map[string]bool{"BANK": true, "CASH": true, "CCARD": true, "OTH A": true, "OTH L": true}
+
+// qifSection contenido de la sección QIF actual
+type qifSection int
+
+const (
+	qifSkip         qifSection = iota // Sección sin transacciones de cuenta
+	qifAccount                        // Bloque !Account: nombre de la cuenta de las transacciones que siguen
+	qifTransactions                   // Transacciones de cuenta
+)
+
+// qifSource lee las transacciones de un archivo QIF. Cada entrada es una serie de líneas con
+// un código de campo en la primera letra, terminada en "^".
+type qifSource struct {
+	scanner  *bufio.Scanner
+	accounts accountLookup
+	line     int
+	section  qifSection
+	account  string         // Cuenta del último bloque !Account
+	seen     map[string]int // Entradas idénticas ya leídas, para distinguir sus IDs
+}
+
+// newQIFSource exige que el archivo empiece con un header (!Type o !Account)
+func newQIFSource(reader io.Reader, accounts accountLookup) (*qifSource, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 4096), maxStatementTokenSize)
+	source := &qifSource{scanner: scanner, accounts: accounts, seen: make(map[string]int)}
+
+	for source.scanner.Scan() {
+		source.line++
+		text := strings.TrimSpace(strings.TrimPrefix(source.scanner.Text(), "\ufeff"))
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "!") {
+			return nil, fmt.Errorf("invalid QIF file: expected a !Type or !Account header at line %d", source.line)
+		}
+		source.readHeader(text)
+		return source, nil
+	}
+	if err := source.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading QIF: %v", err)
+	}
+	return nil, fmt.Errorf("QIF file is empty")
+}
+
+// readHeader cambia de sección según una línea "!..."
+func (s *qifSource) readHeader(text string) {
+	header := strings.ToUpper(text)
+	switch {
+	case header == "!ACCOUNT":
+		s.section = qifAccount
+	case strings.HasPrefix(header, "!TYPE:"):
+		accountType := strings.TrimSpace(strings.TrimPrefix(header, "!TYPE:"))
+		if qifTransactionTypes[accountType] {
+			s.section = qifTransactions
+		} else {
+			s.section = qifSkip
+		}
+	}
+	// !Option y !Clear no cambian la sección
+}
+
+func (s *qifSource) next() (sourceRecord, error) {
+	fields := make(map[byte]string)
+	start := 0
+
+	for {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return sourceRecord{}, fmt.Errorf("error reading QIF: %v", err)
+			}
+			// Una última entrada sin "^" también cuenta
+			if start > 0 && s.section == qifTransactions {
+				return s.record(fields, start), nil
+			}
+			return sourceRecord{}, io.EOF
+		}
+		s.line++
+
+		text := strings.TrimSpace(s.scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] == '!' {
+			s.readHeader(text)
+			fields, start = make(map[byte]string), 0
+			continue
+		}
+		if start == 0 {
+			start = s.line
+		}
+
+		if text[0] != '^' {
+			// Solo se guarda la primera aparición de cada código (las divisiones S/$/E se ignoran)
+			if _, exists := fields[text[0]]; !exists {
+				fields[text[0]] = strings.TrimSpace(text[1:])
+			}
+			continue
+		}
+
+		switch s.section {
+		case qifAccount:
+			s.account = fields['N']
+		case qifTransactions:
+			return s.record(fields, start), nil
+		}
+		fields, start = make(map[byte]string), 0
+	}
+}
+
+// record convierte una entrada en una transacción del usuario dueño de la cuenta
+func (s *qifSource) record(fields map[byte]string, line int) sourceRecord {
+	transaction, err := s.parseTransaction(fields, line)
+	return sourceRecord{line: line, transaction: transaction, err: err}
+}
+
+func (s *qifSource) parseTransaction(fields map[byte]string, line int) (models.UserTransaction, error) {
+	amountText, exists := fields['T']
+	if !exists {
+		amountText = fields['U']
+	}
+
+	// El ID sale del contenido de la entrada; las entradas idénticas se numeran por orden de aparición
+	key := strings.Join([]string{fields['D'], amountText, fields['P'], fields['N']}, "|")
+	s.seen[key]++
+	key += "|" + strconv.Itoa(s.seen[key])
+
+	userID, err := s.accounts.userFor(s.account)
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("%v at line %d", err, line)
+	}
+
+	if fields['D'] == "" {
+		return models.UserTransaction{}, fmt.Errorf("missing date at line %d", line)
+	}
+	datetime, err := parseQIFDate(fields['D'])
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("invalid date at line %d: %v", line, err)
+	}
+
+	// Los miles se separan con coma en QIF ("-1,234.56")
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(amountText), ",", ""), 64)
+	if err != nil {
+		return models.UserTransaction{}, fmt.Errorf("invalid amount at line %d: %v", line, err)
+	}
+
+	return models.UserTransaction{
+		ID:         statementTransactionID(s.account, key),
+		UserID:     userID,
+		Amount:     amount,
+		DateTime:   datetime,
+		ExternalID: statementExternalID(FormatQIF, s.account, key),
+	}, nil
+}
+
+// parseQIFDate convierte una fecha QIF en orden mes/día/año: 1/15/2024, 01/15/24, 1/15'24 (el apóstrofo
+// indica años desde 2000) o YYYY-MM-DD
+func parseQIFDate(value string) (time.Time, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+	if datetime, err := time.Parse("2006-01-02", value); err == nil {
+		return datetime, nil
+	}
+
+	apostrophe := strings.Contains(value, "'")
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '\'' || r == '-' || r == '.' })
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("expected MM/DD/YYYY, got %q", value)
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expected MM/DD/YYYY, got %q", value)
+		}
+		numbers[i] = number
+	}
+
+	month, day, year := numbers[0], numbers[1], numbers[2]
+	if len(parts[2]) <= 2 {
+		if apostrophe || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	datetime := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if datetime.Month() != time.Month(month) || datetime.Day() != day {
+		return time.Time{}, fmt.Errorf("day out of range in %q", value)
+	}
+	return datetime, nil
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationService_ProcessFileOFX(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+	service.SetAccountUsers(map[string]int{"000123456789": 1001})
+
+	// OFX 1.x: header SGML y elementos simples sin cierre; una transferencia con cuenta destino,
+	// un monto inválido y un STMTTRN sin FITID
+	content := `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKACCTFROM><BANKID>121000248<ACCTID>000123456789<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240115103000.000[-3:ART]
+<TRNAMT>-20.50
+<FITID>2024011501
+<NAME>Coffee &amp; Co
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>XFER
+<DTPOSTED>20240116
+<TRNAMT>1500,00
+<FITID>2024011602
+<BANKACCTTO><BANKID>1<ACCTID>999</BANKACCTTO>
+</STMTTRN>
+<STMTTRN>
+<DTPOSTED>20240117
+<TRNAMT>abc
+<FITID>2024011703
+</STMTTRN>
+<STMTTRN>
+<DTPOSTED>20240118
+<TRNAMT>-1
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+
+	stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatOFX, SourceFile: "statement.ofx"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 4 || stats.SuccessRecords != 2 || stats.ErrorRecords != 2 {
+		t.Errorf("Expected 4 records, 2 success and 2 errors, got %+v", stats)
+	}
+	for i, expected := range []string{"Line 24: invalid TRNAMT at line 24", "Line 29: missing FITID at line 29"} {
+		if i >= len(stats.Errors) || !strings.HasPrefix(stats.Errors[i], expected) {
+			t.Errorf("Expected error %d to start with %q, got %v", i, expected, stats.Errors)
+		}
+	}
+
+	// Fecha con zona convertida a UTC y monto con signo; la transferencia sigue siendo de la cuenta del extracto
+	debit, exists := db.GetTransaction(statementTransactionID("000123456789", "2024011501"))
+	if !exists || debit.UserID != 1001 || debit.Amount != -20.50 || debit.SourceLine != 10 ||
+		!debit.DateTime.Equal(time.Date(2024, 1, 15, 13, 30, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected transaction %+v", debit)
+	}
+	if transfer, _ := db.GetTransaction(statementTransactionID("000123456789", "2024011602")); transfer.UserID != 1001 || transfer.Amount != 1500 {
+		t.Errorf("Unexpected transfer %+v", transfer)
+	}
+
+	// Importar de nuevo el mismo extracto repite los FITID
+	stats, err = service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatOFX, ConflictPolicy: ConflictSkip})
+	if err != nil || stats.SkippedRecords != 2 || stats.SuccessRecords != 0 || db.GetTransactionCount() != 2 {
+		t.Errorf("Expected the 2 imported transactions to be skipped, got %+v, err %v", stats, err)
+	}
+
+	for name, invalid := range map[string]string{
+		"empty":        "",
+		"not ofx":      "id,user_id,amount,datetime\n",
+		"unterminated": "<OFX><STMTTRN><FITID>1",
+	} {
+		if _, err := service.ProcessFile(strings.NewReader(invalid), MigrationOptions{Format: FormatOFX}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestMigrationService_ProcessFileOFXIDCollision(t *testing.T) {
+	statement := `<OFX><BANKACCTFROM><ACCTID>000123456789</BANKACCTFROM>
+<STMTTRN><DTPOSTED>20240115<TRNAMT>-20.50<FITID>2024011501</STMTTRN>
+</OFX>`
+	id := statementTransactionID("000123456789", "2024011501")
+
+	sqliteDB, err := NewSQLiteDatabase(":memory:")
+	if err != nil {
+		t.Fatalf("Expected no error opening SQLite, got %v", err)
+	}
+	defer sqliteDB.Close()
+
+	for name, db := range map[string]TransactionRepository{"mock": NewMockDatabase(), "sqlite": sqliteDB} {
+		t.Run(name, func(t *testing.T) {
+			service := NewMigrationService(db)
+			service.GetReportService().SetForceMockMode(true)
+			service.SetAccountUsers(map[string]int{"000123456789": 1001})
+
+			// Una transacción de otro origen ya ocupa el ID derivado del FITID
+			unrelated := models.UserTransaction{ID: id, UserID: 2002, Amount: 99, DateTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			if _, err := db.SaveTransaction(unrelated); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// Ninguna política permite que el extracto la sobrescriba u omita en silencio
+			for _, policy := range []ConflictPolicy{ConflictOverwrite, ConflictSkip} {
+				stats, err := service.ProcessFile(strings.NewReader(statement), MigrationOptions{Format: FormatOFX, ConflictPolicy: policy})
+				if err != nil {
+					t.Fatalf("%s: expected no error, got %v", policy, err)
+				}
+				if stats.ErrorRecords != 1 || stats.ConflictRecords != 1 || len(stats.Errors) != 1 ||
+					!strings.Contains(stats.Errors[0], "different external ID") {
+					t.Errorf("%s: expected an external ID collision, got %+v", policy, stats)
+				}
+			}
+			if stored, _ := db.GetTransaction(id); stored.UserID != 2002 || stored.Amount != 99 {
+				t.Errorf("Expected the unrelated transaction untouched, got %+v", stored)
+			}
+
+			// Con el ID libre el extracto se importa con su clave, y reimportarlo actualiza la misma transacción
+			db.DeleteTransactions([]int{id})
+			for i := 0; i < 2; i++ {
+				stats, err := service.ProcessFile(strings.NewReader(statement), MigrationOptions{Format: FormatOFX})
+				if err != nil || stats.SuccessRecords != 1 {
+					t.Fatalf("Expected the statement to be imported, got %+v, err %v", stats, err)
+				}
+			}
+			if stored, _ := db.GetTransaction(id); stored.ExternalID != "ofx:000123456789:2024011501" || stored.Amount != -20.50 {
+				t.Errorf("Unexpected statement transaction %+v", stored)
+			}
+			if history := db.GetTransactionHistory(id); len(history) == 0 || history[len(history)-1].Transaction.ExternalID == "" {
+				t.Errorf("Expected the external ID in the history, got %+v", history)
+			}
+
+			// Una fila sin clave tampoco puede tomar el ID de la transacción del extracto
+			_, err := db.SaveTransactionWithOptions(unrelated, SaveOptions{ConflictPolicy: ConflictOverwrite})
+			if !errors.Is(err, ErrExternalIDCollision) || !errors.Is(err, ErrTransactionConflict) {
+				t.Errorf("Expected ErrExternalIDCollision, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMigrationService_ProcessFileOFXUnknownAccount(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// OFX 2.x (XML) de una tarjeta de crédito cuya cuenta no está configurada
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX>
+  <CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+    <CCACCTFROM><ACCTID>4111222233334444</ACCTID></CCACCTFROM>
+    <BANKTRANLIST>
+      <STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20240115</DTPOSTED><TRNAMT>-42.10</TRNAMT><FITID>A1</FITID></STMTTRN>
+    </BANKTRANLIST>
+  </CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1>
+</OFX>`
+
+	stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatOFX})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.ErrorRecords != 1 || !strings.Contains(stats.Errors[0], `no user configured for account "4111222233334444" at line 7`) {
+		t.Errorf("Expected unknown account error, got %+v", stats)
+	}
+
+	// default_user_id asigna las cuentas sin configurar
+	stats, err = service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatOFX, DefaultUserID: 1005})
+	if err != nil || stats.SuccessRecords != 1 {
+		t.Fatalf("Expected 1 success, got %+v, err %v", stats, err)
+	}
+	if tx, _ := db.GetTransaction(statementTransactionID("4111222233334444", "A1")); tx.UserID != 1005 || tx.Amount != -42.10 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+}
+
+func TestMigrationService_ProcessFileQIF(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+	service.SetAccountUsers(map[string]int{"Checking": 1002})
+
+	// Una lista de categorías que se ignora, la cuenta, dos entradas idénticas, miles con coma,
+	// una fecha inválida y una última entrada sin "^"
+	content := `!Type:Cat
+NGroceries
+E
+^
+!Account
+NChecking
+TBank
+^
+!Type:Bank
+D01/15/2024
+T-1,234.56
+PRent
+^
+D1/16'24
+T-5.00
+PCoffee
+^
+D1/16'24
+T-5.00
+PCoffee
+^
+D02/30/2024
+T10
+^
+D2024-01-20
+U300
+PSalary`
+
+	stats, err := service.ProcessFile(strings.NewReader(content), MigrationOptions{Format: FormatQIF})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 5 || stats.SuccessRecords != 4 || stats.ErrorRecords != 1 {
+		t.Errorf("Expected 5 records, 4 success and 1 error, got %+v", stats)
+	}
+	if len(stats.Errors) != 1 || !strings.HasPrefix(stats.Errors[0], "Line 22: invalid date at line 22") {
+		t.Errorf("Expected invalid date error, got %v", stats.Errors)
+	}
+
+	transactions := db.GetTransactionsByUserID(1002)
+	if len(transactions) != 4 {
+		t.Fatalf("Expected 4 transactions for user 1002, got %d", len(transactions))
+	}
+	rent, _ := db.GetTransaction(statementTransactionID("Checking", "01/15/2024|-1,234.56|Rent||1"))
+	if rent.Amount != -1234.56 || rent.SourceLine != 10 || !rent.DateTime.Equal(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected transaction %+v", rent)
+	}
+
+	// Sin cuenta ni default_user_id cada entrada es un error
+	stats, err = service.ProcessFile(strings.NewReader("!Type:Bank\nD01/15/2024\nT-1\n^\n"), MigrationOptions{Format: FormatQIF})
+	if err != nil || stats.ErrorRecords != 1 || !strings.Contains(stats.Errors[0], "default_user_id") {
+		t.Errorf("Expected missing account error, got %+v, err %v", stats, err)
+	}
+
+	for name, invalid := range map[string]string{"empty": "\n\n", "no header": "D01/15/2024\nT-1\n^\n"} {
+		if _, err := service.ProcessFile(strings.NewReader(invalid), MigrationOptions{Format: FormatQIF}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseStatementDates(t *testing.T) {
+	ofxTests := map[string]time.Time{
+		"20240115":                   time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		"202401151030":               time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+		"20240115103000.123[-5:EST]": time.Date(2024, 1, 15, 15, 30, 0, 0, time.UTC),
+		"20240115103000[+5.5:IST]":   time.Date(2024, 1, 15, 5, 0, 0, 0, time.UTC),
+	}
+	for value, expected := range ofxTests {
+		if got, err := parseOFXDate(value); err != nil || !got.Equal(expected) {
+			t.Errorf("parseOFXDate(%q) = %v, %v; expected %v", value, got, err, expected)
+		}
+	}
+
+	qifTests := map[string]time.Time{
+		"01/15/2024": time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		"1/15/24":    time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		" 1/15'24":   time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		"12/31/99":   time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC),
+		"2024-01-15": time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+	}
+	for value, expected := range qifTests {
+		if got, err := parseQIFDate(value); err != nil || !got.Equal(expected) {
+			t.Errorf("parseQIFDate(%q) = %v, %v; expected %v", value, got, err, expected)
+		}
+	}
+
+	for _, invalid := range []string{"2024011", "20240115[abc]", "20241315"} {
+		if _, err := parseOFXDate(invalid); err == nil {
+			t.Errorf("parseOFXDate(%q): expected error", invalid)
+		}
+	}
+	for _, invalid := range []string{"13/01/2024", "15.01", "a/b/c"} {
+		if _, err := parseQIFDate(invalid); err == nil {
+			t.Errorf("parseQIFDate(%q): expected error", invalid)
+		}
+	}
+}
+
+func TestSniffFileFormat(t *testing.T) {
+	tests := map[string]FileFormat{
+		"OFXHEADER:100\nDATA:OFXSGML":                        FormatOFX,
+		"<?xml version=\"1.0\"?>\n<?OFX OFXHEADER=\"200\"?>": FormatOFX,
+		"\ufeff!Type:Bank\nD01/15/2024":                      FormatQIF,
+		"!Account\nNChecking":                                FormatQIF,
+		"id,user_id,amount,datetime":                         "",
+	}
+	for prefix, expected := range tests {
+		if got := SniffFileFormat([]byte(prefix)); got != expected {
+			t.Errorf("SniffFileFormat(%q) = %q, expected %q", prefix, got, expected)
+		}
+	}
+}
+
+func TestLoadAccountUsers(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "accounts.json")
+	os.WriteFile(valid, []byte(`{"000123456789": 1001, "Checking": 1002}`), 0644)
+
+	accountUsers, err := LoadAccountUsers(valid)
+	if err != nil || accountUsers["Checking"] != 1002 {
+		t.Errorf("Unexpected accounts %v, err %v", accountUsers, err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"Checking": 0}`), 0644)
+	if _, err := LoadAccountUsers(invalid); err == nil {
+		t.Error("Expected error for non-positive user_id")
+	}
+	if _, err := LoadAccountUsers(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TransactionRepository define las operaciones de almacenamiento de transacciones
+// que necesitan los servicios. MockDatabase es la implementación en memoria y
+// SQLiteDatabase la implementación persistente.
+type TransactionRepository interface {
+	// SaveTransaction guarda una transacción, asignando un ID si no lo tiene y sobrescribiendo si ya existe
+	SaveTransaction(transaction models.UserTransaction) (models.UserTransaction, error)
+
+	// SaveTransactionWithOptions guarda una transacción aplicando la política de conflictos indicada
+	SaveTransactionWithOptions(transaction models.UserTransaction, options SaveOptions) (SaveResult, error)
+
+	// SaveTransactions guarda un lote de forma atómica: se guardan todas las transacciones o ninguna.
+	// Si alguna falla retorna un *BatchSaveError con el error de cada transacción rechazada.
+	SaveTransactions(transactions []models.UserTransaction, options SaveOptions) ([]SaveResult, error)
+
+	// GetTransaction obtiene una transacción por ID
+	GetTransaction(id int) (models.UserTransaction, bool)
+
+	// GetTransactionsByUserID obtiene todas las transacciones de un usuario
+	GetTransactionsByUserID(userID int) []models.UserTransaction
+
+	// GetTransactionsByUserIDWithDateRange obtiene las transacciones de un usuario filtradas por rango de fechas
+	GetTransactionsByUserIDWithDateRange(userID int, fromDate, toDate *time.Time) []models.UserTransaction
+
+	// GetTransactionHistory obtiene todas las versiones de una transacción, de la más antigua a la actual
+	GetTransactionHistory(id int) []models.TransactionVersion
+
+	// GetTransactionsByMigrationID obtiene las transacciones vigentes importadas por una migración,
+	// en el orden de las líneas del archivo de origen
+	GetTransactionsByMigrationID(migrationID string) []models.UserTransaction
+
+	// GetAllTransactions obtiene todas las transacciones
+	GetAllTransactions() []models.UserTransaction
+
+	// ForEachTransaction recorre todas las transacciones ordenadas por ID sobre una vista consistente,
+	// sin cargarlas juntas en memoria. fn no debe usar el repositorio; si retorna un error el recorrido se detiene.
+	ForEachTransaction(fn func(transaction models.UserTransaction) error) error
+
+	// GetTransactionCount retorna el número total de transacciones
+	GetTransactionCount() int
+
+	// SoftDeleteTransaction marca la transacción como eliminada con el motivo indicado, guardando una versión nueva.
+	// Retorna ErrTransactionNotFound si no existe y ErrTransactionAlreadyDeleted si ya estaba eliminada.
+	SoftDeleteTransaction(id int, reason string) (models.UserTransaction, error)
+
+	// DeleteTransactions elimina las transacciones indicadas y retorna las que existían, ordenadas por ID.
+	// El historial de versiones se conserva.
+	DeleteTransactions(ids []int) ([]models.UserTransaction, error)
+
+	// DeleteTransactionsIf elimina las transacciones indicadas que cumplen condition, evaluada sobre la
+	// versión vigente en la misma operación que el borrado: una escritura concurrente no puede colarse
+	// entre la verificación y la eliminación. Retorna las eliminadas, ordenadas por ID.
+	DeleteTransactionsIf(ids []int, condition func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error)
+
+	// ClearTransactions limpia todas las transacciones
+	ClearTransactions()
+
+	// ImportTransactions guarda de forma atómica las transacciones que entrega next hasta io.EOF,
+	// sobrescribiendo los IDs existentes. Con replace elimina antes todas las transacciones y su historial.
+	// Si next o alguna escritura falla el almacén queda sin cambios.
+	ImportTransactions(next func() (models.UserTransaction, error), replace bool) (inserted, overwritten int, err error)
+
+	// Subscribe entrega los cambios confirmados en orden. Con fromSeq > 0 reanuda desde ese número
+	// de secuencia (ErrSequenceTooOld si ya no se conserva, ErrSequenceAhead si aún no se publicó);
+	// con 0 recibe solo los cambios nuevos.
+	Subscribe(fromSeq uint64, bufferSize int) (*Subscription, error)
+
+	// LastSequence retorna el número de secuencia del último cambio publicado
+	LastSequence() uint64
+}
+
+// Verificación en tiempo de compilación de que las implementaciones cumplen el repositorio
+var (
+	_ TransactionRepository = (*MockDatabase)(nil)
+	_ TransactionRepository = (*SQLiteDatabase)(nil)
+)
+
+// ConflictPolicy define qué hacer al guardar una transacción cuyo ID ya existe
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite reemplaza la transacción existente (comportamiento histórico)
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictReject rechaza la nueva transacción con ErrTransactionConflict
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictSkip conserva la transacción existente y descarta la nueva sin error
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictFailIfDifferent acepta duplicados idénticos sin escribir y rechaza los que difieren
+	ConflictFailIfDifferent ConflictPolicy = "fail_if_different"
+)
+
+// ParseConflictPolicy convierte un texto en ConflictPolicy; vacío equivale a ConflictOverwrite
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictReject, ConflictSkip, ConflictFailIfDifferent:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy %q (expected overwrite, reject, skip or fail_if_different)", value)
+	}
+}
+
+// ErrTransactionConflict indica que el ID ya existe y la política de conflictos rechazó la escritura
+var ErrTransactionConflict = errors.New("transaction ID already exists")
+
+// ErrVersionMismatch indica que la transacción cambió desde la versión esperada (SaveOptions.ExpectedVersions)
+var ErrVersionMismatch = errors.New("transaction changed since the expected version")
+
+// ErrExternalIDCollision indica que el ID ya pertenece a una transacción con otra clave externa (o sin clave).
+// Envuelve ErrTransactionConflict y se rechaza con cualquier política de conflictos.
+var ErrExternalIDCollision = fmt.Errorf("%w for a different external ID", ErrTransactionConflict)
+
+// SaveOptions opciones de escritura de una transacción
+type SaveOptions struct {
+	ConflictPolicy ConflictPolicy
+	MigrationID    string // Migración que origina la escritura; se guarda en el historial de versiones
+
+	// ExpectedVersions condiciona la escritura de los IDs indicados a que la última versión de su historial
+	// sea la del mapa (0 = sin historial). Se verifica bajo el mismo lock que la escritura; si no coincide
+	// se rechaza con ErrVersionMismatch.
+	ExpectedVersions map[int]int
+}
+
+// SaveOutcome describe qué ocurrió al guardar una transacción
+type SaveOutcome string
+
+const (
+	SaveInserted    SaveOutcome = "inserted"    // El ID no existía
+	SaveOverwritten SaveOutcome = "overwritten" // El ID existía y se reemplazó
+	SaveSkipped     SaveOutcome = "skipped"     // El ID existía y se conservó la transacción existente
+	SaveUnchanged   SaveOutcome = "unchanged"   // El ID existía con los mismos datos; no se escribió nada
+)
+
+// SaveResult resultado de guardar una transacción
+type SaveResult struct {
+	Transaction models.UserTransaction  // Transacción vigente después de la operación
+	Outcome     SaveOutcome             // Qué ocurrió
+	Previous    *models.UserTransaction // Transacción existente con el mismo ID, si la había
+}
+
+// BatchItemError error de una transacción dentro de un lote
+type BatchItemError struct {
+	Index int // Posición de la transacción en el lote
+	Err   error
+}
+
+// BatchSaveError indica que un lote fue rechazado completo y no se guardó ninguna transacción
+type BatchSaveError struct {
+	Total  int
+	Errors []BatchItemError
+}
+
+// Error implementa la interfaz error
+func (e *BatchSaveError) Error() string {
+	return fmt.Sprintf("batch rejected: %d of %d transactions failed", len(e.Errors), e.Total)
+}
+
+// Unwrap expone los errores individuales para errors.Is / errors.As
+func (e *BatchSaveError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, itemErr := range e.Errors {
+		errs[i] = itemErr.Err
+	}
+	return errs
+}
+
+// resolveConflict decide qué hacer cuando transaction tiene el mismo ID que previous.
+// Retorna SaveOverwritten si se debe escribir, SaveSkipped o SaveUnchanged si no, o un error si se rechaza.
+func resolveConflict(previous, transaction models.UserTransaction, policy ConflictPolicy) (SaveOutcome, error) {
+	// Un ID derivado de una clave externa solo identifica a la transacción con esa misma clave:
+	// ni otra clave ni una transacción sin clave pueden sobrescribirla, ni ella a una sin clave
+	if transaction.ExternalID != previous.ExternalID {
+		return "", fmt.Errorf("%w: id %d has external ID %q, got %q", ErrExternalIDCollision, transaction.ID, previous.ExternalID, transaction.ExternalID)
+	}
+
+	switch policy {
+	case ConflictReject:
+		return "", fmt.Errorf("%w: id %d (policy %s)", ErrTransactionConflict, transaction.ID, policy)
+	case ConflictSkip:
+		return SaveSkipped, nil
+	case ConflictFailIfDifferent:
+		if sameTransactionData(previous, transaction) {
+			return SaveUnchanged, nil
+		}
+		return "", fmt.Errorf("%w with different data: id %d (policy %s)", ErrTransactionConflict, transaction.ID, policy)
+	default:
+		return SaveOverwritten, nil
+	}
+}
+
+// checkExpectedVersion retorna ErrVersionMismatch si options espera para id una versión distinta de current
+func checkExpectedVersion(options SaveOptions, id, current int) error {
+	expected, checked := options.ExpectedVersions[id]
+	if !checked || expected == current {
+		return nil
+	}
+	return fmt.Errorf("%w: id %d is at version %d, expected %d", ErrVersionMismatch, id, current, expected)
+}
+
+// markDeleted retorna la transacción marcada como eliminada, o el error si no puede eliminarse
+func markDeleted(transaction models.UserTransaction, reason string, deletedAt time.Time) (models.UserTransaction, error) {
+	if transaction.Deleted {
+		return transaction, fmt.Errorf("%w: id %d", ErrTransactionAlreadyDeleted, transaction.ID)
+	}
+
+	transaction.Deleted = true
+	transaction.DeletedAt = &deletedAt
+	transaction.DeleteReason = reason
+	return transaction, nil
+}
+
+// sameTransactionData compara los datos de negocio de dos transacciones
+func sameTransactionData(a, b models.UserTransaction) bool {
+	return a.ID == b.ID &&
+		a.UserID == b.UserID &&
+		a.Amount == b.Amount &&
+		a.DateTime.Equal(b.DateTime)
+}
+
+// sameStoredTransaction compara todos los campos guardados de dos transacciones, incluidas la procedencia y el borrado lógico
+func sameStoredTransaction(a, b models.UserTransaction) bool {
+	return sameTransactionData(a, b) &&
+		a.MigrationID == b.MigrationID &&
+		a.SourceFile == b.SourceFile &&
+		a.SourceLine == b.SourceLine &&
+		sameOptionalTime(a.ImportedAt, b.ImportedAt) &&
+		a.ExternalID == b.ExternalID &&
+		a.Deleted == b.Deleted &&
+		sameOptionalTime(a.DeletedAt, b.DeletedAt) &&
+		a.DeleteReason == b.DeleteReason
+}
+
+// sameOptionalTime compara dos fechas opcionales
+func sameOptionalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
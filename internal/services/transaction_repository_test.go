@@ -0,0 +1,561 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// repositoryImplementations retorna una instancia nueva de cada implementación del repositorio
+func repositoryImplementations(t *testing.T) map[string]TransactionRepository {
+	sqliteDB, err := NewSQLiteDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Expected no error opening sqlite database, got %v", err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+
+	return map[string]TransactionRepository{
+		"mock":   NewMockDatabase(),
+		"sqlite": sqliteDB,
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := map[string]ConflictPolicy{
+		"":                  ConflictOverwrite,
+		"overwrite":         ConflictOverwrite,
+		"REJECT":            ConflictReject,
+		" skip ":            ConflictSkip,
+		"fail_if_different": ConflictFailIfDifferent,
+	}
+	for input, expected := range tests {
+		policy, err := ParseConflictPolicy(input)
+		if err != nil {
+			t.Errorf("ParseConflictPolicy(%q): expected no error, got %v", input, err)
+		}
+		if policy != expected {
+			t.Errorf("ParseConflictPolicy(%q): expected %s, got %s", input, expected, policy)
+		}
+	}
+
+	if _, err := ParseConflictPolicy("merge"); err == nil {
+		t.Error("Expected error for unknown conflict policy")
+	}
+}
+
+func TestTransactionRepository_ConflictPolicies(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	original := models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime}
+	changed := models.UserTransaction{ID: 1, UserID: 1001, Amount: 99.99, DateTime: baseTime}
+
+	tests := []struct {
+		name            string
+		policy          ConflictPolicy
+		incoming        models.UserTransaction
+		expectedErr     bool
+		expectedOutcome SaveOutcome
+		expectedAmount  float64
+	}{
+		{"overwrite replaces", ConflictOverwrite, changed, false, SaveOverwritten, 99.99},
+		{"reject fails", ConflictReject, changed, true, "", 150.50},
+		{"skip keeps existing", ConflictSkip, changed, false, SaveSkipped, 150.50},
+		{"fail_if_different accepts identical", ConflictFailIfDifferent, original, false, SaveUnchanged, 150.50},
+		{"fail_if_different rejects changes", ConflictFailIfDifferent, changed, true, "", 150.50},
+	}
+
+	for _, tt := range tests {
+		for name, repository := range repositoryImplementations(t) {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				if _, err := repository.SaveTransaction(original); err != nil {
+					t.Fatalf("Expected no error saving original, got %v", err)
+				}
+
+				result, err := repository.SaveTransactionWithOptions(tt.incoming, SaveOptions{ConflictPolicy: tt.policy})
+				if tt.expectedErr {
+					if !errors.Is(err, ErrTransactionConflict) {
+						t.Fatalf("Expected ErrTransactionConflict, got %v", err)
+					}
+				} else {
+					if err != nil {
+						t.Fatalf("Expected no error, got %v", err)
+					}
+					if result.Outcome != tt.expectedOutcome {
+						t.Errorf("Expected outcome %s, got %s", tt.expectedOutcome, result.Outcome)
+					}
+					if result.Previous == nil || result.Previous.Amount != original.Amount {
+						t.Errorf("Expected previous transaction to be reported, got %+v", result.Previous)
+					}
+				}
+
+				stored, _ := repository.GetTransaction(1)
+				if stored.Amount != tt.expectedAmount {
+					t.Errorf("Expected stored amount %.2f, got %.2f", tt.expectedAmount, stored.Amount)
+				}
+				if repository.GetTransactionCount() != 1 {
+					t.Errorf("Expected 1 transaction, got %d", repository.GetTransactionCount())
+				}
+			})
+		}
+	}
+}
+
+func TestTransactionRepository_AutoIDDoesNotOverwrite(t *testing.T) {
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: time.Now()})
+
+			result, err := repository.SaveTransactionWithOptions(
+				models.UserTransaction{UserID: 1002, Amount: 20, DateTime: time.Now()},
+				SaveOptions{ConflictPolicy: ConflictReject},
+			)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if result.Outcome != SaveInserted || result.Transaction.ID == 1 {
+				t.Errorf("Expected a new ID to be inserted, got %+v", result)
+			}
+			if repository.GetTransactionCount() != 2 {
+				t.Errorf("Expected 2 transactions, got %d", repository.GetTransactionCount())
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_AutoIDSkipsPurgedIDs(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			// Un ID explícito purgado conserva su historial: no debe volver a asignarse
+			repository.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 10, DateTime: baseTime})
+			if _, err := repository.DeleteTransactions([]int{2}); err != nil {
+				t.Fatalf("Expected no error purging, got %v", err)
+			}
+
+			var ids []int
+			for i := 0; i < 2; i++ {
+				result, err := repository.SaveTransactionWithOptions(
+					models.UserTransaction{UserID: 1002, Amount: 20, DateTime: baseTime}, SaveOptions{})
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				ids = append(ids, result.Transaction.ID)
+			}
+			results, err := repository.SaveTransactions([]models.UserTransaction{
+				{UserID: 1002, Amount: 30, DateTime: baseTime},
+				{UserID: 1002, Amount: 40, DateTime: baseTime},
+			}, SaveOptions{})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			for _, result := range results {
+				ids = append(ids, result.Transaction.ID)
+			}
+
+			seen := make(map[int]bool)
+			for _, id := range ids {
+				if id == 2 || seen[id] {
+					t.Errorf("Expected purged and used IDs not to be reused, got %v", ids)
+				}
+				seen[id] = true
+				if versions := repository.GetTransactionHistory(id); len(versions) != 1 || versions[0].Version != 1 {
+					t.Errorf("Expected history of new ID %d to start at version 1, got %+v", id, versions)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_SaveTransactionsIsAtomic(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+
+			// Las filas 2 y 3 son válidas, pero la 1 choca con reject: no se guarda ninguna
+			batch := []models.UserTransaction{
+				{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime},
+				{ID: 1, UserID: 1001, Amount: 99, DateTime: baseTime},
+				{ID: 3, UserID: 1002, Amount: 30, DateTime: baseTime},
+			}
+			_, err := repository.SaveTransactions(batch, SaveOptions{ConflictPolicy: ConflictReject})
+
+			var batchErr *BatchSaveError
+			if !errors.As(err, &batchErr) {
+				t.Fatalf("Expected BatchSaveError, got %v", err)
+			}
+			if len(batchErr.Errors) != 1 || batchErr.Errors[0].Index != 1 {
+				t.Errorf("Expected a single error at index 1, got %+v", batchErr.Errors)
+			}
+			if !errors.Is(err, ErrTransactionConflict) {
+				t.Errorf("Expected error to wrap ErrTransactionConflict, got %v", err)
+			}
+			if repository.GetTransactionCount() != 1 {
+				t.Errorf("Expected rejected batch to leave 1 transaction, got %d", repository.GetTransactionCount())
+			}
+
+			// Sin conflictos el lote completo se guarda, incluidos IDs repetidos dentro del mismo lote
+			batch = []models.UserTransaction{
+				{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime},
+				{ID: 2, UserID: 1001, Amount: 25, DateTime: baseTime},
+				{UserID: 1002, Amount: 30, DateTime: baseTime},
+			}
+			results, err := repository.SaveTransactions(batch, SaveOptions{ConflictPolicy: ConflictOverwrite})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if results[1].Outcome != SaveOverwritten {
+				t.Errorf("Expected second row to overwrite the first, got %s", results[1].Outcome)
+			}
+			if results[2].Transaction.ID == 0 || results[2].Transaction.ID <= 2 {
+				t.Errorf("Expected a new auto ID, got %d", results[2].Transaction.ID)
+			}
+			if repository.GetTransactionCount() != 3 {
+				t.Errorf("Expected 3 transactions, got %d", repository.GetTransactionCount())
+			}
+			if tx, _ := repository.GetTransaction(2); tx.Amount != 25 {
+				t.Errorf("Expected transaction 2 amount 25, got %.2f", tx.Amount)
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_KeepsVersionHistory(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+			repository.SaveTransactionWithOptions(models.UserTransaction{ID: 1, UserID: 1001, Amount: 99.99, DateTime: baseTime},
+				SaveOptions{MigrationID: "mig-2"})
+			repository.SaveTransactions([]models.UserTransaction{{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime}},
+				SaveOptions{MigrationID: "mig-3"})
+			// Un duplicado descartado no crea versión
+			repository.SaveTransactionWithOptions(models.UserTransaction{ID: 1, UserID: 1001, Amount: 5, DateTime: baseTime},
+				SaveOptions{ConflictPolicy: ConflictSkip, MigrationID: "mig-4"})
+
+			versions := repository.GetTransactionHistory(1)
+			if len(versions) != 3 {
+				t.Fatalf("Expected 3 versions, got %d", len(versions))
+			}
+
+			expected := []struct {
+				amount      float64
+				migrationID string
+			}{{150.50, ""}, {99.99, "mig-2"}, {10, "mig-3"}}
+			for i, want := range expected {
+				if versions[i].Version != i+1 || versions[i].Transaction.Amount != want.amount || versions[i].MigrationID != want.migrationID {
+					t.Errorf("Unexpected version %d: %+v", i+1, versions[i])
+				}
+				if versions[i].ChangedAt.IsZero() {
+					t.Errorf("Expected changed_at on version %d", i+1)
+				}
+			}
+
+			// El balance solo usa la versión actual
+			if got := repository.GetTransactionsByUserID(1001); len(got) != 1 || got[0].Amount != 10 {
+				t.Errorf("Expected only the current version, got %+v", got)
+			}
+
+			if versions := repository.GetTransactionHistory(999); len(versions) != 0 {
+				t.Errorf("Expected no history for unknown transaction, got %d", len(versions))
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_TracksProvenance(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	importedAt := time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)
+	imported := func(id, line int, migrationID string) models.UserTransaction {
+		return models.UserTransaction{ID: id, UserID: 1001, Amount: 10, DateTime: baseTime,
+			MigrationID: migrationID, SourceFile: "january.csv", SourceLine: line, ImportedAt: &importedAt}
+	}
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransactions([]models.UserTransaction{imported(2, 3, "mig-a"), imported(1, 2, "mig-a")},
+				SaveOptions{MigrationID: "mig-a"})
+			repository.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1001, Amount: 5, DateTime: baseTime})
+
+			stored, _ := repository.GetTransaction(1)
+			if stored.MigrationID != "mig-a" || stored.SourceFile != "january.csv" || stored.SourceLine != 2 ||
+				stored.ImportedAt == nil || !stored.ImportedAt.Equal(importedAt) {
+				t.Errorf("Expected provenance to be stored, got %+v", stored)
+			}
+
+			// Ordenadas por línea de origen
+			transactions := repository.GetTransactionsByMigrationID("mig-a")
+			if len(transactions) != 2 || transactions[0].ID != 1 || transactions[1].ID != 2 {
+				t.Fatalf("Expected transactions [1 2] of mig-a, got %+v", transactions)
+			}
+
+			// Al sobrescribirla, la transacción pasa a la nueva migración
+			repository.SaveTransactionWithOptions(imported(2, 7, "mig-b"), SaveOptions{MigrationID: "mig-b"})
+			if got := repository.GetTransactionsByMigrationID("mig-a"); len(got) != 1 || got[0].ID != 1 {
+				t.Errorf("Expected only transaction 1 left in mig-a, got %+v", got)
+			}
+			if got := repository.GetTransactionsByMigrationID("mig-b"); len(got) != 1 || got[0].SourceLine != 7 {
+				t.Errorf("Expected transaction 2 from line 7 in mig-b, got %+v", got)
+			}
+
+			// El historial conserva la procedencia de cada versión
+			versions := repository.GetTransactionHistory(2)
+			if len(versions) != 2 || versions[0].Transaction.SourceLine != 3 || versions[1].Transaction.MigrationID != "mig-b" {
+				t.Errorf("Expected provenance in history, got %+v", versions)
+			}
+
+			if got := repository.GetTransactionsByMigrationID(""); len(got) != 0 {
+				t.Errorf("Expected no transactions for an empty migration ID, got %d", len(got))
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_DeleteTransactions(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			for id := 1; id <= 3; id++ {
+				repository.SaveTransaction(models.UserTransaction{ID: id, UserID: 1001, Amount: float64(id), DateTime: baseTime})
+			}
+
+			subscription, _ := repository.Subscribe(0, 10)
+			defer subscription.Close()
+
+			// Los IDs repetidos o inexistentes se ignoran
+			deleted, err := repository.DeleteTransactions([]int{3, 1, 3, 99})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(deleted) != 2 || deleted[0].ID != 1 || deleted[1].ID != 3 {
+				t.Fatalf("Expected transactions [1 3] deleted, got %+v", deleted)
+			}
+
+			if repository.GetTransactionCount() != 1 {
+				t.Errorf("Expected 1 transaction left, got %d", repository.GetTransactionCount())
+			}
+			if got := repository.GetTransactionsByUserID(1001); len(got) != 1 || got[0].ID != 2 {
+				t.Errorf("Expected only transaction 2 for user 1001, got %+v", got)
+			}
+			if versions := repository.GetTransactionHistory(1); len(versions) != 1 {
+				t.Errorf("Expected history of deleted transaction to be kept, got %d versions", len(versions))
+			}
+
+			for _, id := range []int{1, 3} {
+				event := <-subscription.Events()
+				if event.Type != ChangeDelete || event.Before == nil || event.Before.ID != id {
+					t.Errorf("Expected delete event for %d, got %+v", id, event)
+				}
+			}
+
+			if deleted, err := repository.DeleteTransactions([]int{99}); err != nil || len(deleted) != 0 {
+				t.Errorf("Expected nothing deleted, got %+v (%v)", deleted, err)
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_SoftDeleteTransaction(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 100, DateTime: baseTime})
+
+			deleted, err := repository.SoftDeleteTransaction(1, "duplicated charge")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !deleted.Deleted || deleted.DeleteReason != "duplicated charge" || deleted.DeletedAt == nil {
+				t.Errorf("Expected transaction marked as deleted, got %+v", deleted)
+			}
+
+			// La transacción se conserva, marcada, en el almacén y en el índice por usuario
+			stored, found := repository.GetTransaction(1)
+			if !found || !stored.Deleted || stored.DeleteReason != "duplicated charge" || !stored.DeletedAt.Equal(*deleted.DeletedAt) {
+				t.Errorf("Expected stored transaction marked as deleted, got %+v", stored)
+			}
+			if got := repository.GetTransactionsByUserID(1001); len(got) != 1 || !got[0].Deleted {
+				t.Errorf("Expected deleted transaction in user index, got %+v", got)
+			}
+
+			versions := repository.GetTransactionHistory(1)
+			if len(versions) != 2 || versions[0].Transaction.Deleted || !versions[1].Transaction.Deleted ||
+				versions[1].Transaction.DeleteReason != "duplicated charge" {
+				t.Errorf("Expected deletion recorded as version 2, got %+v", versions)
+			}
+
+			if _, err := repository.SoftDeleteTransaction(1, "again"); !errors.Is(err, ErrTransactionAlreadyDeleted) {
+				t.Errorf("Expected ErrTransactionAlreadyDeleted, got %v", err)
+			}
+			if _, err := repository.SoftDeleteTransaction(99, "missing"); !errors.Is(err, ErrTransactionNotFound) {
+				t.Errorf("Expected ErrTransactionNotFound, got %v", err)
+			}
+
+			// Volver a cargar el ID reemplaza la transacción eliminada
+			restored, _ := repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 90, DateTime: baseTime})
+			if stored, _ := repository.GetTransaction(1); stored.Deleted || restored.Deleted {
+				t.Errorf("Expected overwritten transaction to be active, got %+v", stored)
+			}
+		})
+	}
+}
+
+// sliceIterator entrega las transacciones indicadas y luego err (io.EOF para terminar)
+func sliceIterator(transactions []models.UserTransaction, err error) func() (models.UserTransaction, error) {
+	return func() (models.UserTransaction, error) {
+		if len(transactions) == 0 {
+			return models.UserTransaction{}, err
+		}
+		transaction := transactions[0]
+		transactions = transactions[1:]
+		return transaction, nil
+	}
+}
+
+func TestTransactionRepository_ImportTransactions(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+			repository.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime})
+
+			// Un error a mitad de la lectura no deja nada aplicado, ni siquiera el reemplazo
+			readErr := errors.New("read failed")
+			partial := []models.UserTransaction{{ID: 3, UserID: 1002, Amount: 30, DateTime: baseTime}}
+			if _, _, err := repository.ImportTransactions(sliceIterator(partial, readErr), true); !errors.Is(err, readErr) {
+				t.Fatalf("Expected read error, got %v", err)
+			}
+			if repository.GetTransactionCount() != 2 || len(repository.GetTransactionHistory(1)) != 1 {
+				t.Fatalf("Expected store unchanged, got %d transactions", repository.GetTransactionCount())
+			}
+
+			merge := []models.UserTransaction{
+				{ID: 2, UserID: 1001, Amount: 25, DateTime: baseTime},
+				{ID: 3, UserID: 1002, Amount: 30, DateTime: baseTime},
+			}
+			inserted, overwritten, err := repository.ImportTransactions(sliceIterator(merge, io.EOF), false)
+			if err != nil || inserted != 1 || overwritten != 1 || repository.GetTransactionCount() != 3 {
+				t.Fatalf("Expected 1 inserted and 1 overwritten, got %d/%d (%v)", inserted, overwritten, err)
+			}
+
+			subscription, _ := repository.Subscribe(0, 10)
+			defer subscription.Close()
+
+			replace := []models.UserTransaction{{ID: 7, UserID: 1003, Amount: 70, DateTime: baseTime}}
+			inserted, overwritten, err = repository.ImportTransactions(sliceIterator(replace, io.EOF), true)
+			if err != nil || inserted != 1 || overwritten != 0 {
+				t.Fatalf("Expected 1 inserted, got %d/%d (%v)", inserted, overwritten, err)
+			}
+			if repository.GetTransactionCount() != 1 || len(repository.GetTransactionHistory(2)) != 0 {
+				t.Errorf("Expected only transaction 7 and no previous history after replace")
+			}
+			for _, expected := range []ChangeType{ChangeDelete, ChangeDelete, ChangeDelete, ChangeInsert} {
+				if event := <-subscription.Events(); event.Type != expected {
+					t.Errorf("Expected %s event, got %+v", expected, event)
+				}
+			}
+
+			// Los IDs nuevos continúan después de los importados
+			saved, _ := repository.SaveTransaction(models.UserTransaction{UserID: 1003, Amount: 1, DateTime: baseTime})
+			if saved.ID != 8 {
+				t.Errorf("Expected next ID 8, got %d", saved.ID)
+			}
+
+			var ids []int
+			repository.ForEachTransaction(func(transaction models.UserTransaction) error {
+				ids = append(ids, transaction.ID)
+				return nil
+			})
+			if len(ids) != 2 || ids[0] != 7 || ids[1] != 8 {
+				t.Errorf("Expected transactions [7 8] in ID order, got %v", ids)
+			}
+
+			stop := errors.New("stop")
+			if err := repository.ForEachTransaction(func(models.UserTransaction) error { return stop }); err != stop {
+				t.Errorf("Expected the callback error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_DeleteTransactionsIf(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			for id := 1; id <= 3; id++ {
+				repository.SaveTransaction(models.UserTransaction{ID: id, UserID: 1001, Amount: float64(id), DateTime: baseTime})
+			}
+
+			deleted, err := repository.DeleteTransactionsIf([]int{1, 2, 3}, func(transaction models.UserTransaction) bool {
+				return transaction.Amount >= 2
+			})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(deleted) != 2 || deleted[0].ID != 2 || deleted[1].ID != 3 {
+				t.Fatalf("Expected transactions [2 3] deleted, got %+v", deleted)
+			}
+			if _, found := repository.GetTransaction(1); !found || repository.GetTransactionCount() != 1 {
+				t.Errorf("Expected transaction 1 kept, got %d transactions", repository.GetTransactionCount())
+			}
+		})
+	}
+}
+
+func TestTransactionRepository_ExpectedVersions(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, repository := range repositoryImplementations(t) {
+		t.Run(name, func(t *testing.T) {
+			repository.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+
+			// La versión esperada coincide: se escribe el lote, incluido un ID sin historial (versión 0)
+			options := SaveOptions{ConflictPolicy: ConflictOverwrite, ExpectedVersions: map[int]int{1: 1, 2: 0}}
+			_, err := repository.SaveTransactions([]models.UserTransaction{
+				{ID: 1, UserID: 1001, Amount: 20, DateTime: baseTime},
+				{ID: 2, UserID: 1001, Amount: 30, DateTime: baseTime},
+			}, options)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// La transacción 1 ya va en la versión 2: el lote completo se rechaza
+			_, err = repository.SaveTransactions([]models.UserTransaction{
+				{ID: 1, UserID: 1001, Amount: 40, DateTime: baseTime},
+				{ID: 3, UserID: 1001, Amount: 50, DateTime: baseTime},
+			}, SaveOptions{ConflictPolicy: ConflictOverwrite, ExpectedVersions: map[int]int{1: 1}})
+			var batchErr *BatchSaveError
+			if !errors.As(err, &batchErr) || !errors.Is(err, ErrVersionMismatch) || len(batchErr.Errors) != 1 || batchErr.Errors[0].Index != 0 {
+				t.Fatalf("Expected a batch rejected by version, got %v", err)
+			}
+			if tx, _ := repository.GetTransaction(1); tx.Amount != 20 {
+				t.Errorf("Expected transaction 1 unchanged, got %+v", tx)
+			}
+			if _, found := repository.GetTransaction(3); found {
+				t.Error("Expected transaction 3 not to be saved")
+			}
+
+			// Una transacción eliminada conserva su historial: sigue en la última versión
+			repository.DeleteTransactions([]int{2})
+			_, err = repository.SaveTransactionWithOptions(models.UserTransaction{ID: 2, UserID: 1001, Amount: 60, DateTime: baseTime},
+				SaveOptions{ConflictPolicy: ConflictOverwrite, ExpectedVersions: map[int]int{2: 0}})
+			if !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("Expected ErrVersionMismatch for a single save, got %v", err)
+			}
+			_, err = repository.SaveTransactionWithOptions(models.UserTransaction{ID: 2, UserID: 1001, Amount: 60, DateTime: baseTime},
+				SaveOptions{ConflictPolicy: ConflictOverwrite, ExpectedVersions: map[int]int{2: 1}})
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TransactionsService maneja las operaciones de negocio sobre transacciones individuales
+type TransactionsService struct {
+	database TransactionRepository
+	archive  *ArchiveService // Opcional: transacciones movidas fuera del almacén por la retención
+}
+
+// NewTransactionsService crea una nueva instancia de TransactionsService
+func NewTransactionsService(database TransactionRepository) *TransactionsService {
+	return &TransactionsService{
+		database: database,
+	}
+}
+
+// SetArchive establece el archivo de transacciones antiguas, para purgar también las archivadas
+func (ts *TransactionsService) SetArchive(archive *ArchiveService) {
+	ts.archive = archive
+}
+
+// GetTransactionHistory obtiene todas las versiones guardadas de una transacción
+func (ts *TransactionsService) GetTransactionHistory(id int) (*models.TransactionHistory, error) {
+	versions := ts.database.GetTransactionHistory(id)
+
+	// Sin versiones la transacción nunca existió
+	if len(versions) == 0 {
+		return nil, ErrTransactionNotFound
+	}
+
+	return &models.TransactionHistory{
+		TransactionID:  id,
+		CurrentVersion: versions[len(versions)-1].Version,
+		Versions:       versions,
+	}, nil
+}
+
+// GetTransactionsByMigrationID obtiene las transacciones vigentes importadas por una migración
+func (ts *TransactionsService) GetTransactionsByMigrationID(migrationID string) (*models.MigrationTransactions, error) {
+	transactions := ts.database.GetTransactionsByMigrationID(migrationID)
+
+	// Sin transacciones no se puede distinguir una migración desconocida de una totalmente reemplazada
+	if len(transactions) == 0 {
+		return nil, ErrMigrationNotFound
+	}
+
+	return &models.MigrationTransactions{
+		MigrationID:  migrationID,
+		Count:        len(transactions),
+		Transactions: transactions,
+	}, nil
+}
+
+// DeleteTransaction hace el borrado lógico de una transacción: se conserva con el motivo para auditoría
+// pero deja de contar en los balances
+func (ts *TransactionsService) DeleteTransaction(id int, reason string) (models.UserTransaction, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return models.UserTransaction{}, ErrDeleteReasonRequired
+	}
+	return ts.database.SoftDeleteTransaction(id, reason)
+}
+
+// PurgeDeletedTransactions elimina definitivamente las transacciones con borrado lógico, también del archivo.
+// Con deletedBefore solo purga las eliminadas antes de esa fecha. El historial de versiones se conserva.
+// La condición se vuelve a verificar al borrar: una transacción recargada mientras tanto no se purga.
+func (ts *TransactionsService) PurgeDeletedTransactions(deletedBefore *time.Time) (*models.PurgeResult, error) {
+	purgeable := func(transaction models.UserTransaction) bool {
+		if !transaction.Deleted {
+			return false
+		}
+		return deletedBefore == nil || transaction.DeletedAt == nil || transaction.DeletedAt.Before(*deletedBefore)
+	}
+
+	var ids []int
+	err := ts.database.ForEachTransaction(func(transaction models.UserTransaction) error {
+		if purgeable(transaction) {
+			ids = append(ids, transaction.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading transactions: %v", err)
+	}
+
+	result := &models.PurgeResult{Transactions: []models.UserTransaction{}}
+	purged := make(map[int]bool)
+	if len(ids) > 0 {
+		deleted, err := ts.database.DeleteTransactionsIf(ids, purgeable)
+		if err != nil {
+			return nil, fmt.Errorf("error purging transactions: %v", err)
+		}
+		for _, transaction := range deleted {
+			purged[transaction.ID] = true
+		}
+		result.Transactions = append(result.Transactions, deleted...)
+	}
+
+	// Las copias archivadas de las purgadas también se eliminan: sin la transacción vigente volverían a contar
+	if ts.archive != nil {
+		archived, err := ts.archive.RemoveTransactions(func(transaction models.UserTransaction) bool {
+			return purgeable(transaction) || purged[transaction.ID]
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error purging archived transactions: %v", err)
+		}
+		for _, transaction := range archived {
+			if !purged[transaction.ID] {
+				result.Transactions = append(result.Transactions, transaction)
+			}
+		}
+		sort.Slice(result.Transactions, func(i, j int) bool {
+			return result.Transactions[i].ID < result.Transactions[j].ID
+		})
+	}
+
+	result.PurgedCount = len(result.Transactions)
+	return result, nil
+}
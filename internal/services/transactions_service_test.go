@@ -0,0 +1,161 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransactionsService_GetTransactionHistory(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewTransactionsService(db)
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 99.99, DateTime: baseTime})
+
+	history, err := service.GetTransactionHistory(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if history.TransactionID != 1 || history.CurrentVersion != 2 || len(history.Versions) != 2 {
+		t.Errorf("Expected 2 versions of transaction 1, got %+v", history)
+	}
+
+	_, err = service.GetTransactionHistory(999)
+	if err != ErrTransactionNotFound {
+		t.Errorf("Expected ErrTransactionNotFound, got %v", err)
+	}
+}
+
+func TestTransactionsService_GetTransactionsByMigrationID(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewTransactionsService(db)
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime, MigrationID: "mig-a", SourceLine: 2})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1002, Amount: 99.99, DateTime: baseTime, MigrationID: "mig-b", SourceLine: 2})
+
+	result, err := service.GetTransactionsByMigrationID("mig-a")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.MigrationID != "mig-a" || result.Count != 1 || result.Transactions[0].ID != 1 {
+		t.Errorf("Expected transaction 1 of mig-a, got %+v", result)
+	}
+
+	_, err = service.GetTransactionsByMigrationID("mig-unknown")
+	if err != ErrMigrationNotFound {
+		t.Errorf("Expected ErrMigrationNotFound, got %v", err)
+	}
+}
+
+func TestTransactionsService_DeleteTransaction(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewTransactionsService(db)
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+
+	if _, err := service.DeleteTransaction(1, "   "); err != ErrDeleteReasonRequired {
+		t.Errorf("Expected ErrDeleteReasonRequired, got %v", err)
+	}
+
+	deleted, err := service.DeleteTransaction(1, " duplicated ")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !deleted.Deleted || deleted.DeleteReason != "duplicated" {
+		t.Errorf("Expected transaction deleted with trimmed reason, got %+v", deleted)
+	}
+
+	if _, err := service.DeleteTransaction(1, "again"); !errors.Is(err, ErrTransactionAlreadyDeleted) {
+		t.Errorf("Expected ErrTransactionAlreadyDeleted, got %v", err)
+	}
+}
+
+func TestTransactionsService_PurgeDeletedTransactions(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewTransactionsService(db)
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for id := 1; id <= 3; id++ {
+		db.SaveTransaction(models.UserTransaction{ID: id, UserID: 1001, Amount: 10, DateTime: baseTime})
+	}
+	db.SoftDeleteTransaction(1, "duplicated")
+	db.SoftDeleteTransaction(2, "duplicated")
+
+	// Las eliminadas después del corte se conservan
+	past := time.Now().Add(-time.Hour)
+	result, err := service.PurgeDeletedTransactions(&past)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.PurgedCount != 0 || db.GetTransactionCount() != 3 {
+		t.Errorf("Expected nothing purged before the cutoff, got %+v", result)
+	}
+
+	result, err = service.PurgeDeletedTransactions(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.PurgedCount != 2 || result.Transactions[0].ID != 1 || result.Transactions[1].ID != 2 {
+		t.Errorf("Expected transactions [1 2] purged, got %+v", result)
+	}
+	if _, found := db.GetTransaction(1); found || db.GetTransactionCount() != 1 {
+		t.Errorf("Expected only transaction 3 left, got %d transactions", db.GetTransactionCount())
+	}
+	if versions := db.GetTransactionHistory(1); len(versions) != 2 {
+		t.Errorf("Expected history of purged transaction to be kept, got %d versions", len(versions))
+	}
+}
+
+// reloadBeforeDelete simula una recarga concurrente que llega entre la lectura y el borrado
+type reloadBeforeDelete struct {
+	TransactionRepository
+	reload models.UserTransaction
+}
+
+func (r *reloadBeforeDelete) DeleteTransactionsIf(ids []int, condition func(models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	r.TransactionRepository.SaveTransaction(r.reload)
+	return r.TransactionRepository.DeleteTransactionsIf(ids, condition)
+}
+
+func TestTransactionsService_PurgeKeepsReloadedTransactions(t *testing.T) {
+	db := NewMockDatabase()
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 10, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime})
+	db.SoftDeleteTransaction(1, "duplicated")
+	db.SoftDeleteTransaction(2, "duplicated")
+
+	repository := &reloadBeforeDelete{TransactionRepository: db,
+		reload: models.UserTransaction{ID: 2, UserID: 1001, Amount: 20, DateTime: baseTime}}
+	subscription, _ := db.Subscribe(0, 10)
+	defer subscription.Close()
+
+	result, err := NewTransactionsService(repository).PurgeDeletedTransactions(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.PurgedCount != 1 || result.Transactions[0].ID != 1 {
+		t.Errorf("Expected only transaction 1 purged, got %+v", result)
+	}
+
+	// La recargada no se toca: ni se borra y restaura ni se le agregan versiones
+	if tx, found := db.GetTransaction(2); !found || tx.Deleted {
+		t.Errorf("Expected reloaded transaction 2 kept, got %+v", tx)
+	}
+	if versions := db.GetTransactionHistory(2); len(versions) != 3 {
+		t.Errorf("Expected 3 versions of transaction 2, got %d", len(versions))
+	}
+	for _, expected := range []ChangeType{ChangeOverwrite, ChangeDelete} {
+		if event := <-subscription.Events(); event.Type != expected {
+			t.Errorf("Expected %s event, got %+v", expected, event)
+		}
+	}
+	if db.LastSequence() != 6 {
+		t.Errorf("Expected no other change events, got last sequence %d", db.LastSequence())
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyKeyConflict indica que la clave de idempotencia ya se usó con otro archivo u otras opciones
+var ErrIdempotencyKeyConflict = errors.New("idempotency key was already used with a different request")
+
+// UploadRecord subida registrada: el resultado que se repite si llega otra vez la misma clave o el mismo archivo
+type UploadRecord struct {
+	Key         string // Idempotency-Key de la request (vacío = la subida se identifica por su contenido)
+	SHA256      string // SHA-256 del archivo recibido, en hexadecimal
+	Fingerprint string // SHA-256 del archivo más las opciones que cambian el resultado de la migración
+	CreatedAt   time.Time
+	ExpiresAt   time.Time // Cero mientras la migración está en curso
+
+	// Resultado: la respuesta de una migración síncrona (sin las estadísticas completas) o el trabajo de una asíncrona
+	Result *models.MigrationResult
+	Job    *MigrationJob
+
+	done      chan struct{} // Se cierra cuando la migración termina o se descarta
+	completed *list.Element // Posición en la lista de subidas terminadas (nil mientras está en curso)
+}
+
+// UploadRegistry recuerda las subidas a /migrate durante ttl para no importar dos veces el mismo archivo
+// cuando el cliente reintenta. Recuerda como máximo maxRecords subidas terminadas: al superarlo descarta
+// las más antiguas antes de su vencimiento. El registro vive en memoria y se pierde al reiniciar.
+type UploadRegistry struct {
+	ttl        time.Duration
+	maxRecords int
+	spoolDir   string
+
+	mutex     sync.Mutex
+	byKey     map[string]*UploadRecord
+	byContent map[string]*UploadRecord // Última subida de cada Fingerprint, con o sin clave
+	completed *list.List               // Subidas terminadas en orden de vencimiento, la más antigua primero
+
+	now func() time.Time
+}
+
+// NewUploadRegistry crea el registro de subidas. Los resultados se recuerdan durante ttl desde que
+// la migración termina, hasta maxRecords subidas; spoolDir es el directorio de las copias de los archivos
+// (vacío = directorio temporal).
+func NewUploadRegistry(ttl time.Duration, maxRecords int, spoolDir string) (*UploadRegistry, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid idempotency expiry: %v", ttl)
+	}
+	if maxRecords <= 0 {
+		return nil, fmt.Errorf("invalid idempotency record limit: %d", maxRecords)
+	}
+	if spoolDir != "" {
+		if err := os.MkdirAll(spoolDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating migration spool directory: %v", err)
+		}
+	}
+	return &UploadRegistry{
+		ttl:        ttl,
+		maxRecords: maxRecords,
+		spoolDir:   spoolDir,
+		byKey:      make(map[string]*UploadRecord),
+		byContent:  make(map[string]*UploadRecord),
+		completed:  list.New(),
+		now:        time.Now,
+	}, nil
+}
+
+// SpooledUpload archivo recibido copiado a disco, con su SHA-256
+type SpooledUpload struct {
+	Path   string
+	SHA256 string
+	Size   int64
+}
+
+// Remove borra la copia del archivo
+func (u *SpooledUpload) Remove() {
+	os.Remove(u.Path)
+}
+
+// Receive copia el archivo de reader a disco calculando su SHA-256 en el mismo recorrido
+func (r *UploadRegistry) Receive(reader io.Reader) (*SpooledUpload, error) {
+	file, err := os.CreateTemp(r.spoolDir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating spool file: %v", err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("error receiving file: %v", err)
+	}
+	return &SpooledUpload{Path: file.Name(), SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
+
+// UploadFingerprint identifica una subida por el SHA-256 del archivo y las opciones que cambian su resultado.
+// El mismo archivo con otra política de conflictos o con otras columnas es otra migración.
+func UploadFingerprint(contentSHA256 string, options MigrationOptions) string {
+	options = options.withDefaults()
+
+	// json.Marshal ordena las claves del mapping: el resultado no depende del orden de los campos
+	encoded, _ := json.Marshal([]interface{}{
+		contentSHA256,
+		options.ConflictPolicy,
+		options.Atomic,
+		options.Format,
+		options.Compression,
+		options.Sheet,
+		options.DefaultUserID,
+		options.Mapping,
+	})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin reserva la subida identificada por key o, si key está vacío, por fingerprint (también la encuentran
+// los reintentos sin clave de una subida que tenía clave).
+// Si la misma subida ya terminó retorna su registro con replay = true para repetir su resultado; si está
+// en curso espera a que termine. Una clave usada con otro fingerprint retorna ErrIdempotencyKeyConflict.
+// Con replay = false el llamador debe cerrar la reserva con Complete o Release.
+func (r *UploadRegistry) Begin(ctx context.Context, key, contentSHA256, fingerprint string) (record *UploadRecord, replay bool, err error) {
+	for {
+		r.mutex.Lock()
+		r.purgeExpired()
+
+		existing := r.byContent[fingerprint]
+		if key != "" {
+			existing = r.byKey[key]
+		}
+		if existing == nil {
+			record = &UploadRecord{
+				Key:         key,
+				SHA256:      contentSHA256,
+				Fingerprint: fingerprint,
+				CreatedAt:   r.now().UTC(),
+				done:        make(chan struct{}),
+			}
+			r.index(record)
+			r.mutex.Unlock()
+			return record, false, nil
+		}
+		r.mutex.Unlock()
+
+		if existing.Fingerprint != fingerprint {
+			return nil, false, ErrIdempotencyKeyConflict
+		}
+
+		// La misma subida está en curso: se espera su resultado en lugar de importar el archivo en paralelo
+		select {
+		case <-existing.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+
+		r.mutex.Lock()
+		completed := !existing.ExpiresAt.IsZero() && r.lookup(existing) == existing
+		r.mutex.Unlock()
+		if completed {
+			return existing, true, nil
+		}
+		// La subida original se descartó: se vuelve a intentar la reserva
+	}
+}
+
+// Complete registra el resultado de la subida; se repite hasta que vence el ttl o se supera maxRecords
+func (r *UploadRegistry) Complete(record *UploadRecord, result *models.MigrationResult, job *MigrationJob) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	record.Result = result
+	record.Job = job
+	record.ExpiresAt = r.now().Add(r.ttl).UTC()
+	close(record.done)
+
+	// Una subida descartada mientras terminaba (ForgetMigration) no se recuerda
+	if r.lookup(record) != record {
+		return
+	}
+	record.completed = r.completed.PushBack(record)
+	for r.completed.Len() > r.maxRecords {
+		r.unindex(r.completed.Front().Value.(*UploadRecord))
+	}
+}
+
+// Release descarta la subida (la migración falló o ya no se debe repetir): un nuevo intento la procesa de nuevo
+func (r *UploadRegistry) Release(record *UploadRecord) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.lookup(record) == record {
+		r.unindex(record)
+	}
+	select {
+	case <-record.done:
+	default:
+		close(record.done)
+	}
+}
+
+// ForgetMigration descarta las subidas cuyo resultado es la migración migrationID (por ejemplo, al
+// revertirla), para que un nuevo intento importe el archivo otra vez
+func (r *UploadRegistry) ForgetMigration(migrationID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, records := range []map[string]*UploadRecord{r.byKey, r.byContent} {
+		for _, record := range records {
+			if record.migrationID() == migrationID {
+				r.unindex(record)
+			}
+		}
+	}
+}
+
+// migrationID retorna el ID de la migración de una subida terminada (vacío si sigue en curso)
+func (record *UploadRecord) migrationID() string {
+	switch {
+	case record.Result != nil:
+		return record.Result.MigrationID
+	case record.Job != nil:
+		return record.Job.ID
+	}
+	return ""
+}
+
+// lookup retorna el registro vigente con la misma clave o contenido que record
+func (r *UploadRegistry) lookup(record *UploadRecord) *UploadRecord {
+	if record.Key != "" {
+		return r.byKey[record.Key]
+	}
+	return r.byContent[record.Fingerprint]
+}
+
+func (r *UploadRegistry) index(record *UploadRecord) {
+	if record.Key != "" {
+		r.byKey[record.Key] = record
+	}
+	r.byContent[record.Fingerprint] = record
+}
+
+func (r *UploadRegistry) unindex(record *UploadRecord) {
+	if record.Key != "" && r.byKey[record.Key] == record {
+		delete(r.byKey, record.Key)
+	}
+	if r.byContent[record.Fingerprint] == record {
+		delete(r.byContent, record.Fingerprint)
+	}
+	if record.completed != nil {
+		r.completed.Remove(record.completed)
+		record.completed = nil
+	}
+}
+
+// purgeExpired descarta los resultados vencidos recorriendo solo el inicio de la lista de subidas
+// terminadas: con un ttl fijo vencen en el mismo orden en que terminaron. Las subidas en curso no vencen.
+func (r *UploadRegistry) purgeExpired() {
+	now := r.now()
+	for front := r.completed.Front(); front != nil; front = r.completed.Front() {
+		record := front.Value.(*UploadRecord)
+		if now.Before(record.ExpiresAt) {
+			return
+		}
+		r.unindex(record)
+	}
+}
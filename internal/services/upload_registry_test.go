@@ -0,0 +1,203 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadRegistry_Receive(t *testing.T) {
+	registry, err := NewUploadRegistry(time.Hour, 10, t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	content := "id,user_id,amount,datetime\n1,1001,100,2024-01-15\n"
+	upload, err := registry.Receive(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer upload.Remove()
+
+	sum := sha256.Sum256([]byte(content))
+	if upload.SHA256 != hex.EncodeToString(sum[:]) || upload.Size != int64(len(content)) {
+		t.Errorf("Unexpected upload %+v", upload)
+	}
+	if saved, _ := os.ReadFile(upload.Path); string(saved) != content {
+		t.Errorf("Expected spooled copy of the file, got %q", saved)
+	}
+
+	if _, err := NewUploadRegistry(0, 10, ""); err == nil {
+		t.Error("Expected error for zero expiry")
+	}
+	if _, err := NewUploadRegistry(time.Hour, 0, ""); err == nil {
+		t.Error("Expected error for zero record limit")
+	}
+}
+
+func TestUploadFingerprint(t *testing.T) {
+	base := UploadFingerprint("abc", MigrationOptions{})
+	if base != UploadFingerprint("abc", MigrationOptions{ConflictPolicy: ConflictOverwrite, Format: FormatCSV, SourceFile: "other.csv"}) {
+		t.Error("Expected defaults and file name not to change the fingerprint")
+	}
+	if base == UploadFingerprint("abd", MigrationOptions{}) || base == UploadFingerprint("abc", MigrationOptions{ConflictPolicy: ConflictSkip}) {
+		t.Error("Expected content and conflict policy to change the fingerprint")
+	}
+
+	mapping := ColumnMapping{"id": {"ref"}, "amount": {"importe"}}
+	if UploadFingerprint("abc", MigrationOptions{Mapping: mapping}) == base {
+		t.Error("Expected column mapping to change the fingerprint")
+	}
+}
+
+func TestUploadRegistry_BeginReplay(t *testing.T) {
+	registry, _ := NewUploadRegistry(time.Hour, 10, "")
+	ctx := context.Background()
+
+	// Sin clave la subida se identifica por su contenido y opciones
+	record, replay, err := registry.Begin(ctx, "", "sha", "fp-1")
+	if err != nil || replay {
+		t.Fatalf("Expected new upload, got replay %v, err %v", replay, err)
+	}
+	result := &models.MigrationResult{MigrationID: "mig-1"}
+	registry.Complete(record, result, nil)
+
+	again, replay, err := registry.Begin(ctx, "", "sha", "fp-1")
+	if err != nil || !replay || again.Result.MigrationID != "mig-1" {
+		t.Errorf("Expected replay of mig-1, got %+v, replay %v, err %v", again, replay, err)
+	}
+	if _, replay, _ := registry.Begin(ctx, "", "sha", "fp-2"); replay {
+		t.Error("Expected other options to start a new upload")
+	}
+
+	// Con clave: el mismo archivo se repite y otro archivo es un conflicto
+	keyed, _, _ := registry.Begin(ctx, "key-1", "sha", "fp-3")
+	registry.Complete(keyed, result, nil)
+	if _, replay, err := registry.Begin(ctx, "key-1", "sha", "fp-3"); err != nil || !replay {
+		t.Errorf("Expected replay for the same key, got replay %v, err %v", replay, err)
+	}
+	if _, _, err := registry.Begin(ctx, "key-1", "other", "fp-4"); err != ErrIdempotencyKeyConflict {
+		t.Errorf("Expected ErrIdempotencyKeyConflict, got %v", err)
+	}
+	if again, replay, _ := registry.Begin(ctx, "", "sha", "fp-3"); !replay || again != keyed {
+		t.Error("Expected a retry without key to find the keyed upload")
+	}
+}
+
+func TestUploadRegistry_WaitsForUploadInProgress(t *testing.T) {
+	registry, _ := NewUploadRegistry(time.Hour, 10, "")
+	ctx := context.Background()
+
+	first, _, _ := registry.Begin(ctx, "key", "sha", "fp")
+
+	type outcome struct {
+		record *UploadRecord
+		replay bool
+	}
+	results := make(chan outcome, 1)
+	go func() {
+		record, replay, _ := registry.Begin(ctx, "key", "sha", "fp")
+		results <- outcome{record, replay}
+	}()
+
+	// Si la subida original se descarta, el reintento la procesa de nuevo
+	registry.Release(first)
+	got := <-results
+	if got.replay || got.record == first {
+		t.Fatalf("Expected a new reservation after release, got %+v", got)
+	}
+
+	go func() {
+		record, replay, _ := registry.Begin(ctx, "key", "sha", "fp")
+		results <- outcome{record, replay}
+	}()
+	registry.Complete(got.record, &models.MigrationResult{}, nil)
+	if replayed := <-results; !replayed.replay || replayed.record != got.record {
+		t.Errorf("Expected replay after completion, got %+v", replayed)
+	}
+
+	// El cliente que espera puede cancelar
+	pending, _, _ := registry.Begin(ctx, "other", "sha", "fp")
+	defer registry.Release(pending)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := registry.Begin(cancelled, "other", "sha", "fp"); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestUploadRegistry_Expiry(t *testing.T) {
+	registry, _ := NewUploadRegistry(time.Hour, 10, "")
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	registry.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	record, _, _ := registry.Begin(ctx, "key", "sha", "fp")
+	registry.Complete(record, &models.MigrationResult{}, nil)
+
+	now = now.Add(59 * time.Minute)
+	if _, replay, _ := registry.Begin(ctx, "key", "sha", "fp"); !replay {
+		t.Error("Expected replay before expiry")
+	}
+
+	// Vencido el plazo, la misma clave se puede usar incluso con otro archivo
+	now = now.Add(time.Minute)
+	if _, replay, err := registry.Begin(ctx, "key", "other", "fp-2"); err != nil || replay {
+		t.Errorf("Expected new upload after expiry, got replay %v, err %v", replay, err)
+	}
+}
+
+func TestUploadRegistry_LimitsCompletedUploads(t *testing.T) {
+	registry, _ := NewUploadRegistry(time.Hour, 2, "")
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	registry.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, key := range []string{"key-1", "key-2", "key-3"} {
+		record, _, _ := registry.Begin(ctx, key, "sha", "fp-"+key)
+		registry.Complete(record, &models.MigrationResult{MigrationID: "mig-" + key}, nil)
+		now = now.Add(time.Minute)
+	}
+
+	// Con el límite superado se olvida la subida más antigua, antes de su vencimiento
+	if _, replay, _ := registry.Begin(ctx, "key-1", "sha", "fp-key-1"); replay {
+		t.Error("Expected the oldest upload to be forgotten")
+	}
+	if _, replay, _ := registry.Begin(ctx, "key-3", "sha", "fp-key-3"); !replay {
+		t.Error("Expected the newest upload to be kept")
+	}
+
+	// Las subidas vencen en el orden en que terminaron; las que siguen en curso no cuentan
+	now = now.Add(time.Hour - 2*time.Minute)
+	registry.mutex.Lock()
+	registry.purgeExpired()
+	remaining := registry.completed.Len()
+	registry.mutex.Unlock()
+	if remaining != 1 || len(registry.byKey) != 2 {
+		t.Errorf("Expected key-3 and the pending key-1 left, got %d completed and %d keys", remaining, len(registry.byKey))
+	}
+}
+
+func TestUploadRegistry_ForgetMigration(t *testing.T) {
+	registry, _ := NewUploadRegistry(time.Hour, 10, "")
+	ctx := context.Background()
+
+	record, _, _ := registry.Begin(ctx, "key", "sha", "fp")
+	registry.Complete(record, &models.MigrationResult{MigrationID: "mig-1"}, nil)
+	job, _, _ := registry.Begin(ctx, "", "sha", "fp-job")
+	registry.Complete(job, nil, &MigrationJob{ID: "mig-2"})
+
+	// Al revertir mig-1 la subida se procesa de nuevo, por clave y por contenido
+	registry.ForgetMigration("mig-1")
+	if _, replay, _ := registry.Begin(ctx, "key", "sha", "fp"); replay {
+		t.Error("Expected a new upload after forgetting the migration")
+	}
+	if _, replay, _ := registry.Begin(ctx, "", "sha", "fp-job"); !replay {
+		t.Error("Expected other migrations to be kept")
+	}
+}
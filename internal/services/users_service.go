@@ -2,27 +2,44 @@ package services
 
 import (
 	"api-stori/internal/models"
+	"fmt"
 	"time"
 )
 
 // UsersService maneja las operaciones de negocio relacionadas con usuarios
 type UsersService struct {
-	database *MockDatabase
+	database TransactionRepository
+	archive  *ArchiveService // Opcional: transacciones movidas fuera del almacén por la retención
 }
 
 // NewUsersService crea una nueva instancia de UsersService
-func NewUsersService(database *MockDatabase) *UsersService {
+func NewUsersService(database TransactionRepository) *UsersService {
 	return &UsersService{
 		database: database,
 	}
 }
 
+// SetArchive establece el archivo del que se leen los periodos archivados
+func (us *UsersService) SetArchive(archive *ArchiveService) {
+	us.archive = archive
+}
+
 // GetUserBalance obtiene el balance de un usuario con filtros opcionales de fecha
 func (us *UsersService) GetUserBalance(userID int, fromDate, toDate *time.Time) (*models.BalanceInfo, error) {
 	// Obtener transacciones del usuario (con filtro de fechas si se especifica)
 	userTransactions := us.database.GetTransactionsByUserIDWithDateRange(userID, fromDate, toDate)
 
-	// Si no hay transacciones, el usuario no existe
+	// Completar con el archivo si el rango llega a periodos archivados
+	if us.archive != nil {
+		archived, err := us.archive.GetTransactionsByUserIDWithDateRange(userID, fromDate, toDate)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archived transactions: %v", err)
+		}
+		userTransactions = us.mergeArchived(userTransactions, archived)
+	}
+
+	// Las transacciones con borrado lógico no cuentan: si no quedan otras, el usuario no existe
+	userTransactions = activeTransactions(userTransactions)
 	if len(userTransactions) == 0 {
 		return nil, ErrUserNotFound
 	}
@@ -37,6 +54,29 @@ func (us *UsersService) GetUserBalance(userID int, fromDate, toDate *time.Time)
 	}, nil
 }
 
+// mergeArchived agrega las transacciones archivadas cuyo ID no está en el almacén. La comparación es contra
+// la transacción vigente completa, no solo contra las del rango: si el almacén tiene el ID con otra fecha o
+// de otro usuario, la copia archivada está desactualizada y se descarta.
+func (us *UsersService) mergeArchived(transactions, archived []models.UserTransaction) []models.UserTransaction {
+	for _, transaction := range archived {
+		if _, live := us.database.GetTransaction(transaction.ID); !live {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions
+}
+
+// activeTransactions retorna las transacciones que no tienen borrado lógico
+func activeTransactions(transactions []models.UserTransaction) []models.UserTransaction {
+	active := make([]models.UserTransaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		if !transaction.Deleted {
+			active = append(active, transaction)
+		}
+	}
+	return active
+}
+
 // calculateBalance calcula el balance, total de débitos y créditos
 func (us *UsersService) calculateBalance(transactions []models.UserTransaction) (float64, float64, float64) {
 	var balance float64
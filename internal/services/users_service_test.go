@@ -89,3 +89,28 @@ func TestUsersService_GetUserBalanceWithDateRange(t *testing.T) {
 		t.Errorf("Expected 200.00 credit, got %f", float64(balance.TotalCredits))
 	}
 }
+
+func TestUsersService_GetUserBalanceExcludesSoftDeleted(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewUsersService(db)
+
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	db.SaveTransaction(models.UserTransaction{ID: 1, UserID: 1001, Amount: 150.50, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 2, UserID: 1001, Amount: -75.25, DateTime: baseTime})
+	db.SaveTransaction(models.UserTransaction{ID: 3, UserID: 1002, Amount: 20, DateTime: baseTime})
+	db.SoftDeleteTransaction(2, "duplicated")
+	db.SoftDeleteTransaction(3, "test data")
+
+	balance, err := service.GetUserBalance(1001, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if float64(balance.Balance) != 150.50 || float64(balance.TotalDebits) != 0 {
+		t.Errorf("Expected balance 150.50 without the deleted debit, got %+v", balance)
+	}
+
+	// Un usuario con todas sus transacciones eliminadas no existe, igual que uno sin transacciones
+	if _, err := service.GetUserBalance(1002, nil, nil); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
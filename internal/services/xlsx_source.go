@@ -0,0 +1,405 @@
+package services
+
+import (
+	"api-stori/internal/models"
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxXLSXSharedStringsSize tamaño máximo (descomprimido) de la tabla de textos del libro, que se carga en memoria
+const maxXLSXSharedStringsSize = 64 << 20
+
+// xlsxCell celda de una fila de la hoja
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"` // Coordenada, p. ej. "C5"
+	Type   string   `xml:"t,attr"` // s: texto compartido, inlineStr, str, b, e; vacío o n: número
+	Value  string   `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+// xlsxText texto simple o con formato (runs)
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	text := t.Text
+	for _, run := range t.Runs {
+		text += run.Text
+	}
+	return text
+}
+
+// xlsxRow fila de la hoja; las celdas vacías suelen omitirse
+type xlsxRow struct {
+	Number int        `xml:"r,attr"`
+	Cells  []xlsxCell `xml:"c"`
+}
+
+// xlsxSource lee las filas de una hoja de un libro XLSX. La hoja se descomprime y recorre en streaming;
+// solo la tabla de textos compartidos se carga en memoria.
+type xlsxSource struct {
+	sheet    string
+	body     io.ReadCloser
+	decoder  *xml.Decoder
+	strings  []string
+	date1904 bool
+	columns  columnIndex
+	row      int
+	spool    *os.File // Copia temporal del archivo subido; se borra al cerrar
+}
+
+// newXLSXSource abre el libro, elige la hoja sheet (nombre o posición desde 1; vacío = la primera)
+// y toma como header su primera fila con datos
+func newXLSXSource(reader io.Reader, sheet string, mapping ColumnMapping) (_ *xlsxSource, err error) {
+	// El formato ZIP necesita acceso aleatorio: si el archivo llega como stream se copia a disco
+	readerAt, size, spool, err := randomAccess(reader, FormatXLSX)
+	if err != nil {
+		return nil, err
+	}
+	source := &xlsxSource{spool: spool}
+	defer func() {
+		if err != nil {
+			source.Close()
+		}
+	}()
+
+	archive, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: %v", err)
+	}
+
+	sheetPath, err := source.readWorkbook(archive, sheet)
+	if err != nil {
+		return nil, err
+	}
+	if source.strings, err = readSharedStrings(archive); err != nil {
+		return nil, err
+	}
+
+	entry, err := openZipEntry(archive, sheetPath)
+	if err != nil {
+		return nil, err
+	}
+	source.body = entry
+	source.decoder = xml.NewDecoder(entry)
+
+	// El header es la primera fila con algún valor
+	for {
+		number, cells, readErr := source.nextRow()
+		if readErr == io.EOF {
+			return nil, fmt.Errorf("XLSX sheet %q is empty", source.sheet)
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		header := source.values(cells, 0)
+		if !isEmptyRow(header) {
+			if source.columns, err = resolveColumns(header, mapping); err != nil {
+				return nil, fmt.Errorf("sheet %q row %d: %v", source.sheet, number, err)
+			}
+			return source, nil
+		}
+	}
+}
+
+// readWorkbook lee la lista de hojas y la configuración de fechas, y retorna la ruta de la hoja elegida
+func (s *xlsxSource) readWorkbook(archive *zip.Reader, sheet string) (string, error) {
+	var workbook struct {
+		Properties struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipEntry(archive, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+
+	var relationships struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipEntry(archive, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return "", err
+	}
+
+	if len(workbook.Sheets) == 0 {
+		return "", fmt.Errorf("invalid XLSX file: workbook has no sheets")
+	}
+	s.date1904 = workbook.Properties.Date1904 == "1" || workbook.Properties.Date1904 == "true"
+
+	// Elegir la hoja por nombre o por posición
+	selected := -1
+	names := make([]string, len(workbook.Sheets))
+	for i, candidate := range workbook.Sheets {
+		names[i] = candidate.Name
+		if sheet != "" && strings.EqualFold(candidate.Name, strings.TrimSpace(sheet)) {
+			selected = i
+		}
+	}
+	if sheet == "" {
+		selected = 0
+	} else if position, err := strconv.Atoi(sheet); selected < 0 && err == nil && position >= 1 && position <= len(names) {
+		selected = position - 1
+	}
+	if selected < 0 {
+		return "", fmt.Errorf("sheet %q not found in XLSX file. Available sheets: %s", sheet, strings.Join(names, ", "))
+	}
+	s.sheet = names[selected]
+
+	for _, relationship := range relationships.Items {
+		if relationship.ID != workbook.Sheets[selected].RID {
+			continue
+		}
+		if strings.HasPrefix(relationship.Target, "/") {
+			return strings.TrimPrefix(relationship.Target, "/"), nil
+		}
+		return path.Join("xl", relationship.Target), nil
+	}
+	return "", fmt.Errorf("invalid XLSX file: no data for sheet %q", s.sheet)
+}
+
+// readSharedStrings carga la tabla de textos compartidos (opcional en el libro)
+func readSharedStrings(archive *zip.Reader) ([]string, error) {
+	file := findZipEntry(archive, "xl/sharedStrings.xml")
+	if file == nil {
+		return nil, nil
+	}
+	entry, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: xl/sharedStrings.xml: %v", err)
+	}
+	defer entry.Close()
+
+	var table struct {
+		Items []xlsxText `xml:"si"`
+	}
+	limited := &io.LimitedReader{R: entry, N: maxXLSXSharedStringsSize + 1}
+	if err := xml.NewDecoder(limited).Decode(&table); err != nil {
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("invalid XLSX file: shared strings exceed %d bytes", maxXLSXSharedStringsSize)
+		}
+		return nil, fmt.Errorf("invalid XLSX file: xl/sharedStrings.xml: %v", err)
+	}
+
+	values := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		values[i] = item.String()
+	}
+	return values, nil
+}
+
+func findZipEntry(archive *zip.Reader, name string) *zip.File {
+	for _, file := range archive.File {
+		if file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
+
+func openZipEntry(archive *zip.Reader, name string) (io.ReadCloser, error) {
+	file := findZipEntry(archive, name)
+	if file == nil {
+		return nil, fmt.Errorf("invalid XLSX file: missing %s", name)
+	}
+	entry, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: %s: %v", name, err)
+	}
+	return entry, nil
+}
+
+func decodeZipEntry(archive *zip.Reader, name string, target interface{}) error {
+	entry, err := openZipEntry(archive, name)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+
+	if err := xml.NewDecoder(entry).Decode(target); err != nil {
+		return fmt.Errorf("invalid XLSX file: %s: %v", name, err)
+	}
+	return nil
+}
+
+// Close libera la hoja y borra la copia temporal del archivo
+func (s *xlsxSource) Close() error {
+	if s.body != nil {
+		s.body.Close()
+	}
+	if s.spool != nil {
+		s.spool.Close()
+		os.Remove(s.spool.Name())
+	}
+	return nil
+}
+
+func (s *xlsxSource) next() (sourceRecord, error) {
+	for {
+		number, cells, err := s.nextRow()
+		if err != nil {
+			return sourceRecord{}, err
+		}
+
+		// Excel suele guardar filas vacías con formato; se ignoran
+		record := s.values(cells, s.columns.width)
+		if isEmptyRow(record) {
+			continue
+		}
+
+		transaction, err := s.parseRow(record, cells)
+		if err != nil {
+			err = fmt.Errorf("sheet %q row %d: %v", s.sheet, number, err)
+		}
+		return sourceRecord{line: number, transaction: transaction, err: err}, nil
+	}
+}
+
+// nextRow avanza hasta la siguiente fila de la hoja; io.EOF al terminar
+func (s *xlsxSource) nextRow() (int, []xlsxCell, error) {
+	for {
+		token, err := s.decoder.Token()
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("error reading XLSX sheet %q: %v", s.sheet, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+		if err := s.decoder.DecodeElement(&row, &start); err != nil {
+			return 0, nil, fmt.Errorf("error reading XLSX sheet %q: %v", s.sheet, err)
+		}
+		// El número de fila es opcional: sin él, la fila sigue a la anterior
+		if row.Number == 0 {
+			row.Number = s.row + 1
+		}
+		s.row = row.Number
+		return row.Number, row.Cells, nil
+	}
+}
+
+// values ubica el texto de cada celda en su columna. Las filas se completan hasta width
+// porque Excel omite las celdas vacías del final.
+func (s *xlsxSource) values(cells []xlsxCell, width int) []string {
+	record := make([]string, width)
+	for i, cell := range cells {
+		column := cellColumn(cell, i)
+		for len(record) <= column {
+			record = append(record, "")
+		}
+		record[column] = s.cellText(cell)
+	}
+
+	// Celdas vacías más allá del header no cuentan como columnas de más
+	for len(record) > width && record[len(record)-1] == "" {
+		record = record[:len(record)-1]
+	}
+	return record
+}
+
+// cellText texto de una celda según su tipo
+func (s *xlsxSource) cellText(cell xlsxCell) string {
+	switch cell.Type {
+	case "s":
+		index, err := strconv.Atoi(cell.Value)
+		if err != nil || index < 0 || index >= len(s.strings) {
+			return ""
+		}
+		return s.strings[index]
+	case "inlineStr":
+		return cell.Inline.String()
+	default:
+		return cell.Value
+	}
+}
+
+// parseRow valida la fila igual que una fila CSV. Una fecha guardada como número de serie de Excel
+// se convierte antes de validarla.
+func (s *xlsxSource) parseRow(record []string, cells []xlsxCell) (models.UserTransaction, error) {
+	if len(record) == s.columns.width {
+		for i, cell := range cells {
+			column := cellColumn(cell, i)
+			if column != s.columns.datetime || (cell.Type != "" && cell.Type != "n") {
+				continue
+			}
+			serial, err := strconv.ParseFloat(cell.Value, 64)
+			if err != nil {
+				return models.UserTransaction{}, fmt.Errorf("invalid datetime at line %d: %v", s.row, err)
+			}
+			datetime, err := excelSerialTime(serial, s.date1904)
+			if err != nil {
+				return models.UserTransaction{}, fmt.Errorf("invalid datetime at line %d: %v", s.row, err)
+			}
+			record[column] = datetime.Format("2006-01-02 15:04:05")
+		}
+	}
+	return parseTransaction(record, s.columns, s.row)
+}
+
+// excelSerialTime convierte un número de serie de Excel (días desde la época del libro, con la hora
+// como fracción del día) en fecha UTC, redondeada al segundo
+func excelSerialTime(serial float64, date1904 bool) (time.Time, error) {
+	if serial < 0 || serial > 2958465 { // 9999-12-31
+		return time.Time{}, fmt.Errorf("serial date %v out of range", serial)
+	}
+
+	// Época 1900: Excel cuenta el inexistente 29/02/1900, por eso se parte del 30/12/1899
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if serial < 61 {
+		epoch = epoch.AddDate(0, 0, 1)
+	}
+
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second), nil
+}
+
+// cellColumn índice desde 0 de la columna de la celda según su coordenada ("C5", "AB12").
+// Sin coordenada, la celda ocupa su posición en la fila.
+func cellColumn(cell xlsxCell, position int) int {
+	column := 0
+	for _, char := range strings.ToUpper(cell.Ref) {
+		if char < 'A' || char > 'Z' {
+			break
+		}
+		column = column*26 + int(char-'A') + 1
+	}
+	if column == 0 || column > 16384 { // XFD, la última columna de Excel
+		return position
+	}
+	return column - 1
+}
+
+func isEmptyRow(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildXLSX arma un libro mínimo con las hojas indicadas (nombre -> XML de sheetData) y textos compartidos
+func buildXLSX(t *testing.T, sheetNames []string, sheetData map[string]string, sharedStrings []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	write := func(name, content string) {
+		entry, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Expected no error creating %s, got %v", name, err)
+		}
+		entry.Write([]byte(content))
+	}
+
+	var sheets, relationships strings.Builder
+	for i, name := range sheetNames {
+		id := string(rune('1' + i))
+		sheets.WriteString(`<sheet name="` + name + `" sheetId="` + id + `" r:id="rId` + id + `"/>`)
+		relationships.WriteString(`<Relationship Id="rId` + id + `" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet` + id + `.xml"/>`)
+		write("xl/worksheets/sheet"+id+".xml", `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`+sheetData[name]+`</sheetData></worksheet>`)
+	}
+
+	write("xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`+sheets.String()+`</sheets></workbook>`)
+	write("xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+relationships.String()+`</Relationships>`)
+
+	var shared strings.Builder
+	for _, value := range sharedStrings {
+		shared.WriteString("<si><t>" + value + "</t></si>")
+	}
+	write("xl/sharedStrings.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`+shared.String()+`</sst>`)
+
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Expected no error closing XLSX, got %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMigrationService_ProcessFileXLSX(t *testing.T) {
+	db := NewMockDatabase()
+	service := NewMigrationService(db)
+	service.GetReportService().SetForceMockMode(true)
+
+	// Header con textos compartidos, fecha como número de serie, fecha como texto,
+	// una fila vacía con formato, un monto inválido y una celda omitida
+	data := `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="s"><v>3</v></c></row>
+<row r="2"><c r="A2"><v>1</v></c><c r="B2"><v>1001</v></c><c r="C2"><v>150.5</v></c><c r="D2" s="1"><v>45306.4375</v></c></row>
+<row r="3"><c r="A3"><v>2</v></c><c r="B3"><v>1001</v></c><c r="C3"><v>-20</v></c><c r="D3" t="inlineStr"><is><t>2024-01-16 09:15:00</t></is></c></row>
+<row r="4"><c r="A4" s="2"/><c r="B4" s="2"/></row>
+<row r="5"><c r="A5"><v>3</v></c><c r="B5"><v>1002</v></c><c r="C5" t="s"><v>4</v></c><c r="D5"><v>45306</v></c></row>
+<row r="7"><c r="A7"><v>4</v></c><c r="B7"><v>1002</v></c><c r="D7"><v>45306</v></c></row>`
+	workbook := buildXLSX(t, []string{"Notes", "Data"}, map[string]string{"Notes": "", "Data": data},
+		[]string{"id", "user_id", "amount", "datetime", "n/a"})
+
+	stats, err := service.ProcessFile(bytes.NewReader(workbook), MigrationOptions{Format: FormatXLSX, Sheet: "data", SourceFile: "finance.xlsx"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stats.TotalRecords != 4 || stats.SuccessRecords != 2 || stats.ErrorRecords != 2 {
+		t.Errorf("Expected 4 records, 2 success and 2 errors, got %+v", stats)
+	}
+
+	// Los errores indican hoja y fila
+	for i, expected := range []string{`Line 5: sheet "Data" row 5: invalid amount`, `Line 7: sheet "Data" row 7: invalid amount`} {
+		if i >= len(stats.Errors) || !strings.HasPrefix(stats.Errors[i], expected) {
+			t.Errorf("Expected error %d to start with %q, got %v", i, expected, stats.Errors)
+		}
+	}
+
+	tx, _ := db.GetTransaction(1)
+	if !tx.DateTime.Equal(time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)) || tx.Amount != 150.5 || tx.SourceLine != 2 {
+		t.Errorf("Unexpected transaction %+v", tx)
+	}
+
+	// La hoja también se elige por posición; un stream sin acceso aleatorio se copia a disco
+	validation, err := service.ValidateFile(io.MultiReader(bytes.NewReader(workbook)), MigrationOptions{Format: FormatXLSX, Sheet: "2"})
+	if err != nil || validation.Stats.TotalRecords != 4 {
+		t.Errorf("Unexpected validation %+v, err %v", validation, err)
+	}
+
+	// La primera hoja (vacía) se usa por defecto
+	if _, err := service.ProcessFile(bytes.NewReader(workbook), MigrationOptions{Format: FormatXLSX}); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("Expected empty sheet error, got %v", err)
+	}
+	if _, err := service.ProcessFile(bytes.NewReader(workbook), MigrationOptions{Format: FormatXLSX, Sheet: "Sheet9"}); err == nil || !strings.Contains(err.Error(), "Notes, Data") {
+		t.Errorf("Expected unknown sheet error listing sheets, got %v", err)
+	}
+	if _, err := service.ProcessFile(strings.NewReader("id,user_id"), MigrationOptions{Format: FormatXLSX}); err == nil || !strings.Contains(err.Error(), "invalid XLSX file") {
+		t.Errorf("Expected invalid XLSX error, got %v", err)
+	}
+}
+
+func TestExcelSerialTime(t *testing.T) {
+	tests := []struct {
+		serial   float64
+		date1904 bool
+		expected time.Time
+	}{
+		{45306.4375, false, time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{43844.4375, true, time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{1, false, time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{61, false, time.Date(1900, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{45306.99999999, false, time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := excelSerialTime(tt.serial, tt.date1904)
+		if err != nil || !got.Equal(tt.expected) {
+			t.Errorf("excelSerialTime(%v, %v) = %v, %v; expected %v", tt.serial, tt.date1904, got, err, tt.expected)
+		}
+	}
+
+	if _, err := excelSerialTime(-1, false); err == nil {
+		t.Error("Expected error for negative serial")
+	}
+}
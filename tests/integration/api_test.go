@@ -1,14 +1,27 @@
 package integration
 
 import (
+	"api-stori/internal/models"
 	"api-stori/tests/config"
 	"api-stori/tests/test_utils"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHealthEndpoint(t *testing.T) {
@@ -99,9 +112,79 @@ func TestMigrateEndpoint(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
 
-	// El endpoint /migrate ahora solo devuelve código HTTP sin body
-	if resp.ContentLength != 0 {
-		t.Errorf("Expected empty body, got content length %d", resp.ContentLength)
+	// El endpoint /migrate devuelve el resumen de la migración
+	var result models.MigrationResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	if result.TotalRecords != 3 || result.SuccessRecords != 3 || result.ErrorRecords != 0 || result.UsersAffected != 2 {
+		t.Errorf("Unexpected migration result %+v", result)
+	}
+	if result.MigrationID == "" || result.DateRange == nil {
+		t.Errorf("Expected migration ID and date range in result, got %+v", result)
+	}
+}
+
+func TestMigrateEndpointPartialSuccess(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := `id,user_id,amount,datetime
+1,8001,100.00,2024-01-15 10:30:00
+2,8001,invalid,2024-01-15 14:45:00
+3,8002,-40.00,2024-01-16 09:15:00`
+
+	tests := []struct {
+		name           string
+		prefer         string
+		expectedStatus int
+		expectedBody   bool
+	}{
+		{"Result body", "", http.StatusMultiStatus, true},
+		{"Explicit representation", "return=representation", http.StatusMultiStatus, true},
+		{"Legacy empty body", "return=minimal", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := createMultipartFormData(t, "csv_file", "partial.csv", csvContent)
+			req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+			if err != nil {
+				t.Fatalf("Expected no error creating request, got %v", err)
+			}
+			req.Header.Set("Content-Type", contentType)
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Expected no error making request, got %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if !tt.expectedBody {
+				if resp.ContentLength != 0 {
+					t.Errorf("Expected empty body, got content length %d", resp.ContentLength)
+				}
+				return
+			}
+
+			var result models.MigrationResult
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Expected no error decoding JSON, got %v", err)
+			}
+			if result.SuccessRecords != 2 || result.ErrorRecords != 1 || len(result.Errors) != 1 || result.ErrorsTruncated {
+				t.Errorf("Unexpected migration result %+v", result)
+			}
+			if result.TotalAmount != 60 || result.LargestAmount != 100 || result.SmallestAmount != -40 {
+				t.Errorf("Unexpected amount statistics %+v", result)
+			}
+		})
 	}
 }
 
@@ -162,6 +245,1152 @@ func TestMigrateEndpointNoFile(t *testing.T) {
 	}
 }
 
+func TestMigrateEndpointConflictPolicy(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00`
+	changedCSV := `id,user_id,amount,datetime
+1,1001,999.99,2024-01-15 10:30:00`
+
+	client := &http.Client{}
+	upload := func(content, query string) *http.Response {
+		body, contentType := createMultipartFormData(t, "csv_file", "test.csv", content)
+		req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate"+query, body)
+		if err != nil {
+			t.Fatalf("Expected no error creating request, got %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := upload(csvContent, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Una política desconocida se rechaza
+	if resp := upload(changedCSV, "?on_conflict=merge"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid policy, got %d", resp.StatusCode)
+	}
+
+	// Con reject el ID repetido no cambia el historial
+	upload(changedCSV, "?on_conflict=reject")
+
+	resp, err := http.Get(server.URL + config.GetPathAPI() + "/users/1001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	if result["balance"] != 150.50 {
+		t.Errorf("Expected balance 150.50 after rejected re-upload, got %v", result["balance"])
+	}
+}
+
+func TestMigrateEndpointAtomic(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := `id,user_id,amount,datetime
+1,1001,150.50,2024-01-15 10:30:00
+2,1001,invalid,2024-01-15 14:45:00
+3,1001,200.00,2024-01-16 09:15:00
+4,1001,50.00,invalid-date`
+
+	body, contentType := createMultipartFormData(t, "csv_file", "test.csv", csvContent)
+	req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate?atomic=true", body)
+	if err != nil {
+		t.Fatalf("Expected no error creating request, got %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	if errs, ok := result["errors"].([]interface{}); !ok || len(errs) != 2 {
+		t.Errorf("Expected the full list of 2 errors, got %v", result["errors"])
+	}
+
+	// Ninguna fila válida se guardó
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/1001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	if balanceResp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected user not found after rejected migration, got %d", balanceResp.StatusCode)
+	}
+}
+
+func TestMigrateEndpointFormFields(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	// Las opciones enviadas como campos del formulario antes del archivo se aplican igual que en el query string
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	writer.WriteField("atomic", "true")
+	fileWriter, err := writer.CreateFormFile("csv_file", "fields.csv")
+	if err != nil {
+		t.Fatalf("Expected no error creating form file, got %v", err)
+	}
+	fileWriter.Write([]byte("id,user_id,amount,datetime\n1,5001,10.00,2024-01-15 10:30:00\n2,5001,invalid,2024-01-15 10:30:00"))
+	writer.Close()
+
+	req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", &buf)
+	if err != nil {
+		t.Fatalf("Expected no error creating request, got %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for atomic migration with an invalid row, got %d", resp.StatusCode)
+	}
+}
+
+func TestMigrateEndpointAsync(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := `id,user_id,amount,datetime
+1,6001,150.50,2024-01-15 10:30:00
+2,6001,invalid,2024-01-15 14:45:00
+3,6001,-50.50,2024-01-16 09:15:00`
+
+	body, contentType := createMultipartFormData(t, "csv_file", "async.csv", csvContent)
+	req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate?async=true", body)
+	if err != nil {
+		t.Fatalf("Expected no error creating request, got %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", resp.StatusCode)
+	}
+
+	var job map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	location := resp.Header.Get("Location")
+	if location != config.GetPathAPI()+"/migrations/"+job["id"].(string) {
+		t.Fatalf("Expected Location of job %v, got %q", job["id"], location)
+	}
+
+	// Consultar el estado hasta que el trabajo termine
+	deadline := time.Now().Add(5 * time.Second)
+	for job["state"] == "queued" || job["state"] == "running" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for migration job, last state %v", job["state"])
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		statusResp, err := http.Get(server.URL + location)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if statusResp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", statusResp.StatusCode)
+		}
+		job = nil
+		json.NewDecoder(statusResp.Body).Decode(&job)
+		statusResp.Body.Close()
+	}
+
+	if job["state"] != "succeeded" {
+		t.Fatalf("Expected succeeded job, got %v", job)
+	}
+	stats, _ := job["stats"].(map[string]interface{})
+	if stats["success_records"] != float64(2) || stats["error_records"] != float64(1) {
+		t.Errorf("Unexpected job stats %v", stats)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/6001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 100 {
+		t.Errorf("Expected balance 100 after async migration, got %v", balance.Balance)
+	}
+
+	// Un ID desconocido responde 404
+	notFoundResp, err := http.Get(server.URL + config.GetPathAPI() + "/migrations/mig-unknown")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer notFoundResp.Body.Close()
+
+	if notFoundResp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown migration, got %d", notFoundResp.StatusCode)
+	}
+}
+
+func TestMigrateValidateEndpoint(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := `id,user_id,amount,datetime
+1,7001,150.50,2024-01-15 10:30:00
+2,7001,invalid,2024-01-15 14:45:00`
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{"Validate endpoint", "/migrate/validate", http.StatusOK},
+		{"Dry run", "/migrate?dry_run=true", http.StatusOK},
+		{"Dry run with async", "/migrate?dry_run=true&async=true", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := createMultipartFormData(t, "csv_file", "validate.csv", csvContent)
+			req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+tt.path, body)
+			if err != nil {
+				t.Fatalf("Expected no error creating request, got %v", err)
+			}
+			req.Header.Set("Content-Type", contentType)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Expected no error making request, got %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result struct {
+				Valid bool `json:"valid"`
+				Stats struct {
+					SuccessRecords int      `json:"success_records"`
+					ErrorRecords   int      `json:"error_records"`
+					Errors         []string `json:"errors"`
+				} `json:"stats"`
+				Report models.MigrationReport `json:"report"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Expected no error decoding JSON, got %v", err)
+			}
+			if result.Valid || result.Stats.SuccessRecords != 1 || result.Stats.ErrorRecords != 1 || len(result.Stats.Errors) != 1 {
+				t.Errorf("Unexpected validation result %+v", result)
+			}
+			if result.Report.TotalAmount != 150.50 {
+				t.Errorf("Expected report total amount 150.50, got %v", result.Report.TotalAmount)
+			}
+		})
+	}
+
+	// La validación no guarda nada
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/7001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	if balanceResp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected user not found after validation, got %d", balanceResp.StatusCode)
+	}
+}
+
+func TestMigrateEndpointColumnMapping(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := `UserId,Timestamp,Amount,Channel,ID
+9001,2024-01-15 10:30:00,120.00,web,1
+9001,2024-01-16 09:15:00,-20.00,app,2`
+
+	upload := func(query string) *http.Response {
+		body, contentType := createMultipartFormData(t, "csv_file", "partner.csv", csvContent)
+		req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate"+query, body)
+		if err != nil {
+			t.Fatalf("Expected no error creating request, got %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := upload("?mapping_profile=unknown"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown profile, got %d", resp.StatusCode)
+	}
+	if resp := upload("?column_mapping=" + url.QueryEscape(`{"balance":"Amount"}`)); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid mapping, got %d", resp.StatusCode)
+	}
+
+	mapping := url.QueryEscape(`{"user_id":"userid","datetime":"timestamp"}`)
+	if resp := upload("?column_mapping=" + mapping); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/9001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 100 {
+		t.Errorf("Expected balance 100 after mapped migration, got %v", balance.Balance)
+	}
+}
+
+func TestMigrateEndpointJSON(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	post := func(query, contentType string, body *bytes.Buffer) (int, models.MigrationResult) {
+		req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate"+query, body)
+		if err != nil {
+			t.Fatalf("Expected no error creating request, got %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.MigrationResult
+		if resp.Header.Get("Content-Type") == "application/json" {
+			json.NewDecoder(resp.Body).Decode(&result)
+		}
+		return resp.StatusCode, result
+	}
+
+	// Body JSON Lines: una línea inválida se reporta igual que una fila CSV
+	ndjson := bytes.NewBufferString(`{"id": 1, "user_id": 9101, "amount": 100, "datetime": "2024-01-15T10:30:00Z"}
+{"id": 2, "user_id": 9101, "amount": "bad", "datetime": "2024-01-16T10:30:00Z"}
+`)
+	if status, result := post("", "application/x-ndjson", ndjson); status != http.StatusMultiStatus || result.SuccessRecords != 1 || result.ErrorRecords != 1 {
+		t.Errorf("Unexpected NDJSON response %d %+v", status, result)
+	}
+
+	// Body con un arreglo JSON y opciones en el query string
+	array := bytes.NewBufferString(`[{"id": 3, "user_id": 9101, "amount": -30, "datetime": "2024-01-17T10:30:00Z"}]`)
+	if status, result := post("?on_conflict=skip&filename=batch.json", "application/json", array); status != http.StatusOK || result.SuccessRecords != 1 || result.ConflictPolicy != "skip" {
+		t.Errorf("Unexpected JSON response %d %+v", status, result)
+	}
+
+	// Archivo .json en un formulario multipart
+	body, contentType := createMultipartFormData(t, "csv_file", "more.json", `[{"id": 4, "user_id": 9101, "amount": 5, "datetime": "2024-01-18"}]`)
+	if status, result := post("", contentType, body); status != http.StatusOK || result.SuccessRecords != 1 {
+		t.Errorf("Unexpected multipart JSON response %d %+v", status, result)
+	}
+
+	// Un body que no es un arreglo se rechaza sin migrar nada (igual que un CSV sin header válido)
+	if status, _ := post("", "application/json", bytes.NewBufferString(`{"id": 5}`)); status != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 for non-array body, got %d", status)
+	}
+	if status, _ := post("", "text/plain", bytes.NewBufferString("1,9101,5,2024-01-18")); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unsupported Content-Type, got %d", status)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/9101/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 75 {
+		t.Errorf("Expected balance 75 after JSON migrations, got %v", balance.Balance)
+	}
+}
+
+func TestMigrateEndpointStatement(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	post := func(query, fileName, content string) (int, models.MigrationResult) {
+		body, contentType := createMultipartFormData(t, "csv_file", fileName, content)
+		resp, err := http.Post(server.URL+config.GetPathAPI()+"/migrate"+query, contentType, body)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.MigrationResult
+		if resp.Header.Get("Content-Type") == "application/json" {
+			json.NewDecoder(resp.Body).Decode(&result)
+		}
+		return resp.StatusCode, result
+	}
+
+	// Un QIF sin extensión conocida se reconoce por su contenido; la cuenta no configurada usa default_user_id
+	qif := "!Type:Bank\nD01/15/2024\nT-25.00\nPGroceries\n^\nD01/16/2024\nT100\nPRefund\n^\n"
+	if status, result := post("?default_user_id=9201", "export.dat", qif); status != http.StatusOK || result.SuccessRecords != 2 {
+		t.Errorf("Unexpected QIF response %d %+v", status, result)
+	}
+
+	// Importar el mismo extracto otra vez con on_conflict=skip no duplica las transacciones
+	if status, result := post("?default_user_id=9201&on_conflict=skip", "export.qif", qif); status != http.StatusOK || result.SkippedRecords != 2 {
+		t.Errorf("Unexpected QIF re-import response %d %+v", status, result)
+	}
+
+	if status, _ := post("?default_user_id=0", "export.qif", qif); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid default_user_id, got %d", status)
+	}
+	if status, _ := post("", "export.dat", "just some text"); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown format, got %d", status)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/9201/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 75 {
+		t.Errorf("Expected balance 75 after statement imports, got %v", balance.Balance)
+	}
+}
+
+func TestMigrateEndpointCompressed(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	post := func(contentType, contentEncoding string, body *bytes.Buffer) (int, models.MigrationResult) {
+		req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+		if err != nil {
+			t.Fatalf("Expected no error creating request, got %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", contentEncoding)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.MigrationResult
+		if resp.Header.Get("Content-Type") == "application/json" {
+			json.NewDecoder(resp.Body).Decode(&result)
+		}
+		return resp.StatusCode, result
+	}
+	compress := func(content string) string {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write([]byte(content))
+		writer.Close()
+		return buf.String()
+	}
+
+	// Archivo .csv.gz en el formulario
+	body, contentType := createMultipartFormData(t, "csv_file", "nightly.csv.gz", compress("id,user_id,amount,datetime\n1,9301,100,2024-01-15\n"))
+	if status, result := post(contentType, "", body); status != http.StatusOK || result.SuccessRecords != 1 {
+		t.Errorf("Unexpected .csv.gz response %d %+v", status, result)
+	}
+
+	// Body JSON Lines con Content-Encoding: gzip
+	ndjson := bytes.NewBufferString(compress(`{"id": 2, "user_id": 9301, "amount": -25, "datetime": "2024-01-16"}`))
+	if status, result := post("application/x-ndjson", "gzip", ndjson); status != http.StatusOK || result.SuccessRecords != 1 {
+		t.Errorf("Unexpected gzip body response %d %+v", status, result)
+	}
+	if status, _ := post("application/x-ndjson", "br", bytes.NewBufferString("{}")); status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for unsupported Content-Encoding, got %d", status)
+	}
+
+	// ZIP con varios CSV: un solo resultado con el detalle por archivo
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"a.csv": "id,user_id,amount,datetime\n3,9301,10,2024-01-17\n",
+		"b.csv": "id,user_id,amount,datetime\n4,9301,15,2024-01-18\n5,9301,bad,2024-01-18\n",
+	} {
+		entry, _ := writer.Create(name)
+		entry.Write([]byte(content))
+	}
+	writer.Close()
+
+	body, contentType = createMultipartFormData(t, "csv_file", "batch.zip", archive.String())
+	status, result := post(contentType, "", body)
+	if status != http.StatusMultiStatus || result.SuccessRecords != 2 || result.ErrorRecords != 1 || len(result.Files) != 2 {
+		t.Errorf("Unexpected ZIP response %d %+v", status, result)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/9301/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 100 {
+		t.Errorf("Expected balance 100 after compressed uploads, got %v", balance.Balance)
+	}
+}
+
+func TestMigrateEndpointIdempotency(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	post := func(key, query, content string) (*http.Response, models.MigrationResult) {
+		body, contentType := createMultipartFormData(t, "csv_file", "retry.csv", content)
+		req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate"+query, body)
+		if err != nil {
+			t.Fatalf("Expected no error creating request, got %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.MigrationResult
+		if resp.Header.Get("Content-Type") == "application/json" {
+			json.NewDecoder(resp.Body).Decode(&result)
+		}
+		return resp, result
+	}
+	history := func(id string) int {
+		resp, err := http.Get(server.URL + config.GetPathAPI() + "/transactions/" + id + "/history")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var history models.TransactionHistory
+		json.NewDecoder(resp.Body).Decode(&history)
+		return len(history.Versions)
+	}
+
+	content := "id,user_id,amount,datetime\n1,9401,100,2024-01-15\n2,9401,bad,2024-01-16\n"
+
+	// Primera subida: se importa y el resultado registra el SHA-256 del archivo
+	resp, first := post("retry-1", "", content)
+	if resp.StatusCode != http.StatusMultiStatus || first.SuccessRecords != 1 || len(first.SHA256) != 64 {
+		t.Fatalf("Unexpected first response %d %+v", resp.StatusCode, first)
+	}
+
+	// El reintento con la misma clave repite el resultado sin importar de nuevo
+	resp, replayed := post("retry-1", "", content)
+	if resp.StatusCode != http.StatusMultiStatus || resp.Header.Get("Idempotent-Replayed") != "true" || replayed.MigrationID != first.MigrationID {
+		t.Errorf("Expected replay of %s, got %d %+v", first.MigrationID, resp.StatusCode, replayed)
+	}
+
+	// Sin clave, el mismo archivo con las mismas opciones también se repite
+	if resp, replayed = post("", "", content); resp.Header.Get("Idempotent-Replayed") != "true" || replayed.MigrationID != first.MigrationID {
+		t.Errorf("Expected replay by content, got %+v", replayed)
+	}
+	if versions := history("1"); versions != 1 {
+		t.Errorf("Expected the transaction to be written once, got %d versions", versions)
+	}
+
+	// La misma clave con otro archivo es un conflicto
+	if resp, _ = post("retry-1", "", content+"3,9401,5,2024-01-17\n"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409 for a different file, got %d", resp.StatusCode)
+	}
+
+	// El mismo archivo con otras opciones es otra migración
+	resp, skipped := post("", "?on_conflict=skip", content)
+	if resp.Header.Get("Idempotent-Replayed") != "" || skipped.MigrationID == first.MigrationID || skipped.SkippedRecords != 1 || skipped.SHA256 != first.SHA256 {
+		t.Errorf("Expected a new migration with the same SHA-256, got %+v", skipped)
+	}
+
+	// Una subida asíncrona repetida responde el mismo trabajo
+	resp, _ = post("retry-async", "?async=true", "id,user_id,amount,datetime\n10,9402,5,2024-01-15\n")
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusAccepted || location == "" {
+		t.Fatalf("Expected status 202 with Location, got %d", resp.StatusCode)
+	}
+	resp, _ = post("retry-async", "?async=true", "id,user_id,amount,datetime\n10,9402,5,2024-01-15\n")
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Location") != location || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected replay of job %s, got %d %s", location, resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// Sin clave, la subida asíncrona también se repite por contenido
+	resp, _ = post("", "?async=true", "id,user_id,amount,datetime\n10,9402,5,2024-01-15\n")
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Location") != location || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected replay of job %s by content, got %d %s", location, resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	if resp, _ = post(strings.Repeat("k", 256), "", content); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a too long key, got %d", resp.StatusCode)
+	}
+}
+
+func TestResumableUploadEndpoints(t *testing.T) {
+	t.Setenv("MIGRATION_UPLOAD_DIR", t.TempDir())
+	t.Setenv("MIGRATION_UPLOAD_MAX_SIZE", "1000")
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := "id,user_id,amount,datetime\n1,6101,150.50,2024-01-15 10:30:00\n2,6101,-50.50,2024-01-16 09:15:00\n"
+	tusRequest := func(method, path string, body string, headers map[string]string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Expected no error creating request, got %v", err)
+		}
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	chunk := func(offset int) map[string]string {
+		return map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": strconv.Itoa(offset)}
+	}
+
+	// El tamaño máximo se anuncia en OPTIONS y una subida más grande se rechaza
+	if options := tusRequest("OPTIONS", config.GetPathAPI()+"/uploads", "", nil); options.Header.Get("Tus-Max-Size") != "1000" {
+		t.Errorf("Expected Tus-Max-Size 1000, got %q", options.Header.Get("Tus-Max-Size"))
+	}
+	if tooLarge := tusRequest("POST", config.GetPathAPI()+"/uploads", "", map[string]string{"Upload-Length": "1001"}); tooLarge.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for an upload over the maximum size, got %d", tooLarge.StatusCode)
+	}
+
+	// Opciones inválidas se rechazan antes de recibir el archivo
+	invalid := tusRequest("POST", config.GetPathAPI()+"/uploads", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(csvContent)),
+		"Upload-Metadata": "on_conflict " + base64.StdEncoding.EncodeToString([]byte("maybe")),
+	})
+	if invalid.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid options, got %d", invalid.StatusCode)
+	}
+
+	created := tusRequest("POST", config.GetPathAPI()+"/uploads", "", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(csvContent)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("big.csv")),
+	})
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", created.StatusCode)
+	}
+	location := created.Header.Get("Location")
+	if !strings.HasPrefix(location, config.GetPathAPI()+"/uploads/") {
+		t.Fatalf("Unexpected Location %q", location)
+	}
+
+	// Primera parte; después HEAD indica desde dónde retomar
+	first := tusRequest("PATCH", location, csvContent[:20], chunk(0))
+	if first.StatusCode != http.StatusNoContent || first.Header.Get("Upload-Offset") != "20" {
+		t.Fatalf("Expected status 204 at offset 20, got %d at %q", first.StatusCode, first.Header.Get("Upload-Offset"))
+	}
+	head := tusRequest("HEAD", location, "", nil)
+	if head.StatusCode != http.StatusOK || head.Header.Get("Upload-Offset") != "20" || head.Header.Get("Upload-Length") != strconv.Itoa(len(csvContent)) {
+		t.Fatalf("Unexpected HEAD response %d %v", head.StatusCode, head.Header)
+	}
+	if mismatch := tusRequest("PATCH", location, csvContent[10:], chunk(10)); mismatch.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409 for wrong offset, got %d", mismatch.StatusCode)
+	}
+	if stale := tusRequest("PATCH", location, csvContent, chunk(0)); stale.StatusCode != http.StatusConflict || stale.Header.Get("Upload-Offset") != "20" {
+		t.Errorf("Expected status 409 at offset 20 for a stale offset, got %d at %q", stale.StatusCode, stale.Header.Get("Upload-Offset"))
+	}
+	if tooLarge := tusRequest("PATCH", location, csvContent, chunk(20)); tooLarge.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a part over Upload-Length, got %d", tooLarge.StatusCode)
+	}
+
+	// Una parte cortada a mitad del body conserva lo recibido y responde el offset desde donde retomar
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no error connecting, got %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "PATCH %s HTTP/1.1\r\nHost: localhost\r\nTus-Resumable: 1.0.0\r\n"+
+		"Content-Type: application/offset+octet-stream\r\nUpload-Offset: 20\r\nContent-Length: %d\r\n\r\n%s",
+		location, len(csvContent)-20, csvContent[20:30])
+	conn.(*net.TCPConn).CloseWrite()
+	interrupted, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Expected a response to the interrupted part, got %v", err)
+	}
+	interrupted.Body.Close()
+	if interrupted.StatusCode != http.StatusBadRequest || interrupted.Header.Get("Upload-Offset") != "30" {
+		t.Errorf("Expected status 400 at offset 30 for an interrupted part, got %d at %q", interrupted.StatusCode, interrupted.Header.Get("Upload-Offset"))
+	}
+
+	// La última parte completa el archivo y encola su migración
+	last := tusRequest("PATCH", location, csvContent[30:], chunk(30))
+	if last.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", last.StatusCode)
+	}
+	migrationLocation := last.Header.Get("Migration-Location")
+	if !strings.HasPrefix(migrationLocation, config.GetPathAPI()+"/migrations/") {
+		t.Fatalf("Expected Migration-Location, got %q", migrationLocation)
+	}
+
+	var job map[string]interface{}
+	deadline := time.Now().Add(5 * time.Second)
+	for job == nil || job["state"] == "queued" || job["state"] == "running" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for migration job, last state %v", job["state"])
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		statusResp, err := http.Get(server.URL + migrationLocation)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		job = nil
+		json.NewDecoder(statusResp.Body).Decode(&job)
+		statusResp.Body.Close()
+	}
+	contentSHA := sha256.Sum256([]byte(csvContent))
+	if job["state"] != "succeeded" || job["source_file"] != "big.csv" || job["sha256"] != hex.EncodeToString(contentSHA[:]) {
+		t.Fatalf("Expected succeeded job for big.csv, got %v", job)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/6101/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 100 {
+		t.Errorf("Expected balance 100 after resumable upload, got %v", balance.Balance)
+	}
+
+	// Cancelar una subida la elimina; sin Tus-Resumable se responde 412
+	cancelled := tusRequest("POST", config.GetPathAPI()+"/uploads", "", map[string]string{"Upload-Length": "100"})
+	if deleted := tusRequest("DELETE", cancelled.Header.Get("Location"), "", nil); deleted.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", deleted.StatusCode)
+	}
+	if gone := tusRequest("HEAD", cancelled.Header.Get("Location"), "", nil); gone.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 after termination, got %d", gone.StatusCode)
+	}
+	if resp := tusRequest("POST", config.GetPathAPI()+"/uploads", "", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "100"}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412, got %d", resp.StatusCode)
+	}
+}
+
+func TestTransactionHistoryEndpoint(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	client := &http.Client{}
+	for _, amount := range []string{"150.50", "99.99"} {
+		csvContent := "id,user_id,amount,datetime\n1,1001," + amount + ",2024-01-15 10:30:00"
+		body, contentType := createMultipartFormData(t, "csv_file", "test.csv", csvContent)
+		req, _ := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(server.URL + config.GetPathAPI() + "/transactions/1/history")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var history models.TransactionHistory
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	if history.CurrentVersion != 2 || len(history.Versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %+v", history)
+	}
+	if history.Versions[0].Transaction.Amount != 150.50 || history.Versions[1].Transaction.Amount != 99.99 {
+		t.Errorf("Expected versions to keep prior values, got %+v", history.Versions)
+	}
+	if history.Versions[0].MigrationID == "" || history.Versions[0].MigrationID == history.Versions[1].MigrationID {
+		t.Errorf("Expected each version to record its own migration ID, got %q and %q",
+			history.Versions[0].MigrationID, history.Versions[1].MigrationID)
+	}
+}
+
+func TestMigrationTransactionsEndpoint(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	csvContent := "id,user_id,amount,datetime\n1,1001,150.50,2024-01-15 10:30:00\n2,1002,99.99,2024-01-16 10:30:00"
+	body, contentType := createMultipartFormData(t, "csv_file", "january.csv", csvContent)
+	req, _ := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	resp.Body.Close()
+
+	// El ID de la migración se obtiene del historial de una de sus transacciones
+	resp, err = http.Get(server.URL + config.GetPathAPI() + "/transactions/1/history")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var history models.TransactionHistory
+	json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	if len(history.Versions) == 0 {
+		t.Fatalf("Expected history for transaction 1, got %+v", history)
+	}
+	migrationID := history.Versions[len(history.Versions)-1].MigrationID
+
+	resp, err = http.Get(server.URL + config.GetPathAPI() + "/migrations/" + migrationID + "/transactions")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var result models.MigrationTransactions
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	if result.Count != 2 || len(result.Transactions) != 2 {
+		t.Fatalf("Expected 2 transactions, got %+v", result)
+	}
+	for i, transaction := range result.Transactions {
+		if transaction.SourceFile != "january.csv" || transaction.SourceLine != i+2 || transaction.ImportedAt == nil {
+			t.Errorf("Expected provenance january.csv line %d, got %+v", i+2, transaction)
+		}
+	}
+
+	resp, err = http.Get(server.URL + config.GetPathAPI() + "/migrations/mig-unknown/transactions")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown migration, got %d", resp.StatusCode)
+	}
+}
+
+func TestRollbackMigrationEndpoint(t *testing.T) {
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	migrate := func(content string) models.MigrationResult {
+		body, contentType := createMultipartFormData(t, "csv_file", "rollback.csv", content)
+		resp, err := http.Post(server.URL+config.GetPathAPI()+"/migrate", contentType, body)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.MigrationResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+	rollback := func(migrationID, query string) (int, models.RollbackReport) {
+		req, _ := http.NewRequest("DELETE", server.URL+config.GetPathAPI()+"/migrations/"+migrationID+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error making request, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var report models.RollbackReport
+		if resp.Header.Get("Content-Type") == "application/json" {
+			json.NewDecoder(resp.Body).Decode(&report)
+		}
+		return resp.StatusCode, report
+	}
+	balance := func() float64 {
+		resp, err := http.Get(server.URL + config.GetPathAPI() + "/users/9501/balance")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+
+		var info models.BalanceInfo
+		json.NewDecoder(resp.Body).Decode(&info)
+		return float64(info.Balance)
+	}
+
+	migrate("id,user_id,amount,datetime\n1,9501,100,2024-01-15\n")
+	bad := "id,user_id,amount,datetime\n1,9501,1000,2024-01-15\n2,9501,500,2024-01-16\n"
+	wrong := migrate(bad)
+	if balance() != 1500 {
+		t.Fatalf("Expected balance 1500 after the bad file, got %v", balance())
+	}
+
+	// dry_run muestra la reversión sin aplicarla
+	if status, report := rollback(wrong.MigrationID, "?dry_run=true"); status != http.StatusOK || !report.DryRun || report.RestoredRecords != 1 || report.DeletedRecords != 1 || balance() != 1500 {
+		t.Errorf("Unexpected dry run %d %+v", status, report)
+	}
+
+	status, report := rollback(wrong.MigrationID, "")
+	if status != http.StatusOK || report.RestoredRecords != 1 || report.DeletedRecords != 1 {
+		t.Fatalf("Unexpected rollback %d %+v", status, report)
+	}
+	if balance() != 100 {
+		t.Errorf("Expected balance 100 after rollback, got %v", balance())
+	}
+
+	// Después de revertirla, la misma subida se importa de nuevo en lugar de repetir el resultado
+	if again := migrate(bad); again.MigrationID == wrong.MigrationID || again.SuccessRecords != 2 {
+		t.Errorf("Expected a new import after rollback, got %+v", again)
+	}
+
+	if status, _ := rollback("mig-unknown", ""); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown migration, got %d", status)
+	}
+	if status, _ := rollback(wrong.MigrationID, "?dry_run=maybe"); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid dry_run, got %d", status)
+	}
+}
+
+func TestSnapshotExportImportEndpoints(t *testing.T) {
+	adminToken := "test-admin-token"
+	t.Setenv("ADMIN_TOKEN", adminToken)
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	client := &http.Client{}
+
+	csvContent := "id,user_id,amount,datetime\n1,1001,150.50,2024-01-15 10:30:00\n2,1001,-75.25,2024-01-16 10:30:00"
+	body, contentType := createMultipartFormData(t, "csv_file", "january.csv", csvContent)
+	req, _ := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	resp.Body.Close()
+
+	// Exportar
+	req, _ = http.NewRequest("GET", server.URL+config.GetPathAPI()+"/admin/snapshot", nil)
+	req.Header.Set("X-Admin-Token", adminToken)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error exporting, got %v", err)
+	}
+	var snapshot bytes.Buffer
+	snapshot.ReadFrom(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-Snapshot-Count") != "2" {
+		t.Errorf("Expected 2 transactions in manifest, got %s", resp.Header.Get("X-Snapshot-Count"))
+	}
+	checksum := resp.Header.Get("X-Snapshot-SHA256")
+
+	// Reimportar reemplazando el contenido, verificando el checksum del manifiesto
+	req, _ = http.NewRequest("POST", server.URL+config.GetPathAPI()+"/admin/snapshot?mode=replace&sha256="+checksum, &snapshot)
+	req.Header.Set("X-Admin-Token", adminToken)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error importing, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected no error decoding JSON, got %v", err)
+	}
+	if result["count"] != float64(2) || result["sha256"] != checksum {
+		t.Errorf("Unexpected import result %v", result)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/1001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer balanceResp.Body.Close()
+
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	if balance.Balance != 75.25 {
+		t.Errorf("Expected balance 75.25 after round trip, got %.2f", balance.Balance)
+	}
+}
+
+func TestArchiveEndpoints(t *testing.T) {
+	t.Setenv("ARCHIVE_DIR", t.TempDir())
+	adminToken := "test-admin-token"
+	t.Setenv("ADMIN_TOKEN", adminToken)
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	client := &http.Client{}
+
+	csvContent := "id,user_id,amount,datetime\n1,3001,100.00,2020-01-15 10:30:00\n2,3001,-40.00,2020-02-15 10:30:00\n3,3001,25.00,2999-01-01 00:00:00"
+	body, contentType := createMultipartFormData(t, "csv_file", "old.csv", csvContent)
+	req, _ := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	resp.Body.Close()
+
+	// Archivar las transacciones con más de un año de antigüedad
+	req, _ = http.NewRequest("POST", server.URL+config.GetPathAPI()+"/admin/archive?older_than_days=365", nil)
+	req.Header.Set("X-Admin-Token", adminToken)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error archiving, got %v", err)
+	}
+	var run models.ArchiveRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if run.ArchivedCount != 2 || len(run.Partitions) != 2 {
+		t.Errorf("Expected 2 transactions archived in 2 partitions, got %+v", run)
+	}
+
+	// El balance incluye las transacciones archivadas
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/3001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	balanceResp.Body.Close()
+	if balance.Balance != 85 || balance.TotalDebits != -40 {
+		t.Errorf("Expected balance 85.00 including archived debits, got %+v", balance)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+config.GetPathAPI()+"/admin/archive", nil)
+	req.Header.Set("X-Admin-Token", adminToken)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	var status models.ArchiveStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	if status.TotalArchived != 2 || len(status.Runs) != 1 {
+		t.Errorf("Unexpected archive status %+v", status)
+	}
+}
+
+func TestDeleteTransactionEndpoint(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin-token")
+	server := test_utils.SetupTestServer()
+	defer server.Close()
+
+	client := &http.Client{}
+
+	csvContent := "id,user_id,amount,datetime\n1,4001,100.00,2024-01-15 10:30:00\n2,4001,-30.00,2024-01-16 10:30:00"
+	body, contentType := createMultipartFormData(t, "csv_file", "deletes.csv", csvContent)
+	req, _ := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	resp.Body.Close()
+
+	// Borrado lógico del débito
+	req, _ = http.NewRequest("DELETE", server.URL+config.GetPathAPI()+"/transactions/2?reason=duplicated", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error deleting, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	balanceResp, err := http.Get(server.URL + config.GetPathAPI() + "/users/4001/balance")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var balance models.BalanceInfo
+	json.NewDecoder(balanceResp.Body).Decode(&balance)
+	balanceResp.Body.Close()
+	if balance.Balance != 100 || balance.TotalDebits != 0 {
+		t.Errorf("Expected balance 100.00 without the deleted debit, got %+v", balance)
+	}
+
+	// La purga elimina definitivamente la transacción borrada
+	req, _ = http.NewRequest("POST", server.URL+config.GetPathAPI()+"/admin/purge", nil)
+	req.Header.Set("X-Admin-Token", "test-admin-token")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error purging, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result models.PurgeResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.PurgedCount != 1 {
+		t.Errorf("Expected 1 transaction purged, got %d %+v", resp.StatusCode, result)
+	}
+}
+
 func TestBalanceEndpoint(t *testing.T) {
 	server := test_utils.SetupTestServer()
 	defer server.Close()
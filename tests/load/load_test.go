@@ -1,11 +1,15 @@
 package load
 
 import (
+	"api-stori/internal/services"
 	"api-stori/tests/config"
 	"api-stori/tests/test_utils"
 	"bytes"
 	"fmt"
 	"net/http"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -236,3 +240,79 @@ func TestLoadBalanceWithDateRange(t *testing.T) {
 		t.Errorf("Expected 0 errors, got %d", errorCount)
 	}
 }
+
+// TestLoadConcurrentMigrationsStoreComparison runs the same mix of concurrent migrations and
+// balance reads against a single-lock store (the original MockDatabase design) and against the
+// sharded MockDatabase, and reports the throughput of each. The gain depends on GOMAXPROCS:
+// with a single CPU both stores perform about the same.
+func TestLoadConcurrentMigrationsStoreComparison(t *testing.T) {
+	concurrency := 10      // Migraciones concurrentes
+	recordsPerFile := 2000 // Registros por migración
+	readers := 10          // Goroutines consultando balances mientras se migra
+	readsPerReader := 2000 // Consultas por goroutine
+	userCount := 100
+
+	// Un archivo por migración con IDs disjuntos para que ninguna sobrescriba a otra
+	files := make([]string, concurrency)
+	for i := range files {
+		files[i] = test_utils.GenerateTestCSVWithIDOffset(i*recordsPerFile+1, recordsPerFile, userCount)
+	}
+
+	stores := []struct {
+		name       string
+		repository services.TransactionRepository
+	}{
+		{"single_lock", test_utils.NewSingleLockRepository(services.NewMockDatabase())},
+		{"sharded", services.NewMockDatabase()},
+	}
+
+	throughput := make(map[string]float64)
+	for _, store := range stores {
+		migrationService := services.NewMigrationService(store.repository)
+		migrationService.SetReportService(nil) // Sin reportes: solo se mide el almacén
+		usersService := services.NewUsersService(store.repository)
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		errs := make(chan error, concurrency)
+
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := migrationService.ProcessCSV(strings.NewReader(files[i])); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+
+		for r := 0; r < readers; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				for i := 0; i < readsPerReader; i++ {
+					usersService.GetUserBalance(1001+(r+i)%userCount, nil, nil)
+				}
+			}(r)
+		}
+
+		wg.Wait()
+		duration := time.Since(start)
+		close(errs)
+
+		for err := range errs {
+			t.Fatalf("%s: migration failed: %v", store.name, err)
+		}
+		if got := store.repository.GetTransactionCount(); got != concurrency*recordsPerFile {
+			t.Errorf("%s: expected %d transactions, got %d", store.name, concurrency*recordsPerFile, got)
+		}
+
+		operations := concurrency*recordsPerFile + readers*readsPerReader
+		throughput[store.name] = float64(operations) / duration.Seconds()
+		t.Logf("%s: %d records migrated and %d balance reads in %v (%.2f ops/sec)",
+			store.name, concurrency*recordsPerFile, readers*readsPerReader, duration, throughput[store.name])
+	}
+
+	t.Logf("Sharded vs single lock throughput: %.2fx (GOMAXPROCS=%d)",
+		throughput["sharded"]/throughput["single_lock"], runtime.GOMAXPROCS(0))
+}
@@ -1,14 +1,29 @@
 package performance
 
 import (
+	"api-stori/internal/handlers"
+	"api-stori/internal/models"
+	"api-stori/internal/services"
 	"api-stori/tests/config"
 	"api-stori/tests/test_utils"
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
 // TestPerformanceMigration tests migration endpoint performance
@@ -188,6 +203,197 @@ func TestMemoryUsage(t *testing.T) {
 	t.Logf("Records per second: %.2f", 10000.0/duration.Seconds())
 }
 
+// discardRepository counts saved transactions without keeping them, so that the heap
+// measured during a migration only reflects the ingestion path
+type discardRepository struct {
+	services.TransactionRepository
+	saved atomic.Int64
+}
+
+func (r *discardRepository) SaveTransactionWithOptions(transaction models.UserTransaction, options services.SaveOptions) (services.SaveResult, error) {
+	r.saved.Add(1)
+	return services.SaveResult{Transaction: transaction, Outcome: services.SaveInserted}, nil
+}
+
+// TestLargeUploadBoundedMemory streams a generated CSV larger than the old 32 MB multipart
+// limit and checks that the peak heap does not grow with the file size.
+// PERF_UPLOAD_MB sets the upload size (default 64).
+func TestLargeUploadBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large upload in short mode")
+	}
+
+	repository := &discardRepository{TransactionRepository: services.NewMockDatabase()}
+	handler := handlers.NewMigrationHandler(services.NewMigrationService(repository))
+	upload := runLargeUpload(t, handler, repository, "", "")
+	if len(upload.result.SHA256) != 64 {
+		t.Errorf("Expected the SHA-256 of the upload, got %q", upload.result.SHA256)
+	}
+}
+
+// TestLargeUploadThroughRegistry repeats the large upload with the idempotency registry enabled.
+// With or without Idempotency-Key the file is spooled to disk to compute its SHA-256 before the
+// migration, and the heap stays bounded as well.
+func TestLargeUploadThroughRegistry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large upload in short mode")
+	}
+
+	for name, key := range map[string]string{"without key": "", "with key": "perf-large-upload"} {
+		t.Run(name, func(t *testing.T) {
+			spoolDir := t.TempDir()
+			registry, err := services.NewUploadRegistry(time.Hour, 1000, spoolDir)
+			if err != nil {
+				t.Fatalf("Expected no error creating registry, got %v", err)
+			}
+
+			repository := &discardRepository{TransactionRepository: services.NewMockDatabase()}
+			handler := handlers.NewMigrationHandler(services.NewMigrationService(repository))
+			handler.SetUploadRegistry(registry)
+
+			upload := runLargeUpload(t, handler, repository, key, spoolDir)
+			if upload.result.SHA256 != upload.sha256 {
+				t.Errorf("Expected SHA-256 %s, got %q", upload.sha256, upload.result.SHA256)
+			}
+			if upload.spooled == 0 {
+				t.Error("Expected the upload to be spooled")
+			}
+			if entries, _ := os.ReadDir(spoolDir); len(entries) != 0 {
+				t.Errorf("Expected the spool directory to be empty after the migration, got %d files", len(entries))
+			}
+		})
+	}
+}
+
+// largeUpload outcome of runLargeUpload
+type largeUpload struct {
+	result  models.MigrationResult
+	sha256  string // SHA-256 of the generated file
+	spooled int    // Most files seen in the spool directory during the upload
+}
+
+// runLargeUpload sends a generated CSV of PERF_UPLOAD_MB (default 64) through handler while sampling
+// the heap and, if spoolDir is set, the files in it. Fails if the peak heap grows more than 32 MB.
+func runLargeUpload(t *testing.T, handler *handlers.MigrationHandler, repository *discardRepository, key, spoolDir string) largeUpload {
+	t.Helper()
+
+	uploadMB := 64
+	if value := os.Getenv("PERF_UPLOAD_MB"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			t.Fatalf("Invalid PERF_UPLOAD_MB %q", value)
+		}
+		uploadMB = parsed
+	}
+	const heapLimit = 32 << 20
+
+	router := mux.NewRouter()
+	router.HandleFunc(config.GetPathAPI()+"/migrate", handler.MigrateCSV).Methods("POST")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// El archivo se genera mientras se envía: ni el cliente ni el servidor lo tienen completo en memoria
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	var records int
+	fileHash := sha256.New()
+	go func() {
+		fileWriter, err := writer.CreateFormFile("csv_file", "large_test.csv")
+		if err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+
+		buffered := bufio.NewWriter(io.MultiWriter(fileWriter, fileHash))
+		buffered.WriteString("id,user_id,amount,datetime\n")
+		written := 0
+		baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+		for written < uploadMB<<20 {
+			records++
+			n, _ := fmt.Fprintf(buffered, "%d,%d,%.2f,%s\n", records, 1001+records%100, float64(records%1000)-500,
+				baseTime.Add(time.Duration(records)*time.Second).Format("2006-01-02 15:04:05"))
+			written += n
+		}
+		buffered.Flush()
+		bodyWriter.CloseWithError(writer.Close())
+	}()
+
+	// Medir el pico de heap (y los archivos copiados a disco) mientras dura la migración
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	var peak atomic.Uint64
+	var spooled atomic.Int64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				var current runtime.MemStats
+				runtime.ReadMemStats(&current)
+				if current.HeapAlloc > peak.Load() {
+					peak.Store(current.HeapAlloc)
+				}
+				if spoolDir != "" {
+					if entries, _ := os.ReadDir(spoolDir); int64(len(entries)) > spooled.Load() {
+						spooled.Store(int64(len(entries)))
+					}
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	req, err := http.NewRequest("POST", server.URL+config.GetPathAPI()+"/migrate", bodyReader)
+	if err != nil {
+		t.Fatalf("Expected no error creating request, got %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	close(done)
+	<-sampled
+	if err != nil {
+		t.Fatalf("Expected no error making request, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	duration := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var result models.MigrationResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Expected a JSON result, got %v", err)
+	}
+	if int(repository.saved.Load()) != records {
+		t.Errorf("Expected %d transactions saved, got %d", records, repository.saved.Load())
+	}
+
+	growth := int64(peak.Load()) - int64(baseline)
+	t.Logf("Upload: %d MB, %d records in %v (%.0f records/sec), peak heap growth: %.1f MB",
+		uploadMB, records, duration, float64(records)/duration.Seconds(), float64(growth)/(1<<20))
+
+	if growth > heapLimit {
+		t.Errorf("Expected peak heap growth below %d MB, got %.1f MB", heapLimit>>20, float64(growth)/(1<<20))
+	}
+
+	return largeUpload{result: result, sha256: hex.EncodeToString(fileHash.Sum(nil)), spooled: int(spooled.Load())}
+}
+
 // TestConcurrentRequests tests concurrent request handling
 func TestConcurrentRequests(t *testing.T) {
 	// Setup test server
@@ -205,7 +411,7 @@ func TestConcurrentRequests(t *testing.T) {
 			results := make(chan error, concurrency)
 
 			for i := 0; i < concurrency; i++ {
-				go func() {
+				go func(i int) {
 					userID := 1001 + (i % 10)
 					url := fmt.Sprintf("%s%s/users/%d/balance", server.URL, config.GetPathAPI(), userID)
 
@@ -222,7 +428,7 @@ func TestConcurrentRequests(t *testing.T) {
 					}
 
 					results <- nil
-				}()
+				}(i)
 			}
 
 			// Collect results
@@ -254,6 +460,44 @@ func TestConcurrentRequests(t *testing.T) {
 	}
 }
 
+// BenchmarkStoreConcurrentSaves compares concurrent saves and balance reads against a
+// single-lock store and the sharded MockDatabase. Run with -cpu 1,4,8 to see how each scales.
+func BenchmarkStoreConcurrentSaves(b *testing.B) {
+	stores := []struct {
+		name       string
+		repository func() services.TransactionRepository
+	}{
+		{"single_lock", func() services.TransactionRepository {
+			return test_utils.NewSingleLockRepository(services.NewMockDatabase())
+		}},
+		{"sharded", func() services.TransactionRepository { return services.NewMockDatabase() }},
+	}
+
+	baseTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, store := range stores {
+		b.Run(store.name, func(b *testing.B) {
+			repository := store.repository()
+			var nextID atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := int(nextID.Add(1))
+					userID := 1001 + id%100
+					if id%4 == 0 {
+						// Una de cada cuatro operaciones es una consulta de balance
+						repository.GetTransactionsByUserID(userID)
+						continue
+					}
+					repository.SaveTransaction(models.UserTransaction{
+						ID: id, UserID: userID, Amount: 1, DateTime: baseTime.Add(time.Duration(id) * time.Second),
+					})
+				}
+			})
+		})
+	}
+}
+
 // Helper function to generate CSV data
 func generateCSV(recordCount int) string {
 	var buf bytes.Buffer
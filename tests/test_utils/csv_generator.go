@@ -64,3 +64,33 @@ func GenerateTestCSVWithUsers(recordCount, userCount int) string {
 	writer.Flush()
 	return buf.String()
 }
+
+// GenerateTestCSVWithIDOffset generates CSV data whose IDs start at firstID
+// Useful for concurrent migrations that must not overwrite each other
+func GenerateTestCSVWithIDOffset(firstID, recordCount, userCount int) string {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	// Write header
+	writer.Write([]string{"id", "user_id", "amount", "datetime"})
+
+	// Write records
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < recordCount; i++ {
+		id := firstID + i
+		userID := 1001 + (id % userCount)
+		amount := float64(id%1000) - 500.0
+		datetime := baseTime.Add(time.Duration(id) * time.Minute)
+
+		record := []string{
+			strconv.Itoa(id),
+			strconv.Itoa(userID),
+			fmt.Sprintf("%.2f", amount),
+			datetime.Format("2006-01-02 15:04:05"),
+		}
+		writer.Write(record)
+	}
+
+	writer.Flush()
+	return buf.String()
+}
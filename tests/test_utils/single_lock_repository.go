@@ -0,0 +1,128 @@
+package test_utils
+
+import (
+	"api-stori/internal/models"
+	"api-stori/internal/services"
+	"sync"
+	"time"
+)
+
+// SingleLockRepository wraps a repository behind one RWMutex, reproducing the original
+// MockDatabase locking (every write blocks every other write and every read).
+// It is the baseline used by load and performance tests to measure the sharded store.
+type SingleLockRepository struct {
+	mutex      sync.RWMutex
+	repository services.TransactionRepository
+}
+
+// Verificación en tiempo de compilación de que SingleLockRepository cumple el repositorio
+var _ services.TransactionRepository = (*SingleLockRepository)(nil)
+
+// NewSingleLockRepository creates a SingleLockRepository around repository
+func NewSingleLockRepository(repository services.TransactionRepository) *SingleLockRepository {
+	return &SingleLockRepository{repository: repository}
+}
+
+func (r *SingleLockRepository) SaveTransaction(transaction models.UserTransaction) (models.UserTransaction, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.SaveTransaction(transaction)
+}
+
+func (r *SingleLockRepository) SaveTransactionWithOptions(transaction models.UserTransaction, options services.SaveOptions) (services.SaveResult, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.SaveTransactionWithOptions(transaction, options)
+}
+
+func (r *SingleLockRepository) SaveTransactions(transactions []models.UserTransaction, options services.SaveOptions) ([]services.SaveResult, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.SaveTransactions(transactions, options)
+}
+
+func (r *SingleLockRepository) GetTransaction(id int) (models.UserTransaction, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetTransaction(id)
+}
+
+func (r *SingleLockRepository) GetTransactionsByUserID(userID int) []models.UserTransaction {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetTransactionsByUserID(userID)
+}
+
+func (r *SingleLockRepository) GetTransactionsByUserIDWithDateRange(userID int, fromDate, toDate *time.Time) []models.UserTransaction {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetTransactionsByUserIDWithDateRange(userID, fromDate, toDate)
+}
+
+func (r *SingleLockRepository) GetTransactionHistory(id int) []models.TransactionVersion {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetTransactionHistory(id)
+}
+
+func (r *SingleLockRepository) GetTransactionsByMigrationID(migrationID string) []models.UserTransaction {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetTransactionsByMigrationID(migrationID)
+}
+
+func (r *SingleLockRepository) GetAllTransactions() []models.UserTransaction {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetAllTransactions()
+}
+
+func (r *SingleLockRepository) ForEachTransaction(fn func(transaction models.UserTransaction) error) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.ForEachTransaction(fn)
+}
+
+func (r *SingleLockRepository) GetTransactionCount() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.repository.GetTransactionCount()
+}
+
+func (r *SingleLockRepository) SoftDeleteTransaction(id int, reason string) (models.UserTransaction, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.SoftDeleteTransaction(id, reason)
+}
+
+func (r *SingleLockRepository) DeleteTransactions(ids []int) ([]models.UserTransaction, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.DeleteTransactions(ids)
+}
+
+func (r *SingleLockRepository) DeleteTransactionsIf(ids []int, condition func(transaction models.UserTransaction) bool) ([]models.UserTransaction, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.DeleteTransactionsIf(ids, condition)
+}
+
+func (r *SingleLockRepository) ClearTransactions() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.repository.ClearTransactions()
+}
+
+func (r *SingleLockRepository) ImportTransactions(next func() (models.UserTransaction, error), replace bool) (int, int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.repository.ImportTransactions(next, replace)
+}
+
+func (r *SingleLockRepository) Subscribe(fromSeq uint64, bufferSize int) (*services.Subscription, error) {
+	return r.repository.Subscribe(fromSeq, bufferSize)
+}
+
+func (r *SingleLockRepository) LastSequence() uint64 {
+	return r.repository.LastSequence()
+}